@@ -0,0 +1,247 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeExpireBatchSize is the max number of the tombstones written by a tx of the expirer.
+const activeExpireBatchSize = 1024
+
+// expiringKey is a key of a BPTree bucket due to expire at the unix time expireAt in milliseconds.
+type expiringKey struct {
+	expireAt uint64
+	bucket   string
+	key      []byte
+}
+
+// expiringKeyHeap is a min heap of the expiring keys by expireAt.
+type expiringKeyHeap []expiringKey
+
+func (h expiringKeyHeap) Len() int           { return len(h) }
+func (h expiringKeyHeap) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+func (h expiringKeyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiringKeyHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiringKey))
+}
+
+func (h *expiringKeyHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// expirer deletes the expired keys of the BPTree buckets in the background, see Options.ActiveExpireInterval.
+// The keys are pushed into the heap when they're indexed with a ttl, by the commits and by Open, so a run only
+// looks at the keys due instead of sweeping the indexes. A key overwritten, deleted or renamed since is left in
+// the heap, and skipped once it's due. The heap is guarded by db.mu like the indexes.
+type expirer struct {
+	keys     expiringKeyHeap
+	started  bool
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newExpirer() *expirer {
+	return &expirer{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// trackExpiringKey pushes the key into the heap of the expirer if the record sets it with a ttl.
+func (db *DB) trackExpiringKey(bucket string, key []byte, meta *MetaData) {
+	if db.expirer == nil || meta.Flag != DataSetFlag {
+		return
+	}
+
+	if expireAt := meta.ExpireAtMillis(); expireAt != 0 {
+		heap.Push(&db.expirer.keys, expiringKey{expireAt: expireAt, bucket: bucket, key: key})
+	}
+}
+
+// trackExpiringTree pushes the keys with a ttl of the tree, the tree of a renamed bucket is tracked under its
+// new name.
+func (db *DB) trackExpiringTree(bucket string, tree *BPTree) {
+	if db.expirer == nil {
+		return
+	}
+
+	records, _ := tree.All()
+	for _, r := range records {
+		db.trackExpiringKey(bucket, r.H.Key, r.H.Meta)
+	}
+}
+
+// startExpireWorker starts the expirer, it's not started for a read-only db.
+func (db *DB) startExpireWorker() {
+	if db.expirer == nil || db.isReadOnly() {
+		return
+	}
+
+	db.expirer.started = true
+	go db.expireWorker()
+}
+
+// stopExpireWorker stops the expirer and waits for its run in progress. It's called by Close before taking
+// db.mu, which the run waits for.
+func (db *DB) stopExpireWorker() {
+	if db.expirer == nil || !db.expirer.started {
+		return
+	}
+
+	db.expirer.stopOnce.Do(func() {
+		close(db.expirer.stopCh)
+		<-db.expirer.doneCh
+	})
+}
+
+func (db *DB) expireWorker() {
+	defer close(db.expirer.doneCh)
+
+	ticker := time.NewTicker(db.opt.ActiveExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = db.expireKeys(db.nowMillis())
+		case <-db.expirer.stopCh:
+			return
+		}
+	}
+}
+
+// expireKeys deletes the keys expired at the unix time now in milliseconds, and returns how many. The tombstones
+// are written by the txs of activeExpireBatchSize keys, which hold the lock like any other write, so a key is
+// checked against the index right before its tombstone is queued and a commit never races the expirer.
+func (db *DB) expireKeys(now uint64) (expired int, err error) {
+	for {
+		var (
+			keys []expiringKey
+			more bool
+		)
+
+		err = db.Update(func(tx *Tx) (err error) {
+			tx.expiring = true
+			keys, more, err = tx.putExpiredKeys(now)
+			return err
+		})
+		if err != nil {
+			// the keys are checked again by the next run.
+			db.mu.Lock()
+			for _, key := range keys {
+				heap.Push(&db.expirer.keys, key)
+			}
+			db.mu.Unlock()
+			return expired, err
+		}
+
+		expired += len(keys)
+		atomic.AddInt64(&db.activeExpired, int64(len(keys)))
+		if db.expiredNotifier != nil {
+			for _, key := range keys {
+				db.expiredNotifier.notifyDropped(key.bucket, key.key, key.expireAt)
+			}
+		}
+		if !more {
+			return expired, nil
+		}
+	}
+}
+
+// putExpiredKeys queues the tombstones of the keys expired at now, up to activeExpireBatchSize, and returns the
+// keys and if more are due.
+func (tx *Tx) putExpiredKeys(now uint64) (keys []expiringKey, more bool, err error) {
+	due := &tx.db.expirer.keys
+	for due.Len() > 0 && (*due)[0].expireAt <= now {
+		if len(keys) == activeExpireBatchSize {
+			return keys, true, nil
+		}
+
+		key := heap.Pop(due).(expiringKey)
+		if !tx.db.isExpiringKey(key) {
+			continue
+		}
+
+		keys = append(keys, key)
+		if err := tx.put(key.bucket, key.key, nil, Persistent, DataDeleteFlag, now/1000, DataStructureBPTree); err != nil {
+			return keys, false, err
+		}
+	}
+
+	return keys, false, nil
+}
+
+// isExpiringKey returns if the index still holds the key as it's pushed, with the same expiration.
+func (db *DB) isExpiringKey(key expiringKey) bool {
+	tree, ok := db.BPTreeIdx[key.bucket]
+	if !ok {
+		return false
+	}
+
+	r, err := tree.Find(key.key)
+	if err != nil {
+		return false
+	}
+
+	meta := r.H.Meta
+	return meta.Flag == DataSetFlag && meta.ExpireAtMillis() == key.expireAt
+}
+
+// expiringKeysBetween returns the keys of the bucket, of all the buckets for "", expiring from start until end in
+// milliseconds, by the order of the buckets and the keys. The heap is walked instead of the indexes, it only holds
+// the keys with a ttl, and the keys no longer expiring as they're pushed are left out.
+func (db *DB) expiringKeysBetween(bucket string, start, end uint64) [][]byte {
+	var (
+		found = make(map[string]struct{})
+		due   []expiringKey
+	)
+	for _, key := range db.expirer.keys {
+		if bucket != "" && key.bucket != bucket || key.expireAt < start || key.expireAt >= end {
+			continue
+		}
+
+		id := string(getNewKey(key.bucket, key.key))
+		if _, ok := found[id]; ok || !db.isExpiringKey(key) {
+			continue
+		}
+		found[id] = struct{}{}
+		due = append(due, key)
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].bucket != due[j].bucket {
+			return due[i].bucket < due[j].bucket
+		}
+		return bytes.Compare(due[i].key, due[j].key) < 0
+	})
+
+	var keys [][]byte
+	for _, key := range due {
+		keys = append(keys, key.key)
+	}
+
+	return keys
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// indexedKeys returns the number of the keys in the index of the bucket, including the deleted ones.
+func indexedKeys(t *testing.T, db *DB, bucket string) (n int) {
+	require.NoError(t, db.View(func(tx *Tx) error {
+		if tree, ok := db.BPTreeIdx[bucket]; ok {
+			records, _ := tree.All()
+			n = len(records)
+		}
+		return nil
+	}))
+
+	return n
+}
+
+func TestDB_ActiveExpire(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	opts.ActiveExpireInterval = 100 * time.Millisecond
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			if err := tx.Put("cache", GetTestBytes(i), GetRandomBytes(24), 1); err != nil {
+				return err
+			}
+		}
+		for i := 100; i < 110; i++ {
+			if err := tx.Put("cache", GetTestBytes(i), GetRandomBytes(24), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// the keys overwritten or extended before they expire are live.
+	txPut(t, db, "cache", []byte("overwritten"), []byte("value"), 1, nil)
+	txPut(t, db, "cache", []byte("overwritten"), []byte("value"), Persistent, nil)
+	txPut(t, db, "cache", []byte("extended"), []byte("value"), 1, nil)
+	txPut(t, db, "cache", []byte("extended"), []byte("value"), 3600, nil)
+	require.NoError(t, db.SetBucketReadOnly(DataStructureBPTree, "cache", true))
+	require.Equal(t, 112, indexedKeys(t, db, "cache"))
+
+	// without any reads the expired keys are dropped from the index, even from a frozen bucket.
+	require.Eventually(t, func() bool {
+		return db.Stats().ActiveExpired == 100
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, 12, indexedKeys(t, db, "cache"))
+	var errs []error
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for i := 100; i < 110; i++ {
+			_, err := tx.Get("cache", GetTestBytes(i))
+			errs = append(errs, err)
+		}
+		return nil
+	}))
+	require.Equal(t, make([]error, 10), errs)
+	txGet(t, db, "cache", []byte("overwritten"), []byte("value"), nil)
+	txGet(t, db, "cache", []byte("extended"), []byte("value"), nil)
+	txGet(t, db, "cache", GetTestBytes(0), nil, ErrKeyNotFound)
+
+	// the tombstones are written, merge drops the expired records with them.
+	reopen()
+	txGet(t, db, "cache", GetTestBytes(0), nil, ErrNotFoundKey)
+	require.Equal(t, 0, db.Stats().ActiveExpired)
+	require.NoError(t, db.Merge())
+	reopen()
+	require.Equal(t, 12, indexedKeys(t, db, "cache"))
+	txGet(t, db, "cache", GetTestBytes(0), nil, ErrKeyNotFound)
+	txGet(t, db, "cache", []byte("extended"), []byte("value"), nil)
+}
+
+func TestDB_ActiveExpireKeys(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.ActiveExpireInterval = time.Hour
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		n := activeExpireBatchSize*2 + 10
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < n; i++ {
+				if err := tx.Put("cache", GetTestBytes(i), []byte("value"), 10); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		txPut(t, db, "cache", []byte("later"), []byte("value"), 100, nil)
+		txDel(t, db, "cache", GetTestBytes(0), nil)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RenameBucket(DataStructureBPTree, "cache", "renamed")
+		}))
+
+		// the keys due are deleted in batches, the deleted key is skipped and the renamed keys are tracked.
+		now := db.nowMillis()
+		expired, err := db.expireKeys(now + 10*1000)
+		require.NoError(t, err)
+		require.Equal(t, n-1, expired)
+		require.Equal(t, 2, indexedKeys(t, db, "renamed"))
+		txGet(t, db, "renamed", []byte("later"), []byte("value"), nil)
+
+		expired, err = db.expireKeys(now + 10*1000)
+		require.NoError(t, err)
+		require.Equal(t, 0, expired)
+		expired, err = db.expireKeys(now + 100*1000)
+		require.NoError(t, err)
+		require.Equal(t, 1, expired)
+		require.Equal(t, 1, indexedKeys(t, db, "renamed"))
+		require.Equal(t, n, db.Stats().ActiveExpired)
+	})
+}
+
+func TestDB_ActiveExpireClose(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.ActiveExpireInterval = time.Millisecond
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "cache", GetTestBytes(i), []byte("value"), 1, nil)
+	}
+
+	require.NoError(t, db.Close())
+	select {
+	case <-db.expirer.doneCh:
+	default:
+		t.Fatal("the expirer is not stopped by Close")
+	}
+
+	// a Close timing out leaves the db open, with the expirer and the OnExpired calls running.
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	opts.CloseTimeout = 10 * time.Millisecond
+	opts.OnExpired = func(bucket string, key []byte) {}
+	db, err = Open(opts)
+	require.NoError(t, err)
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	require.True(t, errors.Is(db.Close(), ErrCloseTimeout))
+	require.NoError(t, tx.Rollback())
+	select {
+	case <-db.expirer.doneCh:
+		t.Fatal("the expirer is stopped by the Close timing out")
+	case <-db.expiredNotifier.doneCh:
+		t.Fatal("the OnExpired calls are stopped by the Close timing out")
+	default:
+	}
+	require.NoError(t, db.Close())
+	<-db.expirer.doneCh
+	<-db.expiredNotifier.doneCh
+	opts.CloseTimeout, opts.OnExpired = 0, nil
+
+	// the expirer is not started for the sparse index.
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	opts.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Nil(t, db.expirer)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_ActiveExpireDueKeysOnly(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.ActiveExpireInterval = time.Hour
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	const persistent, expiring = 1000000, 1000
+	for batch := 0; batch < persistent; batch += 100000 {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := batch; i < batch+100000; i++ {
+				if err := tx.Put("cache", GetTestBytes(i), nil, Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := persistent; i < persistent+expiring; i++ {
+			if err := tx.Put("cache", GetTestBytes(i), []byte("value"), 10); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// only the keys with a ttl are tracked, Open rebuilds them from the files.
+	require.Equal(t, expiring, db.expirer.keys.Len())
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, expiring, db.expirer.keys.Len())
+
+	// the ttl extended by Expire leaves the key in the heap until its earlier expiration, it's skipped then.
+	extended := GetTestBytes(persistent)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Expire("cache", extended, 100)
+	}))
+	require.Equal(t, expiring+1, db.expirer.keys.Len())
+
+	// a sweep pops the keys due only, however many persistent keys.
+	expired, err := db.expireKeys(db.nowMillis())
+	require.NoError(t, err)
+	require.Equal(t, 0, expired)
+	require.Equal(t, expiring+1, db.expirer.keys.Len())
+
+	clock.Add(10 * time.Second)
+	expired, err = db.expireKeys(db.nowMillis())
+	require.NoError(t, err)
+	require.Equal(t, expiring-1, expired)
+	require.Equal(t, 1, db.expirer.keys.Len())
+	require.Equal(t, persistent+1, indexedKeys(t, db, "cache"))
+	txGet(t, db, "cache", extended, []byte("value"), nil)
+
+	clock.Add(100 * time.Second)
+	expired, err = db.expireKeys(db.nowMillis())
+	require.NoError(t, err)
+	require.Equal(t, 1, expired)
+	require.Equal(t, 0, db.expirer.keys.Len())
+	txGet(t, db, "cache", extended, nil, ErrKeyNotFound)
+}
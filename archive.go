@@ -0,0 +1,249 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveCodec is the compression of the archives written by BackupArchive.
+type ArchiveCodec int
+
+const (
+	// ArchiveGzip compresses the archive with gzip, like BackupTarGZ.
+	ArchiveGzip ArchiveCodec = iota
+
+	// ArchiveZstd compresses the archive with zstd.
+	ArchiveZstd
+
+	// ArchiveNone writes the tar archive uncompressed.
+	ArchiveNone
+)
+
+// String returns the name of the codec.
+func (c ArchiveCodec) String() string {
+	switch c {
+	case ArchiveGzip:
+		return "gzip"
+	case ArchiveZstd:
+		return "zstd"
+	case ArchiveNone:
+		return "none"
+	}
+
+	return fmt.Sprintf("ArchiveCodec(%d)", int(c))
+}
+
+// ArchiveOptions are the options of BackupArchive.
+type ArchiveOptions struct {
+	// Codec is the compression of the archive, gzip by default.
+	Codec ArchiveCodec
+
+	// Level is the compression level of the codec, 0 for the default of the codec. The levels of gzip go from
+	// gzip.HuffmanOnly to gzip.BestCompression, the levels of zstd are the ones of the zstd command, which are
+	// mapped to the closest level of the encoder.
+	Level int
+
+	// Concurrency is a hint of how many goroutines compress the archive, 0 or 1 for the codec to compress it on
+	// the goroutine of BackupArchive. gzip compresses the blocks of the archive concurrently then, as members of
+	// the gzip stream each, which any gzip reader reads as one. zstd compresses its blocks concurrently itself.
+	Concurrency int
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic in the header of a tar archive.
+const tarMagicOffset = 257
+
+// newArchiveWriter returns the writer compressing the archive to w with the codec of opts.
+func newArchiveWriter(w io.Writer, opts ArchiveOptions) (io.WriteCloser, error) {
+	switch opts.Codec {
+	case ArchiveGzip:
+		level := opts.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if opts.Concurrency > 1 {
+			return newParallelGzipWriter(w, level, opts.Concurrency)
+		}
+		return gzip.NewWriterLevel(w, level)
+	case ArchiveZstd:
+		concurrency := opts.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(concurrency)}
+		if opts.Level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.Level)))
+		}
+		return zstd.NewWriter(w, options...)
+	case ArchiveNone:
+		return nopWriteCloser{w}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrArchiveCodecNotSupported, opts.Codec)
+}
+
+// newArchiveReader returns the reader of the tar archive in r, compressed with any codec of BackupArchive, which
+// is told from the magic at the start of r.
+func newArchiveReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, tarMagicOffset+len(tarMagic))
+	magic, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case len(magic) > tarMagicOffset && bytes.HasPrefix(magic[tarMagicOffset:], tarMagic):
+		return ioutil.NopCloser(br), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown archive format", ErrRestoreArchive)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// parallelGzipBlockSize is the size of the blocks compressed concurrently by parallelGzipWriter.
+const parallelGzipBlockSize = 1 << 20
+
+// parallelGzipWriter compresses the blocks written to it on up to concurrency goroutines, each block as a member
+// of the gzip stream, and writes them in order.
+type parallelGzipWriter struct {
+	level   int
+	buf     []byte
+	blocks  chan chan parallelGzipBlock // the blocks being compressed, in order
+	written chan error                  // the error of writing the blocks, once they are all written
+	err     atomic.Value                // the first parallelGzipError of writing the blocks, returned by Write
+}
+
+type parallelGzipError struct {
+	err error
+}
+
+type parallelGzipBlock struct {
+	data []byte
+	err  error
+}
+
+func newParallelGzipWriter(w io.Writer, level, concurrency int) (*parallelGzipWriter, error) {
+	// the level is checked once here rather than by each block.
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+
+	p := &parallelGzipWriter{
+		level:   level,
+		buf:     make([]byte, 0, parallelGzipBlockSize),
+		blocks:  make(chan chan parallelGzipBlock, concurrency-1),
+		written: make(chan error, 1),
+	}
+	go p.writeBlocks(w)
+
+	return p, nil
+}
+
+func (p *parallelGzipWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if err, ok := p.err.Load().(parallelGzipError); ok {
+			return n - len(b), err.err
+		}
+
+		m := cap(p.buf) - len(p.buf)
+		if m > len(b) {
+			m = len(b)
+		}
+		p.buf = append(p.buf, b[:m]...)
+		b = b[m:]
+
+		if len(p.buf) == cap(p.buf) {
+			p.compressBlock()
+		}
+	}
+
+	return n, nil
+}
+
+// Close compresses the last block and waits for all of them to be written, it returns the first error met.
+func (p *parallelGzipWriter) Close() error {
+	if len(p.buf) > 0 {
+		p.compressBlock()
+	}
+	close(p.blocks)
+
+	return <-p.written
+}
+
+// compressBlock compresses the block buffered on a new goroutine, once less than concurrency blocks are pending.
+func (p *parallelGzipWriter) compressBlock() {
+	data := p.buf
+	p.buf = make([]byte, 0, parallelGzipBlockSize)
+
+	block := make(chan parallelGzipBlock, 1)
+	p.blocks <- block
+	go func() {
+		var buf bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&buf, p.level)
+		_, err := gz.Write(data)
+		if err == nil {
+			err = gz.Close()
+		}
+		block <- parallelGzipBlock{data: buf.Bytes(), err: err}
+	}()
+}
+
+func (p *parallelGzipWriter) writeBlocks(w io.Writer) {
+	var err error
+	for block := range p.blocks {
+		b := <-block
+		if err != nil {
+			continue
+		}
+		if err = b.err; err == nil {
+			_, err = w.Write(b.data)
+		}
+		if err != nil {
+			p.err.Store(parallelGzipError{err: err})
+		}
+	}
+
+	p.written <- err
+}
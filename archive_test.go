@@ -0,0 +1,191 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BackupArchive(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 1 * MB
+	defer os.RemoveAll(opt.Dir)
+	restoreDir, _ := ioutil.TempDir("", "nutsdb-restore")
+	defer os.RemoveAll(restoreDir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	fillContentHashDB(t, db, false)
+	// more than a block of the parallel gzip.
+	for i := 0; i < 3*parallelGzipBlockSize/(4*KB); i++ {
+		txPut(t, db, "kv", GetTestBytes(i), bytes.Repeat(GetTestBytes(i), 256), Persistent, nil)
+	}
+	expected := dbContents(t, db)
+
+	tests := []struct {
+		name  string
+		opts  ArchiveOptions
+		magic []byte
+	}{
+		{"gzip", ArchiveOptions{}, gzipMagic},
+		{"gzip-1", ArchiveOptions{Level: gzip.BestSpeed}, gzipMagic},
+		{"gzip-1 parallel", ArchiveOptions{Level: gzip.BestSpeed, Concurrency: 4}, gzipMagic},
+		{"zstd", ArchiveOptions{Codec: ArchiveZstd}, zstdMagic},
+		{"zstd-1 parallel", ArchiveOptions{Codec: ArchiveZstd, Level: 1, Concurrency: 4}, zstdMagic},
+		{"none", ArchiveOptions{Codec: ArchiveNone}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, db.BackupArchive(&buf, tt.opts))
+			if tt.magic != nil {
+				require.Equal(t, tt.magic, buf.Bytes()[:len(tt.magic)])
+			} else {
+				require.Equal(t, tarMagic, buf.Bytes()[tarMagicOffset:tarMagicOffset+len(tarMagic)])
+			}
+
+			archive := filepath.Join(restoreDir, tt.name+".archive")
+			require.NoError(t, ioutil.WriteFile(archive, buf.Bytes(), 0644))
+			_, err := VerifyBackup(archive)
+			require.NoError(t, err)
+
+			dir := filepath.Join(restoreDir, tt.name)
+			require.NoError(t, RestoreTarGZ(bytes.NewReader(buf.Bytes()), dir))
+			restoreOpt := opt
+			restoreOpt.Dir = dir
+			restored, err := Open(restoreOpt)
+			require.NoError(t, err)
+			defer restored.Close()
+			require.Equal(t, expected, dbContents(t, restored))
+		})
+	}
+
+	t.Run("not supported", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := db.BackupArchive(&buf, ArchiveOptions{Level: 42})
+		require.Error(t, err)
+		err = db.BackupArchive(&buf, ArchiveOptions{Codec: ArchiveCodec(42)})
+		require.True(t, errors.Is(err, ErrArchiveCodecNotSupported))
+
+		err = RestoreTarGZ(bytes.NewReader([]byte("not an archive")), filepath.Join(restoreDir, "unknown"))
+		require.True(t, errors.Is(err, ErrRestoreArchive))
+	})
+}
+
+// backupBenchSize is the size of the data of the db backed up by BenchmarkDB_BackupArchive.
+const backupBenchSize = 1 << 30
+
+func BenchmarkDB_BackupArchive(b *testing.B) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb-bench")
+	opt.SegmentSize = 256 * MB
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	// the values are half random, half repeated, to be compressed about as much as common data.
+	const valueSize = 4 * KB
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < backupBenchSize/valueSize; {
+		err := db.Update(func(tx *Tx) error {
+			for j := 0; j < 1000 && i < backupBenchSize/valueSize; j, i = j+1, i+1 {
+				value := make([]byte, valueSize)
+				r.Read(value[:valueSize/2])
+				copy(value[valueSize/2:], bytes.Repeat(GetTestBytes(i), valueSize/2/len(GetTestBytes(i))+1))
+				if err := tx.Put("bench", GetTestBytes(i), value, Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	benchmarks := []struct {
+		name string
+		opts ArchiveOptions
+	}{
+		{"gzip-6", ArchiveOptions{Level: 6}},
+		{"gzip-1", ArchiveOptions{Level: gzip.BestSpeed}},
+		{"gzip-1-parallel", ArchiveOptions{Level: gzip.BestSpeed, Concurrency: 8}},
+		{"zstd", ArchiveOptions{Codec: ArchiveZstd}},
+		{"zstd-1", ArchiveOptions{Codec: ArchiveZstd, Level: 1}},
+		{"none", ArchiveOptions{Codec: ArchiveNone}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(backupBenchSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var out countingWriter
+				if err := db.BackupArchive(&out, bm.opts); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(out)/backupBenchSize, "ratio")
+			}
+		})
+	}
+}
+
+func TestParallelGzipWriter_WriteError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	p, err := newParallelGzipWriter(errWriter{errWrite}, gzip.BestSpeed, 4)
+	require.NoError(t, err)
+
+	block := make([]byte, parallelGzipBlockSize)
+	for i := 0; ; i++ {
+		require.True(t, i < 1000, "Write never returned the error of writing the blocks")
+		if _, err = p.Write(block); err != nil {
+			break
+		}
+	}
+	require.Equal(t, errWrite, err)
+	require.Equal(t, errWrite, p.Close())
+}
+
+// errWriter fails every write with err.
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
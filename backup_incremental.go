@@ -0,0 +1,142 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// BackupSince writes the data files with an ID greater than sinceFileID to w as a tar.gz archive, the active file
+// being copied as far as it's committed. It returns the watermark to pass to the next BackupSince, the ID of the
+// last data file rotated, which can't change anymore.
+//
+// A sinceFileID of -1 backs up all the data files, the base of the increments. The increments are applied on top
+// of the base restored by RestoreTarGZ with RestoreIncrementTarGZ, in the order they are written. Once the data
+// files since the watermark are merged, the increments can't follow the base anymore and ErrFullBackupRequired
+// is returned, start again from a new base then.
+func (db *DB) BackupSince(w io.Writer, sinceFileID int64) (lastFileID int64, err error) {
+	if db.dataFS != nil {
+		return 0, ErrDBReadOnly
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return 0, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	snapshot, err := db.takeBackupSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.release()
+
+	var (
+		fileIDs      []int
+		activeFileID int64
+	)
+	for _, f := range snapshot.files {
+		if f.fileID >= 0 {
+			fileIDs = append(fileIDs, int(f.fileID))
+		}
+		if f.active {
+			activeFileID = f.fileID
+		}
+	}
+	sort.Ints(fileIDs)
+
+	// the file active at the previous backup is removed by merge.
+	if sinceFileID >= 0 && !containsFileID(fileIDs, sinceFileID+1) {
+		return 0, ErrFullBackupRequired
+	}
+
+	err = snapshot.writeArchive(w, ArchiveOptions{}, func(f *backupFile) bool {
+		return f.fileID > sinceFileID && f.fileID <= activeFileID
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return activeFileID - 1, nil
+}
+
+// RestoreIncrementTarGZ applies the incremental backup written by BackupSince on top of the backup restored in
+// dir, by RestoreTarGZ and the previous increments. The increment must start from the watermark of the backup
+// restored, otherwise ErrRestoreIncrement is returned and dir is left untouched. The data files of the increment
+// replace the ones of dir one by one once the whole archive is extracted.
+func RestoreIncrementTarGZ(r io.Reader, dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+
+	tmp, err := extractArchive(r, dir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	fileIDs, others, err := dataFileIDsIn(tmp)
+	if err != nil {
+		return err
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%w: %s is not a data file", ErrRestoreArchive, others[0])
+	}
+	restoredFileIDs, _, err := dataFileIDsIn(dir)
+	if err != nil {
+		return err
+	}
+
+	// the increment starts from the file active at the previous backup and goes on from there.
+	if len(fileIDs) == 0 || !containsFileID(restoredFileIDs, int64(fileIDs[0])) ||
+		restoredFileIDs[len(restoredFileIDs)-1] > fileIDs[len(fileIDs)-1] {
+		return ErrRestoreIncrement
+	}
+
+	for _, fileID := range fileIDs {
+		if err := os.Rename(getDataPath(int64(fileID), tmp), getDataPath(int64(fileID), dir)); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(dir)
+}
+
+// dataFileIDsIn returns the sorted IDs of the data files in dir and the names of the other files.
+func dataFileIDsIn(dir string) (fileIDs []int, others []string, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range files {
+		id, ok := parseDataFileName(file.Name())
+		if !ok || file.IsDir() {
+			others = append(others, file.Name())
+			continue
+		}
+		fileIDs = append(fileIDs, id)
+	}
+	sort.Ints(fileIDs)
+
+	return fileIDs, others, nil
+}
+
+// containsFileID returns if the sorted fileIDs hold fileID.
+func containsFileID(fileIDs []int, fileID int64) bool {
+	i := sort.SearchInts(fileIDs, int(fileID))
+	return i < len(fileIDs) && int64(fileIDs[i]) == fileID
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// archivedFiles returns the names of the files in the tar.gz archive.
+func archivedFiles(t *testing.T, archive []byte) []string {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, path.Base(header.Name))
+	}
+}
+
+func TestDB_BackupSince(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 8 * KB
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	backupSince := func(sinceFileID int64) ([]byte, int64) {
+		var buf bytes.Buffer
+		lastFileID, err := db.BackupSince(&buf, sinceFileID)
+		require.NoError(t, err)
+		return buf.Bytes(), lastFileID
+	}
+	putValues := func(from, to int) {
+		for i := from; i < to; i++ {
+			txPut(t, db, "kv", GetTestBytes(i), append(GetTestBytes(i), make([]byte, 256)...), Persistent, nil)
+		}
+	}
+
+	fillContentHashDB(t, db, false)
+	base, baseFileID := backupSince(-1)
+	require.Equal(t, db.ActiveFile.fileID-1, baseFileID)
+	require.Len(t, archivedFiles(t, base), int(db.ActiveFile.fileID)+1)
+
+	// the increments hold the file active at the previous backup and the ones written since.
+	putValues(0, 100)
+	txDel(t, db, "kv", GetTestBytes(1), nil)
+	first, firstFileID := backupSince(baseFileID)
+	require.Greater(t, firstFileID, baseFileID+1)
+	require.Equal(t, dataFileName(int(baseFileID+1)), archivedFiles(t, first)[0])
+	require.Len(t, archivedFiles(t, first), int(db.ActiveFile.fileID-baseFileID))
+
+	putValues(50, 150)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.SAdd("set", []byte("key"), []byte("added")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd("zset", []byte("added"), 100, []byte("added")); err != nil {
+			return err
+		}
+		if _, err := tx.LPop("list", []byte("key")); err != nil {
+			return err
+		}
+		return tx.Delete("kv", GetTestBytes(2))
+	}))
+	second, secondFileID := backupSince(firstFileID)
+	require.Greater(t, secondFileID, firstFileID)
+
+	var full bytes.Buffer
+	require.NoError(t, db.BackupTarGZ(&full))
+	want := dbContents(t, db)
+	wantHash := contentHash(t, db, HashOptions{})
+
+	restoreDir, _ := ioutil.TempDir("", "nutsdb_restore")
+	defer os.RemoveAll(restoreDir)
+	fullDir, incrementalDir := path.Join(restoreDir, "full"), path.Join(restoreDir, "incremental")
+	require.NoError(t, RestoreTarGZ(bytes.NewReader(full.Bytes()), fullDir))
+	require.NoError(t, RestoreTarGZ(bytes.NewReader(base), incrementalDir))
+
+	// the increments are applied in order.
+	require.ErrorIs(t, RestoreIncrementTarGZ(bytes.NewReader(second), incrementalDir), ErrRestoreIncrement)
+	require.NoError(t, RestoreIncrementTarGZ(bytes.NewReader(first), incrementalDir))
+	require.NoError(t, RestoreIncrementTarGZ(bytes.NewReader(second), incrementalDir))
+	require.ErrorIs(t, RestoreIncrementTarGZ(bytes.NewReader(first), incrementalDir), ErrRestoreIncrement)
+
+	// a full backup is not an increment.
+	require.ErrorIs(t, RestoreIncrementTarGZ(bytes.NewReader(full.Bytes()), incrementalDir), ErrRestoreArchive)
+
+	for _, dir := range []string{fullDir, incrementalDir} {
+		restoreOpt := opt
+		restoreOpt.Dir = dir
+		withDBOption(t, restoreOpt, func(t *testing.T, restored *DB) {
+			require.Equal(t, want, dbContents(t, restored))
+			require.Equal(t, wantHash, contentHash(t, restored, HashOptions{}))
+		})
+	}
+
+	// the increments can't follow the base once merged.
+	require.NoError(t, db.Merge())
+	_, err = db.BackupSince(&bytes.Buffer{}, secondFileID)
+	require.ErrorIs(t, err, ErrFullBackupRequired)
+	_, lastFileID := backupSince(-1)
+	require.Equal(t, db.ActiveFile.fileID-1, lastFileID)
+}
@@ -0,0 +1,360 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BackupManifestName is the file of the manifest written by Backup and BackupTarGZ, see BackupInfo.
+const BackupManifestName = "nutsdb-backup-manifest.json"
+
+// backupManifestVersion is the version of the format of the backup manifest.
+const backupManifestVersion = 1
+
+// BackupInfo is the manifest of a backup: the files backed up with their checksums, and the options of the db
+// they are written with.
+type BackupInfo struct {
+	// Version is the version of the format of the manifest.
+	Version int `json:"version"`
+
+	// DataVersion is the newest format of the entries the db writes, see MetaVersionExpireAt.
+	DataVersion uint8 `json:"data_version"`
+
+	SegmentSize  int64        `json:"segment_size"`
+	EntryIdxMode EntryIdxMode `json:"entry_idx_mode"`
+	CreatedAt    time.Time    `json:"created_at"`
+
+	// Files are the files backed up, sorted by name.
+	Files []BackupFileInfo `json:"files"`
+}
+
+// BackupFileInfo is a file of a backup.
+type BackupFileInfo struct {
+	// Name is the path of the file relative to the dir of the backup, with slashes.
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// VerifyBackup checks the backup at path, the dir written by Backup or the archive written by BackupArchive,
+// against its manifest and returns the manifest. A file missing, with another size or checksum, or a data file
+// the manifest doesn't list, is returned as ErrBackupCorrupted naming the file.
+func VerifyBackup(path string) (*BackupInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return verifyBackupDir(path)
+	}
+
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return verifyBackupArchive(f)
+}
+
+// Verify reads every entry of the data files and checks its crc, the active file as far as it's committed.
+// A corrupted entry is returned with the file and the offset of the entry, wrapping ErrCrc if its crc doesn't
+// match. Like Backup, Verify doesn't block the writes and merge is refused meanwhile.
+func (db *DB) Verify() error {
+	if db.dataFS != nil {
+		return ErrDBReadOnly
+	}
+
+	snapshot, err := db.takeBackupSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+
+	for _, f := range snapshot.files {
+		if f.fileID < 0 {
+			continue
+		}
+		if err := verifyDataFile(snapshot.path(f), f.name, f.size, f.active, db.opt.BufferSizeOfRecovery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyDataFile reads the entries of the first size bytes of the data file at path and checks their crc. The
+// entries of the committed part of the active file fill it, the ones of a rotated file end at the zeroed tail.
+func verifyDataFile(path, name string, size int64, committed bool, bufSize int) error {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fr := newFileRecoveryFromReader(ioutil.NopCloser(io.NewSectionReader(file, 0, size)), bufSize)
+	for off := int64(0); off < size; {
+		entry, err := fr.readEntry()
+		if !committed && (entry == nil && err == nil || err == io.EOF ||
+			err == io.ErrUnexpectedEOF && size-off < DataEntryHeaderSize) {
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && entry == nil {
+			err = ErrEntryZero
+		}
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", name, off, err)
+		}
+		off += entry.Size()
+	}
+
+	return nil
+}
+
+// newBackupManifest returns the manifest of a backup of the db, without files.
+func (db *DB) newBackupManifest() *BackupInfo {
+	return &BackupInfo{
+		Version:      backupManifestVersion,
+		DataVersion:  MetaVersionExpireAt,
+		SegmentSize:  db.opt.SegmentSize,
+		EntryIdxMode: db.opt.EntryIdxMode,
+		CreatedAt:    clockNow(db.opt.Clock).UTC(),
+	}
+}
+
+func (m *BackupInfo) add(name string, sum *fileChecksum) {
+	m.Files = append(m.Files, sum.info(filepath.ToSlash(name)))
+}
+
+func (m *BackupInfo) encode() ([]byte, error) {
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Name < m.Files[j].Name
+	})
+
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// verify checks the files found in the backup against the manifest.
+func (m *BackupInfo) verify(files map[string]BackupFileInfo) error {
+	for _, want := range m.Files {
+		have, ok := files[want.Name]
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: %s is missing", ErrBackupCorrupted, want.Name)
+		case have.Size != want.Size:
+			return fmt.Errorf("%w: %s has %d bytes, want %d", ErrBackupCorrupted, want.Name, have.Size, want.Size)
+		case have.CRC32 != want.CRC32 || have.SHA256 != want.SHA256:
+			return fmt.Errorf("%w: %s checksum mismatch", ErrBackupCorrupted, want.Name)
+		}
+		delete(files, want.Name)
+	}
+
+	for name := range files {
+		if _, ok := parseDataFileName(name); ok {
+			return fmt.Errorf("%w: %s is not in the manifest", ErrBackupCorrupted, name)
+		}
+	}
+
+	return nil
+}
+
+// readBackupManifest reads the manifest of the backup in dir, ErrBackupCorrupted if it's missing or malformed.
+func readBackupManifest(dir string) (*BackupInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, BackupManifestName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s is missing", ErrBackupCorrupted, BackupManifestName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBackupManifest(data)
+}
+
+func decodeBackupManifest(data []byte) (*BackupInfo, error) {
+	m := &BackupInfo{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: bad %s: %v", ErrBackupCorrupted, BackupManifestName, err)
+	}
+	if m.Version != backupManifestVersion {
+		return nil, fmt.Errorf("%w: unsupported %s version %d", ErrBackupCorrupted, BackupManifestName, m.Version)
+	}
+
+	return m, nil
+}
+
+func verifyBackupDir(dir string) (*BackupInfo, error) {
+	m, err := readBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]BackupFileInfo)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == BackupManifestName {
+			return err
+		}
+
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sum := newFileChecksum()
+		if _, err := io.Copy(sum, f); err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = sum.info(filepath.ToSlash(name))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, m.verify(files)
+}
+
+func verifyBackupArchive(r io.Reader) (*BackupInfo, error) {
+	ar, err := newArchiveReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+	tr := tar.NewReader(ar)
+
+	var (
+		m     *BackupInfo
+		files = make(map[string]BackupFileInfo)
+	)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := tarEntryPath(header)
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		name = filepath.ToSlash(name)
+
+		if name == BackupManifestName {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if m, err = decodeBackupManifest(data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sum := newFileChecksum()
+		if _, err := io.Copy(sum, tr); err != nil {
+			return nil, err
+		}
+		files[name] = sum.info(name)
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("%w: %s is missing", ErrBackupCorrupted, BackupManifestName)
+	}
+
+	return m, m.verify(files)
+}
+
+// verifyRestoredBackup checks the backup extracted into dir against its manifest and removes the manifest, dir
+// being the dir of a db from then on. The archives written before the manifests have none and are not checked.
+func verifyRestoredBackup(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, BackupManifestName)); os.IsNotExist(err) {
+		return nil
+	}
+
+	if _, err := verifyBackupDir(dir); err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Join(dir, BackupManifestName))
+}
+
+// fileChecksum sums the bytes written to it.
+type fileChecksum struct {
+	crc  hash.Hash32
+	sha  hash.Hash
+	size int64
+}
+
+func newFileChecksum() *fileChecksum {
+	return &fileChecksum{crc: crc32.NewIEEE(), sha: sha256.New()}
+}
+
+func (c *fileChecksum) Write(p []byte) (int, error) {
+	_, _ = c.crc.Write(p)
+	_, _ = c.sha.Write(p)
+	c.size += int64(len(p))
+	return len(p), nil
+}
+
+func (c *fileChecksum) info(name string) BackupFileInfo {
+	return BackupFileInfo{Name: name, Size: c.size, CRC32: c.crc.Sum32(), SHA256: hex.EncodeToString(c.sha.Sum(nil))}
+}
+
+// writeFileSync writes data to the file at path and syncs it.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
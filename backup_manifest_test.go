@@ -0,0 +1,170 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flipByte flips a byte of the file at path.
+func flipByte(t *testing.T, path string, off int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+}
+
+// flipArchivedByte returns the tar.gz archive with a byte of the file named name flipped.
+func flipArchivedByte(t *testing.T, archive []byte, name string, off int) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		if path.Base(header.Name) == name {
+			data[off] ^= 0xff
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	return buf.Bytes()
+}
+
+func TestVerifyBackup(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 8 * KB
+	defer os.RemoveAll(opt.Dir)
+	backupDir, _ := ioutil.TempDir("", "nutsdb-backup")
+	defer os.RemoveAll(backupDir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	fillContentHashDB(t, db, false)
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "kv", GetTestBytes(i), make([]byte, 256), Persistent, nil)
+	}
+	require.Greater(t, db.ActiveFile.fileID, int64(1))
+
+	t.Run("dir", func(t *testing.T) {
+		dir := filepath.Join(backupDir, "dir")
+		require.NoError(t, db.Backup(dir))
+
+		info, err := VerifyBackup(dir)
+		require.NoError(t, err)
+		require.Equal(t, opt.SegmentSize, info.SegmentSize)
+		require.Equal(t, MetaVersionExpireAt, info.DataVersion)
+		require.Equal(t, dataFileName(0), info.Files[0].Name)
+		require.Equal(t, dataFileName(int(db.ActiveFile.fileID)), info.Files[db.ActiveFile.fileID].Name)
+
+		// the corrupted file is named.
+		flipByte(t, filepath.Join(dir, dataFileName(1)), 100)
+		_, err = VerifyBackup(dir)
+		require.True(t, errors.Is(err, ErrBackupCorrupted))
+		require.Contains(t, err.Error(), dataFileName(1))
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, db.BackupTarGZ(&buf))
+		archive := filepath.Join(backupDir, "backup.tar.gz")
+		require.NoError(t, ioutil.WriteFile(archive, buf.Bytes(), 0644))
+
+		info, err := VerifyBackup(archive)
+		require.NoError(t, err)
+		require.Equal(t, dataFileName(int(db.ActiveFile.fileID)), info.Files[db.ActiveFile.fileID].Name)
+
+		corrupted := flipArchivedByte(t, buf.Bytes(), dataFileName(1), 100)
+		require.NoError(t, ioutil.WriteFile(archive, corrupted, 0644))
+		_, err = VerifyBackup(archive)
+		require.True(t, errors.Is(err, ErrBackupCorrupted))
+		require.Contains(t, err.Error(), dataFileName(1))
+
+		// the restore fails before anything is written.
+		dir := filepath.Join(backupDir, "restored")
+		err = RestoreTarGZ(bytes.NewReader(corrupted), dir)
+		require.True(t, errors.Is(err, ErrBackupCorrupted))
+		_, err = os.Stat(dir)
+		require.True(t, os.IsNotExist(err))
+
+		// the manifest is not left in the db restored.
+		require.NoError(t, RestoreTarGZ(bytes.NewReader(buf.Bytes()), dir))
+		_, err = os.Stat(filepath.Join(dir, BackupManifestName))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		dir := filepath.Join(backupDir, "nomanifest")
+		require.NoError(t, db.Backup(dir))
+		require.NoError(t, os.Remove(filepath.Join(dir, BackupManifestName)))
+
+		_, err := VerifyBackup(dir)
+		require.True(t, errors.Is(err, ErrBackupCorrupted))
+	})
+}
+
+func TestDB_Verify(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 8 * KB
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "kv", GetTestBytes(i), make([]byte, 256), Persistent, nil)
+	}
+	require.Greater(t, db.ActiveFile.fileID, int64(1))
+	require.NoError(t, db.Verify())
+
+	// a byte of the value of the first entry of a rotated file is flipped on the disk.
+	flipByte(t, filepath.Join(opt.Dir, dataFileName(1)), DataEntryHeaderSize+DataEntryExpireAtSize+100)
+	err = db.Verify()
+	require.True(t, errors.Is(err, ErrCrc))
+	require.Contains(t, err.Error(), dataFileName(1)+" at offset 0")
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// backupFile is a file of the db to back up, the first size bytes of it.
+type backupFile struct {
+	name   string // the path relative to the dir of the db
+	info   os.FileInfo
+	size   int64
+	fileID int64 // the ID of the data file, -1 for the other files
+	active bool
+}
+
+// backupSnapshot is the cut of the files of the db a backup copies: the files of the dir as they are when the
+// backup starts, the active file as far as it's committed then. The data files are only appended to, so they
+// are copied without holding the db lock while the writes go on, and merge is refused with ErrIsBackingUp
+// until the snapshot is released so that they aren't removed meanwhile.
+type backupSnapshot struct {
+	db    *DB
+	files []*backupFile
+}
+
+// takeBackupSnapshot takes the db lock just long enough to list the files and the committed size of the
+// active file.
+func (db *DB) takeBackupSnapshot() (*backupSnapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if db.isMerging {
+		return nil, ErrIsMerging
+	}
+
+	s := &backupSnapshot{db: db}
+	err := filepath.Walk(db.opt.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(db.opt.Dir, path)
+		if err != nil {
+			return err
+		}
+
+		// the manifest restored along with the backup is written again.
+		if name == BackupManifestName {
+			return nil
+		}
+
+		f := &backupFile{name: name, info: info, size: info.Size(), fileID: -1}
+		if !info.IsDir() && filepath.Dir(name) == "." {
+			if id, ok := parseDataFileName(info.Name()); ok {
+				f.fileID = int64(id)
+			}
+		}
+		if db.ActiveFile != nil && f.fileID == db.ActiveFile.fileID {
+			f.size = db.ActiveFile.writeOff
+			f.active = true
+		}
+		s.files = append(s.files, f)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&db.runningBackups, 1)
+
+	return s, nil
+}
+
+// release lets merge run again.
+func (s *backupSnapshot) release() {
+	atomic.AddInt64(&s.db.runningBackups, -1)
+}
+
+// path returns the path of the file in the dir of the db.
+func (s *backupSnapshot) path(f *backupFile) string {
+	return filepath.Join(s.db.opt.Dir, f.name)
+}
+
+// check returns an error if the copy of the file is torn, only the active file can be, see verifyDataFile.
+func (s *backupSnapshot) check(f *backupFile) error {
+	if !f.active {
+		return nil
+	}
+
+	return verifyDataFile(s.path(f), f.name, f.size, true, s.db.opt.BufferSizeOfRecovery)
+}
+
+// vanished returns if the file the snapshot listed is removed since and can be left out of the backup, e.g. the
+// starting marker, the data files can't be removed.
+func (s *backupSnapshot) vanished(f *backupFile) bool {
+	if f.fileID >= 0 {
+		return false
+	}
+
+	_, err := os.Stat(s.path(f))
+	return os.IsNotExist(err)
+}
+
+// copyTo copies the files of the snapshot into dir, with the manifest of the backup.
+func (s *backupSnapshot) copyTo(dir string) error {
+	manifest := s.db.newBackupManifest()
+	for _, f := range s.files {
+		dst := filepath.Join(dir, f.name)
+		if f.info.IsDir() {
+			if err := os.MkdirAll(dst, f.info.Mode().Perm()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.check(f); err != nil {
+			return err
+		}
+		sum := newFileChecksum()
+		if err := copyFilePrefix(s.path(f), dst, f.info.Mode().Perm(), f.size, sum); err != nil {
+			if os.IsNotExist(err) && s.vanished(f) {
+				continue
+			}
+			return err
+		}
+		manifest.add(f.name, sum)
+	}
+
+	data, err := manifest.encode()
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(dir, BackupManifestName), data); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// writeArchive writes the files of the snapshot accepted by filter to w as a tar archive compressed as opts tell,
+// under the base name of the dir of the db. A nil filter writes all the files, followed by the manifest of the
+// backup.
+func (s *backupSnapshot) writeArchive(w io.Writer, opts ArchiveOptions, filter func(f *backupFile) bool) error {
+	cw, err := newArchiveWriter(w, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeTar(cw, filter); err != nil {
+		_ = cw.Close()
+		return err
+	}
+
+	return cw.Close()
+}
+
+// writeTar writes the files of the snapshot accepted by filter to w as a tar archive, see writeArchive.
+func (s *backupSnapshot) writeTar(w io.Writer, filter func(f *backupFile) bool) error {
+	tw := tar.NewWriter(w)
+
+	manifest := s.db.newBackupManifest()
+	baseDir := filepath.Base(s.db.opt.Dir)
+	for _, f := range s.files {
+		if filter != nil && !filter(f) {
+			continue
+		}
+		if err := s.check(f); err != nil {
+			return err
+		}
+
+		sum := newFileChecksum()
+		if err := tarAddFile(tw, filepath.Join(baseDir, f.name), s.path(f), f.info, f.size, sum); err != nil {
+			if os.IsNotExist(err) && s.vanished(f) {
+				continue
+			}
+			return err
+		}
+		if !f.info.IsDir() {
+			manifest.add(f.name, sum)
+		}
+	}
+
+	if filter == nil {
+		data, err := manifest.encode()
+		if err != nil {
+			return err
+		}
+		if err := tarAddBytes(tw, filepath.Join(baseDir, BackupManifestName), data); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// copyFilePrefix copies the first size bytes of the file src to dst and syncs it, writing them to sum too.
+func copyFilePrefix(src, dst string, perm os.FileMode, size int64, sum io.Writer) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.CopyN(io.MultiWriter(out, sum), in, size); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stallingWriter calls stall before the first write to w.
+type stallingWriter struct {
+	w     io.Writer
+	once  sync.Once
+	stall func()
+}
+
+func (s *stallingWriter) Write(p []byte) (int, error) {
+	s.once.Do(s.stall)
+	return s.w.Write(p)
+}
+
+// requirePrefixConsistent checks that the db holds the keys written before "last" and none written after it.
+func requirePrefixConsistent(t *testing.T, opt Options) int {
+	var (
+		last    int
+		entries Entries
+	)
+	withDBOption(t, opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get("meta", []byte("last"))
+			if err != nil {
+				return err
+			}
+			if last, err = strconv.Atoi(string(e.Value)); err != nil {
+				return err
+			}
+			entries, err = tx.GetAll("kv")
+			return err
+		}))
+	})
+
+	require.Len(t, entries, last+1)
+	for i, e := range entries {
+		require.Equal(t, fmt.Sprintf("key-%08d", i), string(e.Key))
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 100), e.Value)
+	}
+
+	return last
+}
+
+func TestDB_BackupConcurrentWrites(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 64 * KB
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var (
+		committed int64 = -1
+		stop            = make(chan struct{})
+		done            = make(chan error)
+	)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				close(done)
+				return
+			default:
+			}
+
+			err := db.Update(func(tx *Tx) error {
+				if err := tx.Put("kv", []byte(fmt.Sprintf("key-%08d", i)), bytes.Repeat([]byte{byte(i)}, 100), Persistent); err != nil {
+					return err
+				}
+				return tx.Put("meta", []byte("last"), []byte(strconv.Itoa(i)), Persistent)
+			})
+			if err != nil {
+				done <- err
+				return
+			}
+			atomic.StoreInt64(&committed, int64(i))
+		}
+	}()
+
+	// the writes go on while the backup copies the files, and merge waits for it.
+	commitsWhileCopying := func() {
+		from := atomic.LoadInt64(&committed)
+		deadline := time.Now().Add(10 * time.Second)
+		for atomic.LoadInt64(&committed) < from+100 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		require.GreaterOrEqual(t, atomic.LoadInt64(&committed), from+100)
+		require.Equal(t, ErrIsBackingUp, db.Merge())
+	}
+
+	for atomic.LoadInt64(&committed) < 1000 {
+		time.Sleep(time.Millisecond)
+	}
+	var archive bytes.Buffer
+	require.NoError(t, db.BackupTarGZ(&stallingWriter{w: &archive, stall: commitsWhileCopying}))
+
+	backupDir, _ := ioutil.TempDir("", "nutsdb_backup")
+	defer os.RemoveAll(backupDir)
+	require.NoError(t, db.Backup(backupDir))
+
+	close(stop)
+	require.NoError(t, <-done)
+
+	restoreDir := filepath.Join(backupDir, "restore")
+	require.NoError(t, RestoreTarGZ(&archive, restoreDir))
+	restoreOpt := opt
+	restoreOpt.Dir = restoreDir
+	archived := requirePrefixConsistent(t, restoreOpt)
+	require.GreaterOrEqual(t, archived, 1000)
+
+	backupOpt := opt
+	backupOpt.Dir = backupDir
+	require.GreaterOrEqual(t, requirePrefixConsistent(t, backupOpt), archived+100)
+}
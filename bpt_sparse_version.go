@@ -0,0 +1,238 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// BPTSparseIdxVersion is the format version of the indexes of HintBPTSparseIdxMode. The version 2 length-prefixes
+	// the bucket in the keys of the b+ trees, see getNewKey, and hex-encodes the bucket in the name of the bucket
+	// meta files, so any byte sequence is a valid bucket name. The version 1 had no version file.
+	BPTSparseIdxVersion = 2
+
+	// BPTSparseIdxVersionName is the name of the file holding the format version in the bpt dir.
+	BPTSparseIdxVersionName = "VERSION"
+)
+
+// bptSparseIdxVersion returns the format version of the indexes of HintBPTSparseIdxMode in the dir, 1 if there is
+// no version file.
+func (db *DB) bptSparseIdxVersion() (int, error) {
+	data, err := ioutil.ReadFile(getBPTVersionPath(db.opt.Dir))
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, ErrBPTSparseIdxVersion
+	}
+
+	return version, nil
+}
+
+// checkBPTSparseIdxVersion returns ErrBPTSparseIdxVersion unless the indexes are in the current format, it's
+// used when the db is read-only and can't upgrade them.
+func (db *DB) checkBPTSparseIdxVersion() error {
+	version, err := db.bptSparseIdxVersion()
+	if err != nil {
+		return err
+	}
+	if version != BPTSparseIdxVersion {
+		return ErrBPTSparseIdxVersion
+	}
+
+	return nil
+}
+
+// upgradeBPTSparseIdx rewrites the indexes of HintBPTSparseIdxMode in the current format. The b+ tree and root
+// index of each data file but the active one, which Open indexes in memory, and the bucket meta files are rebuilt
+// from the entries of the data files, and the version file is written last, so an interrupted upgrade is done again
+// by the next Open.
+func (db *DB) upgradeBPTSparseIdx(dataFileIds []int) error {
+	version, err := db.bptSparseIdxVersion()
+	if err != nil {
+		return err
+	}
+	if version == BPTSparseIdxVersion {
+		return nil
+	}
+	if version != 1 {
+		return ErrBPTSparseIdxVersion
+	}
+
+	bucketMetas := make(BucketMetasIdx)
+	for i, fID := range dataFileIds {
+		if err := db.rebuildBPTSparseIdx(int64(fID), i < len(dataFileIds)-1, bucketMetas); err != nil {
+			return err
+		}
+	}
+
+	if err := db.rewriteBucketMetas(bucketMetas); err != nil {
+		return err
+	}
+
+	return db.writeBPTSparseIdxVersion()
+}
+
+// writeBPTSparseIdxVersion writes the version file of the current format.
+func (db *DB) writeBPTSparseIdxVersion() error {
+	fd, err := os.OpenFile(getBPTVersionPath(db.opt.Dir), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err = fd.WriteString(strconv.Itoa(BPTSparseIdxVersion)); err != nil {
+		return err
+	}
+
+	if db.opt.SyncEnable {
+		return fd.Sync()
+	}
+
+	return nil
+}
+
+// rebuildBPTSparseIdx adds the BPTree entries of the data file of fID to bucketMetas, and writes its b+ tree and
+// root index unless writeIdx is false.
+func (db *DB) rebuildBPTSparseIdx(fID int64, writeIdx bool, bucketMetas BucketMetasIdx) error {
+	tree := NewTree()
+	keyPosMap := make(map[string]int64)
+
+	err := db.readDataFileEntries(fID, func(entry *Entry, off int64) error {
+		if entry.Meta.Ds != DataStructureBPTree {
+			return nil
+		}
+
+		bucket := string(entry.Bucket)
+		addBucketMetaKey(bucketMetas, bucket, entry.Key)
+		if !writeIdx {
+			return nil
+		}
+
+		newKey := getNewKey(bucket, entry.Key)
+		keyPosMap[string(newKey)] = off
+		h := NewHint().WithKey(newKey).WithFileId(fID).WithMeta(entry.Meta).WithDataPos(uint64(off))
+		return tree.Insert(newKey, nil, h, CountFlagEnabled)
+	})
+	if err != nil || !writeIdx || tree.root == nil {
+		return err
+	}
+
+	bptPath, rootPath := getBPTPath(fID, db.opt.Dir), getBPTRootPath(fID, db.opt.Dir)
+	for _, path := range []string{bptPath, rootPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	tree.Filepath = bptPath
+	tree.enabledKeyPosMap = true
+	tree.SetKeyPosMap(keyPosMap)
+	if err := tree.WriteNodes(db.opt.RWMode, db.opt.SyncEnable, 1); err != nil {
+		return err
+	}
+
+	rootIdx := &BPTreeRootIdx{
+		rootOff:   uint64(tree.root.Address),
+		fID:       uint64(fID),
+		startSize: uint32(len(tree.FirstKey)),
+		endSize:   uint32(len(tree.LastKey)),
+		start:     tree.FirstKey,
+		end:       tree.LastKey,
+	}
+	_, err = rootIdx.Persistence(rootPath, 0, db.opt.SyncEnable)
+
+	return err
+}
+
+// readDataFileEntries calls f with the entries of the data file of fID and their offsets.
+func (db *DB) readDataFileEntries(fID int64, f func(entry *Entry, off int64) error) error {
+	fr, err := db.openFileRecovery(fID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fr.release()
+	}()
+
+	var off int64
+	for off < db.opt.SegmentSize {
+		entry, err := fr.readEntry()
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrIndexOutOfBound || err == nil && entry == nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := f(entry, off); err != nil {
+			return err
+		}
+		off += entry.Size()
+	}
+
+	return nil
+}
+
+// addBucketMetaKey widens the start and end keys of the bucket in bucketMetas to the key.
+func addBucketMetaKey(bucketMetas BucketMetasIdx, bucket string, key []byte) {
+	keySize := uint32(len(key))
+	bucketMeta, ok := bucketMetas[bucket]
+	if !ok {
+		bucketMetas[bucket] = &BucketMeta{start: key, end: key, startSize: keySize, endSize: keySize}
+		return
+	}
+
+	if compare(bucketMeta.start, key) > 0 {
+		bucketMeta.start = key
+		bucketMeta.startSize = keySize
+	}
+	if compare(bucketMeta.end, key) < 0 {
+		bucketMeta.end = key
+		bucketMeta.endSize = keySize
+	}
+}
+
+// rewriteBucketMetas replaces the bucket meta files with the ones of bucketMetas.
+func (db *DB) rewriteBucketMetas(bucketMetas BucketMetasIdx) error {
+	dir := getBucketMetaPath(db.opt.Dir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(dir + string(os.PathSeparator) + f.Name()); err != nil {
+			return err
+		}
+	}
+
+	for bucket, bucketMeta := range bucketMetas {
+		if err := writeBucketMeta(getBucketMetaFilePath(bucket, db.opt.Dir), bucketMeta, db.opt.SyncEnable); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sparseCollisions are buckets and keys which collide when the bucket and the key are concatenated, with the
+// bucket repeated after the key or not, and buckets which aren't valid file names.
+var sparseCollisions = []struct {
+	bucket string
+	key    []byte
+}{
+	{"x", []byte("yxKxy")},
+	{"xyx", []byte("K")},
+	{"ab", []byte("c")},
+	{"a", []byte("bc")},
+	{"a", []byte("ba")},
+	{"a/b", []byte("k")},
+	{"", []byte("k")},
+	{"\x00\xff", []byte("\x00")},
+	{"\x00", []byte("\xff\x00")},
+	{"..", []byte("k")},
+}
+
+func sparseOptions() Options {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintBPTSparseIdxMode
+	opts.SegmentSize = 1 * KB
+	return opts
+}
+
+func requireSparseCollisions(t *testing.T, db *DB) {
+	for i, c := range sparseCollisions {
+		var value []byte
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get(c.bucket, c.key)
+			if err == nil {
+				value = e.Value
+			}
+			return err
+		}), "bucket %q key %q", c.bucket, c.key)
+		require.Equal(t, GetTestBytes(i), value, "bucket %q key %q", c.bucket, c.key)
+	}
+
+	var keys []string
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("a")
+		for _, e := range entries {
+			keys = append(keys, string(e.Key))
+		}
+		return err
+	}))
+	sort.Strings(keys)
+	require.Equal(t, []string{"ba", "bc"}, keys)
+
+	keys = nil
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, _, err := tx.PrefixScan("x", []byte("y"), 0, 10)
+		for _, e := range entries {
+			keys = append(keys, string(e.Key))
+		}
+		return err
+	}))
+	require.Equal(t, []string{"yxKxy"}, keys)
+}
+
+func TestDB_BPTSparseCollidingBuckets(t *testing.T) {
+	opts := sparseOptions()
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	for i, c := range sparseCollisions {
+		txPut(t, db, c.bucket, c.key, GetTestBytes(i), Persistent, nil)
+	}
+	requireSparseCollisions(t, db)
+
+	// the entries are read from the indexes on disk once the data file is rotated.
+	for i := 0; i < 30; i++ {
+		txPut(t, db, "padding", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+	}
+	requireSparseCollisions(t, db)
+
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	requireSparseCollisions(t, db)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_BPTSparseUpgrade(t *testing.T) {
+	opts := sparseOptions()
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i, c := range sparseCollisions[:5] {
+		txPut(t, db, c.bucket, c.key, GetTestBytes(i), Persistent, nil)
+	}
+	for i := 0; i < 30; i++ {
+		txPut(t, db, "padding", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+	}
+	require.NoError(t, db.Close())
+
+	// the indexes of the version 1 had no version file and named the bucket meta files after the bucket, the index
+	// files are overwritten so only an upgrade reads the entries again.
+	bptDir := getBPTDir(opts.Dir)
+	require.NoError(t, os.Remove(getBPTVersionPath(opts.Dir)))
+	metaFiles, err := ioutil.ReadDir(getBucketMetaPath(opts.Dir))
+	require.NoError(t, err)
+	for _, f := range metaFiles {
+		require.NoError(t, os.Remove(filepath.Join(getBucketMetaPath(opts.Dir), f.Name())))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(getBucketMetaPath(opts.Dir), "a"+BucketMetaSuffix), []byte("v1"), 0644))
+	for _, pattern := range []string{"*" + BPTIndexSuffix, filepath.Join("root", "*"+BPTRootIndexSuffix)} {
+		files, err := filepath.Glob(filepath.Join(bptDir, pattern))
+		require.NoError(t, err)
+		require.NotEmpty(t, files)
+		for _, file := range files {
+			require.NoError(t, ioutil.WriteFile(file, make([]byte, 4*KB), 0644))
+		}
+	}
+
+	// the degraded mode can't upgrade them.
+	degraded := opts
+	degraded.CrashLoopThreshold = 1
+	require.NoError(t, ioutil.WriteFile(filepath.Join(opts.Dir, StartingMarkerName), []byte("1"), 0644))
+	_, err = Open(degraded)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrBPTSparseIdxVersion.Error())
+	require.NoError(t, os.Remove(filepath.Join(opts.Dir, StartingMarkerName)))
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	for i, c := range sparseCollisions[:5] {
+		txGet(t, db, c.bucket, c.key, GetTestBytes(i), nil)
+	}
+	var n int
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("padding")
+		n = len(entries)
+		return err
+	}))
+	require.Equal(t, 30, n)
+	_, err = os.Stat(filepath.Join(getBucketMetaPath(opts.Dir), "a"+BucketMetaSuffix))
+	require.True(t, os.IsNotExist(err))
+
+	version, err := ioutil.ReadFile(getBPTVersionPath(opts.Dir))
+	require.NoError(t, err)
+	require.Equal(t, "2", string(version))
+	require.NoError(t, db.Close())
+
+	// an unknown version isn't read.
+	require.NoError(t, ioutil.WriteFile(getBPTVersionPath(opts.Dir), []byte("3"), 0644))
+	_, err = Open(opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrBPTSparseIdxVersion.Error())
+}
@@ -725,6 +725,35 @@ func (t *BPTree) Insert(key []byte, e *Entry, h *Hint, countFlag bool) error {
 	return t.splitLeaf(leaf, key, pointer)
 }
 
+// Delete removes the key from the b+ tree, and returns if it's found. The leaf is not merged with its
+// siblings, a leaf emptied is kept in the tree and filled again by the inserts.
+func (t *BPTree) Delete(key []byte) bool {
+	leaf := t.FindLeaf(key)
+	if leaf == nil {
+		return false
+	}
+
+	for i := 0; i < leaf.KeysNum; i++ {
+		if compare(key, leaf.Keys[i]) != 0 {
+			continue
+		}
+
+		if r, ok := leaf.pointers[i].(*Record); ok && r.H.Meta.Flag != DataDeleteFlag && t.ValidKeyCount > 0 {
+			t.ValidKeyCount--
+		}
+
+		copy(leaf.Keys[i:], leaf.Keys[i+1:leaf.KeysNum])
+		copy(leaf.pointers[i:], leaf.pointers[i+1:leaf.KeysNum])
+		leaf.KeysNum--
+		leaf.Keys[leaf.KeysNum] = nil
+		leaf.pointers[leaf.KeysNum] = nil
+
+		return true
+	}
+
+	return false
+}
+
 // getSplitIndex returns split index at the given length.
 func getSplitIndex(length int) int {
 	if length%2 == 0 {
@@ -95,6 +95,46 @@ func TestBPTree_Find(t *testing.T) {
 	assert.Equal(t, val, r.E.Value)
 }
 
+func TestBPTree_Delete(t *testing.T) {
+	withBPTree(t, func(t *testing.T, tree *BPTree) {
+		// empty whole leaves, the emptied leaves are still scanned and filled again.
+		for i := 0; i < 100; i++ {
+			if i%10 < 7 {
+				require.True(t, tree.Delete([]byte(fmt.Sprintf(keyFormat, i))))
+			}
+		}
+		require.False(t, tree.Delete([]byte(fmt.Sprintf(keyFormat, 0))))
+		require.False(t, tree.Delete([]byte("missing")))
+		require.Equal(t, 30, tree.ValidKeyCount)
+
+		_, err := tree.Find([]byte(fmt.Sprintf(keyFormat, 0)))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		records, err := tree.All()
+		require.NoError(t, err)
+		require.Len(t, records, 30)
+		for i, r := range records {
+			require.Equal(t, []byte(fmt.Sprintf(keyFormat, i/3*10+7+i%3)), r.H.Key)
+		}
+
+		records, err = tree.Range([]byte(fmt.Sprintf(keyFormat, 10)), []byte(fmt.Sprintf(keyFormat, 29)))
+		require.NoError(t, err)
+		require.Len(t, records, 6)
+
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf(keyFormat, i))
+			require.NoError(t, tree.Insert(key, NewEntry().WithKey(key), NewHint().WithKey(key).WithMeta(NewMetaData().WithFlag(DataSetFlag)), CountFlagEnabled))
+		}
+		require.Equal(t, 100, tree.ValidKeyCount)
+		records, err = tree.All()
+		require.NoError(t, err)
+		require.Len(t, records, 100)
+		for i, r := range records {
+			require.Equal(t, []byte(fmt.Sprintf(keyFormat, i)), r.H.Key)
+		}
+	})
+}
+
 func TestBPTree_PrefixScan(t *testing.T) {
 
 	t.Run("prefix scan in empty b+ tree", func(t *testing.T) {
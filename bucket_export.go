@@ -0,0 +1,415 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+)
+
+// bucketsExportVersion is the version of the format of the buckets export.
+const bucketsExportVersion = 1
+
+// bucketsExportMagic starts the buckets export.
+var bucketsExportMagic = []byte("NUTSBKTS")
+
+// the markers of the buckets export, an item follows exportItem, exportEnd ends the items of a bucket.
+const (
+	exportEnd byte = iota
+	exportItem
+)
+
+// ImportOptions represents the options of DB.ImportBuckets.
+type ImportOptions struct {
+	// Rename maps the name of an exported bucket to the bucket it's imported into, the buckets missing from it
+	// keep their name.
+	Rename map[string]string
+}
+
+// BackupBuckets writes the live items of the buckets of the data structure ds to w, to be loaded by ImportBuckets
+// into another db. The items are read from the indexes, so the export holds no stale or deleted entry, and the
+// items with a ttl carry their expiration, so they expire at the same time once imported. The export is written
+// as: magic | version | ds | buckets | crc32, a bucket being its name, its items and exportEnd.
+// It returns ErrBucketNotFound if one of the buckets doesn't exist.
+func (db *DB) BackupBuckets(w io.Writer, ds uint16, buckets []string) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	if ds != DataStructureBPTree && ds != DataStructureSet && ds != DataStructureSortedSet && ds != DataStructureList {
+		return ErrDataStructureNotSupported
+	}
+
+	var exportErr error
+	err := db.View(func(tx *Tx) error {
+		for _, bucket := range buckets {
+			if !tx.db.hasBucketIndex(ds, bucket) {
+				exportErr = fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+				return nil
+			}
+		}
+
+		e := newSnapshotWriter(w)
+		e.write(bucketsExportMagic)
+		e.putUint32(bucketsExportVersion)
+		e.putUint32(uint32(ds))
+		e.putUint32(uint32(len(buckets)))
+		for _, bucket := range buckets {
+			e.putBytes([]byte(bucket))
+
+			var err error
+			switch ds {
+			case DataStructureBPTree:
+				err = exportBPTree(tx, e, bucket)
+			case DataStructureSet:
+				err = exportSet(tx, e, bucket)
+			case DataStructureSortedSet:
+				exportSortedSet(tx, e, bucket)
+			case DataStructureList:
+				err = exportList(tx, e, bucket)
+			}
+			if err != nil {
+				return err
+			}
+			e.write([]byte{exportEnd})
+		}
+		e.putUint32Unhashed(e.crc.Sum32())
+
+		return e.flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	return exportErr
+}
+
+// ImportBuckets loads the buckets written by BackupBuckets in a single transaction, under the names given by
+// ImportOptions.Rename. The values of the BPTree keys and the scores of the sorted set members already in the db
+// are overwritten, the set members and the list items are added to the ones already there. The items which have
+// expired since the export are left out, the others keep their expiration.
+func (db *DB) ImportBuckets(r io.Reader, opts ImportOptions) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	if err := importBuckets(tx, newSnapshotReader(r, math.MaxInt32), opts); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// hasBucketIndex returns if there is an index for the bucket of the data structure ds.
+func (db *DB) hasBucketIndex(ds uint16, bucket string) (ok bool) {
+	switch ds {
+	case DataStructureBPTree:
+		_, ok = db.BPTreeIdx[bucket]
+	case DataStructureSet:
+		_, ok = db.SetIdx[bucket]
+	case DataStructureSortedSet:
+		_, ok = db.SortedSetIdx[bucket]
+	case DataStructureList:
+		_, ok = db.Index.list[bucket]
+	}
+
+	return ok
+}
+
+// exportBPTree writes the live keys of the bucket: key | value | expiration in milliseconds, 0 if persistent.
+func exportBPTree(tx *Tx, e *snapshotWriter, bucket string) error {
+	it := NewIterator(tx, bucket, IteratorOptions{})
+	defer it.Close()
+
+	for {
+		ok, err := it.SetNext()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		entry := it.Entry()
+		e.write([]byte{exportItem})
+		e.putBytes(entry.Key)
+		e.putBytes(entry.Value)
+		e.putUint64(entry.Meta.ExpireAtMillis())
+	}
+}
+
+// exportSet writes the live sets of the bucket: key | expiration | members count | members.
+func exportSet(tx *Tx, e *snapshotWriter, bucket string) error {
+	set := tx.db.SetIdx[bucket]
+	for key := range set.M {
+		records, err := set.SMembers(key)
+		if err != nil {
+			continue
+		}
+		values, err := tx.getSetValues(records)
+		if err != nil {
+			return err
+		}
+
+		e.write([]byte{exportItem})
+		e.putBytes([]byte(key))
+		e.putUint64(expireAtMillis(set.TTL[key], set.TimeStamp[key]))
+		e.putUint32(uint32(len(values)))
+		for _, value := range values {
+			e.putBytes(value)
+		}
+	}
+
+	return nil
+}
+
+// exportSortedSet writes the sorted set of the bucket: score type | expiration, then its members in rank order:
+// key | score | int score | value.
+func exportSortedSet(tx *Tx, e *snapshotWriter, bucket string) {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		e.write([]byte{byte(zset.ScoreFloat64)})
+		e.putUint64(0)
+		return
+	}
+
+	e.write([]byte{byte(sortedSet.ScoreType())})
+	e.putUint64(expireAtMillis(sortedSet.TTL()))
+
+	var (
+		nodes  []*zset.SortedSetNode
+		cursor []byte
+	)
+	for {
+		nodes, cursor, _ = sortedSet.Scan(cursor, 1024)
+		for _, node := range nodes {
+			e.write([]byte{exportItem})
+			e.putBytes([]byte(node.Key()))
+			e.putUint64(math.Float64bits(float64(node.Score())))
+			e.putUint64(uint64(node.IntScore()))
+			e.putBytes(node.Value)
+		}
+		if cursor == nil {
+			return
+		}
+	}
+}
+
+// exportList writes the live lists of the bucket: key | expiration | items count | items in the list order.
+func exportList(tx *Tx, e *snapshotWriter, bucket string) error {
+	l := tx.db.Index.list[bucket]
+	for key, items := range l.Items {
+		if l.IsExpire(key) {
+			continue
+		}
+
+		e.write([]byte{exportItem})
+		e.putBytes([]byte(key))
+		e.putUint64(expireAtMillis(l.TTL[key], l.TimeStamp[key]))
+		e.putUint32(uint32(items.Size()))
+		for _, item := range items.Values() {
+			value, err := tx.db.getValueByRecord(item.(*Record))
+			if err != nil {
+				return err
+			}
+			e.putBytes(value)
+		}
+	}
+
+	return nil
+}
+
+// expireAtMillis returns the expiration in milliseconds of the ttl set at the timestamp, 0 if it's Persistent.
+func expireAtMillis(ttl uint32, timestamp uint64) uint64 {
+	if ttl == Persistent {
+		return 0
+	}
+
+	return (timestamp + uint64(ttl)) * 1000
+}
+
+func importBuckets(tx *Tx, r *snapshotReader, opts ImportOptions) error {
+	if !bytes.Equal(r.read(len(bucketsExportMagic)), bucketsExportMagic) || r.uint32() != bucketsExportVersion {
+		return ErrBucketsExport
+	}
+
+	ds := uint16(r.uint32())
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		bucket := string(r.bytes())
+		if renamed, ok := opts.Rename[bucket]; ok {
+			bucket = renamed
+		}
+
+		var err error
+		switch ds {
+		case DataStructureBPTree:
+			err = importBPTree(tx, r, bucket)
+		case DataStructureSet:
+			err = importSet(tx, r, bucket)
+		case DataStructureSortedSet:
+			err = importSortedSet(tx, r, bucket)
+		case DataStructureList:
+			err = importList(tx, r, bucket)
+		default:
+			return ErrBucketsExport
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	sum := r.crc.Sum32()
+	if r.uint32Unhashed() != sum || r.err != nil {
+		return ErrBucketsExport
+	}
+
+	return nil
+}
+
+// nextItem returns if another item of the bucket follows.
+func (r *snapshotReader) nextItem() bool {
+	return r.uint8() == exportItem && r.err == nil
+}
+
+func importBPTree(tx *Tx, r *snapshotReader, bucket string) error {
+	for r.nextItem() {
+		key, value, expireAt := r.bytes(), r.bytes(), r.uint64()
+		if r.err != nil {
+			break
+		}
+
+		var err error
+		if expireAt == 0 {
+			err = tx.put(bucket, key, value, Persistent, DataSetFlag, tx.nowSeconds(), DataStructureBPTree)
+		} else if expireAt > timeMillis(tx.now()) {
+			err = tx.PutWithExpireAt(bucket, key, value, time.Unix(0, int64(expireAt)*int64(time.Millisecond)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func importSet(tx *Tx, r *snapshotReader, bucket string) error {
+	for r.nextItem() {
+		key, expireAt := r.bytes(), r.uint64()
+		members := make([][]byte, 0)
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			members = append(members, r.bytes())
+		}
+		if r.err != nil {
+			break
+		}
+
+		ttl, live := importTTL(tx, expireAt)
+		if !live || len(members) == 0 {
+			continue
+		}
+		if err := tx.SAdd(bucket, key, members...); err != nil {
+			return err
+		}
+		if ttl != Persistent {
+			if err := tx.ExpireSet(bucket, key, ttl); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func importSortedSet(tx *Tx, r *snapshotReader, bucket string) error {
+	scoreType, expireAt := zset.ScoreType(r.uint8()), r.uint64()
+	ttl, live := importTTL(tx, expireAt)
+	if err := tx.setZSetScoreType(bucket, scoreType); err != nil {
+		return err
+	}
+
+	var added bool
+	for r.nextItem() {
+		key, score, intScore, value := r.bytes(), math.Float64frombits(r.uint64()), int64(r.uint64()), r.bytes()
+		if r.err != nil || !live {
+			continue
+		}
+
+		var err error
+		if scoreType == zset.ScoreInt64 {
+			err = tx.ZAddInt(bucket, key, intScore, value)
+		} else {
+			err = tx.ZAdd(bucket, key, score, value)
+		}
+		if err != nil {
+			return err
+		}
+		added = true
+	}
+
+	if added && ttl != Persistent {
+		return tx.ExpireZSet(bucket, ttl)
+	}
+
+	return nil
+}
+
+func importList(tx *Tx, r *snapshotReader, bucket string) error {
+	for r.nextItem() {
+		key, expireAt := r.bytes(), r.uint64()
+		items := make([][]byte, 0)
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			items = append(items, r.bytes())
+		}
+		if r.err != nil {
+			break
+		}
+
+		ttl, live := importTTL(tx, expireAt)
+		if !live || len(items) == 0 {
+			continue
+		}
+		if err := tx.RPush(bucket, key, items...); err != nil {
+			return err
+		}
+		if ttl != Persistent {
+			if err := tx.ExpireList(bucket, key, ttl); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// importTTL returns the ttl in seconds left until the expiration in milliseconds, rounded up, and false if it has
+// passed already.
+func importTTL(tx *Tx, expireAt uint64) (uint32, bool) {
+	if expireAt == 0 {
+		return Persistent, true
+	}
+
+	now := timeMillis(tx.now())
+	if expireAt <= now {
+		return 0, false
+	}
+
+	return uint32((expireAt - now + 999) / 1000), true
+}
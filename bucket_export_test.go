@@ -0,0 +1,183 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BackupBuckets(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	newOpt := func() Options {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.SegmentSize = 8 * KB
+		opt.Clock = clock
+		return opt
+	}
+
+	withDBOption(t, newOpt(), func(t *testing.T, src *DB) {
+		require.NoError(t, src.Update(func(tx *Tx) error {
+			for i := 0; i < 5; i++ {
+				bucket := fmt.Sprintf("tenant%d", i)
+				for j := 0; j < 20; j++ {
+					if err := tx.Put(bucket, GetTestBytes(j), GetTestBytes(i*100+j), Persistent); err != nil {
+						return err
+					}
+				}
+				if err := tx.PutWithTTL(bucket, []byte("session"), []byte("value"), 100*time.Second); err != nil {
+					return err
+				}
+				if err := tx.SAdd(bucket, []byte("set"), []byte("a"), []byte("b")); err != nil {
+					return err
+				}
+				if err := tx.ExpireSet(bucket, []byte("set"), 100); err != nil {
+					return err
+				}
+				if err := tx.RPush(bucket, []byte("list"), []byte("a"), []byte("b"), []byte("c")); err != nil {
+					return err
+				}
+				if err := tx.ExpireList(bucket, []byte("list"), 100); err != nil {
+					return err
+				}
+				if err := tx.ZAdd(bucket, []byte("a"), 1, []byte("a")); err != nil {
+					return err
+				}
+				if err := tx.ZAdd(bucket, []byte("b"), 2, []byte("b")); err != nil {
+					return err
+				}
+				if err := tx.ExpireZSet(bucket, 100); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		txDel(t, src, "tenant1", GetTestBytes(0), nil)
+		txPut(t, src, "tenant1", GetTestBytes(1), []byte("updated"), Persistent, nil)
+		txPut(t, src, "tenant3", []byte("expired"), []byte("value"), 1, nil)
+
+		buckets := []string{"tenant1", "tenant3"}
+		exports := make(map[uint16][]byte)
+		for _, ds := range []uint16{DataStructureBPTree, DataStructureSet, DataStructureList, DataStructureSortedSet} {
+			var buf bytes.Buffer
+			require.NoError(t, src.BackupBuckets(&buf, ds, buckets))
+			exports[ds] = buf.Bytes()
+		}
+		require.ErrorIs(t, src.BackupBuckets(&bytes.Buffer{}, DataStructureBPTree, []string{"missing"}), ErrBucketNotFound)
+		require.ErrorIs(t, src.BackupBuckets(&bytes.Buffer{}, DataStructureNone, buckets), ErrDataStructureNotSupported)
+
+		// the items are imported 40 seconds later.
+		clock.Add(40 * time.Second)
+		withDBOption(t, newOpt(), func(t *testing.T, dst *DB) {
+			// a corrupted export imports nothing.
+			corrupted := append([]byte{}, exports[DataStructureBPTree]...)
+			corrupted[len(corrupted)-10] ^= 0xff
+			require.ErrorIs(t, dst.ImportBuckets(bytes.NewReader(corrupted), ImportOptions{}), ErrBucketsExport)
+			require.ErrorIs(t, dst.ImportBuckets(bytes.NewReader([]byte("garbage")), ImportOptions{}), ErrBucketsExport)
+			require.Empty(t, dst.BPTreeIdx)
+
+			opts := ImportOptions{Rename: map[string]string{"tenant3": "renamed"}}
+			for _, export := range exports {
+				require.NoError(t, dst.ImportBuckets(bytes.NewReader(export), opts))
+			}
+
+			var (
+				values  = make(map[string]string)
+				ttl     = make(map[string]uint32)
+				members = make(map[string]int)
+				absent  []error
+			)
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				for _, bucket := range []string{"tenant1", "renamed"} {
+					entries, err := tx.GetAll(bucket)
+					if err != nil {
+						return err
+					}
+					for _, e := range entries {
+						values[bucket+"/"+string(e.Key)] = string(e.Value)
+					}
+					session, err := tx.Get(bucket, []byte("session"))
+					if err != nil {
+						return err
+					}
+					ttl[bucket+"/session"] = uint32((session.Meta.ExpireAt - timeMillis(tx.now())) / 1000)
+					if ttl[bucket+"/list"], err = tx.GetListTTL(bucket, []byte("list")); err != nil {
+						return err
+					}
+
+					set, err := tx.SMembers(bucket, []byte("set"))
+					if err != nil {
+						return err
+					}
+					list, err := tx.LRange(bucket, []byte("list"), 0, -1)
+					if err != nil {
+						return err
+					}
+					zset, err := tx.ZMembers(bucket)
+					if err != nil {
+						return err
+					}
+					members[bucket+"/set"], members[bucket+"/list"], members[bucket+"/zset"] = len(set), len(list), len(zset)
+				}
+
+				for _, bucket := range []string{"tenant0", "tenant2", "tenant3", "tenant4"} {
+					_, err := tx.Get(bucket, GetTestBytes(1))
+					absent = append(absent, err)
+					_, err = tx.ZMembers(bucket)
+					absent = append(absent, err)
+				}
+				return nil
+			}))
+
+			require.Len(t, values, 19+1+20+1)
+			require.Equal(t, "updated", values["tenant1/"+string(GetTestBytes(1))])
+			require.NotContains(t, values, "tenant1/"+string(GetTestBytes(0)))
+			require.Equal(t, string(GetTestBytes(305)), values["renamed/"+string(GetTestBytes(5))])
+			require.NotContains(t, values, "renamed/expired")
+			for _, bucket := range []string{"tenant1", "renamed"} {
+				require.Equal(t, uint32(60), ttl[bucket+"/session"])
+				require.Equal(t, uint32(60), ttl[bucket+"/list"])
+				require.Equal(t, 2, members[bucket+"/set"])
+				require.Equal(t, 3, members[bucket+"/list"])
+				require.Equal(t, 2, members[bucket+"/zset"])
+			}
+			for _, err := range absent {
+				require.Error(t, err)
+			}
+
+			// the items expire at the time they would have in the source db.
+			clock.Add(61 * time.Second)
+			txGet(t, dst, "renamed", []byte("session"), nil, ErrNotFoundKey)
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				_, err := tx.SMembers("renamed", []byte("set"))
+				absent = []error{err}
+				_, err = tx.LRange("renamed", []byte("list"), 0, -1)
+				absent = append(absent, err)
+				_, err = tx.ZMembers("renamed")
+				absent = append(absent, err)
+				return nil
+			}))
+			for _, err := range absent {
+				require.Error(t, err)
+			}
+		})
+	})
+}
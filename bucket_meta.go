@@ -112,3 +112,22 @@ func ReadBucketMeta(name string) (bucketMeta *BucketMeta, err error) {
 	}
 	return
 }
+
+// writeBucketMeta writes the bucketMeta to the file at the given path name.
+func writeBucketMeta(name string, bucketMeta *BucketMeta, syncEnable bool) error {
+	fd, err := os.OpenFile(filepath.Clean(name), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if _, err = fd.WriteAt(bucketMeta.Encode(), 0); err != nil {
+		return err
+	}
+
+	if syncEnable {
+		return fd.Sync()
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "strings"
+
+// The bucket names form a hierarchy by convention, e.g. "app/tenant-42/cache", the levels are separated by
+// BucketPathSeparator. The buckets are stored under their names as they are, the hierarchy is only used by
+// Tx.IterateBucketsByPrefix and Tx.DeleteBucketTree, which match the names by prefix, so a bucket named with
+// a "/" before the convention is a path like any other.
+//
+// BucketPath escapes the levels holding a separator or BucketPathEscape, so a path built by it is split back
+// into the same levels by SplitBucketPath, and BucketPath(parent...) + BucketPathSeparator is only a prefix
+// of the paths below parent.
+const (
+	// BucketPathSeparator separates the levels of a bucket path.
+	BucketPathSeparator = "/"
+
+	// BucketPathEscape escapes a BucketPathSeparator or BucketPathEscape within a level of a bucket path.
+	BucketPathEscape = `\`
+)
+
+var bucketPathEscaper = strings.NewReplacer(
+	BucketPathEscape, BucketPathEscape+BucketPathEscape,
+	BucketPathSeparator, BucketPathEscape+BucketPathSeparator,
+)
+
+// BucketPath returns the bucket name of the path of the levels, e.g. BucketPath("app", "tenant-42", "cache")
+// returns "app/tenant-42/cache".
+func BucketPath(levels ...string) string {
+	escaped := make([]string, len(levels))
+	for i, level := range levels {
+		escaped[i] = bucketPathEscaper.Replace(level)
+	}
+
+	return strings.Join(escaped, BucketPathSeparator)
+}
+
+// SplitBucketPath returns the levels of the bucket path, the reverse of BucketPath. A dangling escape is kept.
+func SplitBucketPath(bucket string) []string {
+	var (
+		levels []string
+		level  strings.Builder
+	)
+
+	for i := 0; i < len(bucket); i++ {
+		switch {
+		case strings.HasPrefix(bucket[i:], BucketPathEscape) && i+1 < len(bucket):
+			i++
+			level.WriteByte(bucket[i])
+		case strings.HasPrefix(bucket[i:], BucketPathSeparator):
+			levels = append(levels, level.String())
+			level.Reset()
+		default:
+			level.WriteByte(bucket[i])
+		}
+	}
+
+	return append(levels, level.String())
+}
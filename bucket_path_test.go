@@ -0,0 +1,48 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketPath(t *testing.T) {
+	for _, tc := range []struct {
+		levels []string
+		bucket string
+	}{
+		{[]string{"app", "tenant-42", "cache"}, "app/tenant-42/cache"},
+		{[]string{"app"}, "app"},
+		{[]string{"a/b", "c"}, `a\/b/c`},
+		{[]string{`a\`, "b"}, `a\\/b`},
+		{[]string{"", "b", ""}, "/b/"},
+	} {
+		require.Equal(t, tc.bucket, BucketPath(tc.levels...))
+		require.Equal(t, tc.levels, SplitBucketPath(tc.bucket))
+	}
+
+	// a legacy name with a "/" is split at it, a dangling escape is kept.
+	require.Equal(t, []string{"legacy", "name"}, SplitBucketPath("legacy/name"))
+	require.Equal(t, []string{`a\`}, SplitBucketPath(`a\`))
+
+	// the prefix of a parent doesn't match a level holding an escaped separator or escape.
+	parent := BucketPath(`a\`) + BucketPathSeparator
+	require.True(t, strings.HasPrefix(BucketPath(`a\`, "b"), parent))
+	require.False(t, strings.HasPrefix(BucketPath(`a\/b`), parent))
+	require.False(t, strings.HasPrefix(BucketPath(`a`, "b"), parent))
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// bucketQuota is the quota of a bucket set by SetBucketQuota, a limit of 0 is no limit.
+type bucketQuota struct {
+	maxKeys  int64
+	maxBytes int64
+}
+
+// bucketQuotaUsage is the usage of a bucket with a quota counted by a tx: the live entries of the bucket when
+// the tx first writes to it, see BucketStats, and the entries the tx adds since.
+type bucketQuotaUsage struct {
+	keys  int64
+	bytes int64
+	added map[string]int64 // the size of the entries added by the tx, by entry
+}
+
+// SetBucketQuota limits the live entries of the bucket of the data structure ds to maxKeys and their approximate
+// size to maxBytes, counted like BucketStats.LiveKeys and BucketStats.LiveBytes. A limit of 0 or less is no limit,
+// without any the quota is removed.
+// A Put, SAdd, LPush, RPush, LInsert or ZAdd which would exceed the quota fails with ErrBucketQuotaExceeded when
+// it's queued. The entries deleted or expired free the quota, those deleted by a tx once it's committed. The quota
+// is kept with the bucket name like the default ttl, and written as a record, so it's loaded again by Open.
+func (db *DB) SetBucketQuota(ds uint16, bucket string, maxKeys int64, maxBytes int64) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	switch ds {
+	case DataStructureSet, DataStructureSortedSet, DataStructureBPTree, DataStructureList:
+	default:
+		return ErrDataStructureNotSupported
+	}
+
+	return db.Update(func(tx *Tx) error {
+		return tx.putBucketQuota(ds, bucket, bucketQuota{maxKeys: maxKeys, maxBytes: maxBytes}, db.nowSeconds())
+	})
+}
+
+// BucketQuota returns the quota of the bucket of the data structure ds, 0 for no limit.
+func (db *DB) BucketQuota(ds uint16, bucket string) (maxKeys int64, maxBytes int64, err error) {
+	err = db.View(func(tx *Tx) error {
+		quota := tx.db.bucketQuotas[bucketID{ds: ds, bucket: bucket}]
+		maxKeys, maxBytes = quota.maxKeys, quota.maxBytes
+		return nil
+	})
+
+	return maxKeys, maxBytes, err
+}
+
+// putBucketQuota writes the quota of the bucket, the key of the record is the data structure followed by the limits.
+func (tx *Tx) putBucketQuota(ds uint16, bucket string, quota bucketQuota, timestamp uint64) error {
+	key := make([]byte, 18)
+	binary.BigEndian.PutUint16(key, ds)
+	binary.BigEndian.PutUint64(key[2:], uint64(quota.maxKeys))
+	binary.BigEndian.PutUint64(key[10:], uint64(quota.maxBytes))
+
+	return tx.put(bucket, key, nil, Persistent, DataBucketQuotaFlag, timestamp, DataStructureNone)
+}
+
+// setBucketQuota applies the record written by putBucketQuota.
+func (db *DB) setBucketQuota(bucket string, key []byte) {
+	if len(key) != 18 {
+		return
+	}
+
+	id := bucketID{ds: binary.BigEndian.Uint16(key), bucket: bucket}
+	quota := bucketQuota{maxKeys: int64(binary.BigEndian.Uint64(key[2:])), maxBytes: int64(binary.BigEndian.Uint64(key[10:]))}
+	if quota.maxKeys < 0 {
+		quota.maxKeys = 0
+	}
+	if quota.maxBytes < 0 {
+		quota.maxBytes = 0
+	}
+
+	if quota.maxKeys > 0 || quota.maxBytes > 0 {
+		db.bucketQuotas[id] = quota
+	} else {
+		delete(db.bucketQuotas, id)
+	}
+}
+
+// mergeBucketQuotas writes the quotas into the new active file, the records of the merged files are dropped
+// with them.
+func (db *DB) mergeBucketQuotas(tx *Tx) error {
+	timestamp := db.nowSeconds()
+	for id, quota := range db.bucketQuotas {
+		if err := tx.putBucketQuota(id.ds, id.bucket, quota, timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkBucketQuota returns ErrBucketQuotaExceeded if the record to put adds an entry exceeding the quota of its
+// bucket, otherwise the entry is counted in the usage of the tx. The records of merge are not counted.
+func (tx *Tx) checkBucketQuota(bucket string, key, value []byte, meta *MetaData) error {
+	if tx.merging || len(tx.db.bucketQuotas) == 0 {
+		return nil
+	}
+
+	id := bucketID{ds: meta.Ds, bucket: bucket}
+	quota, ok := tx.db.bucketQuotas[id]
+	if !ok {
+		return nil
+	}
+
+	// entry identifies the entry the record adds or replaces, none for a list element, and oldSize is the size
+	// of the live entry it replaces, -1 if there is none.
+	var (
+		entry   string
+		oldSize int64 = -1
+		size          = int64(len(key) + len(value))
+	)
+	switch {
+	case meta.Ds == DataStructureBPTree && meta.Flag == DataSetFlag:
+		entry = string(key)
+		if index, ok := tx.db.BPTreeIdx[bucket]; ok {
+			if r, err := index.Find(key); err == nil && r.H.Meta.Flag != DataDeleteFlag && !tx.db.isExpired(r.H.Meta) {
+				oldSize = int64(r.H.Meta.KeySize + r.H.Meta.ValueSize)
+			}
+		}
+	case meta.Ds == DataStructureSet && meta.Flag == DataSetFlag:
+		// sPut skips the members already in the set.
+		entry = string(getNewKey(string(key), value))
+	case meta.Ds == DataStructureList && (meta.Flag == DataLPushFlag || meta.Flag == DataRPushFlag || meta.Flag == DataLInsertFlag):
+	case meta.Ds == DataStructureSortedSet && (meta.Flag == DataZAddFlag || meta.Flag == DataZAddIntFlag):
+		member := strings.Split(string(key), SeparatorForZSetKey)[0]
+		entry, size = member, int64(len(member)+len(value))
+		if sortedSet, ok := tx.sortedSet(bucket); ok {
+			if node, ok := sortedSet.Dict[member]; ok {
+				oldSize = int64(len(member) + len(node.Value))
+			}
+		}
+	default:
+		return nil
+	}
+
+	usage := tx.bucketQuotaUsage(id)
+	if added, ok := usage.added[entry]; ok {
+		oldSize = added
+	}
+
+	keys, bytes := usage.keys+1, usage.bytes+size
+	if oldSize >= 0 {
+		keys, bytes = usage.keys, usage.bytes+size-oldSize
+	}
+	if quota.maxKeys > 0 && keys > quota.maxKeys || quota.maxBytes > 0 && bytes > quota.maxBytes {
+		return ErrBucketQuotaExceeded
+	}
+
+	usage.keys, usage.bytes = keys, bytes
+	if entry != "" {
+		usage.added[entry] = size
+	}
+
+	return nil
+}
+
+// bucketQuotaUsage returns the usage of the bucket counted by the tx, starting from its live entries.
+func (tx *Tx) bucketQuotaUsage(id bucketID) *bucketQuotaUsage {
+	if usage, ok := tx.quotaUsages[id]; ok {
+		return usage
+	}
+
+	usage := &bucketQuotaUsage{added: make(map[string]int64)}
+	if ok, _ := tx.ExistBucket(id.ds, id.bucket); ok {
+		stats := tx.bucketStats(id.ds, id.bucket)
+		usage.keys, usage.bytes = int64(stats.LiveKeys), stats.LiveBytes
+	}
+
+	if tx.quotaUsages == nil {
+		tx.quotaUsages = make(map[bucketID]*bucketQuotaUsage)
+	}
+	tx.quotaUsages[id] = usage
+
+	return usage
+}
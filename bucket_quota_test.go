@@ -0,0 +1,153 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_SetBucketQuota(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%d", i))
+	}
+
+	require.Equal(t, ErrDataStructureNotSupported, db.SetBucketQuota(DataStructureNone, "tenant", 3, 0))
+	require.NoError(t, db.SetBucketQuota(DataStructureBPTree, "tenant", 3, 0))
+
+	// the writes of a tx are counted together, the one exceeding the quota fails when it's queued.
+	var errs []error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 4; i++ {
+			errs = append(errs, tx.Put("tenant", key(i), []byte("value"), Persistent))
+		}
+		return nil
+	}))
+	require.Equal(t, []error{nil, nil, nil, ErrBucketQuotaExceeded}, errs)
+	txGet(t, db, "tenant", key(3), nil, ErrKeyNotFound)
+
+	// an overwrite doesn't add a key, a delete frees one.
+	txPut(t, db, "tenant", key(0), []byte("new value"), Persistent, nil)
+	txPut(t, db, "tenant", key(3), []byte("value"), Persistent, ErrBucketQuotaExceeded)
+	txPut(t, db, "other", key(3), []byte("value"), Persistent, nil)
+	txDel(t, db, "tenant", key(0), nil)
+	txPut(t, db, "tenant", key(3), []byte("value"), Persistent, nil)
+
+	// the quota is loaded by Open and rewritten by merge, the usage is counted from the entries.
+	reopen()
+	txPut(t, db, "tenant", key(4), []byte("value"), Persistent, ErrBucketQuotaExceeded)
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "other", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+	}
+	require.NoError(t, db.Merge())
+	reopen()
+	maxKeys, maxBytes, err := db.BucketQuota(DataStructureBPTree, "tenant")
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 0}, []int64{maxKeys, maxBytes})
+	txPut(t, db, "tenant", key(4), []byte("value"), Persistent, ErrBucketQuotaExceeded)
+
+	// the expired entries free the quota.
+	txDel(t, db, "tenant", key(3), nil)
+	txPut(t, db, "tenant", key(3), []byte("value"), 1, nil)
+	txPut(t, db, "tenant", key(4), []byte("value"), Persistent, ErrBucketQuotaExceeded)
+	clock.Add(2 * time.Second)
+	txPut(t, db, "tenant", key(4), []byte("value"), Persistent, nil)
+
+	// the bytes are the size of the keys and values of the live entries, 10 bytes each for the 3 keys.
+	require.NoError(t, db.SetBucketQuota(DataStructureBPTree, "tenant", 0, 30))
+	txPut(t, db, "tenant", key(1), []byte("value-"), Persistent, ErrBucketQuotaExceeded)
+	txPut(t, db, "tenant", key(1), []byte("val"), Persistent, nil)
+	txPut(t, db, "tenant", key(5), []byte("v"), Persistent, ErrBucketQuotaExceeded)
+	txDel(t, db, "tenant", key(2), nil)
+	txPut(t, db, "tenant", key(5), []byte("value"), Persistent, nil)
+
+	// removing the quota.
+	require.NoError(t, db.SetBucketQuota(DataStructureBPTree, "tenant", 0, 0))
+	txPut(t, db, "tenant", key(6), []byte("value"), Persistent, nil)
+	reopen()
+	txPut(t, db, "tenant", key(7), []byte("value"), Persistent, nil)
+}
+
+func TestDB_SetBucketQuotaCollections(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		for _, ds := range []uint16{DataStructureSet, DataStructureList, DataStructureSortedSet} {
+			require.NoError(t, db.SetBucketQuota(ds, "tenant", 2, 0))
+		}
+
+		var errs []error
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			errs = append(errs,
+				tx.SAdd("tenant", []byte("set"), []byte("a"), []byte("b")),
+				tx.SAdd("tenant", []byte("set"), []byte("a")),
+				tx.SAdd("tenant", []byte("set"), []byte("c")),
+				tx.RPush("tenant", []byte("list"), []byte("a"), []byte("b")),
+				tx.LPush("tenant", []byte("list"), []byte("c")),
+				tx.ZAdd("tenant", []byte("a"), 1, nil),
+				tx.ZAdd("tenant", []byte("b"), 2, nil),
+				tx.ZAdd("tenant", []byte("a"), 3, nil),
+				tx.ZAdd("tenant", []byte("c"), 4, nil),
+			)
+			return nil
+		}))
+		require.Equal(t, []error{nil, nil, ErrBucketQuotaExceeded, nil, ErrBucketQuotaExceeded, nil, nil, nil, ErrBucketQuotaExceeded}, errs)
+
+		// the removals free the quota once they're committed.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SRem("tenant", []byte("set"), []byte("a")); err != nil {
+				return err
+			}
+			if _, err := tx.LPop("tenant", []byte("list")); err != nil {
+				return err
+			}
+			return tx.ZRem("tenant", "a")
+		}))
+		errs = nil
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			errs = append(errs,
+				tx.SAdd("tenant", []byte("set"), []byte("c")),
+				tx.RPush("tenant", []byte("list"), []byte("c")),
+				tx.ZAdd("tenant", []byte("c"), 4, nil),
+			)
+			return nil
+		}))
+		require.Equal(t, []error{nil, nil, nil}, errs)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+)
+
+// SetBucketReadOnly freezes the bucket of the data structure ds, or unfreezes it with readOnly false. The writes
+// to a frozen bucket, including DeleteBucket and RenameBucket, fail with ErrBucketReadOnly when they're queued,
+// the reads are not affected, and merge still rewrites its records. The flag is kept with the bucket name like
+// the default ttl, and written as a record, so it's loaded again by Open.
+func (db *DB) SetBucketReadOnly(ds uint16, bucket string, readOnly bool) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	switch ds {
+	case DataStructureSet, DataStructureSortedSet, DataStructureBPTree, DataStructureList:
+	default:
+		return ErrDataStructureNotSupported
+	}
+
+	return db.Update(func(tx *Tx) error {
+		return tx.putBucketReadOnly(ds, bucket, readOnly, db.nowSeconds())
+	})
+}
+
+// IsBucketReadOnly returns if the bucket of the data structure ds is frozen by SetBucketReadOnly.
+func (db *DB) IsBucketReadOnly(ds uint16, bucket string) (readOnly bool, err error) {
+	err = db.View(func(tx *Tx) error {
+		_, readOnly = tx.db.bucketReadOnly[bucketID{ds: ds, bucket: bucket}]
+		return nil
+	})
+
+	return readOnly, err
+}
+
+// putBucketReadOnly writes the read-only flag of the bucket, the key of the record is the data structure followed
+// by the flag.
+func (tx *Tx) putBucketReadOnly(ds uint16, bucket string, readOnly bool, timestamp uint64) error {
+	key := make([]byte, 3)
+	binary.BigEndian.PutUint16(key, ds)
+	if readOnly {
+		key[2] = 1
+	}
+
+	return tx.put(bucket, key, nil, Persistent, DataBucketReadOnlyFlag, timestamp, DataStructureNone)
+}
+
+// checkBucketWritable returns ErrBucketReadOnly if the record to put writes to a frozen bucket. The records of
+// merge and of the expirer and the bucket metadata are always written.
+func (tx *Tx) checkBucketWritable(bucket string, key []byte, meta *MetaData) error {
+	if tx.merging || tx.expiring || len(tx.db.bucketReadOnly) == 0 {
+		return nil
+	}
+
+	var buckets []bucketID
+	switch meta.Flag {
+	case DataBucketReadOnlyFlag, DataBucketDefaultTTLFlag:
+		if meta.Ds == DataStructureNone {
+			return nil
+		}
+	case DataBucketRenameFlag:
+		if meta.Ds == DataStructureNone {
+			rename := newBucketRename(bucket, key, 0, 0)
+			buckets = append(buckets, bucketID{ds: rename.ds, bucket: rename.from}, bucketID{ds: rename.ds, bucket: rename.to})
+		}
+	}
+	buckets = append(buckets, bucketID{ds: recordDataStructure(meta), bucket: bucket})
+
+	for _, id := range buckets {
+		if _, ok := tx.db.bucketReadOnly[id]; ok {
+			return ErrBucketReadOnly
+		}
+	}
+
+	return nil
+}
+
+// setBucketReadOnly applies the record written by putBucketReadOnly.
+func (db *DB) setBucketReadOnly(bucket string, key []byte) {
+	if len(key) != 3 {
+		return
+	}
+
+	id := bucketID{ds: binary.BigEndian.Uint16(key), bucket: bucket}
+	if key[2] == 1 {
+		db.bucketReadOnly[id] = struct{}{}
+	} else {
+		delete(db.bucketReadOnly, id)
+	}
+}
+
+// mergeBucketReadOnly writes the read-only flags into the new active file, the records of the merged files are
+// dropped with them.
+func (db *DB) mergeBucketReadOnly(tx *Tx) error {
+	timestamp := db.nowSeconds()
+	for id := range db.bucketReadOnly {
+		if err := tx.putBucketReadOnly(id.ds, id.bucket, true, timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
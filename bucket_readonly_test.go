@@ -0,0 +1,101 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_SetBucketReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+
+	txPut(t, db, "frozen", []byte("key"), []byte("value"), Persistent, nil)
+	txSAdd(t, db, "frozen", []byte("set"), []byte("member"), nil)
+	require.Equal(t, ErrDataStructureNotSupported, db.SetBucketReadOnly(DataStructureNone, "frozen", true))
+	require.NoError(t, db.SetBucketReadOnly(DataStructureBPTree, "frozen", true))
+	require.NoError(t, db.SetBucketReadOnly(DataStructureSet, "frozen", true))
+
+	requireFrozen := func() {
+		readOnly, err := db.IsBucketReadOnly(DataStructureBPTree, "frozen")
+		require.NoError(t, err)
+		require.True(t, readOnly)
+
+		// the writes fail when they're queued, before the commit.
+		var errs []error
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			errs = append(errs,
+				tx.Put("frozen", []byte("key"), []byte("new"), Persistent),
+				tx.Delete("frozen", []byte("key")),
+				tx.SAdd("frozen", []byte("set"), []byte("other")),
+				tx.SRem("frozen", []byte("set"), []byte("member")),
+				tx.DeleteBucket(DataStructureBPTree, "frozen"),
+				tx.RenameBucket(DataStructureSet, "frozen", "renamed"),
+			)
+			return nil
+		}))
+		for _, err := range errs {
+			require.Equal(t, ErrBucketReadOnly, err)
+		}
+
+		// the reads and the other buckets are not affected.
+		txGet(t, db, "frozen", []byte("key"), []byte("value"), nil)
+		txSIsMember(t, db, "frozen", []byte("set"), []byte("member"), true)
+		txPut(t, db, "other", []byte("key"), []byte("value"), Persistent, nil)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush("frozen", []byte("list"), []byte("item"))
+		}))
+	}
+	requireFrozen()
+
+	// the flag is loaded by Open, and merge rewrites the frozen bucket and the flag.
+	reopen()
+	requireFrozen()
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "other", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+	}
+	require.NoError(t, db.Merge())
+	reopen()
+	requireFrozen()
+
+	// unfreezing restores the writes.
+	require.NoError(t, db.SetBucketReadOnly(DataStructureBPTree, "frozen", false))
+	txPut(t, db, "frozen", []byte("key"), []byte("new"), Persistent, nil)
+	txSAdd(t, db, "frozen", []byte("set"), []byte("other"), ErrBucketReadOnly)
+
+	reopen()
+	readOnly, err := db.IsBucketReadOnly(DataStructureBPTree, "frozen")
+	require.NoError(t, err)
+	require.False(t, readOnly)
+	txGet(t, db, "frozen", []byte("key"), []byte("new"), nil)
+	txDel(t, db, "frozen", []byte("key"), nil)
+}
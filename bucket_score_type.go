@@ -0,0 +1,134 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"github.com/nutsdb/nutsdb/ds/zset"
+)
+
+// SetZSetScoreType sets the type of the scores of the sorted set stored at bucket. The sorted sets hold
+// float64 scores by default, ZAddInt and the other int64 APIs need ScoreInt64 to be set first. The type of a
+// sorted set which holds members can't be changed, it returns ErrScoreTypeMismatch then. The type is kept with
+// the bucket name like the default ttl, so it outlives DeleteBucket, and written as a record, so it's loaded
+// again by Open.
+func (db *DB) SetZSetScoreType(bucket string, scoreType zset.ScoreType) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	if scoreType != zset.ScoreFloat64 && scoreType != zset.ScoreInt64 {
+		return ErrScoreType
+	}
+
+	return db.Update(func(tx *Tx) error {
+		return tx.setZSetScoreType(bucket, scoreType)
+	})
+}
+
+// ZSetScoreType returns the type of the scores of the sorted set stored at bucket, see SetZSetScoreType.
+func (db *DB) ZSetScoreType(bucket string) (scoreType zset.ScoreType, err error) {
+	err = db.View(func(tx *Tx) error {
+		scoreType = tx.db.bucketScoreTypes[bucket]
+		return nil
+	})
+
+	return scoreType, err
+}
+
+// setZSetScoreType queues the score type of the sorted set stored at bucket if it changes.
+// It returns ErrScoreTypeMismatch if the sorted set holds members, taking the pending writes into account.
+func (tx *Tx) setZSetScoreType(bucket string, scoreType zset.ScoreType) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if tx.zsetScoreType(bucket) == scoreType {
+		return nil
+	}
+	if !tx.zsetEmpty(bucket) {
+		return ErrScoreTypeMismatch
+	}
+
+	return tx.putZSetScoreType(bucket, scoreType, tx.nowSeconds())
+}
+
+// putZSetScoreType writes the score type of the sorted set, the key of the record is the score type.
+func (tx *Tx) putZSetScoreType(bucket string, scoreType zset.ScoreType, timestamp uint64) error {
+	err := tx.put(bucket, []byte{byte(scoreType)}, nil, Persistent, DataBucketScoreTypeFlag, timestamp, DataStructureNone)
+	if err != nil {
+		return err
+	}
+
+	if tx.scoreTypes == nil {
+		tx.scoreTypes = make(map[string]zset.ScoreType)
+	}
+	tx.scoreTypes[bucket] = scoreType
+
+	return nil
+}
+
+// zsetScoreType returns the score type of the sorted set stored at bucket, taking the pending writes into account.
+func (tx *Tx) zsetScoreType(bucket string) zset.ScoreType {
+	if scoreType, ok := tx.scoreTypes[bucket]; ok {
+		return scoreType
+	}
+
+	return tx.db.bucketScoreTypes[bucket]
+}
+
+// zsetEmpty returns if the sorted set stored at bucket holds no member, taking the pending writes into account.
+func (tx *Tx) zsetEmpty(bucket string) bool {
+	for i := len(tx.pendingWrites) - 1; i >= 0; i-- {
+		entry := tx.pendingWrites[i]
+		if string(entry.Bucket) != bucket {
+			continue
+		}
+
+		switch entry.Meta.Flag {
+		case DataZAddFlag, DataZAddIntFlag:
+			return false
+		case DataSortedSetBucketDeleteFlag:
+			return true
+		}
+	}
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	return !ok || sortedSet.Size() == 0
+}
+
+// setBucketScoreType applies the record written by putZSetScoreType.
+func (db *DB) setBucketScoreType(bucket string, key []byte) {
+	if len(key) != 1 {
+		return
+	}
+
+	if scoreType := zset.ScoreType(key[0]); scoreType != zset.ScoreFloat64 {
+		db.bucketScoreTypes[bucket] = scoreType
+	} else {
+		delete(db.bucketScoreTypes, bucket)
+	}
+}
+
+// mergeBucketScoreTypes writes the score types into the new active file, the records of the merged files are
+// dropped with them.
+func (db *DB) mergeBucketScoreTypes(tx *Tx) error {
+	timestamp := db.nowSeconds()
+	for bucket, scoreType := range db.bucketScoreTypes {
+		if err := tx.putZSetScoreType(bucket, scoreType, timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
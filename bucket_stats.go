@@ -0,0 +1,211 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sort"
+)
+
+// BucketStats represents the statistics of a bucket, see DB.BucketStats.
+type BucketStats struct {
+	// LiveKeys represents the number of the live entries of the bucket: the keys of a BPTree bucket,
+	// the members of the sets of a set bucket, the items of the lists of a list bucket and the members
+	// of a sorted set.
+	LiveKeys int
+
+	// LiveBytes represents the approximate size of the live entries, their keys plus their values.
+	LiveBytes int64
+
+	// DeadRecords represents the number of the records of the bucket in the data files which don't hold
+	// a live entry: the overwritten values, the deletes and the expired entries a merge would reclaim,
+	// and the ttl and cap records of the collections.
+	DeadRecords int
+
+	// FileIDs represents the sorted IDs of the data files the live entries reside in. The entries of the
+	// sets, lists and sorted sets are not tracked by file, the files holding any record of their bucket
+	// are returned, which a merge narrows down to the live ones as it rewrites them.
+	FileIDs []int64
+}
+
+// bucketID identifies a bucket of a data structure.
+type bucketID struct {
+	ds     uint16
+	bucket string
+}
+
+// bucketRecords counts the records of each bucket in each data file. It's updated when a record is
+// committed or replayed by Open and when merge removes a data file.
+type bucketRecords map[bucketID]map[int64]int
+
+// add counts the record of the bucket written in the data file of fileID.
+func (b bucketRecords) add(meta *MetaData, bucket string, fileID int64) {
+	id := bucketID{ds: recordDataStructure(meta), bucket: bucket}
+	files, ok := b[id]
+	if !ok {
+		files = make(map[int64]int)
+		b[id] = files
+	}
+	files[fileID]++
+}
+
+// removeFile forgets the records of the data file of fileID.
+func (b bucketRecords) removeFile(fileID int64) {
+	for id, files := range b {
+		delete(files, fileID)
+		if len(files) == 0 {
+			delete(b, id)
+		}
+	}
+}
+
+// rename moves the counts of the bucket of the data structure ds to the new name.
+func (b bucketRecords) rename(ds uint16, from, to string) {
+	fromID, toID := bucketID{ds: ds, bucket: from}, bucketID{ds: ds, bucket: to}
+	for fileID, n := range b[fromID] {
+		files, ok := b[toID]
+		if !ok {
+			files = make(map[int64]int)
+			b[toID] = files
+		}
+		files[fileID] += n
+	}
+	delete(b, fromID)
+}
+
+// recordDataStructure returns the data structure of the bucket of a record, the bucket deletes are written
+// without one.
+func recordDataStructure(meta *MetaData) uint16 {
+	if meta.Ds != DataStructureNone {
+		return meta.Ds
+	}
+
+	switch meta.Flag {
+	case DataSetBucketDeleteFlag:
+		return DataStructureSet
+	case DataSortedSetBucketDeleteFlag:
+		return DataStructureSortedSet
+	case DataBPTreeBucketDeleteFlag:
+		return DataStructureBPTree
+	case DataListBucketDeleteFlag:
+		return DataStructureList
+	}
+
+	return DataStructureNone
+}
+
+// BucketStats returns the statistics of the bucket of the data structure ds. The live entries are counted
+// from the indexes, so it costs a walk of the bucket, and the records on disk from the counts kept as they
+// are written, it doesn't read the data files.
+func (db *DB) BucketStats(ds uint16, bucket string) (*BucketStats, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	var (
+		stats *BucketStats
+		err   error
+	)
+	if viewErr := db.View(func(tx *Tx) error {
+		var ok bool
+		if ok, err = tx.ExistBucket(ds, bucket); err == nil && !ok {
+			err = ErrBucketNotFound
+		}
+		if err == nil {
+			stats = tx.bucketStats(ds, bucket)
+		}
+		return nil
+	}); viewErr != nil {
+		return nil, viewErr
+	}
+
+	return stats, err
+}
+
+func (tx *Tx) bucketStats(ds uint16, bucket string) *BucketStats {
+	var (
+		stats   = &BucketStats{}
+		fileIDs = make(map[int64]struct{})
+		now     = tx.nowSeconds()
+		records = tx.db.bucketRecords[bucketID{ds: ds, bucket: bucket}]
+	)
+
+	addRecord := func(r *Record) {
+		stats.LiveKeys++
+		stats.LiveBytes += int64(r.meta().KeySize) + int64(r.meta().ValueSize)
+	}
+
+	switch ds {
+	case DataStructureBPTree:
+		_, _, pointers := tx.db.BPTreeIdx[bucket].getAll()
+		for _, pointer := range pointers {
+			r := pointer.(*Record)
+			if r.H.Meta.Flag != DataDeleteFlag && !tx.db.isExpired(r.H.Meta) {
+				addRecord(r)
+				fileIDs[r.H.FileID] = struct{}{}
+			}
+		}
+	case DataStructureSet:
+		set := tx.db.SetIdx[bucket]
+		for key, members := range set.M {
+			if set.expiredAt(key, now) {
+				continue
+			}
+			for _, chain := range members.byHash {
+				for _, r := range chain {
+					addRecord(r)
+				}
+			}
+		}
+	case DataStructureList:
+		l := tx.db.Index.getList(bucket)
+		for key, items := range l.Items {
+			if l.expiredAt(key, now) {
+				continue
+			}
+			for _, item := range items.Values() {
+				addRecord(item.(*Record))
+			}
+		}
+	case DataStructureSortedSet:
+		sortedSet, _ := tx.sortedSet(bucket)
+		for key, node := range sortedSet.Dict {
+			stats.LiveKeys++
+			stats.LiveBytes += int64(len(key)) + int64(len(node.Value))
+		}
+	}
+
+	if ds != DataStructureBPTree {
+		for fileID := range records {
+			fileIDs[fileID] = struct{}{}
+		}
+	}
+
+	for _, n := range records {
+		stats.DeadRecords += n
+	}
+	stats.DeadRecords -= stats.LiveKeys
+	if stats.DeadRecords < 0 {
+		stats.DeadRecords = 0
+	}
+
+	for fileID := range fileIDs {
+		stats.FileIDs = append(stats.FileIDs, fileID)
+	}
+	sort.Slice(stats.FileIDs, func(i, j int) bool {
+		return stats.FileIDs[i] < stats.FileIDs[j]
+	})
+
+	return stats
+}
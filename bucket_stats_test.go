@@ -0,0 +1,141 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BucketStats(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	// each entry is 61 bytes, the 17th rolls over to the next data file.
+	opts.SegmentSize = 1 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	requireStats := func(want BucketStats) {
+		stats, err := db.BucketStats(DataStructureBPTree, "bucket")
+		require.NoError(t, err)
+		require.Equal(t, want, *stats)
+	}
+
+	_, err = db.BucketStats(DataStructureBPTree, "bucket")
+	require.Equal(t, ErrBucketNotFound, err)
+
+	// 10 keys of 5 bytes with values of 8 bytes.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			if err := tx.Put("bucket", []byte(fmt.Sprintf("key-%d", i)), []byte("value-00"), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	requireStats(BucketStats{LiveKeys: 10, LiveBytes: 130, FileIDs: []int64{0}})
+
+	// 5 overwrites with values of 16 bytes and 2 deletes, the last one is written in the data file 1.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 5; i++ {
+			if err := tx.Put("bucket", []byte(fmt.Sprintf("key-%d", i)), []byte("value-0000000000"), Persistent); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete("bucket", []byte("key-5")); err != nil {
+			return err
+		}
+		return tx.Delete("bucket", []byte("key-6"))
+	}))
+	afterDeletes := BucketStats{LiveKeys: 8, LiveBytes: 5*21 + 3*13, DeadRecords: 9, FileIDs: []int64{0}}
+	requireStats(afterDeletes)
+
+	// the counts are rebuilt by Open.
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	requireStats(afterDeletes)
+
+	// the merge rewrites the live keys into the data file 2 and removes the others.
+	require.NoError(t, db.Merge())
+	afterMerge := BucketStats{LiveKeys: 8, LiveBytes: 5*21 + 3*13, FileIDs: []int64{2}}
+	requireStats(afterMerge)
+
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	requireStats(afterMerge)
+
+	require.NoError(t, db.Close())
+}
+
+func TestDB_BucketStatsCollections(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd("set", []byte("k"), []byte("a"), []byte("bb"), []byte("ccc")); err != nil {
+				return err
+			}
+			if err := tx.SRem("set", []byte("k"), []byte("a")); err != nil {
+				return err
+			}
+			if err := tx.RPush("list", []byte("k"), []byte("a"), []byte("bb"), []byte("ccc")); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("zset", []byte("m1"), 1, []byte("v")); err != nil {
+				return err
+			}
+			return tx.ZAdd("zset", []byte("m2"), 2, []byte("vv"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if _, err := tx.LPop("list", []byte("k")); err != nil {
+				return err
+			}
+			return tx.ZRem("zset", "m1")
+		}))
+
+		for _, tc := range []struct {
+			ds     uint16
+			bucket string
+			want   BucketStats
+		}{
+			{DataStructureSet, "set", BucketStats{LiveKeys: 2, LiveBytes: 7, DeadRecords: 2, FileIDs: []int64{0}}},
+			{DataStructureList, "list", BucketStats{LiveKeys: 2, LiveBytes: 7, DeadRecords: 2, FileIDs: []int64{0}}},
+			{DataStructureSortedSet, "zset", BucketStats{LiveKeys: 1, LiveBytes: 4, DeadRecords: 2, FileIDs: []int64{0}}},
+		} {
+			stats, err := db.BucketStats(tc.ds, tc.bucket)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, *stats, tc.bucket)
+		}
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.DeleteBucket(DataStructureSet, "set")
+		}))
+		_, err := db.BucketStats(DataStructureSet, "set")
+		require.Equal(t, ErrBucketNotFound, err)
+
+		_, err = db.BucketStats(DataStructureNone, "set")
+		require.Equal(t, ErrDataStructureNotSupported, err)
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+)
+
+// SetBucketDefaultTTL sets the ttl of the entries put into the bucket of the data structure ds with the ttl
+// Persistent, an explicit ttl is kept. Persistent clears the default. Only the BPTree buckets have a default ttl,
+// it's kept with the bucket name, so it applies to a bucket created after it's set and outlives DeleteBucket.
+// The default is written as a record, so it's loaded again by Open.
+func (db *DB) SetBucketDefaultTTL(ds uint16, bucket string, ttl uint32) error {
+	if ds != DataStructureBPTree {
+		return ErrDataStructureNotSupported
+	}
+
+	return db.Update(func(tx *Tx) error {
+		return tx.putBucketDefaultTTL(ds, bucket, ttl, db.nowSeconds())
+	})
+}
+
+// BucketDefaultTTL returns the default ttl of the bucket of the data structure ds, Persistent if there is none.
+func (db *DB) BucketDefaultTTL(ds uint16, bucket string) (ttl uint32, err error) {
+	err = db.View(func(tx *Tx) error {
+		ttl = tx.db.bucketDefaultTTLs[bucketID{ds: ds, bucket: bucket}]
+		return nil
+	})
+
+	return ttl, err
+}
+
+// putBucketDefaultTTL writes the default ttl of the bucket, the key of the record is the data structure followed
+// by the ttl, it's kept by the hints in every EntryIdxMode.
+func (tx *Tx) putBucketDefaultTTL(ds uint16, bucket string, ttl uint32, timestamp uint64) error {
+	key := make([]byte, 6)
+	binary.BigEndian.PutUint16(key, ds)
+	binary.BigEndian.PutUint32(key[2:], ttl)
+
+	return tx.put(bucket, key, nil, Persistent, DataBucketDefaultTTLFlag, timestamp, DataStructureNone)
+}
+
+// entryTTL returns the ttl of an entry put into the BPTree bucket, the default ttl of the bucket replaces Persistent.
+// The ttl is kept if the tx is closed, put returns the error then.
+func (tx *Tx) entryTTL(bucket string, ttl uint32) uint32 {
+	if ttl != Persistent || tx.checkTxIsClosed() != nil {
+		return ttl
+	}
+
+	return tx.db.bucketDefaultTTLs[bucketID{ds: DataStructureBPTree, bucket: bucket}]
+}
+
+// setBucketDefaultTTL applies the record written by putBucketDefaultTTL.
+func (db *DB) setBucketDefaultTTL(bucket string, key []byte) {
+	if len(key) != 6 {
+		return
+	}
+
+	id := bucketID{ds: binary.BigEndian.Uint16(key), bucket: bucket}
+	if ttl := binary.BigEndian.Uint32(key[2:]); ttl != Persistent {
+		db.bucketDefaultTTLs[id] = ttl
+	} else {
+		delete(db.bucketDefaultTTLs, id)
+	}
+}
+
+// mergeBucketDefaultTTLs writes the default ttls into the new active file, the records of the merged files are
+// dropped with them.
+func (db *DB) mergeBucketDefaultTTLs(tx *Tx) error {
+	timestamp := db.nowSeconds()
+	for id, ttl := range db.bucketDefaultTTLs {
+		if err := tx.putBucketDefaultTTL(id.ds, id.bucket, ttl, timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_SetBucketDefaultTTL(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+
+	ttlOf := func(bucket string, key []byte) uint32 {
+		var ttl uint32
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, key)
+			if err == nil {
+				ttl = e.Meta.TTL
+			}
+			return err
+		}))
+		return ttl
+	}
+
+	require.Equal(t, ErrDataStructureNotSupported, db.SetBucketDefaultTTL(DataStructureSet, "sessions", 1800))
+
+	// the default is set before the bucket is created.
+	require.NoError(t, db.SetBucketDefaultTTL(DataStructureBPTree, "sessions", 1800))
+	ttl, err := db.BucketDefaultTTL(DataStructureBPTree, "sessions")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1800), ttl)
+
+	txPut(t, db, "sessions", []byte("default"), []byte("value"), Persistent, nil)
+	txPut(t, db, "sessions", []byte("explicit"), []byte("value"), 60, nil)
+	txPut(t, db, "other", []byte("default"), []byte("value"), Persistent, nil)
+	require.Equal(t, uint32(1800), ttlOf("sessions", []byte("default")))
+	require.Equal(t, uint32(60), ttlOf("sessions", []byte("explicit")))
+	require.Equal(t, Persistent, ttlOf("other", []byte("default")))
+
+	// the default is loaded by Open, and rewritten by merge.
+	reopen()
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "other", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+	}
+	require.NoError(t, db.Merge())
+	reopen()
+	require.Equal(t, uint32(1800), ttlOf("sessions", []byte("default")))
+	require.Equal(t, uint32(60), ttlOf("sessions", []byte("explicit")))
+	txPut(t, db, "sessions", []byte("after-reopen"), []byte("value"), Persistent, nil)
+	require.Equal(t, uint32(1800), ttlOf("sessions", []byte("after-reopen")))
+
+	// the default outlives the bucket.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(DataStructureBPTree, "sessions")
+	}))
+	txPut(t, db, "sessions", []byte("recreated"), []byte("value"), Persistent, nil)
+	require.Equal(t, uint32(1800), ttlOf("sessions", []byte("recreated")))
+
+	// clearing the default.
+	require.NoError(t, db.SetBucketDefaultTTL(DataStructureBPTree, "sessions", Persistent))
+	txPut(t, db, "sessions", []byte("cleared"), []byte("value"), Persistent, nil)
+	require.Equal(t, Persistent, ttlOf("sessions", []byte("cleared")))
+	require.Equal(t, uint32(1800), ttlOf("sessions", []byte("recreated")))
+
+	reopen()
+	ttl, err = db.BucketDefaultTTL(DataStructureBPTree, "sessions")
+	require.NoError(t, err)
+	require.Equal(t, Persistent, ttl)
+	txPut(t, db, "sessions", []byte("cleared-after-reopen"), []byte("value"), Persistent, nil)
+	require.Equal(t, Persistent, ttlOf("sessions", []byte("cleared-after-reopen")))
+}
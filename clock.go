@@ -0,0 +1,94 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "time"
+
+// Clock tells the time to the timestamps of the entries and to the expiration of the keys, see Options.Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// fixedClock is a Clock stopped at a time.
+type fixedClock time.Time
+
+// Now returns the time of the clock.
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// clockNow returns the time of the clock, the wall time if it's nil.
+func clockNow(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+
+	return clock.Now()
+}
+
+// unixSeconds returns the unix time of the clock in seconds.
+func unixSeconds(clock Clock) uint64 {
+	return uint64(clockNow(clock).Unix())
+}
+
+// unixMillis returns the unix time of the clock in milliseconds.
+func unixMillis(clock Clock) uint64 {
+	return timeMillis(clockNow(clock))
+}
+
+// timeMillis returns the unix time of t in milliseconds, 0 for the times before 1970.
+func timeMillis(t time.Time) uint64 {
+	if ms := t.UnixNano() / int64(time.Millisecond); ms > 0 {
+		return uint64(ms)
+	}
+
+	return 0
+}
+
+// nowSeconds returns the unix time of Options.Clock in seconds, the timestamp of the entries written.
+func (db *DB) nowSeconds() uint64 {
+	return unixSeconds(db.opt.Clock)
+}
+
+// nowMillis returns the unix time of Options.Clock in milliseconds.
+func (db *DB) nowMillis() uint64 {
+	return unixMillis(db.opt.Clock)
+}
+
+// isExpired returns if the entry has expired by the time of Options.Clock.
+func (db *DB) isExpired(meta *MetaData) bool {
+	return meta.isExpiredAt(db.nowMillis())
+}
+
+// clock returns Options.Clock of the db of the tx, nil once the tx is closed and its db is gone, so that a write
+// on the closed tx fails with ErrTxClosed.
+func (tx *Tx) clock() Clock {
+	if tx.db == nil {
+		return nil
+	}
+
+	return tx.db.opt.Clock
+}
+
+// now returns the time of Options.Clock.
+func (tx *Tx) now() time.Time {
+	return clockNow(tx.clock())
+}
+
+// nowSeconds returns the unix time of Options.Clock in seconds, the timestamp of the entries written.
+func (tx *Tx) nowSeconds() uint64 {
+	return unixSeconds(tx.clock())
+}
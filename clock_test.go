@@ -0,0 +1,142 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Clock(t *testing.T) {
+	// the clock is far from the wall clock, so that any expiration judged by the wall clock fails the test.
+	start := time.Now().Add(-24 * time.Hour)
+	clock := NewFakeClock(start)
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Put("bptree", []byte("ttl"), []byte("value"), 10); err != nil {
+				return err
+			}
+			if err := tx.PutWithTTL("bptree", []byte("millis"), []byte("value"), 1500*time.Millisecond); err != nil {
+				return err
+			}
+			if err := tx.LPush("list", []byte("key"), []byte("value")); err != nil {
+				return err
+			}
+			if err := tx.SAdd("set", []byte("key"), []byte("value")); err != nil {
+				return err
+			}
+			return tx.ZAdd("zset", []byte("key"), 1, []byte("value"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.ExpireList("list", []byte("key"), 10); err != nil {
+				return err
+			}
+			if err := tx.ExpireSet("set", []byte("key"), 10); err != nil {
+				return err
+			}
+			return tx.ExpireZSet("zset", 10)
+		}))
+
+		// the entries are written at the time of the clock.
+		var (
+			timestamp uint64
+			errs      []error
+		)
+		read := func() {
+			errs = errs[:0]
+			require.NoError(t, db.View(func(tx *Tx) error {
+				e, err := tx.Get("bptree", []byte("ttl"))
+				if err == nil {
+					timestamp = e.Meta.Timestamp
+				}
+				errs = append(errs, err)
+				_, err = tx.Get("bptree", []byte("millis"))
+				errs = append(errs, err)
+				_, err = tx.LRange("list", []byte("key"), 0, -1)
+				errs = append(errs, err)
+				_, err = tx.SMembers("set", []byte("key"))
+				errs = append(errs, err)
+				_, err = tx.ZCard("zset")
+				errs = append(errs, err)
+				return nil
+			}))
+		}
+		read()
+		require.Equal(t, uint64(start.Unix()), timestamp)
+		require.Equal(t, []error{nil, nil, nil, nil, nil}, errs)
+
+		clock.Add(time.Second)
+		read()
+		require.Equal(t, []error{nil, nil, nil, nil, nil}, errs)
+
+		clock.Add(time.Second)
+		read()
+		require.Equal(t, []error{nil, ErrNotFoundKey, nil, nil, nil}, errs)
+
+		clock.Add(10 * time.Second)
+		read()
+		require.Equal(t, ErrNotFoundKey, errs[0])
+		require.Equal(t, ErrNotFoundKey, errs[1])
+		require.Equal(t, ErrListNotFound, errs[2])
+		require.Error(t, errs[3])
+		require.Equal(t, ErrBucket, errs[4])
+
+		// the iterators skip the keys expired by the clock.
+		var keys int
+		require.NoError(t, db.View(func(tx *Tx) error {
+			it := NewIterator(tx, "bptree", IteratorOptions{})
+			for {
+				ok, err := it.SetNext()
+				if err != nil || !ok {
+					return err
+				}
+				keys++
+			}
+		}))
+		require.Equal(t, 0, keys)
+	})
+}
+
+func TestDB_ClockActiveExpire(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.ActiveExpireInterval = 10 * time.Millisecond
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		txPut(t, db, "cache", []byte("key"), []byte("value"), 1, nil)
+
+		// the key is due by the clock only.
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(t, 0, db.Stats().ActiveExpired)
+		require.Equal(t, 1, indexedKeys(t, db, "cache"))
+
+		clock.Add(time.Second)
+		require.Eventually(t, func() bool {
+			return db.Stats().ActiveExpired == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, 0, indexedKeys(t, db, "cache"))
+	})
+}
@@ -0,0 +1,294 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+	"sort"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+)
+
+// HashOptions represents the options of DB.ContentHash.
+type HashOptions struct {
+	// IgnoreTTL represents if the TTL and timestamps are left out of the hash,
+	// e.g. to compare a database with the one its items are re-imported into.
+	IgnoreTTL bool
+}
+
+// the markers of the canonical stream hashed by ContentHash.
+const (
+	contentHashBPTree byte = iota + 1
+	contentHashSet
+	contentHashSortedSet
+	contentHashList
+
+	contentHashBucket
+	contentHashKey
+	contentHashItem
+)
+
+// ContentHash returns the SHA-256 of every live item of the database in a canonical order:
+// the data structures in a fixed order, the buckets and keys sorted, the set members sorted,
+// the sorted set members sorted by key and the list items in the list order.
+// The TTL is normalized to the absolute expiry in seconds.
+// Logically identical databases have the same hash regardless of the file layout,
+// the merge history and the index mode.
+func (db *DB) ContentHash(opts HashOptions) ([]byte, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	w := &contentHasher{h: sha256.New(), opts: opts}
+	err := db.View(func(tx *Tx) error {
+		if err := w.hashBPTree(tx); err != nil {
+			return err
+		}
+		if err := w.hashSet(tx); err != nil {
+			return err
+		}
+		w.hashSortedSet(tx)
+		return w.hashList(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return w.h.Sum(nil), nil
+}
+
+type contentHasher struct {
+	h    hash.Hash
+	opts HashOptions
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func (w *contentHasher) writeMarker(marker byte) {
+	w.buf[0] = marker
+	_, _ = w.h.Write(w.buf[:1])
+}
+
+func (w *contentHasher) writeUint64(v uint64) {
+	binary.BigEndian.PutUint64(w.buf[:8], v)
+	_, _ = w.h.Write(w.buf[:8])
+}
+
+// writeBytes writes the data prefixed by its length, so that the adjacent fields can't be confused.
+func (w *contentHasher) writeBytes(data []byte) {
+	n := binary.PutUvarint(w.buf[:], uint64(len(data)))
+	_, _ = w.h.Write(w.buf[:n])
+	_, _ = w.h.Write(data)
+}
+
+// writeExpiry writes the absolute expiry of the item, 0 for the persistent one.
+func (w *contentHasher) writeExpiry(ttl uint32, timestamp uint64) {
+	if w.opts.IgnoreTTL {
+		return
+	}
+
+	if ttl == Persistent {
+		w.writeUint64(0)
+		return
+	}
+	w.writeUint64(timestamp + uint64(ttl))
+}
+
+// writeEntryExpiry writes the absolute expiry of the entry, the ExpireAt in milliseconds is rounded up to
+// seconds, so that it hashes like the same expiry set by a TTL.
+func (w *contentHasher) writeEntryExpiry(meta *MetaData) {
+	if meta.ExpireAt != 0 && !w.opts.IgnoreTTL {
+		w.writeUint64((meta.ExpireAt + 999) / 1000)
+		return
+	}
+	w.writeExpiry(meta.TTL, meta.Timestamp)
+}
+
+func (w *contentHasher) hashBPTree(tx *Tx) error {
+	w.writeMarker(contentHashBPTree)
+
+	buckets := make([]string, 0, len(tx.db.BPTreeIdx))
+	for bucket := range tx.db.BPTreeIdx {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		if err := w.hashBPTreeBucket(tx, bucket); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *contentHasher) hashBPTreeBucket(tx *Tx, bucket string) error {
+	it := NewIterator(tx, bucket, IteratorOptions{})
+	defer it.Close()
+
+	for i := 0; ; i++ {
+		ok, err := it.SetNext()
+		if err != nil || !ok {
+			return err
+		}
+
+		// the buckets without any live item are left out.
+		if i == 0 {
+			w.writeMarker(contentHashBucket)
+			w.writeBytes([]byte(bucket))
+		}
+
+		entry := it.Entry()
+		w.writeMarker(contentHashKey)
+		w.writeBytes(entry.Key)
+		w.writeBytes(entry.Value)
+		w.writeEntryExpiry(entry.Meta)
+	}
+}
+
+func (w *contentHasher) hashSet(tx *Tx) error {
+	w.writeMarker(contentHashSet)
+
+	buckets := make([]string, 0, len(tx.db.SetIdx))
+	for bucket := range tx.db.SetIdx {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		set := tx.db.SetIdx[bucket]
+		keys := make([]string, 0, len(set.M))
+		for key := range set.M {
+			if set.SCard(key) > 0 {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+
+		w.writeMarker(contentHashBucket)
+		w.writeBytes([]byte(bucket))
+		for _, key := range keys {
+			records, err := set.SMembers(key)
+			if err != nil {
+				return err
+			}
+			values, err := tx.getSetValues(records)
+			if err != nil {
+				return err
+			}
+			sort.Slice(values, func(i, j int) bool {
+				return bytes.Compare(values[i], values[j]) < 0
+			})
+
+			w.writeMarker(contentHashKey)
+			w.writeBytes([]byte(key))
+			w.writeExpiry(set.TTL[key], set.TimeStamp[key])
+			for _, value := range values {
+				w.writeMarker(contentHashItem)
+				w.writeBytes(value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *contentHasher) hashSortedSet(tx *Tx) {
+	w.writeMarker(contentHashSortedSet)
+
+	buckets := make([]string, 0, len(tx.db.SortedSetIdx))
+	for bucket := range tx.db.SortedSetIdx {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		ss, ok := tx.sortedSet(bucket)
+		if !ok || ss.Size() == 0 {
+			continue
+		}
+
+		keys := make([]string, 0, len(ss.Dict))
+		for key := range ss.Dict {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w.writeMarker(contentHashBucket)
+		w.writeBytes([]byte(bucket))
+		w.writeExpiry(ss.TTL())
+		for _, key := range keys {
+			node := ss.Dict[key]
+			w.writeMarker(contentHashKey)
+			w.writeBytes([]byte(key))
+			if ss.ScoreType() == zset.ScoreInt64 {
+				w.writeMarker(byte(zset.ScoreInt64))
+				w.writeUint64(uint64(node.IntScore()))
+			} else {
+				w.writeMarker(byte(zset.ScoreFloat64))
+				w.writeUint64(math.Float64bits(float64(node.Score())))
+			}
+			w.writeBytes(node.Value)
+		}
+	}
+}
+
+func (w *contentHasher) hashList(tx *Tx) error {
+	w.writeMarker(contentHashList)
+
+	buckets := make([]string, 0, len(tx.db.Index.list))
+	for bucket := range tx.db.Index.list {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		l := tx.db.Index.list[bucket]
+		keys := make([]string, 0, len(l.Items))
+		for key, items := range l.Items {
+			if items.Size() > 0 && !l.IsExpire(key) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+
+		w.writeMarker(contentHashBucket)
+		w.writeBytes([]byte(bucket))
+		for _, key := range keys {
+			w.writeMarker(contentHashKey)
+			w.writeBytes([]byte(key))
+			w.writeExpiry(l.TTL[key], l.TimeStamp[key])
+			for _, item := range l.Items[key].Values() {
+				value, err := tx.db.getValueByRecord(item.(*Record))
+				if err != nil {
+					return err
+				}
+				w.writeMarker(contentHashItem)
+				w.writeBytes(value)
+			}
+		}
+	}
+
+	return nil
+}
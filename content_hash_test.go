@@ -0,0 +1,186 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fillContentHashDB writes the same logical content in the forward or reverse order,
+// the reverse order also overwrites and deletes some keys on the way.
+func fillContentHashDB(t *testing.T, db *DB, reverse bool) {
+	order := func(n int) []int {
+		idx := make([]int, n)
+		for i := range idx {
+			idx[i] = i
+			if reverse {
+				idx[i] = n - 1 - i
+			}
+		}
+		return idx
+	}
+
+	for _, i := range order(50) {
+		if reverse {
+			txPut(t, db, "kv", GetTestBytes(i), []byte("stale"), Persistent, nil)
+			txPut(t, db, "kv", GetTestBytes(i+100), GetTestBytes(i), Persistent, nil)
+			txDel(t, db, "kv", GetTestBytes(i+100), nil)
+		}
+		ttl := Persistent
+		if i%5 == 0 {
+			ttl = 3600
+		}
+		txPut(t, db, "kv", GetTestBytes(i), GetTestBytes(i), ttl, nil)
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, i := range order(10) {
+			if err := tx.SAdd("set", []byte("key"), GetTestBytes(i)); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("zset", GetTestBytes(i), float64(i), GetTestBytes(i)); err != nil {
+				return err
+			}
+		}
+		return tx.RPush("list", []byte("key"), GetTestBytes(0), GetTestBytes(1), GetTestBytes(2))
+	}))
+}
+
+func contentHash(t *testing.T, db *DB, opts HashOptions) []byte {
+	hash, err := db.ContentHash(opts)
+	require.NoError(t, err)
+	require.Len(t, hash, 32)
+	return hash
+}
+
+func TestDB_ContentHash(t *testing.T) {
+	newOpt := func(mode EntryIdxMode) Options {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 8 * KB
+		return opt
+	}
+
+	t.Run("clone", func(t *testing.T) {
+		withDBOption(t, newOpt(HintKeyValAndRAMIdxMode), func(t *testing.T, src *DB) {
+			fillContentHashDB(t, src, false)
+
+			withDBOption(t, newOpt(HintKeyAndRAMIdxMode), func(t *testing.T, clone *DB) {
+				fillContentHashDB(t, clone, true)
+
+				// the items with TTL are written at different times.
+				require.Equal(t, contentHash(t, src, HashOptions{IgnoreTTL: true}), contentHash(t, clone, HashOptions{IgnoreTTL: true}))
+			})
+		})
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		opt := newOpt(HintKeyValAndRAMIdxMode)
+		db, err := Open(opt)
+		require.NoError(t, err)
+		fillContentHashDB(t, db, true)
+		want := contentHash(t, db, HashOptions{})
+
+		backupDir, _ := ioutil.TempDir("", "nutsdb_backup")
+		defer os.RemoveAll(backupDir)
+		require.NoError(t, db.Backup(backupDir))
+		require.NoError(t, db.Close())
+
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			require.Equal(t, want, contentHash(t, db, HashOptions{}))
+		})
+
+		withDBOption(t, newOptWithDir(backupDir), func(t *testing.T, db *DB) {
+			require.Equal(t, want, contentHash(t, db, HashOptions{}))
+		})
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		// merge only keeps the entries of the BPTree buckets.
+		withDBOption(t, newOpt(HintKeyValAndRAMIdxMode), func(t *testing.T, db *DB) {
+			for i := 0; i < 100; i++ {
+				value := append(GetTestBytes(i), make([]byte, 512)...)
+				txPut(t, db, "kv", GetTestBytes(i%20), value, uint32(i%3)*3600, nil)
+			}
+			want := contentHash(t, db, HashOptions{})
+
+			require.NoError(t, db.Merge())
+			require.Equal(t, want, contentHash(t, db, HashOptions{}))
+		})
+	})
+
+	t.Run("expire at", func(t *testing.T) {
+		now := time.Unix(1700000000, 0)
+		withClock := func(mode EntryIdxMode) Options {
+			opt := newOpt(mode)
+			opt.Clock = NewFakeClock(now)
+			return opt
+		}
+
+		withDBOption(t, withClock(HintKeyValAndRAMIdxMode), func(t *testing.T, ttlDB *DB) {
+			txPut(t, ttlDB, "kv", GetTestBytes(0), GetTestBytes(0), 3600, nil)
+
+			withDBOption(t, withClock(HintKeyAndRAMIdxMode), func(t *testing.T, expireAtDB *DB) {
+				require.NoError(t, expireAtDB.Update(func(tx *Tx) error {
+					return tx.PutWithExpireAt("kv", GetTestBytes(0), GetTestBytes(0), now.Add(time.Hour))
+				}))
+
+				require.Equal(t, contentHash(t, ttlDB, HashOptions{}), contentHash(t, expireAtDB, HashOptions{}))
+			})
+		})
+	})
+
+	t.Run("mutation", func(t *testing.T) {
+		withDBOption(t, newOpt(HintKeyValAndRAMIdxMode), func(t *testing.T, db *DB) {
+			fillContentHashDB(t, db, false)
+
+			mutations := []func(tx *Tx) error{
+				func(tx *Tx) error { return tx.Put("kv", GetTestBytes(1), []byte("changed"), Persistent) },
+				func(tx *Tx) error { return tx.Put("kv", GetTestBytes(1), []byte("changed"), 7200) },
+				func(tx *Tx) error { return tx.Delete("kv", GetTestBytes(2)) },
+				func(tx *Tx) error { return tx.Put("kv2", GetTestBytes(2), GetTestBytes(2), Persistent) },
+				func(tx *Tx) error { return tx.SAdd("set", []byte("key"), []byte("member")) },
+				func(tx *Tx) error { return tx.SRem("set", []byte("key"), GetTestBytes(0)) },
+				func(tx *Tx) error { return tx.ExpireSet("set", []byte("key"), 3600) },
+				func(tx *Tx) error { return tx.ZAdd("zset", GetTestBytes(1), 100, GetTestBytes(1)) },
+				func(tx *Tx) error { return tx.ZRem("zset", string(GetTestBytes(2))) },
+				func(tx *Tx) error { return tx.ExpireZSet("zset", 3600) },
+				func(tx *Tx) error { return tx.LPush("list", []byte("key"), []byte("item")) },
+				func(tx *Tx) error { return tx.ExpireList("list", []byte("key"), 3600) },
+			}
+
+			prev := contentHash(t, db, HashOptions{})
+			for i, mutate := range mutations {
+				require.NoError(t, db.Update(mutate))
+				hash := contentHash(t, db, HashOptions{})
+				require.NotEqual(t, prev, hash, "mutation %d", i)
+				prev = hash
+			}
+		})
+	})
+}
+
+func newOptWithDir(dir string) Options {
+	opt := DefaultOptions
+	opt.Dir = dir
+	opt.SegmentSize = 8 * KB
+	return opt
+}
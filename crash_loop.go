@@ -0,0 +1,80 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StartingMarkerName is the file counting the consecutive unclean starts, see Options.CrashLoopThreshold.
+const StartingMarkerName = "nutsdb-starting"
+
+// ErrDegradedMode is reported to Options.ErrorHandler when Open falls back to the degraded mode.
+var ErrDegradedMode = errors.New("too many unclean starts, the db is opened in the degraded read-only mode")
+
+// beginStart counts the start in the marker before the indexes are built, the db is put
+// in the degraded mode if there are Options.CrashLoopThreshold unclean starts already.
+func (db *DB) beginStart() error {
+	if db.opt.CrashLoopThreshold <= 0 {
+		return nil
+	}
+
+	starts := 0
+	path := filepath.Join(db.opt.Dir, StartingMarkerName)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		starts, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if starts >= db.opt.CrashLoopThreshold {
+		db.degraded = true
+		if db.opt.ErrorHandler != nil {
+			db.opt.ErrorHandler.HandleError(fmt.Errorf("%w: %d unclean starts in %s", ErrDegradedMode, starts, db.opt.Dir))
+		}
+	}
+
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(starts+1)), 0644)
+}
+
+// endStart removes the marker after a clean start. In the degraded mode the marker is kept,
+// so that the db is opened in the degraded mode again until ExitDegradedMode is called.
+func (db *DB) endStart() error {
+	if db.opt.CrashLoopThreshold <= 0 || db.degraded {
+		return nil
+	}
+
+	return removeStartingMarker(db.opt.Dir)
+}
+
+// ExitDegradedMode clears the unclean starts after the data files are repaired.
+// The db keeps serving in the degraded mode, close it and Open it again for the full open.
+func (db *DB) ExitDegradedMode() error {
+	return removeStartingMarker(db.opt.Dir)
+}
+
+func removeStartingMarker(dir string) error {
+	err := os.Remove(filepath.Join(dir, StartingMarkerName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CrashLoopDegradedMode(t *testing.T) {
+	bucket := "bucket"
+	var handled []error
+
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opt.SegmentSize = 8 * KB
+	opt.CrashLoopThreshold = 2
+	opt.ErrorHandler = ErrorHandlerFunc(func(err error) {
+		handled = append(handled, err)
+	})
+	defer removeDir(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		txPut(t, db, bucket, GetTestBytes(i), make([]byte, 1024), Persistent, nil)
+	}
+	require.NoError(t, db.Close())
+
+	// a clean start leaves no marker.
+	marker := filepath.Join(opt.Dir, StartingMarkerName)
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err))
+
+	// break the CRC of the first entry of the first data file.
+	path := getDataPath(0, opt.Dir)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	data[DataEntryHeaderSize+len(bucket)] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	for i := 0; i < opt.CrashLoopThreshold; i++ {
+		_, err = Open(opt)
+		require.Error(t, err)
+	}
+	require.Empty(t, handled)
+
+	assertDegraded := func(t *testing.T, db *DB) {
+		require.True(t, db.Stats().Degraded)
+
+		// the keys of the broken file are skipped, the others are served.
+		txGet(t, db, bucket, GetTestBytes(0), nil, ErrKeyNotFound)
+		txGet(t, db, bucket, GetTestBytes(19), make([]byte, 1024), nil)
+
+		_, err := db.Begin(true)
+		require.Equal(t, ErrDBReadOnly, err)
+		require.Equal(t, ErrDBReadOnly, db.Merge())
+	}
+
+	// the db stays degraded until ExitDegradedMode is called.
+	for i := 0; i < 2; i++ {
+		db, err = Open(opt)
+		require.NoError(t, err)
+		assertDegraded(t, db)
+		require.Len(t, handled, i+1)
+		require.True(t, errors.Is(handled[i], ErrDegradedMode))
+		if i == 1 {
+			require.NoError(t, db.ExitDegradedMode())
+		}
+		require.NoError(t, db.Close())
+	}
+
+	// repair the data file and open again.
+	data[DataEntryHeaderSize+len(bucket)] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.False(t, db.Stats().Degraded)
+	txGet(t, db, bucket, GetTestBytes(0), make([]byte, 1024), nil)
+	txPut(t, db, bucket, GetTestBytes(20), GetTestBytes(20), Persistent, nil)
+	require.NoError(t, db.Close())
+	require.Len(t, handled, 2)
+
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err))
+}
@@ -27,6 +27,9 @@ var (
 
 	// ErrCapacity is returned when capacity is error.
 	ErrCapacity = errors.New("capacity error")
+
+	// ErrEntryZero is returned when there is no entry at the position the index points to.
+	ErrEntryZero = errors.New("entry is zero")
 )
 
 const (
@@ -35,6 +38,10 @@ const (
 
 	// DataEntryHeaderSize returns the entry header size
 	DataEntryHeaderSize = 42
+
+	// DataEntryExpireAtSize returns the size of the expiration following the header of the entries of
+	// MetaVersionExpireAt
+	DataEntryExpireAtSize = 8
 )
 
 // DataFile records about data file information.
@@ -73,8 +80,16 @@ func (df *DataFile) ReadAt(off int) (e *Entry, err error) {
 		return nil, nil
 	}
 
+	if e.Meta.HeaderSize() > DataEntryHeaderSize {
+		buf = append(buf, make([]byte, DataEntryExpireAtSize)...)
+		if _, err := df.rwManager.ReadAt(buf[DataEntryHeaderSize:], int64(off+DataEntryHeaderSize)); err != nil {
+			return nil, err
+		}
+		e.ParseExpireAt(buf)
+	}
+
 	meta := e.Meta
-	off += DataEntryHeaderSize
+	off += int(meta.HeaderSize())
 	dataSize := meta.PayloadSize()
 
 	dataBuf := make([]byte, dataSize)
@@ -119,12 +134,22 @@ func (df *DataFile) ReadRecord(off int, payloadSize int64) (e *Entry, err error)
 		return nil, err
 	}
 
-	err = e.ParsePayload(buf[DataEntryHeaderSize:])
+	headerSize := e.Meta.HeaderSize()
+	if headerSize > DataEntryHeaderSize {
+		// the expiration follows the header, the payload is read again after it.
+		buf = make([]byte, headerSize+payloadSize)
+		if _, err := df.rwManager.ReadAt(buf, int64(off)); err != nil {
+			return nil, err
+		}
+		e.ParseExpireAt(buf)
+	}
+
+	err = e.ParsePayload(buf[headerSize:])
 	if err != nil {
 		return nil, err
 	}
 
-	crc := e.GetCrc(buf[:DataEntryHeaderSize])
+	crc := e.GetCrc(buf[:headerSize])
 	if crc != e.Meta.Crc {
 		return nil, ErrCrc
 	}
@@ -0,0 +1,171 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConflictSuffix is the suffix of the data files set aside when reconciling the directory.
+const ConflictSuffix = ".conflict"
+
+// ErrDirectoryInconsistent is returned at Open when the data files in the directory do not
+// map to the file IDs one by one, e.g. after restoring a backup by copying files around.
+var ErrDirectoryInconsistent = errors.New("data directory is inconsistent")
+
+// checkDataFiles checks that every data file in the directory is named by its own file ID,
+// so that no two files claim the same ID and every file is found by getDataPath.
+// If Options.ReconcileDir is set, the inconsistencies are resolved by renaming instead of
+// returning ErrDirectoryInconsistent. Every step is a single rename, so a crash in the
+// middle leaves a directory which is checked and reconciled again at the next Open.
+func (db *DB) checkDataFiles() error {
+	files, err := ioutil.ReadDir(db.opt.Dir)
+	if err != nil {
+		return err
+	}
+
+	var (
+		problems []string
+		invalid  []os.FileInfo
+	)
+	claims := make(map[int][]os.FileInfo)
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || filepath.Ext(name) != DataSuffix {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(name, DataSuffix))
+		if err != nil || id < 0 {
+			problems = append(problems, fmt.Sprintf("%s has no valid file ID", name))
+			invalid = append(invalid, f)
+			continue
+		}
+		claims[id] = append(claims[id], f)
+	}
+
+	ids := make([]int, 0, len(claims))
+	for id, fs := range claims {
+		if len(fs) > 1 || fs[0].Name() != dataFileName(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		fs := claims[id]
+		if len(fs) > 1 {
+			names := make([]string, 0, len(fs))
+			for _, f := range fs {
+				names = append(names, f.Name())
+			}
+			problems = append(problems, fmt.Sprintf("file ID %d is claimed by %s", id, strings.Join(names, ", ")))
+		} else {
+			problems = append(problems, fmt.Sprintf("%s should be named %s", fs[0].Name(), dataFileName(id)))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if !db.opt.ReconcileDir {
+		return fmt.Errorf("%w: %s", ErrDirectoryInconsistent, strings.Join(problems, "; "))
+	}
+
+	// the files kept may hold other records than the ones the sorted set snapshot was taken from.
+	if err := removeSortedSetSnapshot(db.opt.Dir); err != nil {
+		return err
+	}
+
+	for _, f := range invalid {
+		if err := db.setAsideDataFile(f); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if err := db.reconcileFileID(id, claims[id]); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(db.opt.Dir)
+}
+
+// reconcileFileID keeps the newest of the files claiming the file ID under its canonical name,
+// and sets the others aside.
+func (db *DB) reconcileFileID(id int, fs []os.FileInfo) error {
+	name := dataFileName(id)
+	sort.Slice(fs, func(i, j int) bool {
+		if !fs[i].ModTime().Equal(fs[j].ModTime()) {
+			return fs[i].ModTime().After(fs[j].ModTime())
+		}
+		if fs[i].Name() == name || fs[j].Name() == name {
+			return fs[i].Name() == name
+		}
+		return fs[i].Name() < fs[j].Name()
+	})
+
+	for _, f := range fs[1:] {
+		if err := db.setAsideDataFile(f); err != nil {
+			return err
+		}
+	}
+
+	if fs[0].Name() == name {
+		return nil
+	}
+
+	return os.Rename(filepath.Join(db.opt.Dir, fs[0].Name()), filepath.Join(db.opt.Dir, name))
+}
+
+// setAsideDataFile renames the data file with ConflictSuffix so that it is no longer read.
+func (db *DB) setAsideDataFile(f os.FileInfo) error {
+	newName := fmt.Sprintf("%s.%d%s", f.Name(), f.ModTime().UnixNano(), ConflictSuffix)
+	return os.Rename(filepath.Join(db.opt.Dir, f.Name()), filepath.Join(db.opt.Dir, newName))
+}
+
+func dataFileName(id int) string {
+	return strconv.Itoa(id) + DataSuffix
+}
+
+// parseDataFileName returns the ID of the data file named name, false if it's not a data file.
+func parseDataFileName(name string) (int, bool) {
+	id, err := strconv.Atoi(strings.TrimSuffix(name, DataSuffix))
+	if err != nil || id < 0 || name != dataFileName(id) {
+		return 0, false
+	}
+
+	return id, true
+}
+
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	return fd.Sync()
+}
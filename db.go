@@ -16,6 +16,8 @@ package nutsdb
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
@@ -28,7 +30,10 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/bwmarrin/snowflake"
 	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/filesystem"
 	"github.com/xujiajun/utils/strconv2"
@@ -38,6 +43,9 @@ var (
 	// ErrDBClosed is returned when db is closed.
 	ErrDBClosed = errors.New("db is closed")
 
+	// ErrDBReadOnly is returned when writing to the db opened by OpenFS or in the degraded mode.
+	ErrDBReadOnly = errors.New("db is read-only")
+
 	// ErrBucket is returned when bucket is not in the HintIdx.
 	ErrBucket = errors.New("err bucket")
 
@@ -50,12 +58,25 @@ var (
 	// ErrBucketNotFound is returned when looking for bucket that does not exist
 	ErrBucketNotFound = errors.New("bucket not found")
 
+	// ErrBucketExists is returned when renaming a bucket to the name of an existing bucket
+	ErrBucketExists = errors.New("bucket already exists")
+
+	// ErrBucketReadOnly is returned when writing to a bucket frozen by SetBucketReadOnly
+	ErrBucketReadOnly = errors.New("bucket is read-only")
+
+	// ErrBucketQuotaExceeded is returned when a write would exceed the quota set by SetBucketQuota
+	ErrBucketQuotaExceeded = errors.New("bucket quota exceeded")
+
 	// ErrDataStructureNotSupported is returned when pass a not supported data structure
 	ErrDataStructureNotSupported = errors.New("this data structure is not supported for now")
 
 	// ErrNotSupportHintBPTSparseIdxMode is returned not support mode `HintBPTSparseIdxMode`
 	ErrNotSupportHintBPTSparseIdxMode = errors.New("not support mode `HintBPTSparseIdxMode`")
 
+	// ErrBPTSparseIdxVersion is returned when the indexes of the mode `HintBPTSparseIdxMode` are in an unknown format,
+	// or in an old one which can't be upgraded because the db is read-only
+	ErrBPTSparseIdxVersion = errors.New("unsupported format version of the `HintBPTSparseIdxMode` indexes")
+
 	// ErrDirLocked is returned when can't get the file lock of dir
 	ErrDirLocked = errors.New("the dir of db is locked")
 
@@ -64,6 +85,57 @@ var (
 
 	// ErrIsMerging is returned when merge in progress
 	ErrIsMerging = errors.New("merge in progress")
+
+	// ErrIsBackingUp is returned by merge when a backup is copying the data files
+	ErrIsBackingUp = errors.New("backup in progress")
+
+	// ErrTxNotFound is returned when the tx is not alive
+	ErrTxNotFound = errors.New("tx not found")
+
+	// ErrTxNotKillable is returned when killing a read/write tx
+	ErrTxNotKillable = errors.New("only read-only tx can be killed")
+
+	// ErrCloseTimeout is returned when the live transactions are not finished within Options.CloseTimeout
+	ErrCloseTimeout = errors.New("timeout waiting for live transactions when closing db")
+
+	// ErrDBNotClosed is returned when restoring a backup over the db still open.
+	ErrDBNotClosed = errors.New("db is not closed")
+
+	// ErrRestoreDirNotEmpty is returned when restoring a backup into a dir holding files already.
+	ErrRestoreDirNotEmpty = errors.New("the dir to restore to is not empty")
+
+	// ErrRestoreArchive is returned when the archive to restore holds an entry out of the dir of the backup.
+	ErrRestoreArchive = errors.New("unsafe entry in the archive to restore")
+
+	// ErrFullBackupRequired is returned by BackupSince when the data files since the watermark are merged.
+	ErrFullBackupRequired = errors.New("the data files are merged since the watermark, full backup required")
+
+	// ErrRestoreIncrement is returned when the incremental backup doesn't follow the backup restored in the dir.
+	ErrRestoreIncrement = errors.New("the incremental backup doesn't follow the restored backup")
+
+	// ErrBucketsExport is returned when importing a buckets export which is corrupted or not written by BackupBuckets.
+	ErrBucketsExport = errors.New("bad buckets export")
+
+	// ErrImportJSON is returned by ImportJSON for a line which is not a record written by ExportJSON.
+	ErrImportJSON = errors.New("bad json record")
+
+	// ErrBackupCorrupted is returned when a file of the backup doesn't match the manifest of the backup.
+	ErrBackupCorrupted = errors.New("backup corrupted")
+
+	// ErrArchiveCodecNotSupported is returned for a codec of ArchiveOptions nutsdb doesn't know.
+	ErrArchiveCodecNotSupported = errors.New("archive codec not supported")
+
+	// ErrCopyToSelf is returned by CopyTo when the db is copied into itself.
+	ErrCopyToSelf = errors.New("db copied into itself")
+
+	// ErrEntryIdxModeMismatch is returned by Open when the db is written in another EntryIdxMode, see Migrate.
+	ErrEntryIdxModeMismatch = errors.New("db written in another EntryIdxMode")
+
+	// ErrMigrateDirNotEmpty is returned by Migrate when the dir of the destination holds files already.
+	ErrMigrateDirNotEmpty = errors.New("migrate dir not empty")
+
+	// ErrMigrateCountMismatch is returned by Migrate when the destination doesn't hold as many records as the source.
+	ErrMigrateCountMismatch = errors.New("migrated record count mismatch")
 )
 
 const (
@@ -126,6 +198,42 @@ const (
 
 	// DataListBucketDeleteFlag represents that set ttl for the list
 	DataExpireListFlag
+
+	// DataZAddIntFlag represents the data ZAddInt flag
+	DataZAddIntFlag
+
+	// DataLInsertFlag represents the data LInsert flag
+	DataLInsertFlag
+
+	// DataListClearFlag represents that the list is cleared, merge writes it before the live elements of the list
+	DataListClearFlag
+
+	// DataListCapFlag represents the data LCap flag
+	DataListCapFlag
+
+	// DataExpireSetFlag represents that set ttl for the set
+	DataExpireSetFlag
+
+	// DataSetClearFlag represents that the set is emptied, merge writes it before the live members of the set
+	DataSetClearFlag
+
+	// DataExpireZSetFlag represents that set ttl for the sorted set
+	DataExpireZSetFlag
+
+	// DataBucketRenameFlag represents that the bucket is renamed, see Tx.RenameBucket
+	DataBucketRenameFlag
+
+	// DataBucketDefaultTTLFlag represents that the default ttl of the bucket is set, see DB.SetBucketDefaultTTL
+	DataBucketDefaultTTLFlag
+
+	// DataBucketReadOnlyFlag represents that the bucket is frozen or unfrozen, see DB.SetBucketReadOnly
+	DataBucketReadOnlyFlag
+
+	// DataBucketQuotaFlag represents that the quota of the bucket is set, see DB.SetBucketQuota
+	DataBucketQuotaFlag
+
+	// DataBucketScoreTypeFlag represents that the score type of the sorted set is set, see DB.SetZSetScoreType
+	DataBucketScoreTypeFlag
 )
 
 const (
@@ -184,17 +292,76 @@ type (
 		fm                      *fileManager
 		flock                   *flock.Flock
 		commitBuffer            *bytes.Buffer
-		mergeStartCh            chan struct{}
-		mergeEndCh              chan error
+		mergeStartCh            chan MergeOptions
+		mergeEndCh              chan mergeDone
 		mergeWorkCloseCh        chan struct{}
+		liveTxs                 map[uint64]*Tx // the live transactions by Tx.seq
+		liveTxsMu               sync.Mutex
+		liveTxsDone             chan struct{} // closed once no tx is live, see waitLiveTxs
+		txSeq                   uint64        // the last Tx.seq
+		txIDNode                *snowflake.Node
+		txIDNodeErr             error // the error of Options.NodeNum, returned by Begin
+		hotKeys                 *hotKeyProfiler
+		listNotifier            *listNotifier
+		dataFS                  dataFileSystem
+		degraded                bool
+		purgedOnOpen            int
+		sortedSetSnapshot       *sortedSetSnapshot
+		loadedSortedSetSnapshot bool
+		bucketRecords           bucketRecords
+		bucketRenames           []bucketRename
+		bucketDefaultTTLs       map[bucketID]uint32
+		bucketReadOnly          map[bucketID]struct{}
+		bucketQuotas            map[bucketID]bucketQuota
+		bucketScoreTypes        map[string]zset.ScoreType
+		expirer                 *expirer
+		activeExpired           int64
+		commitLockNanos         int64 // the time the commits hold db.mu from the encoding of their entries
+		runningBackups          int64 // the backups copying the files, merge is refused meanwhile
+		expiredNotifier         *expiredNotifier
+	}
+
+	// Stats represents the status of the db.
+	Stats struct {
+		// Degraded represents if the db is opened in the degraded read-only mode, see Options.CrashLoopThreshold.
+		Degraded bool
+
+		// PurgedOnOpen represents how many expired keys and lists are not loaded by Open,
+		// see Options.PurgeExpiredOnOpen.
+		PurgedOnOpen int
+
+		// SortedSetSnapshot represents if Open loaded the sorted sets from the snapshot, see Options.SortedSetSnapshot.
+		SortedSetSnapshot bool
+
+		// ActiveExpired represents how many expired keys are deleted in the background since Open,
+		// see Options.ActiveExpireInterval.
+		ActiveExpired int
+	}
+
+	// TxInfoLite describes a live transaction.
+	TxInfoLite struct {
+		// ID identifies the tx for DB.KillTransaction, it increases in the order the transactions begin.
+		ID        uint64
+		Label     string
+		Writable  bool
+		StartTime time.Time
 	}
 
 	// BucketMetasIdx represents the index of the bucket's meta-information
 	BucketMetasIdx map[string]*BucketMeta
 )
 
-// open returns a newly initialized DB object.
-func open(opt Options) (*DB, error) {
+// dataFileSystem is the read-only file system the data files are read from, see OpenFS.
+type dataFileSystem interface {
+	// dataFileIDs returns the sorted IDs of the data files.
+	dataFileIDs() ([]int, error)
+
+	// openDataFile opens the data file of the file ID for reading.
+	openDataFile(fID int64) (io.ReadCloser, error)
+}
+
+// newDB returns a DB object with the empty indexes, the files are not touched.
+func newDB(opt Options) *DB {
 	db := &DB{
 		BPTreeIdx:               make(BPTreeIdx),
 		SetIdx:                  make(SetIdx),
@@ -210,15 +377,42 @@ func open(opt Options) (*DB, error) {
 		ActiveCommittedTxIdsIdx: NewTree(),
 		Index:                   NewIndex(),
 		fm:                      newFileManager(opt.RWMode, opt.MaxFdNumsInCache, opt.CleanFdsCacheThreshold),
-		mergeStartCh:            make(chan struct{}),
-		mergeEndCh:              make(chan error),
+		mergeStartCh:            make(chan MergeOptions),
+		mergeEndCh:              make(chan mergeDone),
 		mergeWorkCloseCh:        make(chan struct{}),
+		liveTxs:                 make(map[uint64]*Tx),
+		listNotifier:            newListNotifier(),
+		bucketRecords:           make(bucketRecords),
+		bucketDefaultTTLs:       make(map[bucketID]uint32),
+		bucketReadOnly:          make(map[bucketID]struct{}),
+		bucketQuotas:            make(map[bucketID]bucketQuota),
+		bucketScoreTypes:        make(map[string]zset.ScoreType),
+	}
+
+	db.Index.clock = opt.Clock
+
+	// the tx ids are generated by one node, the nodes of the txs begun in the same millisecond would generate the same id.
+	db.txIDNode, db.txIDNodeErr = snowflake.NewNode(opt.NodeNum)
+
+	if opt.HotKeySampleRate > 0 {
+		db.hotKeys = newHotKeyProfiler(opt.HotKeySampleRate, opt.HotKeyPlaintextSize)
+	}
+
+	if opt.OnExpired != nil {
+		db.expiredNotifier = newExpiredNotifier(opt.OnExpired, opt.ErrorHandler)
 	}
 
 	commitBuffer := new(bytes.Buffer)
 	commitBuffer.Grow(int(db.opt.CommitBufferSize))
 	db.commitBuffer = commitBuffer
 
+	return db
+}
+
+// open returns a newly initialized DB object.
+func open(opt Options) (*DB, error) {
+	db := newDB(opt)
+
 	if ok := filesystem.PathIsExist(db.opt.Dir); !ok {
 		if err := os.MkdirAll(db.opt.Dir, os.ModePerm); err != nil {
 			return nil, err
@@ -234,7 +428,8 @@ func open(opt Options) (*DB, error) {
 
 	db.flock = flock
 
-	if err := db.checkEntryIdxMode(); err != nil {
+	if err := checkDirEntryIdxMode(db.opt.Dir, db.opt.EntryIdxMode); err != nil {
+		_ = db.flock.Unlock()
 		return nil, err
 	}
 
@@ -250,11 +445,34 @@ func open(opt Options) (*DB, error) {
 		}
 	}
 
+	if err := db.beginStart(); err != nil {
+		_ = db.flock.Unlock()
+		return nil, err
+	}
+
+	if err := db.checkDataFiles(); err != nil {
+		_ = db.flock.Unlock()
+		return nil, err
+	}
+
+	if opt.ActiveExpireInterval > 0 && opt.EntryIdxMode != HintBPTSparseIdxMode {
+		db.expirer = newExpirer()
+	}
+
 	if err := db.buildIndexes(); err != nil {
+		_ = db.fm.close()
+		_ = db.flock.Unlock()
 		return nil, fmt.Errorf("db.buildIndexes error: %s", err)
 	}
 
+	if err := db.endStart(); err != nil {
+		_ = db.fm.close()
+		_ = db.flock.Unlock()
+		return nil, err
+	}
+
 	go db.mergeWorker()
+	db.startExpireWorker()
 
 	return db, nil
 }
@@ -268,11 +486,14 @@ func Open(options Options, ops ...Option) (*DB, error) {
 	return open(*opts)
 }
 
-func (db *DB) checkEntryIdxMode() error {
+// checkDirEntryIdxMode returns ErrEntryIdxModeMismatch if the db in dir is written in HintBPTSparseIdxMode and
+// mode is another one, or the other way round. The data files are the same in all the modes, but the indexes of
+// HintBPTSparseIdxMode are in the bpt dir next to them, so the db is moved to another mode by Migrate.
+func checkDirEntryIdxMode(dir string, mode EntryIdxMode) error {
 	hasDataFlag := false
 	hasBptDirFlag := false
 
-	files, err := ioutil.ReadDir(db.opt.Dir)
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return err
 	}
@@ -293,12 +514,14 @@ func (db *DB) checkEntryIdxMode() error {
 		}
 	}
 
-	if db.opt.EntryIdxMode != HintBPTSparseIdxMode && hasDataFlag && hasBptDirFlag {
-		return errors.New("not support HintBPTSparseIdxMode switch to the other EntryIdxMode")
+	if mode != HintBPTSparseIdxMode && hasDataFlag && hasBptDirFlag {
+		return fmt.Errorf("%w: %s is written in HintBPTSparseIdxMode, see Migrate to move it to another EntryIdxMode",
+			ErrEntryIdxModeMismatch, dir)
 	}
 
-	if db.opt.EntryIdxMode == HintBPTSparseIdxMode && !hasBptDirFlag && hasDataFlag {
-		return errors.New("not support the other EntryIdxMode switch to HintBPTSparseIdxMode")
+	if mode == HintBPTSparseIdxMode && !hasBptDirFlag && hasDataFlag {
+		return fmt.Errorf("%w: %s is not written in HintBPTSparseIdxMode, see Migrate to move it to HintBPTSparseIdxMode",
+			ErrEntryIdxModeMismatch, dir)
 	}
 
 	return nil
@@ -322,46 +545,210 @@ func (db *DB) View(fn func(tx *Tx) error) error {
 	return db.managed(false, fn)
 }
 
+// Stats returns the status of the db.
+func (db *DB) Stats() Stats {
+	return Stats{
+		Degraded:          db.degraded,
+		PurgedOnOpen:      db.purgedOnOpen,
+		SortedSetSnapshot: db.loadedSortedSetSnapshot,
+		ActiveExpired:     int(atomic.LoadInt64(&db.activeExpired)),
+	}
+}
+
+// isReadOnly returns if the db can't be written, it is opened by OpenFS or in the degraded mode.
+func (db *DB) isReadOnly() bool {
+	return db.dataFS != nil || db.degraded
+}
+
 // Backup copies the database to file directory at the given dir.
+// The files are copied as they are when the backup starts without blocking the writes, see backupSnapshot.
+// The backup holds a manifest of the files to be checked by VerifyBackup.
 func (db *DB) Backup(dir string) error {
-	return db.View(func(tx *Tx) error {
-		return filesystem.CopyDir(db.opt.Dir, dir)
-	})
+	if db.dataFS != nil {
+		return ErrDBReadOnly
+	}
+
+	snapshot, err := db.takeBackupSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+
+	return snapshot.copyTo(dir)
 }
 
 // BackupTarGZ Backup copy the database to writer.
+// The files are copied as they are when the backup starts without blocking the writes, see backupSnapshot.
+// The backup holds a manifest of the files to be checked by VerifyBackup.
 func (db *DB) BackupTarGZ(w io.Writer) error {
-	return db.View(func(tx *Tx) error {
-		return tarGZCompress(w, db.opt.Dir)
-	})
+	return db.BackupArchive(w, ArchiveOptions{})
+}
+
+// BackupArchive copies the database to w as a tar archive compressed with the codec of opts, see ArchiveOptions.
+// BackupTarGZ is BackupArchive with the default options. The archive is restored by RestoreTarGZ and RestoreFrom
+// like the ones of BackupTarGZ.
+func (db *DB) BackupArchive(w io.Writer, opts ArchiveOptions) error {
+	if db.dataFS != nil {
+		return ErrDBReadOnly
+	}
+
+	snapshot, err := db.takeBackupSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+
+	return snapshot.writeArchive(w, opts, nil)
 }
 
 // Close releases all db resources.
+// If Options.CloseTimeout is set and the live transactions are not finished in time,
+// ErrCloseTimeout is returned with the labels of the remaining transactions.
 func (db *DB) Close() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
+	if !db.lockWithTimeout(db.opt.CloseTimeout) {
+		var labels []string
+		for _, info := range db.LiveTransactions() {
+			label := info.Label
+			if label == "" {
+				label = "unlabeled"
+			}
+			labels = append(labels, fmt.Sprintf("%s(id=%d, age=%s)", label, info.ID, time.Since(info.StartTime)))
+		}
+		return fmt.Errorf("%w: %s", ErrCloseTimeout, strings.Join(labels, ", "))
+	}
 	if db.closed {
+		db.mu.Unlock()
 		return ErrDBClosed
 	}
-
 	db.closed = true
+	db.mu.Unlock()
+
+	// the expirer and the OnExpired calls may begin transactions, so they are stopped without the lock,
+	// once the closed db fails the new transactions.
+	db.stopExpireWorker()
+	if db.expiredNotifier != nil {
+		db.expiredNotifier.close()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.listNotifier.close()
+
+	snapshotErr := db.writeSortedSetSnapshot()
 
 	err := db.release()
 	if err != nil {
 		return err
 	}
 
+	return snapshotErr
+}
+
+// lockWithTimeout acquires the db lock, if the timeout is positive it gives up when the live transactions
+// are not finished after timeout. The transactions begun after they are finished are waited for.
+func (db *DB) lockWithTimeout(timeout time.Duration) bool {
+	if timeout > 0 && !db.waitLiveTxs(timeout) {
+		return false
+	}
+
+	db.mu.Lock()
+	return true
+}
+
+// waitLiveTxs waits for the live transactions to finish, it returns false if they are not finished after timeout.
+func (db *DB) waitLiveTxs(timeout time.Duration) bool {
+	db.liveTxsMu.Lock()
+	if len(db.liveTxs) == 0 {
+		db.liveTxsMu.Unlock()
+		return true
+	}
+	if db.liveTxsDone == nil {
+		db.liveTxsDone = make(chan struct{})
+	}
+	done := db.liveTxsDone
+	db.liveTxsMu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// LiveTransactions returns the transactions which are not committed or rolled back yet.
+func (db *DB) LiveTransactions() []TxInfoLite {
+	db.liveTxsMu.Lock()
+	defer db.liveTxsMu.Unlock()
+
+	infos := make([]TxInfoLite, 0, len(db.liveTxs))
+	for _, tx := range db.liveTxs {
+		infos = append(infos, TxInfoLite{
+			ID:        tx.seq,
+			Label:     tx.getLabel(),
+			Writable:  tx.writable,
+			StartTime: tx.startTime,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+
+	return infos
+}
+
+// KillTransaction kills the read-only tx at given id, the lock and the iterators of the tx are released at once,
+// so a leaked tx no longer blocks Close or the writers. The operations of the tx return ErrTxKilled afterwards,
+// before they touch the index. An operation of the tx running at the time is not preempted, the tx is released
+// when it ends.
+func (db *DB) KillTransaction(id uint64) error {
+	db.liveTxsMu.Lock()
+	tx, ok := db.liveTxs[id]
+	db.liveTxsMu.Unlock()
+
+	if !ok {
+		return ErrTxNotFound
+	}
+	if tx.writable {
+		return ErrTxNotKillable
+	}
+
+	tx.kill()
 	return nil
 }
 
+func (db *DB) trackTx(tx *Tx) {
+	db.liveTxsMu.Lock()
+	defer db.liveTxsMu.Unlock()
+	db.liveTxs[tx.seq] = tx
+}
+
+func (db *DB) untrackTx(tx *Tx) {
+	db.liveTxsMu.Lock()
+	defer db.liveTxsMu.Unlock()
+	delete(db.liveTxs, tx.seq)
+
+	if len(db.liveTxs) == 0 && db.liveTxsDone != nil {
+		close(db.liveTxsDone)
+		db.liveTxsDone = nil
+	}
+}
+
 // release set all obj in the db instance to nil
 func (db *DB) release() error {
 	GCEnable := db.opt.GCWhenClose
 
-	err := db.ActiveFile.rwManager.Release()
-	if err != nil {
-		return err
+	readOnly := db.dataFS != nil
+
+	if db.ActiveFile != nil {
+		err := db.ActiveFile.rwManager.Release()
+		if err != nil {
+			return err
+		}
 	}
 
 	db.BPTreeIdx = nil
@@ -384,22 +771,24 @@ func (db *DB) release() error {
 
 	db.committedTxIds = nil
 
-	err = db.fm.close()
+	err := db.fm.close()
 
 	if err != nil {
 		return err
 	}
 
-	if !db.flock.Locked() {
-		return ErrDirUnlocked
-	}
+	if !readOnly {
+		if !db.flock.Locked() {
+			return ErrDirUnlocked
+		}
 
-	err = db.flock.Unlock()
-	if err != nil {
-		return err
-	}
+		err = db.flock.Unlock()
+		if err != nil {
+			return err
+		}
 
-	db.mergeWorkCloseCh <- struct{}{}
+		db.mergeWorkCloseCh <- struct{}{}
+	}
 
 	db.fm = nil
 
@@ -447,6 +836,9 @@ func (db *DB) getEntryByHint(h *Hint) (*Entry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read err. pos %d, key %s, err %s", h.DataPos, string(h.Key), err)
 	}
+	if item == nil {
+		return nil, fmt.Errorf("read err. pos %d, key %s, err %w", h.DataPos, string(h.Key), ErrEntryZero)
+	}
 
 	return item, nil
 }
@@ -523,6 +915,19 @@ func (db *DB) getActiveFileWriteOff() (off int64, err error) {
 	return
 }
 
+// openFileRecovery opens the data file of the file ID for recovery.
+func (db *DB) openFileRecovery(fID int64) (*fileRecovery, error) {
+	if db.dataFS != nil {
+		fd, err := db.dataFS.openDataFile(fID)
+		if err != nil {
+			return nil, err
+		}
+		return newFileRecoveryFromReader(fd, db.opt.BufferSizeOfRecovery), nil
+	}
+
+	return newFileRecovery(getDataPath(fID, db.opt.Dir), db.opt.BufferSizeOfRecovery)
+}
+
 func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, committedTxIds map[uint64]struct{}, err error) {
 	var (
 		off int64
@@ -537,9 +942,12 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 	for _, dataID := range dataFileIds {
 		off = 0
 		fID := int64(dataID)
-		path := getDataPath(fID, db.opt.Dir)
-		f, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
+		f, err := db.openFileRecovery(fID)
 		if err != nil {
+			// the degraded mode keeps whatever is readable.
+			if db.degraded {
+				continue
+			}
 			return nil, nil, err
 		}
 
@@ -589,6 +997,9 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 				if off >= db.opt.SegmentSize {
 					break
 				}
+				if db.degraded {
+					break
+				}
 				if err != nil {
 					return nil, nil, err
 				}
@@ -653,6 +1064,7 @@ func (db *DB) buildBPTreeIdx(bucket string, r *Record) error {
 	if err := db.BPTreeIdx[bucket].Insert(r.H.Key, r.E, r.H, CountFlagEnabled); err != nil {
 		return fmt.Errorf("when build BPTreeIdx insert index err: %s", err)
 	}
+	db.trackExpiringKey(bucket, r.H.Key, r.H.Meta)
 
 	return nil
 }
@@ -681,9 +1093,12 @@ func (db *DB) buildBucketMetaIdx() error {
 					continue
 				}
 
-				name = strings.TrimSuffix(name, BucketMetaSuffix)
+				bucket, err := hex.DecodeString(strings.TrimSuffix(name, BucketMetaSuffix))
+				if err != nil {
+					continue
+				}
 
-				bucketMeta, err := ReadBucketMeta(getBucketMetaFilePath(name, db.opt.Dir))
+				bucketMeta, err := ReadBucketMeta(getBucketMetaFilePath(string(bucket), db.opt.Dir))
 				if err == io.EOF {
 					break
 				}
@@ -691,7 +1106,7 @@ func (db *DB) buildBucketMetaIdx() error {
 					return err
 				}
 
-				db.bucketMetas[name] = bucketMeta
+				db.bucketMetas[string(bucket)] = bucketMeta
 			}
 		}
 	}
@@ -734,9 +1149,21 @@ func (db *DB) buildHintIdx(dataFileIds []int) error {
 		return nil
 	}
 
+	db.bucketRenames = db.committedBucketRenames(unconfirmedRecords)
+	db.sortedSetSnapshot = db.loadSortedSetSnapshot(dataFileIds)
+	defer func() {
+		db.sortedSetSnapshot = nil
+	}()
+
+	var latest map[string]*Record
+	if db.opt.PurgeExpiredOnOpen && db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		latest = db.latestBPTreeRecords(unconfirmedRecords)
+	}
+
 	for _, r := range unconfirmedRecords {
 		if _, ok := db.committedTxIds[r.H.Meta.TxID]; ok {
 			bucket := r.Bucket
+			db.bucketRecords.add(r.H.Meta, bucket, r.H.FileID)
 
 			if r.H.Meta.Ds == DataStructureBPTree {
 				r.H.Meta.Status = Committed
@@ -745,7 +1172,7 @@ func (db *DB) buildHintIdx(dataFileIds []int) error {
 					if err = db.buildActiveBPTreeIdx(r); err != nil {
 						return err
 					}
-				} else {
+				} else if latest == nil || db.isLiveOnOpen(latest, r) {
 					if err = db.buildBPTreeIdx(bucket, r); err != nil {
 						return err
 					}
@@ -764,6 +1191,16 @@ func (db *DB) buildHintIdx(dataFileIds []int) error {
 		}
 	}
 
+	if latest != nil {
+		db.Index.rangeList(func(l *List) {
+			for key := range l.TTL {
+				if l.IsExpire(key) {
+					db.purgedOnOpen++
+				}
+			}
+		})
+	}
+
 	if HintBPTSparseIdxMode == db.opt.EntryIdxMode {
 		if err = db.buildBPTreeRootIdxes(dataFileIds); err != nil {
 			return err
@@ -773,11 +1210,45 @@ func (db *DB) buildHintIdx(dataFileIds []int) error {
 	return nil
 }
 
+// latestBPTreeRecords returns the last committed record of each key of the BPTree buckets.
+func (db *DB) latestBPTreeRecords(records []*Record) map[string]*Record {
+	latest := make(map[string]*Record)
+	for _, r := range records {
+		if _, ok := db.committedTxIds[r.H.Meta.TxID]; ok && r.H.Meta.Ds == DataStructureBPTree {
+			latest[db.latestKey(r)] = r
+		}
+	}
+
+	return latest
+}
+
+// latestKey returns the key of the record in the map of latestBPTreeRecords, under the name its bucket is renamed to.
+func (db *DB) latestKey(r *Record) string {
+	bucket := db.currentBucket(DataStructureBPTree, r.Bucket, r.H.FileID, int64(r.H.DataPos))
+	return string(getNewKey(bucket, r.H.Key))
+}
+
+// isLiveOnOpen returns if the record is indexed with Options.PurgeExpiredOnOpen, only the last record
+// of each key is indexed unless it is expired, so that neither an expired record nor the older
+// records it overwrites are loaded.
+func (db *DB) isLiveOnOpen(latest map[string]*Record, r *Record) bool {
+	if latest[db.latestKey(r)] != r {
+		return false
+	}
+
+	if db.isExpired(r.H.Meta) {
+		db.purgedOnOpen++
+		return false
+	}
+
+	return true
+}
+
 func (db *DB) buildNotDSIdxes(bucket string, r *Record) {
 	if r.H.Meta.Flag == DataSetBucketDeleteFlag {
 		db.deleteBucket(DataStructureSet, bucket)
 	}
-	if r.H.Meta.Flag == DataSortedSetBucketDeleteFlag {
+	if r.H.Meta.Flag == DataSortedSetBucketDeleteFlag && !db.sortedSetSnapshot.covers(r) {
 		db.deleteBucket(DataStructureSortedSet, bucket)
 	}
 	if r.H.Meta.Flag == DataBPTreeBucketDeleteFlag {
@@ -786,6 +1257,24 @@ func (db *DB) buildNotDSIdxes(bucket string, r *Record) {
 	if r.H.Meta.Flag == DataListBucketDeleteFlag {
 		db.deleteBucket(DataStructureList, bucket)
 	}
+	if r.H.Meta.Flag == DataBucketDefaultTTLFlag {
+		db.setBucketDefaultTTL(bucket, r.H.Key)
+	}
+	if r.H.Meta.Flag == DataBucketReadOnlyFlag {
+		db.setBucketReadOnly(bucket, r.H.Key)
+	}
+	if r.H.Meta.Flag == DataBucketQuotaFlag {
+		db.setBucketQuota(bucket, r.H.Key)
+	}
+	if r.H.Meta.Flag == DataBucketScoreTypeFlag {
+		db.setBucketScoreType(bucket, r.H.Key)
+	}
+	if r.H.Meta.Flag == DataBucketRenameFlag {
+		rename := newBucketRename(bucket, r.H.Key, r.H.FileID, int64(r.H.DataPos))
+		if rename.ds != DataStructureSortedSet || !db.sortedSetSnapshot.covers(r) {
+			db.renameBucket(rename.ds, rename.from, rename.to)
+		}
+	}
 }
 
 func (db *DB) deleteBucket(ds uint16, bucket string) {
@@ -803,34 +1292,157 @@ func (db *DB) deleteBucket(ds uint16, bucket string) {
 	}
 }
 
+// bucketRename is the rename of a bucket of the data structure ds, at the position of its record in the data files.
+type bucketRename struct {
+	ds       uint16
+	from, to string
+	fileID   int64
+	offset   int64
+}
+
+// bucketRenameKey returns the key of the record written by Tx.RenameBucket: the data structure followed by the new
+// name of the bucket. The key is kept by the hints in every EntryIdxMode, unlike the value.
+func bucketRenameKey(ds uint16, to string) []byte {
+	key := make([]byte, 2, 2+len(to))
+	binary.BigEndian.PutUint16(key, ds)
+	return append(key, to...)
+}
+
+// newBucketRename decodes the record written by Tx.RenameBucket.
+func newBucketRename(bucket string, key []byte, fileID, offset int64) bucketRename {
+	if len(key) < 2 {
+		return bucketRename{ds: DataStructureNone, from: bucket, fileID: fileID, offset: offset}
+	}
+	return bucketRename{ds: binary.BigEndian.Uint16(key), from: bucket, to: string(key[2:]), fileID: fileID, offset: offset}
+}
+
+// after returns if the rename is written after the position in the data files.
+func (r bucketRename) after(fileID, offset int64) bool {
+	return r.fileID > fileID || r.fileID == fileID && r.offset > offset
+}
+
+// committedBucketRenames returns the renames of the committed records, in the order they are written.
+func (db *DB) committedBucketRenames(records []*Record) []bucketRename {
+	var renames []bucketRename
+	for _, r := range records {
+		if _, ok := db.committedTxIds[r.H.Meta.TxID]; ok && r.H.Meta.Ds == DataStructureNone && r.H.Meta.Flag == DataBucketRenameFlag {
+			renames = append(renames, newBucketRename(r.Bucket, r.H.Key, r.H.FileID, int64(r.H.DataPos)))
+		}
+	}
+
+	return renames
+}
+
+// removeBucketRenames forgets the renames of the data file of fileID, merge removes it once its records are
+// rewritten under the current names of their buckets.
+func (db *DB) removeBucketRenames(fileID int64) {
+	renames := db.bucketRenames[:0]
+	for _, rename := range db.bucketRenames {
+		if rename.fileID != fileID {
+			renames = append(renames, rename)
+		}
+	}
+	db.bucketRenames = renames
+}
+
+// currentBucket returns the name of the bucket a record written at the position in the data files under the
+// bucket name is indexed under now, following the renames written after it.
+func (db *DB) currentBucket(ds uint16, bucket string, fileID, offset int64) string {
+	for _, rename := range db.bucketRenames {
+		if rename.ds == ds && rename.from == bucket && rename.after(fileID, offset) {
+			bucket = rename.to
+		}
+	}
+
+	return bucket
+}
+
+// renameBucket re-points the index of the bucket of the data structure ds to its new name.
+func (db *DB) renameBucket(ds uint16, from, to string) {
+	db.deleteBucket(ds, to)
+
+	switch ds {
+	case DataStructureSet:
+		if set, ok := db.SetIdx[from]; ok {
+			db.SetIdx[to] = set
+		}
+	case DataStructureSortedSet:
+		if sortedSet, ok := db.SortedSetIdx[from]; ok {
+			db.SortedSetIdx[to] = sortedSet
+		}
+	case DataStructureBPTree:
+		if tree, ok := db.BPTreeIdx[from]; ok {
+			db.BPTreeIdx[to] = tree
+			db.trackExpiringTree(to, tree)
+		}
+	case DataStructureList:
+		db.Index.renameList(from, to)
+	}
+	db.deleteBucket(ds, from)
+
+	db.bucketRecords.rename(ds, from, to)
+}
+
 // buildSetIdx builds set index when opening the DB.
 func (db *DB) buildSetIdx(bucket string, r *Record) error {
 	if _, ok := db.SetIdx[bucket]; !ok {
 		db.SetIdx[bucket] = NewSet()
+		db.SetIdx[bucket].clock = db.opt.Clock
 	}
 
 	if r.E == nil {
 		return ErrEntryIdxModeOpt
 	}
 
+	set, key := db.SetIdx[bucket], string(r.E.Key)
+	if set.expiredAt(key, r.H.Meta.Timestamp) {
+		set.clear(key)
+	}
+
 	if r.H.Meta.Flag == DataSetFlag {
-		if err := db.SetIdx[bucket].SAdd(string(r.E.Key), [][]byte{r.E.Value}, []*Record{r}); err != nil {
+		if err := set.SAdd(key, [][]byte{r.E.Value}, []*Record{r}); err != nil {
 			return fmt.Errorf("when build SetIdx SAdd index err: %s", err)
 		}
 	}
 
+	// the set of the removed members may have expired or never been added.
 	if r.H.Meta.Flag == DataDeleteFlag {
-		if err := db.SetIdx[bucket].SRem(string(r.E.Key), r.E.Value); err != nil {
+		if err := set.SRem(key, r.E.Value); err != nil && err != ErrKeyNotFound {
 			return fmt.Errorf("when build SetIdx SRem index err: %s", err)
 		}
 	}
 
+	if r.H.Meta.Flag == DataExpireSetFlag {
+		ttl, err := strconv2.StrToInt64(string(r.E.Value))
+		if err != nil {
+			return err
+		}
+		set.Expire(key, uint32(ttl), r.H.Meta.Timestamp)
+	}
+
+	if r.H.Meta.Flag == DataSetClearFlag {
+		set.empty(key)
+	}
+
 	return nil
 }
 
 // buildSortedSetIdx builds sorted set index when opening the DB.
 func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
-	if _, ok := db.SortedSetIdx[bucket]; !ok {
+	if db.sortedSetSnapshot.covers(r) {
+		return nil
+	}
+
+	sortedSet, ok := db.SortedSetIdx[bucket]
+	if ok && sortedSet.ExpiredAt(r.H.Meta.Timestamp) {
+		delete(db.SortedSetIdx, bucket)
+		ok = false
+	}
+	if !ok {
+		// the ttl of a missing sorted set is dropped, it does not create the sorted set.
+		if r.H.Meta.Flag == DataExpireZSetFlag {
+			return nil
+		}
 		db.SortedSetIdx[bucket] = zset.New()
 	}
 
@@ -845,6 +1457,17 @@ func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
 			_ = db.SortedSetIdx[bucket].Put(key, zset.SCORE(score), r.E.Value)
 		}
 	}
+	if r.H.Meta.Flag == DataZAddIntFlag {
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		keyAndScore := strings.Split(string(r.E.Key), SeparatorForZSetKey)
+		if len(keyAndScore) == 2 {
+			key := keyAndScore[0]
+			score, _ := strconv2.StrToInt64(keyAndScore[1])
+			_ = db.SortedSetIdx[bucket].PutInt(key, score, r.E.Value)
+		}
+	}
 	if r.H.Meta.Flag == DataZRemFlag {
 		_ = db.SortedSetIdx[bucket].Remove(string(r.E.Key))
 	}
@@ -859,6 +1482,16 @@ func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
 	if r.H.Meta.Flag == DataZPopMinFlag {
 		_ = db.SortedSetIdx[bucket].PopMin()
 	}
+	if r.H.Meta.Flag == DataExpireZSetFlag {
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		ttl, err := strconv2.StrToInt64(string(r.E.Value))
+		if err != nil {
+			return err
+		}
+		db.SortedSetIdx[bucket].Expire(uint32(ttl), r.H.Meta.Timestamp)
+	}
 
 	return nil
 }
@@ -870,7 +1503,7 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 	if r.E == nil {
 		return ErrEntryIdxModeOpt
 	}
-	if IsExpired(r.E.Meta.TTL, r.E.Meta.Timestamp) {
+	if db.isExpired(r.E.Meta) {
 		return nil
 	}
 	switch r.H.Meta.Flag {
@@ -879,15 +1512,21 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 		if err != nil {
 			return err
 		}
-		ttl := uint32(t)
-		l.TTL[string(r.E.Key)] = ttl
-		l.TimeStamp[string(r.E.Key)] = r.E.Meta.Timestamp
-	case DataLPushFlag:
-		_ = l.LPush(string(r.E.Key), r)
-	case DataRPushFlag:
-		_ = l.RPush(string(r.E.Key), r)
+		l.Expire(string(r.E.Key), uint32(t), r.E.Meta.Timestamp)
+	case DataListClearFlag:
+		l.clear(string(r.E.Key))
+	case DataListCapFlag:
+		maxLen, err := strconv2.StrToInt(string(r.E.Value))
+		if err != nil {
+			return err
+		}
+		l.SetCap(string(r.E.Key), maxLen, r.E.Meta.Timestamp)
+	case DataLPushFlag, DataRPushFlag:
+		key, seq := splitListSeqKey(r.E.Key)
+		r.seq = seq
+		_ = l.push(string(key), r, r.H.Meta.Flag == DataLPushFlag)
 	case DataLRemFlag:
-		countAndValueIndex := strings.Split(string(r.E.Value), SeparatorForListKey)
+		countAndValueIndex := strings.SplitN(string(r.E.Value), SeparatorForListKey, 2)
 		count, _ := strconv2.StrToInt(countAndValueIndex[0])
 		value := []byte(countAndValueIndex[1])
 
@@ -900,12 +1539,9 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 		}); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
-	case DataLPopFlag:
-		if _, err := l.LPop(string(r.E.Key)); err != nil {
-			return ErrWhenBuildListIdx(err)
-		}
-	case DataRPopFlag:
-		if _, err := l.RPop(string(r.E.Key)); err != nil {
+	case DataLPopFlag, DataRPopFlag:
+		key, seq := splitListSeqKey(r.E.Key)
+		if _, err := l.popSeq(string(key), seq, r.H.Meta.Flag == DataLPopFlag); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
 	case DataLSetFlag:
@@ -923,6 +1559,13 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 		if err := l.LTrim(newKey, start, end); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
+	case DataLInsertFlag:
+		keyAndIndex := strings.Split(string(r.E.Key), SeparatorForListKey)
+		newKey := keyAndIndex[0]
+		index, _ := strconv2.StrToInt(keyAndIndex[1])
+		if err := l.LInsert(newKey, index, r); err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
 	case DataLRemByIndex:
 		indexes, err := UnmarshalInts(r.E.Value)
 		if err != nil {
@@ -948,16 +1591,43 @@ func (db *DB) buildIndexes() (err error) {
 		dataFileIds []int
 	)
 
+	if db.dataFS != nil {
+		if dataFileIds, err = db.dataFS.dataFileIDs(); err != nil || len(dataFileIds) == 0 {
+			return
+		}
+		db.MaxFileID = int64(dataFileIds[len(dataFileIds)-1])
+		return db.buildHintIdx(dataFileIds)
+	}
+
 	maxFileID, dataFileIds = db.getMaxFileIDAndFileIDs()
 
 	// init db.ActiveFile
 	db.MaxFileID = maxFileID
 
+	// the degraded mode never writes, the data files are only read.
+	if db.degraded {
+		if dataFileIds == nil {
+			return
+		}
+		if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			if err = db.checkBPTSparseIdxVersion(); err != nil {
+				return
+			}
+		}
+		return db.buildHintIdx(dataFileIds)
+	}
+
 	// set ActiveFile
 	if err = db.setActiveFile(); err != nil {
 		return
 	}
 
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		if err = db.upgradeBPTSparseIdx(dataFileIds); err != nil {
+			return
+		}
+	}
+
 	if dataFileIds == nil && maxFileID == 0 {
 		return
 	}
@@ -1010,7 +1680,7 @@ func (db *DB) managed(writable bool, fn func(tx *Tx) error) (err error) {
 		}
 
 		errRollback := tx.Rollback()
-		err = fmt.Errorf("%v. Rollback err: %v", err, errRollback)
+		err = fmt.Errorf("%w. Rollback err: %v", err, errRollback)
 	}
 
 	return err
@@ -1018,14 +1688,6 @@ func (db *DB) managed(writable bool, fn func(tx *Tx) error) (err error) {
 
 const bptDir = "bpt"
 
-func (db *DB) checkListExpired() {
-	db.Index.rangeList(func(l *List) {
-		for key := range l.TTL {
-			l.IsExpire(key)
-		}
-	})
-}
-
 // IsClose return the value that represents the status of DB
 func (db *DB) IsClose() bool {
 	return db.closed
@@ -0,0 +1,148 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "fmt"
+
+// copyToBatch is how many records CopyTo writes by transaction at most.
+const copyToBatch = 1000
+
+// CopyTo writes every live record of the db into dst through transactions, the BPTree keys, set members, list
+// items and sorted set members, see ExportJSON. dst holds the live data only then, compacted into as few files
+// as it takes, so CopyTo into a db opened with other options migrates to a new SegmentSize or EntryIdxMode.
+//
+// The ttls are carried as the time left to live at the copy, from the time of dst, and the records expired
+// already are left out. The records are written by batches of copyToBatch records at most, and less than
+// CommitBufferSize and SegmentSize of dst. The records of dst with the same keys are overwritten, like ImportJSON
+// does. The writes to the db wait until the copy is done, the copy being read in a single View.
+func (db *DB) CopyTo(dst *DB) error {
+	_, err := db.copyTo(dst)
+	return err
+}
+
+// copyTo is CopyTo, the copier returned tells how many records are copied.
+func (db *DB) copyTo(dst *DB) (*dbCopier, error) {
+	if dst == db {
+		return nil, ErrCopyToSelf
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	maxSize := dst.opt.CommitBufferSize
+	if dst.opt.SegmentSize < maxSize {
+		maxSize = dst.opt.SegmentSize
+	}
+	c := &dbCopier{dst: dst, maxSize: maxSize, counts: make(map[string]int)}
+
+	err := db.View(func(tx *Tx) error {
+		c.now = timeMillis(tx.now())
+		e := &recordExporter{emit: c.add}
+		if err := e.export(tx); err != nil {
+			return err
+		}
+
+		return c.flush()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// dbCopier writes the records of CopyTo into dst by batches.
+type dbCopier struct {
+	dst     *DB
+	maxSize int64
+	now     uint64         // the time of the db copied in milliseconds
+	counts  map[string]int // the records copied by data structure
+
+	batch []copiedRecord
+	size  int64 // the size of the entries of the batch
+}
+
+// copiedRecord is a record of CopyTo with the time it has left to live in milliseconds, 0 if it's persistent.
+type copiedRecord struct {
+	*JSONRecord
+	ttl uint64
+}
+
+func (c *dbCopier) add(r *JSONRecord) error {
+	expireAt := recordExpireAt(r)
+	if expireAt != 0 && expireAt <= c.now {
+		return nil
+	}
+	c.counts[r.Ds]++
+
+	copied := copiedRecord{JSONRecord: r}
+	if expireAt != 0 {
+		copied.ttl = expireAt - c.now
+	}
+
+	size := int64(DataEntryHeaderSize + DataEntryExpireAtSize + len(r.Bucket) + len(r.Key) + len(r.Value))
+	if len(c.batch) == copyToBatch || len(c.batch) > 0 && c.size+size > c.maxSize {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	c.batch = append(c.batch, copied)
+	c.size += size
+
+	return nil
+}
+
+// flush writes the batch in a transaction of dst, an error names the record.
+func (c *dbCopier) flush() error {
+	if len(c.batch) == 0 {
+		return nil
+	}
+
+	tx, err := c.dst.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	now := timeMillis(tx.now())
+	b := &jsonImportBatchState{listLens: make(map[string]int), ttls: make(map[string]struct{})}
+	for _, r := range c.batch {
+		// the ttl counts from the time of dst.
+		r.Timestamp = now / 1000
+		r.ExpireAt = 0
+		if r.ttl != 0 {
+			r.TTL = uint32((r.ttl + 999) / 1000)
+			if r.Ds == jsonBPTree {
+				r.ExpireAt = now + r.ttl
+			}
+		}
+
+		if err := b.importRecord(tx, r.JSONRecord); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("%s %s/%s: %w", r.Ds, r.Bucket, r.Key, err)
+		}
+	}
+	c.batch, c.size = c.batch[:0], 0
+
+	return tx.Commit()
+}
+
+// recordExpireAt returns the expiration of the record in milliseconds, 0 if it's persistent.
+func recordExpireAt(r *JSONRecord) uint64 {
+	if r.ExpireAt != 0 {
+		return r.ExpireAt
+	}
+
+	return expireAtMillis(r.TTL, r.Timestamp)
+}
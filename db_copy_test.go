@@ -0,0 +1,145 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dirSize returns the size of the files in dir.
+func dirSize(t *testing.T, dir string) int64 {
+	var size int64
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return err
+	}))
+	return size
+}
+
+func TestDB_CopyTo(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	newOpt := func() Options {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.SegmentSize = 64 * KB
+		opt.Clock = clock
+		return opt
+	}
+
+	withDBOption(t, newOpt(), func(t *testing.T, src *DB) {
+		fillContentHashDB(t, src, true)
+
+		// the garbage: values overwritten again and again, and keys deleted.
+		for round := 0; round < 10; round++ {
+			require.NoError(t, src.Update(func(tx *Tx) error {
+				for i := 0; i < 100; i++ {
+					value := append(GetTestBytes(round), make([]byte, 512)...)
+					if err := tx.Put("garbage", GetTestBytes(i), value, Persistent); err != nil {
+						return err
+					}
+					if err := tx.Put("deleted", GetTestBytes(i), value, Persistent); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		require.NoError(t, src.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				if err := tx.Delete("deleted", GetTestBytes(i)); err != nil {
+					return err
+				}
+			}
+			if err := tx.Put("ttl", []byte("expired"), []byte("value"), 10); err != nil {
+				return err
+			}
+			if err := tx.PutWithTTL("ttl", []byte("millis"), []byte("value"), 100500*time.Millisecond); err != nil {
+				return err
+			}
+			if err := tx.Put("ttl", []byte("seconds"), []byte("value"), 100); err != nil {
+				return err
+			}
+			if err := tx.SAdd("ttl", []byte("set"), []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+			return tx.ExpireSet("ttl", []byte("set"), 100)
+		}))
+
+		// the ttls left at the copy are 40s.
+		clock.Add(60 * time.Second)
+		expected := dbContents(t, src)
+		for key, value := range expected {
+			expected[key] = strings.Replace(value, "ttl=3600", "ttl=3540", 1)
+		}
+
+		// the copy migrates to another EntryIdxMode and SegmentSize.
+		dstOpt := newOpt()
+		dstOpt.EntryIdxMode = HintKeyAndRAMIdxMode
+		dstOpt.SegmentSize = 128 * KB
+		withDBOption(t, dstOpt, func(t *testing.T, dst *DB) {
+			require.NoError(t, src.CopyTo(dst))
+
+			require.Equal(t, expected, dbContents(t, dst))
+			require.Less(t, dirSize(t, dst.opt.Dir)*4, dirSize(t, src.opt.Dir))
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				entries, err := tx.GetAll("garbage")
+				require.NoError(t, err)
+				require.Len(t, entries, 100)
+				require.Equal(t, append(GetTestBytes(9), make([]byte, 512)...), entries[0].Value)
+
+				_, err = tx.GetAll("deleted")
+				require.Error(t, err)
+				_, err = tx.Get("ttl", []byte("expired"))
+				require.Equal(t, ErrKeyNotFound, err)
+
+				e, err := tx.Get("ttl", []byte("seconds"))
+				require.NoError(t, err)
+				require.Equal(t, uint32(40), e.Meta.TTL)
+				return nil
+			}))
+
+			// the records expire when they would have in the db copied.
+			clock.Add(39 * time.Second)
+			txGet(t, dst, "ttl", []byte("seconds"), []byte("value"), nil)
+			txGet(t, dst, "ttl", []byte("millis"), []byte("value"), nil)
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				members, err := tx.SMembers("ttl", []byte("set"))
+				require.NoError(t, err)
+				require.Len(t, members, 2)
+				return nil
+			}))
+
+			clock.Add(2 * time.Second)
+			txGet(t, dst, "ttl", []byte("seconds"), nil, ErrNotFoundKey)
+			txGet(t, dst, "ttl", []byte("millis"), nil, ErrNotFoundKey)
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				_, err := tx.SMembers("ttl", []byte("set"))
+				require.Error(t, err)
+				return nil
+			}))
+		})
+
+		require.Equal(t, ErrCopyToSelf, src.CopyTo(src))
+	})
+}
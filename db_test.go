@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
@@ -500,6 +502,130 @@ func TestDB_DeleteBucket(t *testing.T) {
 	})
 }
 
+func TestDB_DeleteBucketAcrossRestart(t *testing.T) {
+	write := func(ds uint16, tx *Tx, bucket string, items ...string) error {
+		for _, item := range items {
+			var err error
+			switch ds {
+			case DataStructureBPTree:
+				err = tx.Put(bucket, []byte(item), []byte("value"), Persistent)
+			case DataStructureSet:
+				err = tx.SAdd(bucket, []byte("key"), []byte(item))
+			case DataStructureList:
+				err = tx.RPush(bucket, []byte("key"), []byte(item))
+			case DataStructureSortedSet:
+				err = tx.ZAdd(bucket, []byte(item), 1, []byte("value"))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// read returns the items of the bucket, none if it doesn't exist.
+	read := func(ds uint16, tx *Tx, bucket string) (items []string) {
+		switch ds {
+		case DataStructureBPTree:
+			entries, _ := tx.GetAll(bucket)
+			for _, e := range entries {
+				items = append(items, string(e.Key))
+			}
+		case DataStructureSet:
+			members, _ := tx.SMembers(bucket, []byte("key"))
+			for _, member := range members {
+				items = append(items, string(member))
+			}
+		case DataStructureList:
+			// LRange adds an empty list bucket.
+			if !tx.db.Index.existList(bucket) {
+				return nil
+			}
+			values, _ := tx.LRange(bucket, []byte("key"), 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+		case DataStructureSortedSet:
+			members, _ := tx.ZMembers(bucket)
+			for member := range members {
+				items = append(items, member)
+			}
+		}
+		sort.Strings(items)
+		return items
+	}
+
+	// the lists, sets and sorted sets are only supported in HintKeyValAndRAMIdxMode.
+	for _, tc := range []struct {
+		mode     EntryIdxMode
+		snapshot bool
+		ds       uint16
+	}{
+		{HintKeyValAndRAMIdxMode, false, DataStructureBPTree},
+		{HintKeyValAndRAMIdxMode, false, DataStructureSet},
+		{HintKeyValAndRAMIdxMode, false, DataStructureList},
+		{HintKeyValAndRAMIdxMode, false, DataStructureSortedSet},
+		{HintKeyValAndRAMIdxMode, true, DataStructureSortedSet},
+		{HintKeyAndRAMIdxMode, false, DataStructureBPTree},
+	} {
+		mode, ds := tc.mode, tc.ds
+		opts := DefaultOptions
+		opts.Dir = NutsDBTestDirPath
+		opts.EntryIdxMode = mode
+		opts.SortedSetSnapshot = tc.snapshot
+		opts.SegmentSize = 4 * KB
+		require.NoError(t, os.RemoveAll(opts.Dir))
+
+		db, err := Open(opts)
+		require.NoError(t, err)
+		reopen := func() {
+			require.NoError(t, db.Close())
+			db, err = Open(opts)
+			require.NoError(t, err)
+		}
+		requireItems := func(want ...string) {
+			var (
+				items  []string
+				exists bool
+			)
+			require.NoError(t, db.View(func(tx *Tx) error {
+				exists, _ = tx.ExistBucket(ds, "bucket")
+				items = read(ds, tx, "bucket")
+				return nil
+			}))
+			require.Equal(t, want, items, "mode %d ds %d", mode, ds)
+			require.Equal(t, len(want) > 0, exists, "mode %d ds %d", mode, ds)
+		}
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return write(ds, tx, "bucket", "old-1", "old-2")
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.DeleteBucket(ds, "bucket")
+		}))
+		requireItems()
+		reopen()
+		requireItems()
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return write(ds, tx, "bucket", "new")
+		}))
+		reopen()
+		requireItems("new")
+
+		// the delete is still honored once the records are spread over several files and merged.
+		for i := 0; i < 100; i++ {
+			txPut(t, db, "other", GetTestBytes(i), GetRandomBytes(24), Persistent, nil)
+		}
+		require.NoError(t, db.Merge())
+		reopen()
+		requireItems("new")
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(opts.Dir))
+	}
+}
+
 func withDBOption(t *testing.T, opt Options, fn func(t *testing.T, db *DB)) {
 	db, err := Open(opt)
 	require.NoError(t, err)
@@ -539,3 +665,251 @@ func withBPTSpareeIdxDB(t *testing.T, fn func(t *testing.T, db *DB)) {
 
 	withDBOption(t, opt, fn)
 }
+
+func TestDB_KillTransaction(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.CloseTimeout = 100 * time.Millisecond
+	defer removeDir(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	viewErr := make(chan error, 2)
+	go func() {
+		viewErr <- db.View(func(tx *Tx) error {
+			tx.SetLabel("leaked view")
+			it := NewIterator(tx, "bucket", IteratorOptions{})
+			close(started)
+			<-unblock
+			_, err := it.SetNext()
+			viewErr <- err
+			_, err = tx.Get("bucket", GetTestBytes(0))
+			return err
+		})
+	}()
+	<-started
+
+	infos := db.LiveTransactions()
+	require.Len(t, infos, 1)
+	require.Equal(t, "leaked view", infos[0].Label)
+	require.False(t, infos[0].Writable)
+
+	err = db.Close()
+	require.True(t, errors.Is(err, ErrCloseTimeout))
+	require.Contains(t, err.Error(), "leaked view")
+
+	require.Equal(t, ErrTxNotFound, db.KillTransaction(infos[0].ID+1))
+	require.NoError(t, db.KillTransaction(infos[0].ID))
+
+	// the kill releases the lock of the tx, while its owner is still blocked.
+	require.Empty(t, db.LiveTransactions())
+	require.NoError(t, db.Close())
+
+	close(unblock)
+	require.Equal(t, ErrTxKilled, <-viewErr)
+	require.Contains(t, (<-viewErr).Error(), ErrTxKilled.Error())
+}
+
+func TestDB_KillTransactionInOperation(t *testing.T) {
+	runNutsDBTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush("bucket", GetTestBytes(0), GetTestBytes(1))
+		}))
+
+		started, unblock := make(chan struct{}), make(chan struct{})
+		keysErr, getErr := make(chan error, 1), make(chan error, 1)
+		go func() {
+			_ = db.View(func(tx *Tx) error {
+				keysErr <- tx.LKeys("bucket", "*", func(key string) bool {
+					close(started)
+					<-unblock
+					return true
+				})
+				_, err := tx.LSize("bucket", GetTestBytes(0))
+				getErr <- err
+				return err
+			})
+		}()
+		<-started
+
+		infos := db.LiveTransactions()
+		require.Len(t, infos, 1)
+		require.NoError(t, db.KillTransaction(infos[0].ID))
+
+		// the operation running keeps the tx until it ends.
+		require.Len(t, db.LiveTransactions(), 1)
+		close(unblock)
+		require.NoError(t, <-keysErr)
+		require.Equal(t, ErrTxKilled, <-getErr)
+		require.Empty(t, db.LiveTransactions())
+	})
+}
+
+func TestDB_LiveTransactionIDs(t *testing.T) {
+	runNutsDBTest(t, nil, func(t *testing.T, db *DB) {
+		var txs []*Tx
+		for i := 0; i < 100; i++ {
+			tx, err := db.Begin(false)
+			require.NoError(t, err)
+			txs = append(txs, tx)
+		}
+
+		infos := db.LiveTransactions()
+		require.Len(t, infos, len(txs))
+		ids := make([]uint64, len(infos))
+		for i, info := range infos {
+			ids[i] = info.ID
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for i := 1; i < len(ids); i++ {
+			require.Less(t, ids[i-1], ids[i])
+		}
+
+		// the ids written with the entries are unique as well.
+		txIDs := make(map[uint64]struct{})
+		for _, tx := range txs {
+			txIDs[tx.id] = struct{}{}
+		}
+		require.Len(t, txIDs, len(txs))
+
+		for _, tx := range txs {
+			require.NoError(t, tx.Rollback())
+		}
+		require.Empty(t, db.LiveTransactions())
+	})
+}
+
+func TestDB_KillWritableTransaction(t *testing.T) {
+	runNutsDBTest(t, nil, func(t *testing.T, db *DB) {
+		tx, err := db.Begin(true)
+		require.NoError(t, err)
+
+		infos := db.LiveTransactions()
+		require.Len(t, infos, 1)
+		require.True(t, infos[0].Writable)
+		require.Equal(t, ErrTxNotKillable, db.KillTransaction(infos[0].ID))
+
+		require.NoError(t, tx.Rollback())
+		require.Empty(t, db.LiveTransactions())
+	})
+}
+
+func TestDB_CheckDataFiles(t *testing.T) {
+	bucket := "bucket"
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	defer removeDir(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+	require.NoError(t, db.Close())
+
+	// a stale copy of the data file restored under another name of the same file ID.
+	data, err := ioutil.ReadFile(filepath.Join(opts.Dir, "0.dat"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(opts.Dir, "00.dat"), data, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(opts.Dir, "backup.dat"), data, 0644))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(opts.Dir, "00.dat"), old, old))
+
+	_, err = Open(opts)
+	require.ErrorIs(t, err, ErrDirectoryInconsistent)
+	require.Contains(t, err.Error(), "file ID 0 is claimed by 0.dat, 00.dat")
+	require.Contains(t, err.Error(), "backup.dat has no valid file ID")
+
+	db, err = Open(opts, WithReconcileDir(true))
+	require.NoError(t, err)
+	txGet(t, db, bucket, GetTestBytes(0), GetTestBytes(0), nil)
+	require.NoError(t, db.Close())
+
+	conflicts, err := filepath.Glob(filepath.Join(opts.Dir, "*"+ConflictSuffix))
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_PurgeExpiredOnOpen(t *testing.T) {
+	bucket := "bucket"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	defer removeDir(opt.Dir)
+
+	expired := uint64(time.Now().Unix()) - 100
+	now := uint64(time.Now().Unix())
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		// 90% of the keys are expired.
+		for i := 0; i < 1000; i++ {
+			timestamp, ttl := expired, uint32(1)
+			if i%10 == 0 {
+				timestamp, ttl = now, Persistent
+			}
+			if err := tx.put(bucket, GetTestBytes(i), GetTestBytes(i), ttl, DataSetFlag, timestamp, DataStructureBPTree); err != nil {
+				return err
+			}
+		}
+		if err := tx.put(bucket, []byte("refreshed"), []byte("old"), 1, DataSetFlag, expired, DataStructureBPTree); err != nil {
+			return err
+		}
+		if err := tx.put(bucket, []byte("expired_later"), []byte("old"), Persistent, DataSetFlag, now, DataStructureBPTree); err != nil {
+			return err
+		}
+		if err := tx.RPush("list", []byte("expired"), []byte("item")); err != nil {
+			return err
+		}
+		return tx.put("list", []byte("expired"), []byte("1"), Persistent, DataExpireListFlag, expired, DataStructureList)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		// the later version wins in either direction.
+		if err := tx.put(bucket, []byte("refreshed"), []byte("new"), Persistent, DataSetFlag, now, DataStructureBPTree); err != nil {
+			return err
+		}
+		return tx.put(bucket, []byte("expired_later"), []byte("new"), 1, DataSetFlag, expired, DataStructureBPTree)
+	}))
+	require.NoError(t, db.Close())
+
+	indexSize := func(db *DB) int {
+		num, _, _ := db.BPTreeIdx[bucket].getAll()
+		return num
+	}
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, 1002, indexSize(db))
+	require.Equal(t, 0, db.Stats().PurgedOnOpen)
+	require.NoError(t, db.Close())
+
+	opt.PurgeExpiredOnOpen = true
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.Equal(t, 101, indexSize(db))
+	require.Equal(t, 902, db.Stats().PurgedOnOpen)
+
+	for i := 0; i < 1000; i++ {
+		if i%10 == 0 {
+			txGet(t, db, bucket, GetTestBytes(i), GetTestBytes(i), nil)
+		} else {
+			txGet(t, db, bucket, GetTestBytes(i), nil, ErrKeyNotFound)
+		}
+	}
+	txGet(t, db, bucket, []byte("refreshed"), []byte("new"), nil)
+	txGet(t, db, bucket, []byte("expired_later"), nil, ErrKeyNotFound)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.LRange("list", []byte("expired"), 0, -1)
+		assert.Error(t, err)
+		return nil
+	}))
+}
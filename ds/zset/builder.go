@@ -0,0 +1,85 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zset
+
+import "errors"
+
+// ErrBuildOrder is returned when a node appended to a Builder is not after the nodes appended before.
+var ErrBuildOrder = errors.New("node out of order")
+
+// Builder builds a SortedSet from its nodes appended in rank order. Appending a node doesn't search
+// the skip list, so it is faster than Put to load a sorted set whose order is known already.
+type Builder struct {
+	ss     *SortedSet
+	update [SkipListMaxLevel]*SortedSetNode
+	rank   [SkipListMaxLevel]int64
+}
+
+// NewBuilder returns a Builder of a new SortedSet.
+func NewBuilder() *Builder {
+	b := &Builder{ss: New()}
+	for i := range b.update {
+		b.update[i] = b.ss.header
+	}
+	return b
+}
+
+// Append appends an element with specific key / value / score after the elements appended before.
+// It returns ErrBuildOrder if the element is not after the last one in rank order or its key was appended
+// already, and ErrScoreTypeMismatch as Put does.
+//
+// Time complexity of this method is : O(1).
+func (b *Builder) Append(key string, score SCORE, value []byte) error {
+	return b.append(key, ScoreFloat64, scoreKey{score: score}, value)
+}
+
+// AppendInt appends an element with the exact int64 score after the elements appended before, see Append.
+//
+// Time complexity of this method is : O(1).
+func (b *Builder) AppendInt(key string, score int64, value []byte) error {
+	return b.append(key, ScoreInt64, scoreKey{score: SCORE(score), intScore: score}, value)
+}
+
+func (b *Builder) append(key string, scoreType ScoreType, sk scoreKey, value []byte) error {
+	ss := b.ss
+	if ss.length == 0 {
+		ss.scoreType = scoreType
+	} else if ss.scoreType != scoreType {
+		return ErrScoreTypeMismatch
+	}
+
+	if tail := ss.tail; tail != nil {
+		if c := tail.scoreKey().compare(sk); c > 0 || c == 0 && tail.key >= key {
+			return ErrBuildOrder
+		}
+	}
+	if _, ok := ss.Dict[key]; ok {
+		return ErrBuildOrder
+	}
+
+	update, rank := b.update, b.rank
+	x := ss.linkNode(&update, &rank, sk, key, value)
+	for i := range x.level {
+		b.update[i], b.rank[i] = x, ss.length
+	}
+	ss.Dict[key] = x
+
+	return nil
+}
+
+// SortedSet returns the SortedSet built, the Builder must not be used after.
+func (b *Builder) SortedSet() *SortedSet {
+	return b.ss
+}
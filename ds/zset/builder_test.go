@@ -0,0 +1,97 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zset
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entries(nodes []*SortedSetNode) (entries []string) {
+	for _, node := range nodes {
+		entries = append(entries, fmt.Sprintf("%s:%v:%v", node.Key(), node.Score(), node.Value))
+	}
+	return entries
+}
+
+func TestBuilder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	put := New()
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, put.Put(fmt.Sprint(r.Intn(3000)), SCORE(r.Intn(100)), []byte{byte(i)}))
+	}
+	b := NewBuilder()
+	for _, node := range put.GetByRankRange(1, -1, false) {
+		require.NoError(t, b.Append(node.Key(), node.Score(), node.Value))
+	}
+	built := b.SortedSet()
+
+	same := func() {
+		require.Equal(t, put.Size(), built.Size())
+		require.Equal(t, entries(put.GetByRankRange(1, -1, false)), entries(built.GetByRankRange(1, -1, false)))
+		require.Equal(t, entries(put.GetByRankRange(-1, 1, false)), entries(built.GetByRankRange(-1, 1, false)))
+		for key := range put.Dict {
+			require.Equal(t, put.FindRank(key), built.FindRank(key), key)
+		}
+		for i := 0; i < 50; i++ {
+			start, end := SCORE(r.Intn(120)-10), SCORE(r.Intn(120)-10)
+			require.Equal(t, put.CountByScoreRange(start, end, nil), built.CountByScoreRange(start, end, nil))
+		}
+	}
+	same()
+
+	// the built skip list keeps working with the later puts and removes.
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprint(r.Intn(3000))
+		if r.Intn(3) == 0 {
+			put.Remove(key)
+			built.Remove(key)
+			continue
+		}
+		score := SCORE(r.Intn(100))
+		require.NoError(t, put.Put(key, score, nil))
+		require.NoError(t, built.Put(key, score, nil))
+	}
+	same()
+	assert.Equal(t, entries([]*SortedSetNode{put.PopMin(), put.PopMax()}), entries([]*SortedSetNode{built.PopMin(), built.PopMax()}))
+	same()
+}
+
+func TestBuilder_Append(t *testing.T) {
+	b := NewBuilder()
+	assert.NoError(t, b.Append("b", 1, nil))
+	assert.NoError(t, b.Append("c", 1, nil))
+	assert.Equal(t, ErrBuildOrder, b.Append("a", 1, nil))
+	assert.Equal(t, ErrBuildOrder, b.Append("c", 1, nil))
+	assert.Equal(t, ErrBuildOrder, b.Append("d", 0, nil))
+	assert.Equal(t, ErrBuildOrder, b.Append("b", 2, nil))
+	assert.Equal(t, ErrScoreTypeMismatch, b.AppendInt("d", 2, nil))
+	assert.NoError(t, b.Append("a", 2, nil))
+	assert.Equal(t, "a", b.SortedSet().GetByRank(-1, false).Key())
+	assert.Equal(t, 3, b.SortedSet().FindRank("a"))
+
+	b = NewBuilder()
+	assert.NoError(t, b.AppendInt("a", 1<<60, nil))
+	assert.NoError(t, b.AppendInt("b", 1<<60+1, nil))
+	assert.Equal(t, ErrBuildOrder, b.AppendInt("c", 1<<60, nil))
+	assert.Equal(t, ScoreInt64, b.SortedSet().ScoreType())
+	assert.Equal(t, 0, New().Size())
+	assert.Equal(t, 0, NewBuilder().SortedSet().Size())
+}
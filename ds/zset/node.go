@@ -29,6 +29,7 @@ type SortedSetNode struct {
 	key      string // unique key of this node
 	Value    []byte // associated data
 	score    SCORE  // score to determine the order of this node in the set
+	intScore int64  // exact score of the node in the sorted set with ScoreInt64 type
 	backward *SortedSetNode
 	level    []SortedSetLevel
 }
@@ -42,3 +43,13 @@ func (ssn *SortedSetNode) Key() string {
 func (ssn *SortedSetNode) Score() SCORE {
 	return ssn.score
 }
+
+// IntScore returns the exact int64 score of the node in the sorted set with ScoreInt64 type.
+func (ssn *SortedSetNode) IntScore() int64 {
+	return ssn.intScore
+}
+
+// scoreKey returns the order key of the node.
+func (ssn *SortedSetNode) scoreKey() scoreKey {
+	return scoreKey{score: ssn.score, intScore: ssn.intScore}
+}
@@ -20,6 +20,9 @@
 package zset
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
 	"math/rand"
 )
 
@@ -31,25 +34,68 @@ const (
 	SkipListP = 0.25
 )
 
+// ErrScoreTypeMismatch is returned when the score type of the operation does not match the sorted set.
+var ErrScoreTypeMismatch = errors.New("score type mismatch")
+
+// ErrScanCursor is returned when the cursor of Scan was not returned by Scan.
+var ErrScanCursor = errors.New("bad scan cursor")
+
 // SCORE represents the score type.
 type SCORE float64
 
+// ScoreType represents the type of the scores stored in a SortedSet.
+type ScoreType uint8
+
+const (
+	// ScoreFloat64 represents the float64 scores, it is the default score type.
+	ScoreFloat64 ScoreType = iota
+
+	// ScoreInt64 represents the exact int64 scores.
+	ScoreInt64
+)
+
+// scoreKey is the order key of the nodes. The float64 score of an int64 score is
+// its rounded value, so intScore breaks the ties of the int64 scores above 2^53.
+type scoreKey struct {
+	score    SCORE
+	intScore int64
+}
+
+// compare returns -1, 0 or 1 if k is less than, equal to or greater than o.
+func (k scoreKey) compare(o scoreKey) int {
+	switch {
+	case k.score < o.score:
+		return -1
+	case k.score > o.score:
+		return 1
+	case k.intScore < o.intScore:
+		return -1
+	case k.intScore > o.intScore:
+		return 1
+	}
+	return 0
+}
+
 // SortedSet represents the sorted set.
 type SortedSet struct {
-	header *SortedSetNode
-	tail   *SortedSetNode
-	length int64
-	level  int
-	Dict   map[string]*SortedSetNode
+	header    *SortedSetNode
+	tail      *SortedSetNode
+	length    int64
+	level     int
+	scoreType ScoreType
+	ttl       uint32
+	timestamp uint64
+	Dict      map[string]*SortedSetNode
 }
 
 // createNode returns a newly initialized SortedSetNode Object that implements the SortedSetNode.
-func createNode(level int, score SCORE, key string, value []byte) *SortedSetNode {
+func createNode(level int, sk scoreKey, key string, value []byte) *SortedSetNode {
 	node := SortedSetNode{
-		score: score,
-		key:   key,
-		Value: value,
-		level: make([]SortedSetLevel, level),
+		score:    sk.score,
+		intScore: sk.intScore,
+		key:      key,
+		Value:    value,
+		level:    make([]SortedSetLevel, level),
 	}
 	return &node
 }
@@ -70,7 +116,7 @@ func randomLevel() int {
 	return SkipListMaxLevel
 }
 
-func (ss *SortedSet) insertNode(score SCORE, key string, value []byte) *SortedSetNode {
+func (ss *SortedSet) insertNode(sk scoreKey, key string, value []byte) *SortedSetNode {
 	var update [SkipListMaxLevel]*SortedSetNode
 	var rank [SkipListMaxLevel]int64
 
@@ -84,8 +130,8 @@ func (ss *SortedSet) insertNode(score SCORE, key string, value []byte) *SortedSe
 		}
 
 		for x.level[i].forward != nil &&
-			(x.level[i].forward.score < score ||
-				(x.level[i].forward.score == score && // score is the same but the key is different
+			(x.level[i].forward.scoreKey().compare(sk) < 0 ||
+				(x.level[i].forward.scoreKey() == sk && // score is the same but the key is different
 					x.level[i].forward.key < key)) {
 			rank[i] += x.level[i].span
 			x = x.level[i].forward
@@ -97,6 +143,11 @@ func (ss *SortedSet) insertNode(score SCORE, key string, value []byte) *SortedSe
 	 * scores, and the re-insertion of score and redis object should never
 	 * happen since the caller of Insert() should test in the hash table
 	 * if the element is already inside or not. */
+	return ss.linkNode(&update, &rank, sk, key, value)
+}
+
+// linkNode links a new node after the nodes of update, rank holding the ranks of the nodes of update.
+func (ss *SortedSet) linkNode(update *[SkipListMaxLevel]*SortedSetNode, rank *[SkipListMaxLevel]int64, sk scoreKey, key string, value []byte) *SortedSetNode {
 	level := randomLevel()
 
 	if level > ss.level { // add a new level
@@ -108,7 +159,7 @@ func (ss *SortedSet) insertNode(score SCORE, key string, value []byte) *SortedSe
 		ss.level = level
 	}
 
-	x = createNode(level, score, key, value)
+	x := createNode(level, sk, key, value)
 	for i := 0; i < level; i++ {
 		x.level[i].forward = update[i].level[i].forward
 		update[i].level[i].forward = x
@@ -164,14 +215,14 @@ func (ss *SortedSet) deleteNode(x *SortedSetNode, update [SkipListMaxLevel]*Sort
 }
 
 // delete removes an element with matching score/key from the skiplist.
-func (ss *SortedSet) delete(score SCORE, key string) bool {
+func (ss *SortedSet) delete(sk scoreKey, key string) bool {
 	var update [SkipListMaxLevel]*SortedSetNode
 
 	x := ss.header
 	for i := ss.level - 1; i >= 0; i-- {
 		for x.level[i].forward != nil &&
-			(x.level[i].forward.score < score ||
-				(x.level[i].forward.score == score &&
+			(x.level[i].forward.scoreKey().compare(sk) < 0 ||
+				(x.level[i].forward.scoreKey() == sk &&
 					x.level[i].forward.key < key)) {
 			x = x.level[i].forward
 		}
@@ -180,7 +231,7 @@ func (ss *SortedSet) delete(score SCORE, key string) bool {
 	/* We may have multiple elements with the same score, what we need
 	 * is to find the element with both the right score and object. */
 	x = x.level[0].forward
-	if x != nil && score == x.score && x.key == key {
+	if x != nil && x.scoreKey() == sk && x.key == key {
 		ss.deleteNode(x, update)
 		// free x
 		return true
@@ -194,10 +245,30 @@ func New() *SortedSet {
 		level: 1,
 		Dict:  make(map[string]*SortedSetNode),
 	}
-	sortedSet.header = createNode(SkipListMaxLevel, 0, "", nil)
+	sortedSet.header = createNode(SkipListMaxLevel, scoreKey{}, "", nil)
 	return &sortedSet
 }
 
+// Expire sets the ttl in seconds of the SortedSet counted from the unix timestamp, 0 removes it.
+func (ss *SortedSet) Expire(ttl uint32, timestamp uint64) {
+	ss.ttl, ss.timestamp = ttl, timestamp
+}
+
+// TTL returns the ttl in seconds of the SortedSet and the unix timestamp it counts from, 0 if there is none.
+func (ss *SortedSet) TTL() (ttl uint32, timestamp uint64) {
+	return ss.ttl, ss.timestamp
+}
+
+// ExpiredAt returns whether the ttl of the SortedSet has elapsed at the unix time now.
+func (ss *SortedSet) ExpiredAt(now uint64) bool {
+	return ss.ttl != 0 && uint64(ss.ttl)+ss.timestamp <= now
+}
+
+// ScoreType returns the score type of the SortedSet.
+func (ss *SortedSet) ScoreType() ScoreType {
+	return ss.scoreType
+}
+
 // Size returns the number of elements in the SortedSet.
 func (ss *SortedSet) Size() int {
 	return int(ss.length)
@@ -240,21 +311,42 @@ func (ss *SortedSet) PopMax() *SortedSetNode {
 }
 
 // Put puts an element into the sorted set with specific key / value / score.
+// It returns ErrScoreTypeMismatch if the sorted set holds int64 scores, an empty
+// sorted set takes the score type of the first element put into it.
 //
 // Time complexity of this method is : O(log(N)).
 func (ss *SortedSet) Put(key string, score SCORE, value []byte) error {
+	return ss.put(key, ScoreFloat64, scoreKey{score: score}, value)
+}
+
+// PutInt puts an element with the exact int64 score into the sorted set.
+// It returns ErrScoreTypeMismatch if the sorted set holds float64 scores, an empty
+// sorted set takes the score type of the first element put into it.
+//
+// Time complexity of this method is : O(log(N)).
+func (ss *SortedSet) PutInt(key string, score int64, value []byte) error {
+	return ss.put(key, ScoreInt64, scoreKey{score: SCORE(score), intScore: score}, value)
+}
+
+func (ss *SortedSet) put(key string, scoreType ScoreType, sk scoreKey, value []byte) error {
 	var newNode *SortedSetNode
 
+	if ss.length == 0 {
+		ss.scoreType = scoreType
+	} else if ss.scoreType != scoreType {
+		return ErrScoreTypeMismatch
+	}
+
 	if n, ok := ss.Dict[key]; ok {
 		// score does not change, only update value
-		if n.score == score {
+		if n.scoreKey() == sk {
 			n.Value = value
 		} else { // score changes, delete and re-insert
-			ss.delete(n.score, n.key)
-			newNode = ss.insertNode(score, key, value)
+			ss.delete(n.scoreKey(), n.key)
+			newNode = ss.insertNode(sk, key, value)
 		}
 	} else {
-		newNode = ss.insertNode(score, key, value)
+		newNode = ss.insertNode(sk, key, value)
 	}
 
 	if newNode != nil {
@@ -270,7 +362,7 @@ func (ss *SortedSet) Put(key string, score SCORE, value []byte) error {
 func (ss *SortedSet) Remove(key string) *SortedSetNode {
 	found := ss.Dict[key]
 	if found != nil {
-		ss.delete(found.score, found.key)
+		ss.delete(found.scoreKey(), found.key)
 		return found
 	}
 	return nil
@@ -279,23 +371,87 @@ func (ss *SortedSet) Remove(key string) *SortedSetNode {
 // GetByScoreRangeOptions represents the options of the GetByScoreRange function.
 type GetByScoreRangeOptions struct {
 	Limit        int  // limit the max nodes to return
+	Offset       int  // skip the first nodes in the range before returning any
 	ExcludeStart bool // exclude start value, so it search in interval (start, end] or (start, end)
 	ExcludeEnd   bool // exclude end value, so it search in interval [start, end) or (start, end)
 }
 
 // GetByScoreRange returns the nodes whose score within the specific range.
 // If options is nil, it searches in interval [start, end] without any limit by default.
+// The bounds may be math.Inf(-1) and math.Inf(1) to leave the range open.
 //
 // Time complexity of this method is : O(log(N)).
 func (ss *SortedSet) GetByScoreRange(start SCORE, end SCORE, options *GetByScoreRangeOptions) []*SortedSetNode {
+	return ss.getByScoreRange(scoreKey{score: start}, scoreKey{score: end}, options)
+}
+
+// GetByIntScoreRange returns the nodes whose int64 score within the specific range.
+// It should only be used on the sorted set with ScoreInt64 type.
+//
+// Time complexity of this method is : O(log(N)).
+func (ss *SortedSet) GetByIntScoreRange(start int64, end int64, options *GetByScoreRangeOptions) []*SortedSetNode {
+	return ss.getByScoreRange(scoreKey{score: SCORE(start), intScore: start}, scoreKey{score: SCORE(end), intScore: end}, options)
+}
+
+// CountByScoreRange returns the number of nodes GetByScoreRange would return for the same arguments,
+// without collecting them.
+//
+// Time complexity of this method is : O(log(N)).
+func (ss *SortedSet) CountByScoreRange(start SCORE, end SCORE, options *GetByScoreRangeOptions) int {
+	from, to := scoreKey{score: start}, scoreKey{score: end}
+
+	excludeStart := options != nil && options.ExcludeStart
+	excludeEnd := options != nil && options.ExcludeEnd
+	if from.compare(to) > 0 {
+		from, to = to, from
+		excludeStart, excludeEnd = excludeEnd, excludeStart
+	}
+
+	count := ss.countBefore(to, !excludeEnd) - ss.countBefore(from, excludeStart)
+
+	if options != nil && options.Offset > 0 {
+		count -= options.Offset
+	}
+	if count < 0 {
+		return 0
+	}
+	if options != nil && options.Limit > 0 && count > options.Limit {
+		return options.Limit
+	}
+	return count
+}
+
+// countBefore returns the number of nodes with a score less than sk, or less than or equal to sk if inclusive.
+func (ss *SortedSet) countBefore(sk scoreKey, inclusive bool) int {
+	count := 0
+	x := ss.header
+	for i := ss.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil {
+			cmp := x.level[i].forward.scoreKey().compare(sk)
+			if cmp > 0 || (cmp == 0 && !inclusive) {
+				break
+			}
+			count += int(x.level[i].span)
+			x = x.level[i].forward
+		}
+	}
+	return count
+}
+
+func (ss *SortedSet) getByScoreRange(start scoreKey, end scoreKey, options *GetByScoreRangeOptions) []*SortedSetNode {
 	limit := 1<<31 - 1
 	if options != nil && options.Limit > 0 {
 		limit = options.Limit
 	}
 
+	offset := 0
+	if options != nil && options.Offset > 0 {
+		offset = options.Offset
+	}
+
 	excludeStart := options != nil && options.ExcludeStart
 	excludeEnd := options != nil && options.ExcludeEnd
-	reverse := start > end
+	reverse := start.compare(end) > 0
 	if reverse {
 		start, end = end, start
 		excludeStart, excludeEnd = excludeEnd, excludeStart
@@ -310,26 +466,26 @@ func (ss *SortedSet) GetByScoreRange(start SCORE, end SCORE, options *GetByScore
 
 	if reverse {
 		// search from end to start
-		return ss.searchReverse(nodes, excludeStart, excludeEnd, start, end, limit)
+		return ss.searchReverse(nodes, excludeStart, excludeEnd, start, end, offset, limit)
 	}
 	// search from start to end
-	return ss.searchForward(nodes, excludeStart, excludeEnd, start, end, limit)
+	return ss.searchForward(nodes, excludeStart, excludeEnd, start, end, offset, limit)
 }
 
-func (ss *SortedSet) searchForward(nodes []*SortedSetNode, excludeStart, excludeEnd bool, start, end SCORE, limit int) []*SortedSetNode {
+func (ss *SortedSet) searchForward(nodes []*SortedSetNode, excludeStart, excludeEnd bool, start, end scoreKey, offset, limit int) []*SortedSetNode {
 	// search from start to end
 	x := ss.header
 	if excludeStart {
 		for i := ss.level - 1; i >= 0; i-- {
 			for x.level[i].forward != nil &&
-				x.level[i].forward.score <= start {
+				x.level[i].forward.scoreKey().compare(start) <= 0 {
 				x = x.level[i].forward
 			}
 		}
 	} else {
 		for i := ss.level - 1; i >= 0; i-- {
 			for x.level[i].forward != nil &&
-				x.level[i].forward.score < start {
+				x.level[i].forward.scoreKey().compare(start) < 0 {
 				x = x.level[i].forward
 			}
 		}
@@ -340,19 +496,23 @@ func (ss *SortedSet) searchForward(nodes []*SortedSetNode, excludeStart, exclude
 
 	for x != nil && limit > 0 {
 		if excludeEnd {
-			if x.score >= end {
+			if x.scoreKey().compare(end) >= 0 {
 				break
 			}
 		} else {
-			if x.score > end {
+			if x.scoreKey().compare(end) > 0 {
 				break
 			}
 		}
 
 		next := x.level[0].forward
 
-		nodes = append(nodes, x)
-		limit--
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, x)
+			limit--
+		}
 
 		x = next
 	}
@@ -360,40 +520,49 @@ func (ss *SortedSet) searchForward(nodes []*SortedSetNode, excludeStart, exclude
 	return nodes
 }
 
-func (ss *SortedSet) searchReverse(nodes []*SortedSetNode, excludeStart, excludeEnd bool, start, end SCORE, limit int) []*SortedSetNode {
+func (ss *SortedSet) searchReverse(nodes []*SortedSetNode, excludeStart, excludeEnd bool, start, end scoreKey, offset, limit int) []*SortedSetNode {
 	x := ss.header
 
 	if excludeEnd {
 		for i := ss.level - 1; i >= 0; i-- {
 			for x.level[i].forward != nil &&
-				x.level[i].forward.score < end {
+				x.level[i].forward.scoreKey().compare(end) < 0 {
 				x = x.level[i].forward
 			}
 		}
 	} else {
 		for i := ss.level - 1; i >= 0; i-- {
 			for x.level[i].forward != nil &&
-				x.level[i].forward.score <= end {
+				x.level[i].forward.scoreKey().compare(end) <= 0 {
 				x = x.level[i].forward
 			}
 		}
 	}
 
+	/* Current node is the last with score < or <= end, the header if there is none. */
+	if x == ss.header {
+		return nodes
+	}
+
 	for x != nil && limit > 0 {
 		if excludeStart {
-			if x.score <= start {
+			if x.scoreKey().compare(start) <= 0 {
 				break
 			}
 		} else {
-			if x.score < start {
+			if x.scoreKey().compare(start) < 0 {
 				break
 			}
 		}
 
 		next := x.backward
 
-		nodes = append(nodes, x)
-		limit--
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, x)
+			limit--
+		}
 
 		x = next
 	}
@@ -401,6 +570,86 @@ func (ss *SortedSet) searchReverse(nodes []*SortedSetNode, excludeStart, exclude
 	return nodes
 }
 
+// LexBound represents a bound of a range of keys for GetByLexRange.
+type LexBound struct {
+	Key       string
+	Exclusive bool // exclude Key itself from the range
+	Unbounded bool // leave the side of the range open, Key and Exclusive are ignored
+}
+
+// admitsAsMin reports whether key is not below the bound as the min of a range.
+func (b LexBound) admitsAsMin(key string) bool {
+	return b.Unbounded || key > b.Key || (key == b.Key && !b.Exclusive)
+}
+
+// admitsAsMax reports whether key is not above the bound as the max of a range.
+func (b LexBound) admitsAsMax(key string) bool {
+	return b.Unbounded || key < b.Key || (key == b.Key && !b.Exclusive)
+}
+
+// GetByLexRangeOptions represents the options of the GetByLexRange function.
+type GetByLexRangeOptions struct {
+	Limit  int // limit the max nodes to return
+	Offset int // skip the first nodes in the range before returning any
+}
+
+// GetByLexRange returns the nodes whose key within the range [min, max] in the byte-wise order of the keys.
+// If options is nil, it returns all the nodes in the range.
+//
+// Like Redis ZRANGEBYLEX, it is meant for the sorted sets whose nodes all have the same score, where the
+// nodes are ordered by key. If the scores differ, the nodes keep their score order and it returns the first
+// run of consecutive nodes whose keys are within the range.
+//
+// Time complexity of this method is : O(log(N)) if the nodes have the same score, O(N) otherwise.
+func (ss *SortedSet) GetByLexRange(min, max LexBound, options *GetByLexRangeOptions) []*SortedSetNode {
+	limit := 1<<31 - 1
+	if options != nil && options.Limit > 0 {
+		limit = options.Limit
+	}
+
+	offset := 0
+	if options != nil && options.Offset > 0 {
+		offset = options.Offset
+	}
+
+	var nodes []*SortedSetNode
+
+	if ss.length == 0 {
+		return nodes
+	}
+
+	x := ss.header
+	if ss.PeekMin().scoreKey() == ss.PeekMax().scoreKey() {
+		// the keys are in order, so search the first one in the range.
+		for i := ss.level - 1; i >= 0; i-- {
+			for x.level[i].forward != nil && !min.admitsAsMin(x.level[i].forward.key) {
+				x = x.level[i].forward
+			}
+		}
+	} else {
+		for x.level[0].forward != nil &&
+			!(min.admitsAsMin(x.level[0].forward.key) && max.admitsAsMax(x.level[0].forward.key)) {
+			x = x.level[0].forward
+		}
+	}
+
+	/* Current node is the last before the range. */
+	x = x.level[0].forward
+
+	for x != nil && limit > 0 && min.admitsAsMin(x.key) && max.admitsAsMax(x.key) {
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, x)
+			limit--
+		}
+
+		x = x.level[0].forward
+	}
+
+	return nodes
+}
+
 // GetByRankRange returns nodes within specific rank range [start, end].
 // Note that the rank is 1-based integer. Rank 1 means the first node; Rank -1 means the last node
 // If start is greater than end, the returned array is in reserved order
@@ -461,6 +710,27 @@ func (ss *SortedSet) GetByRankRange(start, end int, remove bool) []*SortedSetNod
 	return nodes
 }
 
+// GetByRevRankRange returns nodes within specific reverse rank range [start, end], the ranks being counted
+// from the highest score down as in FindRevRank. Rank 1 means the last node; Rank -1 means the first node.
+// If start is greater than end, the returned array is in ascending order.
+//
+// Time complexity of this method is : O(log(N) + M) with M the number of nodes returned.
+func (ss *SortedSet) GetByRevRankRange(start, end int) []*SortedSetNode {
+	size := int(ss.length)
+	start, end = ss.sanitizeIndexes(start, end)
+	if start > size && end > size {
+		return nil
+	}
+	if start > size {
+		start = size
+	}
+	if end > size {
+		end = size
+	}
+
+	return ss.GetByRankRange(size+1-start, size+1-end, false)
+}
+
 func (ss *SortedSet) sanitizeIndexes(start, end int) (newStart, newEnd int) {
 	if start < 0 {
 		start = int(ss.length) + start + 1
@@ -492,6 +762,50 @@ func (ss *SortedSet) GetByRank(rank int, remove bool) *SortedSetNode {
 	return nil
 }
 
+// Scan returns up to count nodes in rank order from the cursor, with the cursor to resume from. The scan starts
+// from an empty cursor and is done when the returned cursor is empty. The cursor holds the score and the key of
+// the last node returned rather than its rank, so it resumes from the next node in order even when that node was
+// removed, and the nodes kept for the whole scan are returned once whatever else is added or removed.
+// It returns ErrScanCursor for a malformed cursor.
+//
+// Time complexity of this method is : O(log(N) + count).
+func (ss *SortedSet) Scan(cursor []byte, count int) ([]*SortedSetNode, []byte, error) {
+	x := ss.header
+	if len(cursor) > 0 {
+		if len(cursor) < 16 {
+			return nil, nil, ErrScanCursor
+		}
+		sk := scoreKey{
+			score:    SCORE(math.Float64frombits(binary.BigEndian.Uint64(cursor))),
+			intScore: int64(binary.BigEndian.Uint64(cursor[8:])),
+		}
+		key := string(cursor[16:])
+		for i := ss.level - 1; i >= 0; i-- {
+			for x.level[i].forward != nil &&
+				(x.level[i].forward.scoreKey().compare(sk) < 0 ||
+					(x.level[i].forward.scoreKey() == sk && x.level[i].forward.key <= key)) {
+				x = x.level[i].forward
+			}
+		}
+	}
+
+	var nodes []*SortedSetNode
+	for x = x.level[0].forward; x != nil && len(nodes) < count; x = x.level[0].forward {
+		nodes = append(nodes, x)
+	}
+	if x == nil || len(nodes) == 0 {
+		return nodes, nil, nil
+	}
+
+	last := nodes[len(nodes)-1]
+	next := make([]byte, 16+len(last.key))
+	binary.BigEndian.PutUint64(next, math.Float64bits(float64(last.score)))
+	binary.BigEndian.PutUint64(next[8:], uint64(last.intScore))
+	copy(next[16:], last.key)
+
+	return nodes, next, nil
+}
+
 // GetByKey returns the  node at given key.
 // If node is not found, nil is returned
 //
@@ -512,8 +826,8 @@ func (ss *SortedSet) FindRank(key string) int {
 		x := ss.header
 		for i := ss.level - 1; i >= 0; i-- {
 			for x.level[i].forward != nil &&
-				(x.level[i].forward.score < node.score ||
-					(x.level[i].forward.score == node.score &&
+				(x.level[i].forward.scoreKey().compare(node.scoreKey()) < 0 ||
+					(x.level[i].forward.scoreKey() == node.scoreKey() &&
 						x.level[i].forward.key <= node.key)) {
 				rank += int(x.level[i].span)
 				x = x.level[i].forward
@@ -14,6 +14,10 @@
 package zset
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -336,6 +340,38 @@ func TestSortedSet_Size(t *testing.T) {
 	assertions.Equal(5, ss.Size(), "TestSortedSet_Size err")
 }
 
+func TestSortedSet_PutInt(t *testing.T) {
+	assertions := assert.New(t)
+	ss := New()
+
+	// all the scores are rounded to the same float64 value.
+	base := int64(1)<<53 + 1
+	assertions.NoError(ss.PutInt("key3", base+2, nil))
+	assertions.NoError(ss.PutInt("key1", base, nil))
+	assertions.NoError(ss.PutInt("key2", base+1, nil))
+	assertions.Equal(ScoreInt64, ss.ScoreType())
+	assertions.ErrorIs(ss.Put("key4", 1, nil), ErrScoreTypeMismatch)
+
+	assertions.Equal(1, ss.FindRank("key1"))
+	assertions.Equal(3, ss.FindRank("key3"))
+	assertions.Equal(base+2, ss.PeekMax().IntScore())
+
+	nodes := ss.GetByIntScoreRange(base+1, base+2, &GetByScoreRangeOptions{ExcludeEnd: true})
+	assertions.Len(nodes, 1)
+	assertions.Equal("key2", nodes[0].Key())
+
+	// update the score of key1 to the max.
+	assertions.NoError(ss.PutInt("key1", base+3, nil))
+	assertions.Equal("key1", ss.PeekMax().Key())
+	assertions.Equal(3, ss.Size())
+
+	for ss.Size() > 0 {
+		ss.PopMin()
+	}
+	assertions.NoError(ss.Put("key4", 1, nil))
+	assertions.Equal(ScoreFloat64, ss.ScoreType())
+}
+
 func getResultSet(items ...string) map[string]struct{} {
 	resultSet := make(map[string]struct{}, len(items))
 
@@ -345,3 +381,157 @@ func getResultSet(items ...string) map[string]struct{} {
 
 	return resultSet
 }
+
+func TestSortedSet_CountByScoreRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bounds := func() SCORE {
+		switch r.Intn(10) {
+		case 0:
+			return SCORE(math.Inf(-1))
+		case 1:
+			return SCORE(math.Inf(1))
+		}
+		// few distinct scores so that the bounds often hit ties.
+		return SCORE(r.Intn(50) - 25)
+	}
+
+	InitData(t)
+	// all the scores are above the range, the reverse search must not return the header.
+	assert.Empty(t, ss.GetByScoreRange(0, -10, nil))
+	assert.Equal(t, 0, ss.CountByScoreRange(0, -10, nil))
+
+	for round := 0; round < 20; round++ {
+		ss := New()
+		for i := 0; i < r.Intn(500); i++ {
+			assert.NoError(t, ss.Put(fmt.Sprint(r.Intn(300)), SCORE(r.Intn(40)-20), nil))
+		}
+		for i := 0; i < r.Intn(100); i++ {
+			ss.Remove(fmt.Sprint(r.Intn(300)))
+		}
+
+		for i := 0; i < 200; i++ {
+			start, end := bounds(), bounds()
+			opts := &GetByScoreRangeOptions{ExcludeStart: r.Intn(2) == 0, ExcludeEnd: r.Intn(2) == 0}
+			if r.Intn(4) == 0 {
+				opts.Offset, opts.Limit = r.Intn(20), r.Intn(20)
+			}
+
+			assert.Equal(t, len(ss.GetByScoreRange(start, end, opts)), ss.CountByScoreRange(start, end, opts), "%v %v %+v", start, end, *opts)
+			assert.Equal(t, len(ss.GetByScoreRange(start, end, nil)), ss.CountByScoreRange(start, end, nil), "%v %v", start, end)
+		}
+	}
+}
+
+func TestSortedSet_GetByLexRange(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ss := New()
+	var keys []string
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%03x", r.Intn(4096))
+		if ss.GetByKey(key) == nil {
+			keys = append(keys, key)
+		}
+		assert.NoError(t, ss.Put(key, 0, nil))
+	}
+	sort.Strings(keys)
+
+	bound := func() LexBound {
+		if r.Intn(10) == 0 {
+			return LexBound{Unbounded: true}
+		}
+		// the bounds often land on the keys.
+		return LexBound{Key: fmt.Sprintf("%03x", r.Intn(4096)), Exclusive: r.Intn(2) == 0}
+	}
+
+	for i := 0; i < 1000; i++ {
+		min, max := bound(), bound()
+		opts := &GetByLexRangeOptions{Offset: r.Intn(5), Limit: r.Intn(50)}
+
+		var expected []string
+		for _, key := range keys {
+			if min.admitsAsMin(key) && max.admitsAsMax(key) {
+				expected = append(expected, key)
+			}
+		}
+		if opts.Offset < len(expected) {
+			expected = expected[opts.Offset:]
+		} else {
+			expected = nil
+		}
+		if opts.Limit > 0 && opts.Limit < len(expected) {
+			expected = expected[:opts.Limit]
+		}
+
+		var got []string
+		for _, node := range ss.GetByLexRange(min, max, opts) {
+			got = append(got, node.Key())
+		}
+		assert.Equal(t, expected, got, "%+v %+v %+v", min, max, *opts)
+	}
+}
+
+func TestSortedSet_Scan(t *testing.T) {
+	ss := New()
+	// ties on the score are ordered by the key, and the int64 scores above 2^53 by their exact value.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, ss.PutInt(fmt.Sprint(i), 1<<60+int64(i%3), nil))
+	}
+	all := ss.GetByRankRange(1, -1, false)
+
+	var scanned []*SortedSetNode
+	var cursor []byte
+	for {
+		nodes, next, err := ss.Scan(cursor, 3)
+		assert.NoError(t, err)
+		scanned = append(scanned, nodes...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, all, scanned)
+
+	// the removed last node of a page is resumed from the next node.
+	nodes, cursor, err := ss.Scan(nil, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, all[:4], nodes)
+	ss.Remove(all[3].Key())
+	ss.Remove(all[4].Key())
+	nodes, _, err = ss.Scan(cursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, all[5:7], nodes)
+
+	// the last page ends the scan.
+	nodes, cursor, err = ss.Scan(cursor, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, all[5:], nodes)
+	assert.Nil(t, cursor)
+
+	_, _, err = ss.Scan([]byte("short"), 1)
+	assert.Equal(t, ErrScanCursor, err)
+
+	nodes, cursor, err = New().Scan(nil, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, nodes)
+	assert.Nil(t, cursor)
+}
+
+func TestSortedSet_GetByRevRankRange(t *testing.T) {
+	InitData(t)
+
+	keys := func(nodes []*SortedSetNode) (keys []string) {
+		for _, node := range nodes {
+			keys = append(keys, node.Key())
+		}
+		return keys
+	}
+
+	all := keys(ss.GetByRankRange(-1, 1, false))
+	assert.Equal(t, all, keys(ss.GetByRevRankRange(1, -1)))
+	assert.Equal(t, all[:2], keys(ss.GetByRevRankRange(1, 2)))
+	assert.Equal(t, []string{all[1], all[0]}, keys(ss.GetByRevRankRange(2, 1)))
+	assert.Equal(t, all[len(all)-2:], keys(ss.GetByRevRankRange(-2, -1)))
+	assert.Equal(t, all[len(all)-1:], keys(ss.GetByRevRankRange(len(all), len(all)+5)))
+	assert.Empty(t, ss.GetByRevRankRange(len(all)+1, len(all)+5))
+	assert.Empty(t, New().GetByRevRankRange(1, -1))
+}
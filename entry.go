@@ -15,6 +15,7 @@
 package nutsdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
@@ -26,6 +27,9 @@ import (
 
 var payLoadSizeMismatchErr = errors.New("the payload size in meta mismatch with the payload size needed")
 
+// ErrEntryMetaVersion is returned when the entry is written in a format newer than MetaVersionExpireAt.
+var ErrEntryMetaVersion = errors.New("unsupported format version of the entry")
+
 type (
 	// Entry represents the data item.
 	Entry struct {
@@ -55,16 +59,35 @@ type (
 		Status     uint16 // committed / uncommitted
 		Ds         uint16 // data structure
 		Crc        uint32
+		ExpireAt   uint64 // the unix time in milliseconds the entry expires at, 0 for expiring by the TTL
+		Version    uint8  // the format of the entry, MetaVersionExpireAt if ExpireAt is set
 	}
 )
 
+const (
+	// MetaVersionTTL represents the format of the entries expiring by the TTL in seconds.
+	MetaVersionTTL uint8 = iota
+
+	// MetaVersionExpireAt represents the format of the entries expiring at ExpireAt, which follows the header.
+	MetaVersionExpireAt
+)
+
 func (meta *MetaData) PayloadSize() int64 {
 	return int64(meta.BucketSize) + int64(meta.KeySize) + int64(meta.ValueSize)
 }
 
+// HeaderSize returns the size of the header of the entry, including the expiration of MetaVersionExpireAt.
+func (meta *MetaData) HeaderSize() int64 {
+	if meta.Version >= MetaVersionExpireAt {
+		return DataEntryHeaderSize + DataEntryExpireAtSize
+	}
+
+	return DataEntryHeaderSize
+}
+
 // Size returns the size of the entry.
 func (e *Entry) Size() int64 {
-	return int64(DataEntryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.BucketSize)
+	return e.Meta.HeaderSize() + e.Meta.PayloadSize()
 }
 
 // Encode returns the slice after the entry be encoded.
@@ -75,18 +98,22 @@ func (e *Entry) Size() int64 {
 //	|----------------------------------------------------------------------------------------------------------------|
 //	| uint32| uint64  |uint32 |  uint32 | uint16  | uint32| uint32 | uint16 | uint16 |uint64 |[]byte|[]byte | []byte |
 //	|----------------------------------------------------------------------------------------------------------------|
+//
+// The high byte of the status is the version of the format, the entry of MetaVersionExpireAt has its expiration
+// as an uint64 between the txId and the bucket.
 func (e *Entry) Encode() []byte {
-	keySize := e.Meta.KeySize
-	valueSize := e.Meta.ValueSize
-	bucketSize := e.Meta.BucketSize
+	keySize := int64(e.Meta.KeySize)
+	valueSize := int64(e.Meta.ValueSize)
+	bucketSize := int64(e.Meta.BucketSize)
+	headerSize := e.Meta.HeaderSize()
 
 	// set DataItemHeader buf
 	buf := make([]byte, e.Size())
 	buf = e.setEntryHeaderBuf(buf)
 	// set bucket\key\value
-	copy(buf[DataEntryHeaderSize:(DataEntryHeaderSize+bucketSize)], e.Bucket)
-	copy(buf[(DataEntryHeaderSize+bucketSize):(DataEntryHeaderSize+bucketSize+keySize)], e.Key)
-	copy(buf[(DataEntryHeaderSize+bucketSize+keySize):(DataEntryHeaderSize+bucketSize+keySize+valueSize)], e.Value)
+	copy(buf[headerSize:(headerSize+bucketSize)], e.Bucket)
+	copy(buf[(headerSize+bucketSize):(headerSize+bucketSize+keySize)], e.Key)
+	copy(buf[(headerSize+bucketSize+keySize):(headerSize+bucketSize+keySize+valueSize)], e.Value)
 
 	c32 := crc32.ChecksumIEEE(buf[4:])
 	binary.LittleEndian.PutUint32(buf[0:4], c32)
@@ -94,6 +121,19 @@ func (e *Entry) Encode() []byte {
 	return buf
 }
 
+// encodeTo appends the entry encoded as Encode does to the buffer, without allocating the encoded entry.
+func (e *Entry) encodeTo(buff *bytes.Buffer) {
+	var header [DataEntryHeaderSize + DataEntryExpireAtSize]byte
+	buf := e.setEntryHeaderBuf(header[:e.Meta.HeaderSize()])
+
+	binary.LittleEndian.PutUint32(buf[0:4], e.GetCrc(buf))
+
+	buff.Write(buf)
+	buff.Write(e.Bucket)
+	buff.Write(e.Key)
+	buff.Write(e.Value)
+}
+
 // setEntryHeaderBuf sets the entry header buff.
 func (e *Entry) setEntryHeaderBuf(buf []byte) []byte {
 	binary.LittleEndian.PutUint32(buf[0:4], e.Meta.Crc)
@@ -103,9 +143,12 @@ func (e *Entry) setEntryHeaderBuf(buf []byte) []byte {
 	binary.LittleEndian.PutUint16(buf[20:22], e.Meta.Flag)
 	binary.LittleEndian.PutUint32(buf[22:26], e.Meta.TTL)
 	binary.LittleEndian.PutUint32(buf[26:30], e.Meta.BucketSize)
-	binary.LittleEndian.PutUint16(buf[30:32], e.Meta.Status)
+	binary.LittleEndian.PutUint16(buf[30:32], e.Meta.Status|uint16(e.Meta.Version)<<8)
 	binary.LittleEndian.PutUint16(buf[32:34], e.Meta.Ds)
 	binary.LittleEndian.PutUint64(buf[34:42], e.Meta.TxID)
+	if e.Meta.Version >= MetaVersionExpireAt {
+		binary.LittleEndian.PutUint64(buf[42:50], e.Meta.ExpireAt)
+	}
 
 	return buf
 }
@@ -156,18 +199,32 @@ func (e *Entry) checkPayloadSize(size int64) error {
 }
 
 // ParseMeta parse meta object to entry
+// Only the first DataEntryHeaderSize bytes of buf are parsed, the expiration of MetaVersionExpireAt is parsed
+// by ParseExpireAt.
 func (e *Entry) ParseMeta(buf []byte) error {
+	status := binary.LittleEndian.Uint16(buf[30:32])
 	e.Meta = NewMetaData().WithCrc(binary.LittleEndian.Uint32(buf[0:4])).
 		WithTimeStamp(binary.LittleEndian.Uint64(buf[4:12])).WithKeySize(binary.LittleEndian.Uint32(buf[12:16])).
 		WithValueSize(binary.LittleEndian.Uint32(buf[16:20])).WithFlag(binary.LittleEndian.Uint16(buf[20:22])).
 		WithTTL(binary.LittleEndian.Uint32(buf[22:26])).WithBucketSize(binary.LittleEndian.Uint32(buf[26:30])).
-		WithStatus(binary.LittleEndian.Uint16(buf[30:32])).WithDs(binary.LittleEndian.Uint16(buf[32:34])).
+		WithStatus(status & 0xff).WithDs(binary.LittleEndian.Uint16(buf[32:34])).
 		WithTxID(binary.LittleEndian.Uint64(buf[34:42]))
+	e.Meta.Version = uint8(status >> 8)
+	if e.Meta.Version > MetaVersionExpireAt {
+		return ErrEntryMetaVersion
+	}
 	return nil
 }
 
-// isFilter to confirm if this entry is can be filtered
-func (e *Entry) isFilter() bool {
+// ParseExpireAt parses the expiration of MetaVersionExpireAt from the whole header in buf.
+func (e *Entry) ParseExpireAt(buf []byte) {
+	if e.Meta.Version >= MetaVersionExpireAt {
+		e.Meta.ExpireAt = binary.LittleEndian.Uint64(buf[DataEntryHeaderSize : DataEntryHeaderSize+DataEntryExpireAtSize])
+	}
+}
+
+// isFilter to confirm if this entry is can be filtered, it's expired at the unix time now in milliseconds.
+func (e *Entry) isFilter(now uint64) bool {
 	meta := e.Meta
 	var filterDataSet = []uint16{
 		DataDeleteFlag,
@@ -181,8 +238,7 @@ func (e *Entry) isFilter() bool {
 		DataZPopMinFlag,
 		DataLRemByIndex,
 	}
-	if OneOfUint16Array(meta.Flag, filterDataSet) ||
-		IsExpired(meta.TTL, meta.Timestamp) {
+	if OneOfUint16Array(meta.Flag, filterDataSet) || meta.isExpiredAt(now) {
 		return true
 	}
 
@@ -292,6 +348,16 @@ func (meta *MetaData) WithTTL(ttl uint32) *MetaData {
 	return meta
 }
 
+// WithExpireAt sets the expiration in milliseconds, and the version MetaVersionExpireAt unless it's 0.
+func (meta *MetaData) WithExpireAt(expireAt uint64) *MetaData {
+	meta.ExpireAt = expireAt
+	meta.Version = MetaVersionTTL
+	if expireAt != 0 {
+		meta.Version = MetaVersionExpireAt
+	}
+	return meta
+}
+
 func (meta *MetaData) WithFlag(flag uint16) *MetaData {
 	meta.Flag = flag
 	return meta
@@ -336,11 +402,13 @@ func (e Entries) Less(i, j int) bool {
 
 func (e Entries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
 
-func (e Entries) processEntriesScanOnDisk() (result []*Entry) {
+// processEntriesScanOnDisk sorts the entries and drops the deleted ones and the ones expired at the unix time now
+// in milliseconds.
+func (e Entries) processEntriesScanOnDisk(now uint64) (result []*Entry) {
 	sort.Sort(e)
 	for _, ele := range e {
 		curE := ele
-		if !IsExpired(curE.Meta.TTL, curE.Meta.Timestamp) && curE.Meta.Flag != DataDeleteFlag {
+		if !curE.Meta.isExpiredAt(now) && curE.Meta.Flag != DataDeleteFlag {
 			result = append(result, curE)
 		}
 	}
@@ -374,11 +442,11 @@ func (c CEntries) Less(i, j int) bool {
 
 func (c CEntries) Swap(i, j int) { c.Entries[i], c.Entries[j] = c.Entries[j], c.Entries[i] }
 
-func (c CEntries) processEntriesScanOnDisk() (result []*Entry) {
+func (c CEntries) processEntriesScanOnDisk(now uint64) (result []*Entry) {
 	sort.Sort(c)
 	for _, ele := range c.Entries {
 		curE := ele
-		if !IsExpired(curE.Meta.TTL, curE.Meta.Timestamp) && curE.Meta.Flag != DataDeleteFlag {
+		if !curE.Meta.isExpiredAt(now) && curE.Meta.Flag != DataDeleteFlag {
 			result = append(result, curE)
 		}
 	}
@@ -15,6 +15,7 @@
 package nutsdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"reflect"
 	"testing"
@@ -46,6 +47,19 @@ func (suite *EntryTestSuite) TestEncode() {
 	assert.True(suite.T(), ok, "entry's encode test fail")
 }
 
+func (suite *EntryTestSuite) TestEncodeTo() {
+	buff := bytes.NewBufferString("prefix")
+	suite.entry.encodeTo(buff)
+	assert.Equal(suite.T(), append([]byte("prefix"), suite.expectedEncode...), buff.Bytes())
+
+	entry := suite.entry
+	entry.Meta = NewMetaData().WithKeySize(entry.Meta.KeySize).WithValueSize(entry.Meta.ValueSize).
+		WithBucketSize(entry.Meta.BucketSize).WithTimeStamp(1547707905).WithExpireAt(1547707905000)
+	buff.Reset()
+	entry.encodeTo(buff)
+	assert.Equal(suite.T(), entry.Encode(), buff.Bytes())
+}
+
 func (suite *EntryTestSuite) TestIsZero() {
 
 	if ok := suite.entry.IsZero(); ok {
@@ -122,10 +136,10 @@ func TestEntries_processEntriesScanOnDisk(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.wantResult, tt.e.processEntriesScanOnDisk(), "processEntriesScanOnDisk()")
+			assert.Equalf(t, tt.wantResult, tt.e.processEntriesScanOnDisk(unixMillis(nil)), "processEntriesScanOnDisk()")
 		})
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.wantResult, tt.e.ToCEntries(nil).processEntriesScanOnDisk(), "CEntries.processEntriesScanOnDisk()")
+			assert.Equalf(t, tt.wantResult, tt.e.ToCEntries(nil).processEntriesScanOnDisk(unixMillis(nil)), "CEntries.processEntriesScanOnDisk()")
 		})
 	}
 }
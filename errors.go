@@ -36,3 +36,8 @@ func IsPrefixScan(err error) bool {
 func IsPrefixSearchScan(err error) bool {
 	return errors.Is(err, ErrPrefixSearchScan)
 }
+
+// IsResultTooLarge is true if the scan result exceeds Options.MaxScanResultBytes.
+func IsResultTooLarge(err error) bool {
+	return errors.Is(err, ErrResultTooLarge)
+}
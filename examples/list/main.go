@@ -210,15 +210,12 @@ func testLRem() {
 	if err := db.Update(
 		func(tx *nutsdb.Tx) error {
 			key := []byte("myList")
-			err := tx.LRem(bucket, key, count, value)
+			removed, err := tx.LRem(bucket, key, count, value)
+			fmt.Println("LRem count : ", removed, string(value))
 			return err
 		}); err != nil {
 		log.Fatal(err)
 	}
-	if count < 0 {
-		count = -count
-	}
-	fmt.Println("LRem count : ", count, string(value))
 }
 
 func testLSet() {
@@ -299,7 +296,8 @@ func testLRemByIndex() {
 	if err := db.Update(
 		func(tx *nutsdb.Tx) error {
 			key := []byte("myList")
-			err := tx.LRemByIndex(bucket, key, 0)
+			removedNum, err := tx.LRemByIndex(bucket, key, 0)
+			fmt.Printf("removed num %d\n", removedNum)
 			return err
 		}); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,346 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntry_ExpireAt(t *testing.T) {
+	newEntry := func(expireAt uint64) *Entry {
+		meta := NewMetaData().WithKeySize(uint32(len("key"))).WithValueSize(uint32(len("val"))).
+			WithTimeStamp(1547707905).WithTTL(10).WithBucketSize(uint32(len("bucket"))).WithFlag(DataSetFlag).
+			WithStatus(Committed).WithExpireAt(expireAt)
+		return NewEntry().WithKey([]byte("key")).WithValue([]byte("val")).WithBucket([]byte("bucket")).WithMeta(meta)
+	}
+
+	// the entries without the expiration keep the old format.
+	old := newEntry(0)
+	require.Equal(t, MetaVersionTTL, old.Meta.Version)
+	require.Equal(t, int64(DataEntryHeaderSize+len("bucketkeyval")), old.Size())
+	require.Equal(t, uint16(Committed), binary.LittleEndian.Uint16(old.Encode()[30:32]))
+
+	expect := newEntry(1547707905123)
+	require.Equal(t, MetaVersionExpireAt, expect.Meta.Version)
+	require.Equal(t, int64(DataEntryHeaderSize+DataEntryExpireAtSize+len("bucketkeyval")), expect.Size())
+
+	path := "/tmp/test_entry_expire_at"
+	require.NoError(t, os.RemoveAll(path))
+	defer os.RemoveAll(path)
+
+	fm := newFileManager(FileIO, 1024, 0.5)
+	df, err := fm.getDataFile(path, 1024)
+	require.NoError(t, err)
+	_, err = df.WriteAt(old.Encode(), 0)
+	require.NoError(t, err)
+	_, err = df.WriteAt(expect.Encode(), old.Size())
+	require.NoError(t, err)
+	require.NoError(t, df.Sync())
+
+	// each reader parses both formats.
+	for _, want := range []struct {
+		entry *Entry
+		off   int64
+	}{{old, 0}, {expect, old.Size()}} {
+		e, err := df.ReadAt(int(want.off))
+		require.NoError(t, err)
+		require.Equal(t, want.entry.Encode(), e.Encode())
+
+		e, err = df.ReadRecord(int(want.off), want.entry.Meta.PayloadSize())
+		require.NoError(t, err)
+		require.Equal(t, want.entry.Encode(), e.Encode())
+		require.Equal(t, []byte("val"), e.Value)
+	}
+	require.NoError(t, df.Release())
+	require.NoError(t, fm.close())
+
+	f, err := newFileRecovery(path, 4096)
+	require.NoError(t, err)
+	defer f.release()
+	for _, want := range []*Entry{old, expect} {
+		e, err := f.readEntry()
+		require.NoError(t, err)
+		require.Equal(t, want.Encode(), e.Encode())
+	}
+
+	// the formats of the later versions are refused.
+	buf := expect.Encode()
+	binary.LittleEndian.PutUint16(buf[30:32], uint16(Committed)|uint16(MetaVersionExpireAt+1)<<8)
+	require.Equal(t, ErrEntryMetaVersion, NewEntry().ParseMeta(buf))
+}
+
+func TestMetaData_IsExpired(t *testing.T) {
+	now := time.Now()
+	millis := func(t time.Time) uint64 {
+		return uint64(t.UnixNano() / int64(time.Millisecond))
+	}
+
+	meta := NewMetaData().WithTimeStamp(uint64(now.Unix()) - 10).WithTTL(5)
+	require.True(t, meta.IsExpired())
+	require.Equal(t, (uint64(now.Unix())-5)*1000, meta.ExpireAtMillis())
+
+	// the expiration overrides the ttl.
+	meta.WithExpireAt(millis(now.Add(time.Minute)))
+	require.False(t, meta.IsExpired())
+	meta.WithExpireAt(millis(now.Add(-time.Millisecond)))
+	require.True(t, meta.IsExpired())
+
+	meta = NewMetaData().WithTimeStamp(uint64(now.Unix()) - 10).WithTTL(Persistent)
+	require.False(t, meta.IsExpired())
+	require.Equal(t, uint64(0), meta.ExpireAtMillis())
+}
+
+func TestTx_PutWithTTL(t *testing.T) {
+	bucket := "bucket"
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.PutWithTTL(bucket, []byte("short"), []byte("value"), 500*time.Millisecond); err != nil {
+				return err
+			}
+			return tx.PutWithTTL(bucket, []byte("persistent"), []byte("value"), 0)
+		}))
+
+		clock.Add(400 * time.Millisecond)
+		txGet(t, db, bucket, []byte("short"), []byte("value"), nil)
+
+		clock.Add(300 * time.Millisecond)
+		txGet(t, db, bucket, []byte("short"), nil, ErrNotFoundKey)
+		txGet(t, db, bucket, []byte("persistent"), []byte("value"), nil)
+	})
+}
+
+func TestTx_PutWithExpireAt(t *testing.T) {
+	bucket := "bucket"
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.PutWithExpireAt(bucket, []byte("later"), []byte("value"), expireAt); err != nil {
+			return err
+		}
+		if err := tx.PutWithExpireAt(bucket, []byte("past"), []byte("value"), time.Now().Add(-time.Second)); err != nil {
+			return err
+		}
+		if err := tx.PutWithExpireAt(bucket, []byte("zero"), []byte("value"), time.Time{}); err != nil {
+			return err
+		}
+		// the records of the old format are put along.
+		if err := tx.Put(bucket, []byte("ttl"), []byte("value"), 3600); err != nil {
+			return err
+		}
+		return tx.PutWithTimestamp(bucket, []byte("expired"), []byte("value"), 1, uint64(time.Now().Unix())-10)
+	}))
+
+	// the expired records dropped by merge are not indexed after a reopen.
+	check := func(expiredErr error) {
+		txGet(t, db, bucket, []byte("later"), []byte("value"), nil)
+		txGet(t, db, bucket, []byte("zero"), []byte("value"), nil)
+		txGet(t, db, bucket, []byte("ttl"), []byte("value"), nil)
+		txGet(t, db, bucket, []byte("past"), nil, expiredErr)
+		txGet(t, db, bucket, []byte("expired"), nil, expiredErr)
+
+		var metas []*MetaData
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for _, key := range []string{"later", "zero", "ttl"} {
+				r, err := db.BPTreeIdx[bucket].Find([]byte(key))
+				if err != nil {
+					return err
+				}
+				metas = append(metas, r.H.Meta)
+			}
+			return nil
+		}))
+		require.Equal(t, uint64(expireAt.UnixNano()/int64(time.Millisecond)), metas[0].ExpireAt)
+		require.Equal(t, MetaVersionExpireAt, metas[0].Version)
+		require.True(t, metas[0].TTL >= 3600)
+		require.Equal(t, uint64(0), metas[1].ExpireAt)
+		require.Equal(t, Persistent, metas[1].TTL)
+		require.Equal(t, uint64(0), metas[2].ExpireAt)
+		require.Equal(t, uint32(3600), metas[2].TTL)
+	}
+	check(ErrNotFoundKey)
+
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+	reopen()
+	check(ErrNotFoundKey)
+
+	// merge rewrites the expiration of the live records.
+	for i := 0; i < 100; i++ {
+		txPut(t, db, bucket, []byte("filler"), GetRandomBytes(64), Persistent, nil)
+	}
+	require.NoError(t, db.Merge())
+	check(ErrNotFoundKey)
+	reopen()
+	check(ErrKeyNotFound)
+}
+
+func TestTx_ExpireAt(t *testing.T) {
+	bucket := "bucket"
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	for _, key := range []string{"past", "deadline", "persisted", "zero"} {
+		txPut(t, db, bucket, []byte(key), []byte("value"), Persistent, nil)
+	}
+	deadline := clock.Now().Add(time.Hour)
+	var errs []error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		errs = append(errs, tx.ExpireAt(bucket, []byte("past"), clock.Now().Add(-time.Second)))
+		errs = append(errs, tx.ExpireAt(bucket, []byte("deadline"), deadline))
+		errs = append(errs, tx.ExpireAt(bucket, []byte("persisted"), deadline))
+		errs = append(errs, tx.ExpireAt(bucket, []byte("zero"), deadline))
+		errs = append(errs, tx.ExpireAt(bucket, []byte("missing"), deadline))
+		return nil
+	}))
+	require.Equal(t, []error{nil, nil, nil, nil, ErrKeyNotFound}, errs)
+
+	// the deadline in the past expires the key at once.
+	txGet(t, db, bucket, []byte("past"), nil, ErrNotFoundKey)
+
+	// Persist and the zero time clear the deadline.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Persist(bucket, []byte("persisted")); err != nil {
+			return err
+		}
+		return tx.ExpireAt(bucket, []byte("zero"), time.Time{})
+	}))
+
+	// the deadline holds across a restart, however long the db is closed.
+	require.NoError(t, db.Close())
+	clock.Add(time.Hour - time.Millisecond)
+	db, err = Open(opts)
+	require.NoError(t, err)
+	txGet(t, db, bucket, []byte("deadline"), []byte("value"), nil)
+
+	clock.Add(time.Millisecond)
+	txGet(t, db, bucket, []byte("deadline"), nil, ErrNotFoundKey)
+	txGet(t, db, bucket, []byte("persisted"), []byte("value"), nil)
+	txGet(t, db, bucket, []byte("zero"), []byte("value"), nil)
+
+	require.NoError(t, db.Close())
+	clock.Add(24 * time.Hour)
+	db, err = Open(opts)
+	require.NoError(t, err)
+	txGet(t, db, bucket, []byte("deadline"), nil, ErrNotFoundKey)
+	txGet(t, db, bucket, []byte("persisted"), []byte("value"), nil)
+}
+
+func TestTx_GetKeysExpiringBetween(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		clock := NewFakeClock(time.Now())
+		opts := DefaultOptions
+		opts.Dir = NutsDBTestDirPath
+		opts.Clock = clock
+		opts.ActiveExpireInterval = interval
+		require.NoError(t, os.RemoveAll(opts.Dir))
+
+		withDBOption(t, opts, func(t *testing.T, db *DB) {
+			now := clock.Now()
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for key, at := range map[string]time.Duration{
+					"before":   5 * time.Second,
+					"from":     10 * time.Second,
+					"inside":   15 * time.Second,
+					"to":       20 * time.Second,
+					"after":    30 * time.Second,
+					"deleted":  15 * time.Second,
+					"extended": 15 * time.Second,
+					"expired":  -time.Second,
+				} {
+					if err := tx.PutWithExpireAt("bucket", []byte(key), []byte("value"), now.Add(at)); err != nil {
+						return err
+					}
+				}
+				if err := tx.Put("bucket", []byte("persistent"), []byte("value"), Persistent); err != nil {
+					return err
+				}
+				if err := tx.Put("bucket", []byte("seconds"), []byte("value"), 25); err != nil {
+					return err
+				}
+				return tx.PutWithExpireAt("other", []byte("inside"), []byte("value"), now.Add(15*time.Second))
+			}))
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				if err := tx.Delete("bucket", []byte("deleted")); err != nil {
+					return err
+				}
+				return tx.ExpireAt("bucket", []byte("extended"), now.Add(time.Minute))
+			}))
+
+			get := func(bucket string, from, to time.Time) (keys []string) {
+				require.NoError(t, db.View(func(tx *Tx) error {
+					found, err := tx.GetKeysExpiringBetween(bucket, from, to)
+					for _, key := range found {
+						keys = append(keys, string(key))
+					}
+					return err
+				}))
+				return keys
+			}
+
+			// the window includes from and excludes to.
+			from, to := now.Add(10*time.Second), now.Add(20*time.Second)
+			require.Equal(t, []string{"from", "inside"}, get("bucket", from, to))
+			require.Equal(t, []string{"inside"}, get("other", from, to))
+			require.Nil(t, get("missing", from, to))
+			require.Equal(t, []string{"from", "inside", "inside"}, get("", from, to))
+
+			// the ttls in seconds count from the timestamp of the key.
+			require.Equal(t, []string{"seconds"}, get("bucket", now.Truncate(time.Second).Add(25*time.Second),
+				now.Truncate(time.Second).Add(26*time.Second)))
+
+			// the keys expired already are left out of a window in the past.
+			require.Nil(t, get("bucket", now.Add(-time.Hour), now))
+			require.Equal(t, []string{"before", "from"}, get("bucket", now.Add(-time.Hour), from.Add(time.Millisecond)))
+			clock.Add(10 * time.Second)
+			require.Equal(t, []string{"inside"}, get("bucket", now, to))
+			require.Nil(t, get("bucket", to, from))
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// expiredKey is a key noticed expired, queued for Options.OnExpired.
+type expiredKey struct {
+	bucket string
+	key    []byte
+}
+
+// expiredNotifier queues the keys noticed expired and calls Options.OnExpired with them from its own goroutine,
+// so a slow callback doesn't hold the lock of the tx which noticed the key. A key is notified once for each
+// expiration, the expiration notified is kept until the key is written again or dropped by the expirer.
+type expiredNotifier struct {
+	onExpired    func(bucket string, key []byte)
+	errorHandler ErrorHandler
+
+	mu       sync.Mutex
+	notified map[string]uint64 // the expiration notified, by bucket and key
+	queue    []expiredKey
+
+	signalCh  chan struct{}
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+func newExpiredNotifier(onExpired func(bucket string, key []byte), errorHandler ErrorHandler) *expiredNotifier {
+	n := &expiredNotifier{
+		onExpired:    onExpired,
+		errorHandler: errorHandler,
+		notified:     make(map[string]uint64),
+		signalCh:     make(chan struct{}, 1),
+		closeCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+	go n.run()
+
+	return n
+}
+
+// notify queues the key expired at expireAt in milliseconds, unless it's notified already. The key is copied.
+func (n *expiredNotifier) notify(bucket string, key []byte, expireAt uint64) {
+	n.mu.Lock()
+	id := string(getNewKey(bucket, key))
+	if notified, ok := n.notified[id]; ok && notified == expireAt {
+		n.mu.Unlock()
+		return
+	}
+	n.notified[id] = expireAt
+	n.enqueue(bucket, key)
+	n.mu.Unlock()
+
+	n.signal()
+}
+
+// notifyDropped queues the key expired at expireAt and dropped from the index by the expirer, unless it's
+// notified already. The key can't be noticed again, so its expiration is not kept.
+func (n *expiredNotifier) notifyDropped(bucket string, key []byte, expireAt uint64) {
+	n.mu.Lock()
+	id := string(getNewKey(bucket, key))
+	if notified, ok := n.notified[id]; !ok || notified != expireAt {
+		n.enqueue(bucket, key)
+	}
+	delete(n.notified, id)
+	n.mu.Unlock()
+
+	n.signal()
+}
+
+// forget drops the expiration notified of the key written again.
+func (n *expiredNotifier) forget(bucket string, key []byte) {
+	n.mu.Lock()
+	if len(n.notified) > 0 {
+		delete(n.notified, string(getNewKey(bucket, key)))
+	}
+	n.mu.Unlock()
+}
+
+func (n *expiredNotifier) enqueue(bucket string, key []byte) {
+	n.queue = append(n.queue, expiredKey{bucket: bucket, key: append([]byte(nil), key...)})
+}
+
+func (n *expiredNotifier) signal() {
+	select {
+	case n.signalCh <- struct{}{}:
+	default:
+	}
+}
+
+func (n *expiredNotifier) run() {
+	defer close(n.doneCh)
+
+	for {
+		select {
+		case <-n.signalCh:
+			n.dispatch()
+		case <-n.closeCh:
+			n.dispatch()
+			return
+		}
+	}
+}
+
+// dispatch calls the callback with the keys queued so far.
+func (n *expiredNotifier) dispatch() {
+	n.mu.Lock()
+	queue := n.queue
+	n.queue = nil
+	n.mu.Unlock()
+
+	for _, expired := range queue {
+		n.call(expired)
+	}
+}
+
+// call calls the callback with the key, a panic is recovered and passed to Options.ErrorHandler.
+func (n *expiredNotifier) call(expired expiredKey) {
+	defer func() {
+		if r := recover(); r != nil && n.errorHandler != nil {
+			n.errorHandler.HandleError(fmt.Errorf("panic when calling OnExpired, err is %+v", r))
+		}
+	}()
+
+	n.onExpired(expired.bucket, expired.key)
+}
+
+// close dispatches the keys queued and stops the notifier.
+func (n *expiredNotifier) close() {
+	n.closeOnce.Do(func() {
+		close(n.closeCh)
+		<-n.doneCh
+	})
+}
+
+// isExpired returns if the record of the key read from the bucket has expired, and notifies Options.OnExpired
+// of it. The tombstones are not notified.
+func (tx *Tx) isExpired(bucket string, key []byte, meta *MetaData) bool {
+	if !tx.db.isExpired(meta) {
+		return false
+	}
+
+	if tx.db.expiredNotifier != nil && meta.Flag != DataDeleteFlag {
+		tx.db.expiredNotifier.notify(bucket, key, meta.ExpireAtMillis())
+	}
+
+	return true
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// expiredRecorder counts the keys notified to Options.OnExpired.
+type expiredRecorder struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	errs    []error
+	release chan struct{} // the callback waits for it if it's set
+}
+
+func (r *expiredRecorder) onExpired(bucket string, key []byte) {
+	r.mu.Lock()
+	release := r.release
+	r.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+
+	r.mu.Lock()
+	r.counts[bucket+"/"+string(key)]++
+	r.mu.Unlock()
+
+	if string(key) == "panic" {
+		panic("callback failed")
+	}
+}
+
+func (r *expiredRecorder) snapshot() (map[string]int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int, len(r.counts))
+	for key, n := range r.counts {
+		counts[key] = n
+	}
+
+	return counts, len(r.errs)
+}
+
+func TestDB_OnExpired(t *testing.T) {
+	recorder := &expiredRecorder{counts: make(map[string]int)}
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.OnExpired = recorder.onExpired
+	opts.ErrorHandler = ErrorHandlerFunc(func(err error) {
+		recorder.mu.Lock()
+		recorder.errs = append(recorder.errs, err)
+		recorder.mu.Unlock()
+	})
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	// the keys are put expired already.
+	expiredAt := uint64(time.Now().Unix()) - 10
+	putExpired := func(key string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.PutWithTimestamp("sessions", []byte(key), []byte("value"), 1, expiredAt)
+		}))
+	}
+	for _, key := range []string{"a", "b", "c", "deleted", "panic"} {
+		putExpired(key)
+	}
+	txPut(t, db, "sessions", []byte("live"), []byte("value"), Persistent, nil)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.PutWithTimestamp("sessions", []byte("deleted"), nil, 0, expiredAt)
+	}))
+	txDel(t, db, "sessions", []byte("deleted"), nil)
+
+	// each key is notified once, however many reads notice it.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for i := 0; i < 2; i++ {
+			_, _ = tx.Get("sessions", []byte("a"))
+			_, _ = tx.Get("sessions", []byte("deleted"))
+			_, _ = tx.RangeScan("sessions", []byte("a"), []byte("b"))
+			_, _, _ = tx.PrefixScan("sessions", []byte("c"), 0, ScanNoLimit)
+			it := NewIterator(tx, "sessions", IteratorOptions{})
+			for {
+				if ok, err := it.SetNext(); !ok || err != nil {
+					break
+				}
+			}
+		}
+		return nil
+	}))
+	expected := map[string]int{"sessions/a": 1, "sessions/b": 1, "sessions/c": 1, "sessions/panic": 1}
+	require.Eventually(t, func() bool {
+		counts, errs := recorder.snapshot()
+		return len(counts) == len(expected) && errs == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	counts, _ := recorder.snapshot()
+	require.Equal(t, expected, counts)
+	require.Contains(t, recorder.errs[0].Error(), "callback failed")
+
+	// a slow callback doesn't stall the commits, the key written again is notified again.
+	release := make(chan struct{})
+	recorder.mu.Lock()
+	recorder.release = release
+	recorder.mu.Unlock()
+	putExpired("a")
+	txGet(t, db, "sessions", []byte("a"), nil, ErrNotFoundKey)
+	txPut(t, db, "sessions", []byte("other"), []byte("value"), Persistent, nil)
+	txGet(t, db, "sessions", []byte("other"), []byte("value"), nil)
+
+	// Close waits for the keys queued.
+	close(release)
+	require.NoError(t, db.Close())
+	counts, _ = recorder.snapshot()
+	require.Equal(t, 2, counts["sessions/a"])
+}
+
+func TestDB_OnExpiredActiveExpire(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		count = make(map[string]int)
+	)
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.ActiveExpireInterval = time.Hour
+	opts.OnExpired = func(bucket string, key []byte) {
+		mu.Lock()
+		count[string(key)]++
+		mu.Unlock()
+	}
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	expiredAt := uint64(time.Now().Unix()) - 10
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range []string{"read", "unread"} {
+			if err := tx.PutWithTimestamp("sessions", []byte(key), []byte("value"), 1, expiredAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// the key noticed by a read is not notified again when it's deleted by the expirer.
+	txGet(t, db, "sessions", []byte("read"), nil, ErrNotFoundKey)
+	expired, err := db.expireKeys(db.nowMillis())
+	require.NoError(t, err)
+	require.Equal(t, 2, expired)
+
+	require.NoError(t, db.Close())
+	require.Equal(t, map[string]int{"read": 1, "unread": 1}, count)
+}
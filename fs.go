@@ -0,0 +1,82 @@
+//go:build go1.16
+
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrOpenFSEntryIdxMode is returned when OpenFS is called with an EntryIdxMode other than HintKeyValAndRAMIdxMode.
+var ErrOpenFSEntryIdxMode = errors.New("OpenFS only supports HintKeyValAndRAMIdxMode")
+
+// OpenFS opens the db whose data files are in the root of fsys, e.g. a directory embedded with go:embed.
+// The db is read-only: all the indexes are built in memory at open, so only HintKeyValAndRAMIdxMode
+// is supported, and Update, Merge and Backup return ErrDBReadOnly. opts.Dir is ignored.
+func OpenFS(fsys fs.FS, opts Options) (*DB, error) {
+	if opts.EntryIdxMode != HintKeyValAndRAMIdxMode {
+		return nil, ErrOpenFSEntryIdxMode
+	}
+
+	db := newDB(opts)
+	db.dataFS = &fsDataFileSystem{fsys: fsys}
+
+	if err := db.buildIndexes(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// fsDataFileSystem reads the data files from an fs.FS.
+type fsDataFileSystem struct {
+	fsys fs.FS
+}
+
+func (f *fsDataFileSystem) dataFileIDs() ([]int, error) {
+	entries, err := fs.ReadDir(f.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != DataSuffix {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(name, DataSuffix))
+		if err != nil || id < 0 || name != dataFileName(id) {
+			return nil, fmt.Errorf("%w: %s is not named by a file ID", ErrDirectoryInconsistent, name)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+func (f *fsDataFileSystem) openDataFile(fID int64) (io.ReadCloser, error) {
+	return f.fsys.Open(dataFileName(int(fID)))
+}
@@ -0,0 +1,105 @@
+//go:build go1.16
+
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFS(t *testing.T) {
+	bucket := "bucket"
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.SegmentSize = 8 * 1024
+	defer removeDir(opts.Dir)
+
+	// build the fixture the way it is built offline, and put it in an in-memory file system.
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		txPut(t, db, bucket, GetTestBytes(i), GetRandomBytes(512), Persistent, nil)
+	}
+	txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+	txDel(t, db, bucket, GetTestBytes(1), nil)
+	txSAdd(t, db, bucket, GetTestBytes(0), GetTestBytes(1), nil)
+	txZAdd(t, db, bucket, GetTestBytes(0), GetTestBytes(1), 1.5, nil)
+	txPush(t, db, bucket, GetTestBytes(0), GetTestBytes(1), nil, false)
+	txPush(t, db, bucket, GetTestBytes(0), GetTestBytes(2), nil, false)
+	require.NoError(t, db.Close())
+
+	files, err := filepath.Glob(filepath.Join(opts.Dir, "*"+DataSuffix))
+	require.NoError(t, err)
+	require.Greater(t, len(files), 1)
+	fsys := fstest.MapFS{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		fsys[filepath.Base(file)] = &fstest.MapFile{Data: data}
+	}
+	removeDir(opts.Dir)
+
+	fsOpts := DefaultOptions
+	fsOpts.EntryIdxMode = HintKeyAndRAMIdxMode
+	_, err = OpenFS(fsys, fsOpts)
+	require.ErrorIs(t, err, ErrOpenFSEntryIdxMode)
+
+	fsOpts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	db, err = OpenFS(fsys, fsOpts)
+	require.NoError(t, err)
+
+	txGet(t, db, bucket, GetTestBytes(0), GetTestBytes(0), nil)
+	txGet(t, db, bucket, GetTestBytes(1), nil, ErrNotFoundKey)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, _, err := tx.PrefixScan(bucket, []byte("nutsdb-"), 0, 100)
+		require.NoError(t, err)
+		require.Len(t, entries, 19)
+
+		it := NewIterator(tx, bucket, IteratorOptions{Reverse: true})
+		ok, err := it.SetNext()
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, GetTestBytes(19), it.Entry().Key)
+
+		isMember, err := tx.SIsMember(bucket, GetTestBytes(0), GetTestBytes(1))
+		require.NoError(t, err)
+		require.True(t, isMember)
+
+		score, err := tx.ZScore(bucket, GetTestBytes(0))
+		require.NoError(t, err)
+		require.Equal(t, 1.5, score)
+
+		items, err := tx.LRange(bucket, GetTestBytes(0), 0, -1)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{GetTestBytes(1), GetTestBytes(2)}, items)
+		return nil
+	}))
+
+	require.ErrorIs(t, db.Update(func(tx *Tx) error { return nil }), ErrDBReadOnly)
+	require.ErrorIs(t, db.Merge(), ErrDBReadOnly)
+	require.ErrorIs(t, db.Backup(opts.Dir), ErrDBReadOnly)
+	require.ErrorIs(t, db.BackupTarGZ(&bytes.Buffer{}), ErrDBReadOnly)
+	require.NoError(t, db.Close())
+}
@@ -7,6 +7,7 @@ require (
 	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/emirpasic/gods v1.18.1
 	github.com/gofrs/flock v0.8.1
+	github.com/klauspost/compress v1.15.15
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.1
 	github.com/xujiajun/gorouter v1.2.0
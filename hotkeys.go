@@ -0,0 +1,282 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hotKeySketchWidth and hotKeySketchDepth are the dimensions of the count-min sketch.
+	// An estimate never undercounts, and overcounts by at most e/width (~0.13%) of all the
+	// sampled operations with the probability of 1-e^-depth (~98%).
+	hotKeySketchWidth = 2048
+	hotKeySketchDepth = 4
+
+	// hotKeyTopK is the number of the hottest keys tracked.
+	hotKeyTopK = 64
+)
+
+// HotKeyStat represents the estimated load of a key.
+type HotKeyStat struct {
+	Bucket string
+
+	// KeyHash is the 64-bit FNV-1a hash of the key.
+	KeyHash uint64
+
+	// Key is the plaintext key truncated to Options.HotKeyPlaintextSize, nil if the plaintext is not retained.
+	Key []byte
+
+	// ReadsPerSec and WritesPerSec are the estimated operations per second since the profiler is reset.
+	ReadsPerSec  float64
+	WritesPerSec float64
+}
+
+// HotKeys returns the n hottest keys by the estimated operations, in descending order.
+// It returns nil if Options.HotKeySampleRate is 0.
+func (db *DB) HotKeys(n int) []HotKeyStat {
+	if db.hotKeys == nil {
+		return nil
+	}
+
+	return db.hotKeys.top(n)
+}
+
+// ResetHotKeys clears the counters of the hot key profiler.
+func (db *DB) ResetHotKeys() {
+	if db.hotKeys != nil {
+		db.hotKeys.reset()
+	}
+}
+
+// hotKeyOp represents the type of the operation sampled.
+type hotKeyOp int
+
+const (
+	hotKeyRead hotKeyOp = iota
+	hotKeyWrite
+)
+
+type hotKeyID struct {
+	bucket  string
+	keyHash uint64
+}
+
+type hotKeyItem struct {
+	id     hotKeyID
+	key    []byte
+	counts [2]uint32
+	index  int
+}
+
+func (item *hotKeyItem) total() uint32 {
+	return item.counts[hotKeyRead] + item.counts[hotKeyWrite]
+}
+
+// hotKeyHeap is a min heap of the tracked keys by the total estimated count.
+type hotKeyHeap []*hotKeyItem
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].total() < h[j].total() }
+
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	item := x.(*hotKeyItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// hotKeyProfiler samples the operations into a count-min sketch per operation type,
+// and keeps the keys with the largest estimates in a small heap.
+type hotKeyProfiler struct {
+	rate          uint64 // the sample rate rounded up to a power of two
+	mask          uint64 // rate-1, an operation is sampled if the bits of its random number under mask are 0
+	plaintextSize int
+
+	mu      sync.Mutex
+	sketch  [2][hotKeySketchDepth][hotKeySketchWidth]uint32
+	items   map[hotKeyID]*hotKeyItem
+	heap    hotKeyHeap
+	resetAt time.Time
+}
+
+func newHotKeyProfiler(rate uint32, plaintextSize int) *hotKeyProfiler {
+	pow := uint64(1)
+	for pow < uint64(rate) {
+		pow <<= 1
+	}
+
+	return &hotKeyProfiler{
+		rate:          pow,
+		mask:          pow - 1,
+		plaintextSize: plaintextSize,
+		items:         make(map[hotKeyID]*hotKeyItem),
+		resetAt:       time.Now(),
+	}
+}
+
+// sample records the operation if it is picked at random by the sample rate.
+func (p *hotKeyProfiler) sample(bucket string, key []byte, op hotKeyOp) {
+	if hotKeyRandom()&p.mask != 0 {
+		return
+	}
+
+	id := hotKeyID{bucket: bucket, keyHash: fnvHash64(key)}
+	sketchHash := fnvHash64([]byte(bucket), []byte{0}, key)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var counts [2]uint32
+	for o := range p.sketch {
+		counts[o] = p.estimate(hotKeyOp(o), sketchHash, hotKeyOp(o) == op)
+	}
+
+	if item, ok := p.items[id]; ok {
+		item.counts = counts
+		heap.Fix(&p.heap, item.index)
+		return
+	}
+
+	item := &hotKeyItem{id: id, counts: counts}
+	if len(p.heap) == hotKeyTopK {
+		if p.heap[0].total() >= item.total() {
+			return
+		}
+		delete(p.items, heap.Pop(&p.heap).(*hotKeyItem).id)
+	}
+
+	if p.plaintextSize > 0 {
+		if len(key) > p.plaintextSize {
+			key = key[:p.plaintextSize]
+		}
+		item.key = append([]byte(nil), key...)
+	}
+	p.items[id] = item
+	heap.Push(&p.heap, item)
+}
+
+// hotKeyRandState holds the xorshift states of hotKeyRandom. A sync.Pool keeps a state per P, so that the
+// goroutines sampling on different Ps don't contend on a shared counter or on the lock of math/rand.
+var hotKeyRandState = sync.Pool{
+	New: func() interface{} {
+		state := rand.Uint64() | 1
+		return &state
+	},
+}
+
+// hotKeyRandom returns a 32-bit random number, the high half of the xorshift64* output whose low bits are weaker.
+func hotKeyRandom() uint64 {
+	state := hotKeyRandState.Get().(*uint64)
+	x := *state
+	x ^= x >> 12
+	x ^= x << 25
+	x ^= x >> 27
+	*state = x
+	hotKeyRandState.Put(state)
+
+	return (x * 0x2545f4914f6cdd1d) >> 32
+}
+
+// estimate returns the count of the key in the sketch of the operation, incrementing it first if add is true.
+func (p *hotKeyProfiler) estimate(op hotKeyOp, hash uint64, add bool) uint32 {
+	var min uint32
+	for row := 0; row < hotKeySketchDepth; row++ {
+		col := hotKeySketchIndex(hash, row)
+		if add {
+			p.sketch[op][row][col]++
+		}
+		if c := p.sketch[op][row][col]; row == 0 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// fnvHash64 returns the 64-bit FNV-1a hash of the concatenated data.
+func fnvHash64(data ...[]byte) uint64 {
+	h := fnv.New64a()
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum64()
+}
+
+// hotKeySketchIndex returns the column of the hash in the row, the hash of each row
+// is derived from the hash of the bucket and key by the splitmix64 finalizer.
+func hotKeySketchIndex(hash uint64, row int) int {
+	x := hash + uint64(row+1)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return int(x % hotKeySketchWidth)
+}
+
+func (p *hotKeyProfiler) top(n int) []HotKeyStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	items := make([]*hotKeyItem, len(p.heap))
+	copy(items, p.heap)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].total() > items[j].total()
+	})
+	if n >= 0 && n < len(items) {
+		items = items[:n]
+	}
+
+	elapsed := time.Since(p.resetAt).Seconds()
+	scale := float64(p.rate) / elapsed
+
+	stats := make([]HotKeyStat, 0, len(items))
+	for _, item := range items {
+		stats = append(stats, HotKeyStat{
+			Bucket:       item.id.bucket,
+			KeyHash:      item.id.keyHash,
+			Key:          item.key,
+			ReadsPerSec:  float64(item.counts[hotKeyRead]) * scale,
+			WritesPerSec: float64(item.counts[hotKeyWrite]) * scale,
+		})
+	}
+
+	return stats
+}
+
+func (p *hotKeyProfiler) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sketch = [2][hotKeySketchDepth][hotKeySketchWidth]uint32{}
+	p.items = make(map[hotKeyID]*hotKeyItem)
+	p.heap = nil
+	p.resetAt = time.Now()
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotKeyProfiler_SkewedWorkload(t *testing.T) {
+	p := newHotKeyProfiler(1, 0)
+	bucket := "bucket"
+
+	// 30% of the reads go to one key, 5% of the writes to another,
+	// and the rest are spread over 10000 keys.
+	const total = 100000
+	r := rand.New(rand.NewSource(1))
+	truth := make(map[string]int)
+	for i := 0; i < total; i++ {
+		key := GetTestBytes(r.Intn(10000))
+		op := hotKeyRead
+		switch x := r.Intn(100); {
+		case x < 30:
+			key = []byte("hot_read")
+		case x < 35:
+			key = []byte("hot_write")
+			op = hotKeyWrite
+		}
+		truth[string(key)]++
+		p.sample(bucket, key, op)
+	}
+
+	stats := p.top(2)
+	require.Len(t, stats, 2)
+
+	// the estimates never undercount, and overcount by e/width of the operations with a high probability.
+	bound := float64(total) * 2.72 / hotKeySketchWidth
+	for i, key := range []string{"hot_read", "hot_write"} {
+		h := fnvHash64([]byte(key))
+		require.Equal(t, h, stats[i].KeyHash)
+		require.Nil(t, stats[i].Key)
+
+		item := p.items[hotKeyID{bucket: bucket, keyHash: h}]
+		require.NotNil(t, item)
+		require.GreaterOrEqual(t, float64(item.total()), float64(truth[key]))
+		require.LessOrEqual(t, float64(item.total()), float64(truth[key])+bound)
+	}
+	require.Greater(t, stats[0].ReadsPerSec, stats[0].WritesPerSec)
+	require.Greater(t, stats[1].WritesPerSec, stats[1].ReadsPerSec)
+
+	p.reset()
+	require.Empty(t, p.top(10))
+}
+
+func TestHotKeyProfiler_SampleRate(t *testing.T) {
+	p := newHotKeyProfiler(5, 0)
+	require.Equal(t, uint64(8), p.rate)
+
+	const total = 80000
+	key := []byte("key")
+	for i := 0; i < total; i++ {
+		p.sample("bucket", key, hotKeyRead)
+	}
+
+	// about one of every 8 operations is sampled.
+	item := p.items[hotKeyID{bucket: "bucket", keyHash: fnvHash64(key)}]
+	require.NotNil(t, item)
+	require.InDelta(t, total/8, item.total(), total/8/10)
+}
+
+func BenchmarkHotKeyProfiler_Sample(b *testing.B) {
+	p := newHotKeyProfiler(1024, 0)
+	key := []byte("key")
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.sample("bucket", key, hotKeyRead)
+		}
+	})
+}
+
+func TestDB_HotKeys(t *testing.T) {
+	bucket := "bucket"
+	key := []byte("a_rather_long_hot_key")
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.Nil(t, db.HotKeys(1))
+	})
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.HotKeySampleRate = 4
+	opts.HotKeyPlaintextSize = 8
+	runNutsDBTest(t, &opts, func(t *testing.T, db *DB) {
+
+		txPut(t, db, bucket, key, key, Persistent, nil)
+		for i := 0; i < 100; i++ {
+			txGet(t, db, bucket, key, key, nil)
+		}
+		txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+
+		stats := db.HotKeys(1)
+		require.Len(t, stats, 1)
+		require.Equal(t, bucket, stats[0].Bucket)
+		require.Equal(t, key[:8], stats[0].Key)
+		require.Greater(t, stats[0].ReadsPerSec, float64(0))
+
+		db.ResetHotKeys()
+		require.Empty(t, db.HotKeys(1))
+	})
+}
@@ -17,7 +17,8 @@ type SortedSetIdx map[string]*zset.SortedSet
 type ListIdx map[string]*List
 
 type index struct {
-	list ListIdx
+	list  ListIdx
+	clock Clock // the clock of the lists, see Options.Clock
 }
 
 func NewIndex() *index {
@@ -37,6 +38,7 @@ func (i *index) getList(bucket string) *List {
 		return l
 	}
 	l = NewList()
+	l.clock = i.clock
 	i.list[bucket] = l
 	return l
 }
@@ -45,8 +47,16 @@ func (i *index) deleteList(bucket string) {
 	delete(i.list, bucket)
 }
 
+// renameList moves the lists of the bucket to the new name.
+func (i *index) renameList(from, to string) {
+	if l, ok := i.list[from]; ok {
+		i.list[to] = l
+	}
+}
+
 func (i *index) addList(bucket string) {
 	l := NewList()
+	l.clock = i.clock
 	i.list[bucket] = l
 }
 
@@ -14,12 +14,22 @@
 
 package inmemory
 
+import (
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+)
+
 type (
 	Option func(*Options)
 
 	Options struct {
 		// ShardsCount represents Number of cache shards.
 		ShardsCount uint64
+
+		// Clock tells the time to the timestamps of the entries written and to the expiration of the keys,
+		// see nutsdb.Options.Clock. The wall clock is used if it's nil.
+		Clock nutsdb.Clock
 	}
 
 	// DB indicates that all data is stored in memory.
@@ -44,6 +54,22 @@ func WithShardsCount(shardsCount uint64) Option {
 	}
 }
 
+// WithClock sets the clock of the timestamps and the expirations, see Options.Clock.
+func WithClock(clock nutsdb.Clock) Option {
+	return func(opt *Options) {
+		opt.Clock = clock
+	}
+}
+
+// now returns the time of Options.Clock.
+func (db *DB) now() time.Time {
+	if db.opts.Clock == nil {
+		return time.Now()
+	}
+
+	return db.opts.Clock.Now()
+}
+
 func open(opts Options) (*DB, error) {
 	db := &DB{
 		opts:   opts,
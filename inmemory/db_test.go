@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/nutsdb/nutsdb"
 	"github.com/stretchr/testify/assert"
@@ -225,3 +226,26 @@ func TestDB_PrefixScan(t *testing.T) {
 	assertions.Equal(1, len(es))
 	assertions.True(bytes.Equal(value2b, es[0].Value))
 }
+
+func TestDB_Clock(t *testing.T) {
+	clock := nutsdb.NewFakeClock(time.Unix(1e9, 0))
+	db, err := Open(DefaultOptions, WithShardsCount(1), WithClock(clock))
+	assert.NoError(t, err)
+
+	bucket := "bucket_clock"
+	assert.NoError(t, db.Put(bucket, []byte("ttl"), []byte("value"), 10))
+	assert.NoError(t, db.Put(bucket, []byte("persistent"), []byte("value"), nutsdb.Persistent))
+
+	// the keys expire by the clock of the db, not by the wall clock.
+	clock.Add(9 * time.Second)
+	entry, err := db.Get(bucket, []byte("ttl"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1e9), entry.Meta.Timestamp)
+
+	clock.Add(time.Second)
+	_, err = db.Get(bucket, []byte("ttl"))
+	assert.Equal(t, nutsdb.ErrNotFoundKey, err)
+	keys, err := db.AllKeys(bucket)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("persistent")}, keys)
+}
@@ -24,6 +24,7 @@ func (db *DB) Get(bucket string, key []byte) (*nutsdb.Entry, error) {
 		err   error
 		entry *nutsdb.Entry
 	)
+	now := db.now()
 	err = db.Managed(bucket, false, func(shardDB *ShardDB) error {
 		if idx, ok := shardDB.BPTreeIdx[bucket]; ok {
 			r, err := idx.Find(key)
@@ -31,7 +32,7 @@ func (db *DB) Get(bucket string, key []byte) (*nutsdb.Entry, error) {
 				return err
 			}
 
-			if r.H.Meta.Flag == nutsdb.DataDeleteFlag || r.IsExpired() {
+			if r.H.Meta.Flag == nutsdb.DataDeleteFlag || r.IsExpiredAt(now) {
 				return nutsdb.ErrNotFoundKey
 			}
 
@@ -54,7 +55,7 @@ func (db *DB) Get(bucket string, key []byte) (*nutsdb.Entry, error) {
 
 func (db *DB) Put(bucket string, key, value []byte, ttl uint32) (err error) {
 	err = db.Managed(bucket, true, func(shardDB *ShardDB) error {
-		return put(shardDB, bucket, key, value, ttl, nutsdb.DataSetFlag)
+		return put(shardDB, bucket, key, value, ttl, nutsdb.DataSetFlag, db.now())
 	})
 
 	return
@@ -63,18 +64,19 @@ func (db *DB) Put(bucket string, key, value []byte, ttl uint32) (err error) {
 // Delete removes a key from the bucket at given bucket and key.
 func (db *DB) Delete(bucket string, key []byte) (err error) {
 	err = db.Managed(bucket, true, func(shardDB *ShardDB) error {
-		return put(shardDB, bucket, key, nil, nutsdb.Persistent, nutsdb.DataDeleteFlag)
+		return put(shardDB, bucket, key, nil, nutsdb.Persistent, nutsdb.DataDeleteFlag, db.now())
 	})
 	return
 }
 
 // Range query a range at given bucket, start and end slice.
 func (db *DB) Range(bucket string, start, end []byte, f func(key, value []byte) bool) (err error) {
+	now := db.now()
 	err = db.Managed(bucket, false, func(shardDB *ShardDB) error {
 		if index, ok := shardDB.BPTreeIdx[bucket]; ok {
 			index.FindRange(start, end, func(key []byte, pointer interface{}) bool {
 				record := pointer.(*nutsdb.Record)
-				if record.E.Meta.Flag != nutsdb.DataDeleteFlag && !record.IsExpired() {
+				if record.E.Meta.Flag != nutsdb.DataDeleteFlag && !record.IsExpiredAt(now) {
 					return f(key, record.E.Value)
 				}
 				return true
@@ -87,11 +89,12 @@ func (db *DB) Range(bucket string, start, end []byte, f func(key, value []byte)
 
 // AllKeys list all key of bucket.
 func (db *DB) AllKeys(bucket string) (keys [][]byte, err error) {
+	now := db.now()
 	err = db.Managed(bucket, false, func(shardDB *ShardDB) error {
 		if index, ok := shardDB.BPTreeIdx[bucket]; ok {
 			index.FindRange(index.FirstKey, index.LastKey, func(key []byte, pointer interface{}) bool {
 				record := pointer.(*nutsdb.Record)
-				if record.E.Meta.Flag != nutsdb.DataDeleteFlag && !record.IsExpired() {
+				if record.E.Meta.Flag != nutsdb.DataDeleteFlag && !record.IsExpiredAt(now) {
 					keys = append(keys, key)
 				}
 				return true
@@ -105,6 +108,7 @@ func (db *DB) AllKeys(bucket string) (keys [][]byte, err error) {
 // PrefixScan iterates over a key prefix at given bucket, prefix and limitNum.
 // LimitNum will limit the number of entries return.
 func (db *DB) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum int) (es nutsdb.Entries, off int, err error) {
+	now := db.now()
 	err = db.Managed(bucket, false, func(shardDB *ShardDB) error {
 		if idx, ok := shardDB.BPTreeIdx[bucket]; ok {
 			records, voff, err := idx.PrefixScan(prefix, offsetNum, limitNum)
@@ -113,7 +117,7 @@ func (db *DB) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 				return nutsdb.ErrPrefixScan
 			}
 			for _, r := range records {
-				if r.E.Meta.Flag == nutsdb.DataDeleteFlag || r.IsExpired() {
+				if r.E.Meta.Flag == nutsdb.DataDeleteFlag || r.IsExpiredAt(now) {
 					continue
 				}
 				es = append(es, r.E)
@@ -128,13 +132,13 @@ func (db *DB) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 	return
 }
 
-func put(shardDB *ShardDB, bucket string, key, value []byte, ttl uint32, flag uint16) (err error) {
+func put(shardDB *ShardDB, bucket string, key, value []byte, ttl uint32, flag uint16, now time.Time) (err error) {
 	if _, ok := shardDB.BPTreeIdx[bucket]; !ok {
 		shardDB.BPTreeIdx[bucket] = nutsdb.NewTree()
 	}
 	keySize := uint32(len(key))
 	valueSize := uint32(len(value))
-	timestamp := uint64(time.Now().Unix())
+	timestamp := uint64(now.Unix())
 	bucketSize := uint32(len(bucket))
 	meta := nutsdb.NewMetaData().WithTimeStamp(timestamp).WithKeySize(keySize).WithValueSize(valueSize).WithFlag(flag).WithTTL(ttl).
 		WithBucketSize(bucketSize).WithStatus(nutsdb.Committed).WithDs(nutsdb.DataStructureBPTree)
@@ -14,100 +14,323 @@
 
 package nutsdb
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
 
+// ErrIteratorClosed is returned when using an iterator after it is closed.
+var ErrIteratorClosed = errors.New("iterator is closed")
+
+// Iterator iterates over a BPTree bucket in the key order.
+// The tx holds the lock of the db until it is committed or rolled back, so no other tx or merge
+// can change the index underneath the iterator: it sees the bucket as of the start of the tx,
+// plus the pending writes of the tx itself, and never skips or repeats a key.
 type Iterator struct {
 	tx      *Tx
 	options IteratorOptions
 
-	current *Node
-	i       int
+	current    *Node
+	i          int
+	positioned bool
+
+	// pending is the sorted view of the pending writes of the tx in the bucket,
+	// pi is the position of the next pending entry.
+	pending       []*Entry
+	pi            int
+	bucketDeleted bool
+
+	// skipped and returned count the live entries for IteratorOptions.Offset and Limit.
+	skipped  int
+	returned int
 
 	bucket string
 
+	// start and end bound the keys of a range iterator, nil means unbounded.
+	start, end []byte
+
 	entry *Entry
+
+	closed bool
 }
 
 type IteratorOptions struct {
 	Reverse bool
+
+	// KeyOnly represents if the iterator skips reading the values from the data files
+	// in HintKeyAndRAMIdxMode, the Entry would only have the Key, Bucket and Meta set.
+	// In HintKeyValAndRAMIdxMode the values are in memory already and are returned as well.
+	KeyOnly bool
+
+	// Offset represents how many live entries are skipped from the start or the sought position,
+	// the values of the skipped entries are never read.
+	Offset int
+
+	// Limit represents how many entries are returned at most, with 0 meaning no limit.
+	Limit int
+
+	// IncludeDeleted represents if the tombstones are returned as well, with the Meta.Flag set to
+	// DataDeleteFlag and a nil Value, e.g. to analyze what a merge would reclaim.
+	IncludeDeleted bool
+
+	// IncludeExpired represents if the expired entries are returned as well.
+	IncludeExpired bool
 }
 
+// NewIterator returns an iterator over the bucket, it is closed with the tx at the latest.
 func NewIterator(tx *Tx, bucket string, options IteratorOptions) *Iterator {
-	return &Iterator{
+	it := &Iterator{
 		tx:      tx,
 		bucket:  bucket,
 		options: options,
 	}
+
+	// the iterator of a closed tx is not kept, its calls fail with the error of the tx.
+	if tx.beginOp() == nil {
+		tx.iterators = append(tx.iterators, it)
+		tx.endOp()
+	}
+
+	return it
+}
+
+// NewRangeIterator returns an iterator over the keys of the bucket within [start, end],
+// it streams the entries without loading the whole range. In the reverse mode it starts from end.
+func NewRangeIterator(tx *Tx, bucket string, start, end []byte, reverse bool) *Iterator {
+	it := NewIterator(tx, bucket, IteratorOptions{Reverse: reverse})
+	it.start, it.end = start, end
+
+	return it
+}
+
+// Close releases the iterator, SetNext and Seek return ErrIteratorClosed afterwards.
+// The data files are released after each read, so an abandoned iterator holds no file.
+func (it *Iterator) Close() error {
+	if err := it.beginOp(); err != nil {
+		return ErrIteratorClosed
+	}
+	defer it.tx.endOp()
+
+	it.close()
+	it.tx.removeIterator(it)
+
+	return nil
+}
+
+// close releases the iterator without removing it from the tx.
+func (it *Iterator) close() {
+	it.closed = true
+	it.current, it.pending, it.entry = nil, nil, nil
+}
+
+// beginOp begins an operation of the tx on the iterator, which is ended by the endOp of the tx.
+// The iterators are closed with the tx, so ErrIteratorClosed is returned for a closed tx.
+func (it *Iterator) beginOp() error {
+	if err := it.tx.beginOp(); err != nil {
+		if err == ErrTxClosed {
+			return ErrIteratorClosed
+		}
+		return err
+	}
+	if it.closed {
+		it.tx.endOp()
+		return ErrIteratorClosed
+	}
+
+	return nil
 }
 
 // SetNext would set the next Entry item, and would return (true, nil) if the next item is available
 // Otherwise if the next item is not available it would return (false, nil)
 // If it faces error it would return (false, err)
+// In a writable tx the pending writes of the tx are merged into the iteration.
 func (it *Iterator) SetNext() (bool, error) {
+	if err := it.beginOp(); err != nil {
+		return false, err
+	}
+	defer it.tx.endOp()
+
 	if it.tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return false, fmt.Errorf("%s mode is not supported in iterators", "HintBPTSparseIdxMode")
 	}
 
-	if err := it.tx.checkTxIsClosed(); err != nil {
-		return false, err
+	if !it.positioned {
+		var err error
+		if it.options.Reverse {
+			err = it.SeekToLast()
+		} else {
+			err = it.SeekToFirst()
+		}
+		if err == ErrBucketNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
 	}
 
-	if it.i == -2 {
+	if it.options.Limit > 0 && it.returned >= it.options.Limit {
 		return false, nil
 	}
 
-	if it.current == nil && (it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode ||
-		it.tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode) {
-		if index, ok := it.tx.db.BPTreeIdx[it.bucket]; ok {
-			if it.options.Reverse {
-				err := it.Seek(index.LastKey)
-				if err != nil {
-					return false, err
+	for {
+		record := it.peekRecord()
+		pending := it.peekPending()
+		if record == nil && pending == nil {
+			return false, nil
+		}
+
+		if pending != nil {
+			c := -1
+			if record != nil {
+				c = compare(pending.Key, it.current.Keys[it.i])
+				if it.options.Reverse {
+					c = -c
+				}
+			}
+
+			if c <= 0 {
+				// the pending write overwrites the committed one.
+				if c == 0 {
+					it.step()
+				}
+				it.stepPending()
+
+				in, done := it.inRange(pending.Key)
+				if done {
+					return false, nil
 				}
-			} else {
-				err := it.Seek(index.FirstKey)
-				if err != nil {
-					return false, err
+				if !in || !it.visible(pending.Meta) || it.skip() {
+					continue
 				}
+
+				it.entry = pending
+				return true, nil
 			}
 		}
+
+		it.step()
+
+		in, done := it.inRange(record.H.Key)
+		if done {
+			return false, nil
+		}
+		if !in || !it.visibleRecord(record) || it.skip() {
+			continue
+		}
+
+		if record.H.Meta.Flag == DataDeleteFlag {
+			// there is no value to read for a tombstone.
+			it.entry = NewEntry().WithKey(record.H.Key).WithMeta(record.H.Meta).WithBucket([]byte(it.bucket))
+			return true, nil
+		}
+
+		return it.loadRecord(record)
 	}
+}
+
+// inRange returns if the key is within the range of the iterator, and if the iteration has passed the range.
+func (it *Iterator) inRange(key []byte) (in, done bool) {
+	if it.start != nil && compare(key, it.start) < 0 {
+		return false, it.options.Reverse
+	}
+	if it.end != nil && compare(key, it.end) > 0 {
+		return false, !it.options.Reverse
+	}
+
+	return true, false
+}
+
+// visible returns if the entry is returned, the deleted and expired ones only with the IteratorOptions asking for them.
+func (it *Iterator) visible(meta *MetaData) bool {
+	if meta.Flag == DataDeleteFlag {
+		return it.options.IncludeDeleted
+	}
+	if it.tx.db.isExpired(meta) {
+		return it.options.IncludeExpired
+	}
+
+	return true
+}
+
+// visibleRecord returns if the committed record is returned like visible, its expiration is notified to
+// Options.OnExpired.
+func (it *Iterator) visibleRecord(r *Record) bool {
+	if r.H.Meta.Flag != DataDeleteFlag && it.tx.isExpired(it.bucket, r.H.Key, r.H.Meta) {
+		return it.options.IncludeExpired
+	}
+
+	return it.visible(r.H.Meta)
+}
 
+// skip returns if the live entry is skipped by IteratorOptions.Offset, otherwise it is counted as returned.
+func (it *Iterator) skip() bool {
+	if it.skipped < it.options.Offset {
+		it.skipped++
+		return true
+	}
+
+	it.returned++
+	return false
+}
+
+// peekRecord returns the committed record at the current position, nil if there is no more record.
+func (it *Iterator) peekRecord() *Record {
 	if it.options.Reverse {
-		if it.i < 0 {
+		if it.current != nil && it.i >= it.current.KeysNum {
+			it.i = it.current.KeysNum - 1
+		}
+		for it.current != nil && it.i < 0 {
 			it.current, _ = it.current.pointers[order].(*Node)
-			if it.current == nil {
-				return false, nil
+			if it.current != nil {
+				it.i = it.current.KeysNum - 1
 			}
-			it.i = it.current.KeysNum - 1
 		}
 	} else {
-		if it.current == nil {
-			return false, nil
-		}
-		if it.i >= it.current.KeysNum {
+		for it.current != nil && it.i >= it.current.KeysNum {
 			it.current, _ = it.current.pointers[order-1].(*Node)
-			if it.current == nil {
-				return false, nil
-			}
 			it.i = 0
 		}
 	}
 
 	if it.current == nil {
-		return false, nil
+		return nil
 	}
-	pointer := it.current.pointers[it.i]
-	record := pointer.(*Record)
 
+	return it.current.pointers[it.i].(*Record)
+}
+
+func (it *Iterator) step() {
 	if it.options.Reverse {
 		it.i--
 	} else {
 		it.i++
 	}
+}
+
+// peekPending returns the pending entry at the current position, nil if there is no more pending entry.
+func (it *Iterator) peekPending() *Entry {
+	if it.pi < 0 || it.pi >= len(it.pending) {
+		return nil
+	}
+
+	return it.pending[it.pi]
+}
+
+func (it *Iterator) stepPending() {
+	if it.options.Reverse {
+		it.pi--
+	} else {
+		it.pi++
+	}
+}
 
-	if record.H.Meta.Flag == DataDeleteFlag || record.IsExpired() {
-		return it.SetNext()
+// loadRecord sets the entry of the record, reading the value from the data file in HintKeyAndRAMIdxMode.
+func (it *Iterator) loadRecord(record *Record) (bool, error) {
+	if it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode && it.options.KeyOnly {
+		it.entry = NewEntry().WithKey(record.H.Key).WithMeta(record.H.Meta).WithBucket([]byte(it.bucket))
+		return true, nil
 	}
 
 	if it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode {
@@ -122,6 +345,9 @@ func (it *Iterator) SetNext() (bool, error) {
 			if err != nil {
 				return false, err
 			}
+			if item == nil {
+				return false, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %w", record.H.DataPos, ErrEntryZero)
+			}
 
 			it.entry = item
 			return true, nil
@@ -142,22 +368,147 @@ func (it *Iterator) SetNext() (bool, error) {
 	return false, nil
 }
 
+// loadPending builds the sorted view of the pending writes of the tx in the bucket,
+// only the last write of each key is kept.
+func (it *Iterator) loadPending() {
+	it.pending = nil
+	it.bucketDeleted = false
+	if !it.tx.writable {
+		return
+	}
+
+	latest := make(map[string]*Entry)
+	for _, entry := range it.tx.pendingWrites {
+		if string(entry.Bucket) != it.bucket {
+			continue
+		}
+		if entry.Meta.Flag == DataBPTreeBucketDeleteFlag {
+			latest = make(map[string]*Entry)
+			it.bucketDeleted = true
+			continue
+		}
+		if entry.Meta.Ds == DataStructureBPTree {
+			latest[string(entry.Key)] = entry
+		}
+	}
+
+	for _, entry := range latest {
+		it.pending = append(it.pending, entry)
+	}
+	sort.Slice(it.pending, func(i, j int) bool {
+		return compare(it.pending[i].Key, it.pending[j].Key) < 0
+	})
+}
+
+// index returns the committed index of the bucket, nil if there is none or the bucket is deleted in the tx.
+func (it *Iterator) index() *BPTree {
+	if it.bucketDeleted {
+		return nil
+	}
+
+	return it.tx.db.BPTreeIdx[it.bucket]
+}
+
 // Seek would seek to the key,
 // If the key is not available it would seek to the first smallest greater key than the input key.
+// It returns ErrBucketNotFound if the bucket has neither index nor pending writes in the tx,
+// SetNext would return (false, nil) then.
 func (it *Iterator) Seek(key []byte) error {
-	if it.tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-		return fmt.Errorf("%s mode is not supported in iterators", "HintBPTSparseIdxMode")
+	if err := it.beginOp(); err != nil {
+		return err
 	}
+	defer it.tx.endOp()
 
-	it.current = it.tx.db.BPTreeIdx[it.bucket].FindLeaf(key)
-	if it.current == nil {
-		it.i = -2
+	if err := it.prepare(); err != nil {
+		return err
 	}
 
-	for it.i = 0; it.i < it.current.KeysNum && compare(it.current.Keys[it.i], key) < 0; {
+	it.seek(key)
+	return nil
+}
+
+func (it *Iterator) seek(key []byte) {
+	if index := it.index(); index != nil {
+		it.current = index.FindLeaf(key)
+	}
+	for it.current != nil && it.i < it.current.KeysNum && compare(it.current.Keys[it.i], key) < 0 {
 		it.i++
 	}
 
+	if !it.options.Reverse {
+		it.pi = sort.Search(len(it.pending), func(i int) bool {
+			return compare(it.pending[i].Key, key) >= 0
+		})
+		return
+	}
+
+	// in the reverse mode the pending entries start from the first committed key to return.
+	bound := key
+	if it.peekRecord() != nil && compare(it.current.Keys[it.i], key) > 0 {
+		bound = it.current.Keys[it.i]
+	}
+	it.pi = sort.Search(len(it.pending), func(i int) bool {
+		return compare(it.pending[i].Key, bound) > 0
+	}) - 1
+}
+
+// SeekToFirst would seek to the first key of the bucket, or the start of a range iterator.
+func (it *Iterator) SeekToFirst() error {
+	return it.Seek(it.start)
+}
+
+// SeekToLast would seek to the last key of the bucket, or the end of a range iterator.
+// In the forward mode SetNext would return the last item and then return false.
+func (it *Iterator) SeekToLast() error {
+	if err := it.beginOp(); err != nil {
+		return err
+	}
+	defer it.tx.endOp()
+
+	if err := it.prepare(); err != nil {
+		return err
+	}
+
+	var last []byte
+	if index := it.index(); index != nil {
+		last = index.LastKey
+	}
+	if n := len(it.pending); n > 0 && compare(it.pending[n-1].Key, last) > 0 {
+		last = it.pending[n-1].Key
+	}
+	if it.end != nil && compare(it.end, last) < 0 {
+		last = it.end
+	}
+
+	it.seek(last)
+	return nil
+}
+
+// prepare resets the position of the iterator and loads the pending writes of the tx,
+// it returns an error if the bucket can't be iterated.
+func (it *Iterator) prepare() error {
+	if it.closed {
+		return ErrIteratorClosed
+	}
+
+	it.positioned = true
+	it.current, it.i = nil, 0
+	it.pending, it.pi = nil, 0
+	it.skipped, it.returned = 0, 0
+
+	if err := it.tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if it.tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return fmt.Errorf("%s mode is not supported in iterators", "HintBPTSparseIdxMode")
+	}
+
+	it.loadPending()
+	if it.index() == nil && len(it.pending) == 0 {
+		return ErrBucketNotFound
+	}
+
 	return nil
 }
 
@@ -165,3 +516,31 @@ func (it *Iterator) Seek(key []byte) error {
 func (it *Iterator) Entry() *Entry {
 	return it.entry
 }
+
+// Iterate calls fn for each live entry of the bucket in the key order until fn returns false.
+// The key and value passed to fn are copies, so fn may retain them.
+func (tx *Tx) Iterate(bucket string, fn func(key, value []byte) bool) error {
+	it := NewIterator(tx, bucket, IteratorOptions{})
+	defer it.Close()
+
+	for {
+		ok, err := it.SetNext()
+		if err != nil || !ok {
+			return err
+		}
+
+		entry := it.Entry()
+		key := append([]byte(nil), entry.Key...)
+		value := append([]byte(nil), entry.Value...)
+		if !fn(key, value) {
+			return nil
+		}
+	}
+}
+
+// Iterate runs tx.Iterate in a read tx.
+func (db *DB) Iterate(bucket string, fn func(key, value []byte) bool) error {
+	return db.View(func(tx *Tx) error {
+		return tx.Iterate(bucket, fn)
+	})
+}
@@ -15,10 +15,15 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIterator_SetNext(t *testing.T) {
@@ -240,3 +245,760 @@ func TestIterator_Seek(t *testing.T) {
 		})
 	})
 }
+
+func TestIterator_SeekToFirstAndLast(t *testing.T) {
+	bucket := "bucket_for_iterator"
+
+	t.Run("empty_bucket", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			tx, err := db.Begin(false)
+			assert.NoError(t, err)
+
+			it := NewIterator(tx, bucket, IteratorOptions{Reverse: false})
+			assert.ErrorIs(t, it.SeekToFirst(), ErrBucketNotFound)
+			assert.ErrorIs(t, it.SeekToLast(), ErrBucketNotFound)
+
+			assert.NoError(t, tx.Commit())
+		})
+	})
+
+	t.Run("single_key_bucket", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(1), Persistent, nil)
+
+			tx, err := db.Begin(false)
+			assert.NoError(t, err)
+
+			it := NewIterator(tx, bucket, IteratorOptions{Reverse: false})
+			assert.NoError(t, it.SeekToFirst())
+			ok, err := it.SetNext()
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, GetTestBytes(0), it.Entry().Key)
+
+			ok, err = it.SetNext()
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.NoError(t, it.SeekToLast())
+			ok, err = it.SetNext()
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, GetTestBytes(0), it.Entry().Key)
+
+			ok, err = it.SetNext()
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.NoError(t, tx.Commit())
+		})
+	})
+
+	t.Run("seek_to_last_then_reverse", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			for i := 0; i < 20; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+			}
+
+			tx, err := db.Begin(false)
+			assert.NoError(t, err)
+
+			it := NewIterator(tx, bucket, IteratorOptions{Reverse: true})
+			assert.NoError(t, it.SeekToLast())
+			for i := 19; i >= 0; i-- {
+				ok, err := it.SetNext()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, GetTestBytes(i), it.Entry().Key)
+			}
+
+			ok, err := it.SetNext()
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.NoError(t, tx.Commit())
+		})
+	})
+}
+
+func TestIterator_KeyOnly(t *testing.T) {
+	bucket := "bucket_for_iterator"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	opt.SegmentSize = 8 * 1024
+
+	withDBOption(t, opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 10; i++ {
+			txPut(t, db, bucket, GetTestBytes(i), GetRandomBytes(1024), Persistent, nil)
+		}
+		require.Greater(t, db.MaxFileID, int64(0))
+
+		// the values in the first data file can no longer be read,
+		// so the key only iteration must not touch the data files.
+		path := getDataPath(0, db.opt.Dir)
+		require.NoError(t, db.fm.fdm.closeByPath(path))
+		require.NoError(t, os.Remove(path))
+		require.NoError(t, os.Mkdir(path, os.ModePerm))
+
+		tx, err := db.Begin(false)
+		require.NoError(t, err)
+
+		it := NewIterator(tx, bucket, IteratorOptions{KeyOnly: true})
+		for i := 0; i < 10; i++ {
+			ok, err := it.SetNext()
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, GetTestBytes(i), it.Entry().Key)
+			require.Equal(t, []byte(bucket), it.Entry().Bucket)
+			require.Nil(t, it.Entry().Value)
+		}
+		ok, err := it.SetNext()
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		it = NewIterator(tx, bucket, IteratorOptions{})
+		_, err = it.SetNext()
+		assert.Error(t, err)
+
+		require.NoError(t, tx.Commit())
+	})
+}
+
+func BenchmarkIterator_KeyOnly(b *testing.B) {
+	bucket := "bucket_for_iterator"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	defer removeDir(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(b, err)
+	defer db.Close()
+
+	require.NoError(b, db.Update(func(tx *Tx) error {
+		for i := 0; i < 1000; i++ {
+			if err := tx.Put(bucket, GetTestBytes(i), GetRandomBytes(16*1024), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	iterate := func(b *testing.B, options IteratorOptions) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, db.View(func(tx *Tx) error {
+				it := NewIterator(tx, bucket, options)
+				for {
+					ok, err := it.SetNext()
+					if err != nil || !ok {
+						return err
+					}
+				}
+			}))
+		}
+	}
+
+	b.Run("key_only", func(b *testing.B) {
+		iterate(b, IteratorOptions{KeyOnly: true})
+	})
+	b.Run("with_values", func(b *testing.B) {
+		iterate(b, IteratorOptions{})
+	})
+}
+
+func TestIterator_PendingWrites(t *testing.T) {
+	bucket := "bucket_for_iterator"
+
+	collect := func(t *testing.T, tx *Tx, reverse bool) (keys, values [][]byte) {
+		it := NewIterator(tx, bucket, IteratorOptions{Reverse: reverse})
+		for {
+			ok, err := it.SetNext()
+			require.NoError(t, err)
+			if !ok {
+				return
+			}
+			keys = append(keys, it.Entry().Key)
+			values = append(values, it.Entry().Value)
+		}
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.EntryIdxMode = mode
+
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			for _, i := range []int{1, 3, 5} {
+				txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+			}
+
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				// put-then-iterate, delete-then-iterate and overwrite-then-iterate.
+				require.NoError(t, tx.Put(bucket, GetTestBytes(0), GetTestBytes(0), Persistent))
+				require.NoError(t, tx.Put(bucket, GetTestBytes(4), GetTestBytes(4), Persistent))
+				require.NoError(t, tx.Delete(bucket, GetTestBytes(3)))
+				require.NoError(t, tx.Put(bucket, GetTestBytes(5), []byte("new"), Persistent))
+
+				keys, values := collect(t, tx, false)
+				require.Equal(t, [][]byte{GetTestBytes(0), GetTestBytes(1), GetTestBytes(4), GetTestBytes(5)}, keys)
+				require.Equal(t, [][]byte{GetTestBytes(0), GetTestBytes(1), GetTestBytes(4), []byte("new")}, values)
+
+				keys, _ = collect(t, tx, true)
+				require.Equal(t, [][]byte{GetTestBytes(5), GetTestBytes(4), GetTestBytes(1), GetTestBytes(0)}, keys)
+
+				it := NewIterator(tx, bucket, IteratorOptions{})
+				require.NoError(t, it.Seek(GetTestBytes(2)))
+				ok, err := it.SetNext()
+				require.NoError(t, err)
+				require.True(t, ok)
+				require.Equal(t, GetTestBytes(4), it.Entry().Key)
+				return nil
+			}))
+
+			// the pending writes of a new bucket are visible as well.
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				require.NoError(t, tx.Put("new_bucket", GetTestBytes(0), GetTestBytes(0), Persistent))
+				it := NewIterator(tx, "new_bucket", IteratorOptions{})
+				ok, err := it.SetNext()
+				require.NoError(t, err)
+				require.True(t, ok)
+				require.Equal(t, GetTestBytes(0), it.Entry().Key)
+				return nil
+			}))
+		})
+	}
+}
+
+// writeTombstones writes n consecutive keys and deletes them, then writes the live key after them.
+func writeTombstones(tb testing.TB, db *DB, bucket string, n int, live []byte) {
+	require.NoError(tb, db.Update(func(tx *Tx) error {
+		for i := 0; i < n; i++ {
+			if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%08d", i)), GetTestBytes(i), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(tb, db.Update(func(tx *Tx) error {
+		for i := 0; i < n; i++ {
+			if err := tx.Delete(bucket, []byte(fmt.Sprintf("key_%08d", i))); err != nil {
+				return err
+			}
+		}
+		return tx.Put(bucket, live, live, Persistent)
+	}))
+}
+
+func TestIterator_Tombstones(t *testing.T) {
+	bucket := "bucket_for_iterator"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opt.SyncEnable = false
+
+	withDBOption(t, opt, func(t *testing.T, db *DB) {
+		live := []byte("key_live")
+		writeTombstones(t, db, bucket, 200000, live)
+
+		for _, reverse := range []bool{false, true} {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				it := NewIterator(tx, bucket, IteratorOptions{Reverse: reverse})
+				ok, err := it.SetNext()
+				require.NoError(t, err)
+				require.True(t, ok)
+				require.Equal(t, live, it.Entry().Key)
+
+				ok, err = it.SetNext()
+				require.NoError(t, err)
+				require.False(t, ok)
+				return nil
+			}))
+		}
+	})
+}
+
+func BenchmarkIterator_Tombstones(b *testing.B) {
+	bucket := "bucket_for_iterator"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opt.SyncEnable = false
+	defer removeDir(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(b, err)
+	defer db.Close()
+
+	writeTombstones(b, db, bucket, 10000, []byte("key_live"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, db.View(func(tx *Tx) error {
+			it := NewIterator(tx, bucket, IteratorOptions{})
+			for {
+				ok, err := it.SetNext()
+				if err != nil || !ok {
+					return err
+				}
+			}
+		}))
+	}
+}
+
+func TestIterator_SeekWithoutIndex(t *testing.T) {
+	bucket := "bucket_for_iterator"
+
+	assertNotPositioned := func(t *testing.T, db *DB, expectErr error) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			it := NewIterator(tx, bucket, IteratorOptions{})
+			assert.Equal(t, expectErr, it.Seek(GetTestBytes(0)))
+
+			ok, err := it.SetNext()
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			return nil
+		}))
+	}
+
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opt.SegmentSize = 8 * KB
+
+	withDBOption(t, opt, func(t *testing.T, db *DB) {
+		// the bucket is never written.
+		assertNotPositioned(t, db, ErrBucketNotFound)
+
+		// the only key of the bucket is deleted and merged away.
+		txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+		txDel(t, db, bucket, GetTestBytes(0), nil)
+		for i := 0; i < 20; i++ {
+			txPut(t, db, "filler", GetTestBytes(i), make([]byte, 1024), Persistent, nil)
+		}
+		require.NoError(t, db.Merge())
+		assertNotPositioned(t, db, nil)
+
+		require.NoError(t, db.Close())
+		db, err := Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+		assertNotPositioned(t, db, ErrBucketNotFound)
+	})
+}
+
+func TestIterator_OffsetLimit(t *testing.T) {
+	bucket := "bucket_for_iterator"
+
+	collect := func(t *testing.T, tx *Tx, options IteratorOptions, seek []byte) (keys [][]byte) {
+		it := NewIterator(tx, bucket, options)
+		if seek != nil {
+			require.NoError(t, it.Seek(seek))
+		}
+		for {
+			ok, err := it.SetNext()
+			require.NoError(t, err)
+			if !ok {
+				return
+			}
+			keys = append(keys, it.Entry().Key)
+		}
+	}
+	keys := func(idx ...int) (keys [][]byte) {
+		for _, i := range idx {
+			keys = append(keys, GetTestBytes(i))
+		}
+		return
+	}
+
+	t.Run("live entries", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			for i := 0; i < 10; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+			}
+			txDel(t, db, bucket, GetTestBytes(1), nil)
+			txDel(t, db, bucket, GetTestBytes(8), nil)
+
+			require.NoError(t, db.View(func(tx *Tx) error {
+				assert.Equal(t, keys(3, 4, 5), collect(t, tx, IteratorOptions{Offset: 2, Limit: 3}, nil))
+				assert.Equal(t, keys(6, 5), collect(t, tx, IteratorOptions{Reverse: true, Offset: 2, Limit: 2}, nil))
+				assert.Equal(t, keys(7, 9), collect(t, tx, IteratorOptions{Offset: 6}, nil))
+				assert.Empty(t, collect(t, tx, IteratorOptions{Offset: 8}, nil))
+				assert.Equal(t, keys(0, 2), collect(t, tx, IteratorOptions{Limit: 2}, nil))
+
+				// the offset counts from the sought position.
+				assert.Equal(t, keys(5, 6), collect(t, tx, IteratorOptions{Offset: 1, Limit: 2}, GetTestBytes(4)))
+				assert.Equal(t, keys(3, 2), collect(t, tx, IteratorOptions{Reverse: true, Offset: 1, Limit: 2}, GetTestBytes(4)))
+				return nil
+			}))
+
+			// the pending writes of the tx are counted as well.
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				require.NoError(t, tx.Put(bucket, GetTestBytes(1), GetTestBytes(1), Persistent))
+				require.NoError(t, tx.Delete(bucket, GetTestBytes(2)))
+				assert.Equal(t, keys(1, 3), collect(t, tx, IteratorOptions{Offset: 1, Limit: 2}, nil))
+				return nil
+			}))
+		})
+	})
+
+	t.Run("skipped values are not read", func(t *testing.T) {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.EntryIdxMode = HintKeyAndRAMIdxMode
+		opt.SegmentSize = 8 * 1024
+
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			for i := 0; i < 10; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), GetRandomBytes(1024), Persistent, nil)
+			}
+			require.Greater(t, db.MaxFileID, int64(0))
+
+			// the first data file holds fewer than 8 entries and can no longer be read.
+			path := getDataPath(0, db.opt.Dir)
+			require.NoError(t, db.fm.fdm.closeByPath(path))
+			require.NoError(t, os.Remove(path))
+			require.NoError(t, os.Mkdir(path, os.ModePerm))
+
+			tx, err := db.Begin(false)
+			require.NoError(t, err)
+			assert.Equal(t, keys(8, 9), collect(t, tx, IteratorOptions{Offset: 8}, nil))
+			require.NoError(t, tx.Commit())
+		})
+	})
+}
+
+func TestIterator_Close(t *testing.T) {
+	bucket := "bucket_for_iterator"
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+
+	usings := func(db *DB) map[string]uint {
+		db.fm.fdm.lock.Lock()
+		defer db.fm.fdm.lock.Unlock()
+
+		usings := make(map[string]uint)
+		for path, info := range db.fm.fdm.cache {
+			usings[path] = info.using
+		}
+		return usings
+	}
+
+	withDBOption(t, opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 10; i++ {
+			txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+		}
+		baseline := usings(db)
+
+		// the iterator is abandoned after reading one entry.
+		var it *Iterator
+		require.NoError(t, db.View(func(tx *Tx) error {
+			it = NewIterator(tx, bucket, IteratorOptions{})
+			ok, err := it.SetNext()
+			require.NoError(t, err)
+			require.True(t, ok)
+			return nil
+		}))
+		require.Equal(t, baseline, usings(db))
+
+		ok, err := it.SetNext()
+		require.Equal(t, ErrIteratorClosed, err)
+		require.False(t, ok)
+		require.Equal(t, ErrIteratorClosed, it.Close())
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			it := NewIterator(tx, bucket, IteratorOptions{})
+			require.NoError(t, it.Close())
+
+			_, err := it.SetNext()
+			assert.Equal(t, ErrIteratorClosed, err)
+			assert.Equal(t, ErrIteratorClosed, it.Seek(GetTestBytes(0)))
+			return nil
+		}))
+
+		// the closed iterators are dropped from the tx.
+		require.NoError(t, db.View(func(tx *Tx) error {
+			first := NewIterator(tx, bucket, IteratorOptions{})
+			second := NewIterator(tx, bucket, IteratorOptions{})
+			require.NoError(t, first.Close())
+			assert.Equal(t, []*Iterator{second}, tx.iterators)
+
+			require.NoError(t, tx.IterateBucketEntries(func(string, *Entry) bool { return true }))
+			require.NoError(t, tx.Iterate(bucket, func(key, value []byte) bool { return false }))
+			assert.Equal(t, []*Iterator{second}, tx.iterators)
+
+			require.NoError(t, second.Close())
+			assert.Empty(t, tx.iterators)
+			return nil
+		}))
+	})
+}
+
+func TestIterator_StableUnderConcurrentWrites(t *testing.T) {
+	bucket := "bucket"
+	n := 10000
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		// the even keys are never touched again, the odd ones are put and deleted continuously.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < n; i += 2 {
+				if err := tx.Put(bucket, GetTestBytes(i), GetTestBytes(i), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; ; i = (i + 2) % n {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				assert.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Put(bucket, GetTestBytes(i), GetTestBytes(i), Persistent)
+				}))
+				assert.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Delete(bucket, GetTestBytes(i))
+				}))
+			}
+		}()
+
+		for round := 0; round < 5; round++ {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				seen := make(map[string]bool)
+				var prev []byte
+				it := NewIterator(tx, bucket, IteratorOptions{})
+				for i := 0; ; i++ {
+					ok, err := it.SetNext()
+					if !assert.NoError(t, err) || !ok {
+						break
+					}
+					key := it.Entry().Key
+					assert.False(t, seen[string(key)], "key %s returned twice", key)
+					assert.True(t, prev == nil || compare(prev, key) < 0)
+					seen[string(key)] = true
+					prev = key
+
+					if i%1000 == 0 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+
+				for i := 0; i < n; i += 2 {
+					assert.True(t, seen[string(GetTestBytes(i))])
+				}
+				return it.Close()
+			}))
+		}
+
+		close(stop)
+		<-done
+	})
+}
+
+func TestIterator_Iterate(t *testing.T) {
+	bucket := "bucket"
+
+	t.Run("stop", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			for i := 0; i < 10; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+			}
+			txDel(t, db, bucket, GetTestBytes(3), nil)
+
+			var keys, values [][]byte
+			require.NoError(t, db.Iterate(bucket, func(key, value []byte) bool {
+				keys = append(keys, key)
+				values = append(values, value)
+				return len(keys) < 5
+			}))
+
+			require.Len(t, keys, 5)
+			for i, want := range []int{0, 1, 2, 4, 5} {
+				assert.Equal(t, GetTestBytes(want), keys[i])
+				assert.Equal(t, GetTestBytes(want), values[i])
+			}
+
+			called := false
+			require.NoError(t, db.Iterate("none", func(key, value []byte) bool {
+				called = true
+				return true
+			}))
+			require.False(t, called)
+		})
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+
+			// scribbling over the slices passed to fn must not change the stored entry.
+			require.NoError(t, db.Iterate(bucket, func(key, value []byte) bool {
+				copy(key, "xxxx")
+				copy(value, "xxxx")
+				return true
+			}))
+
+			txGet(t, db, bucket, GetTestBytes(0), GetTestBytes(0), nil)
+			require.NoError(t, db.Iterate(bucket, func(key, value []byte) bool {
+				assert.Equal(t, GetTestBytes(0), key)
+				assert.Equal(t, GetTestBytes(0), value)
+				return true
+			}))
+		})
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.EntryIdxMode = HintKeyAndRAMIdxMode
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			txPut(t, db, bucket, GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+			require.NoError(t, os.Truncate(getDataPath(0, opt.Dir), 0))
+
+			called := false
+			err := db.Iterate(bucket, func(key, value []byte) bool {
+				called = true
+				return true
+			})
+			require.True(t, errors.Is(err, ErrEntryZero))
+			require.False(t, called)
+		})
+	})
+}
+
+func TestIterator_IncludeDeletedAndExpired(t *testing.T) {
+	bucket := "bucket"
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.EntryIdxMode = mode
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			txPut(t, db, bucket, []byte("deleted"), []byte("value"), Persistent, nil)
+			txDel(t, db, bucket, []byte("deleted"), nil)
+			txPut(t, db, bucket, []byte("live"), []byte("value"), Persistent, nil)
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				expired := uint64(time.Now().Add(-time.Hour).Unix())
+				return tx.put(bucket, []byte("expired"), []byte("value"), 1, DataSetFlag, expired, DataStructureBPTree)
+			}))
+
+			iterate := func(options IteratorOptions) (entries []*Entry) {
+				require.NoError(t, db.View(func(tx *Tx) error {
+					it := NewIterator(tx, bucket, options)
+					for {
+						ok, err := it.SetNext()
+						if !assert.NoError(t, err) || !ok {
+							return nil
+						}
+						entries = append(entries, it.Entry())
+					}
+				}))
+				return entries
+			}
+
+			entries := iterate(IteratorOptions{})
+			require.Len(t, entries, 1)
+			assert.Equal(t, []byte("live"), entries[0].Key)
+
+			entries = iterate(IteratorOptions{IncludeDeleted: true, IncludeExpired: true})
+			require.Len(t, entries, 3)
+			assert.Equal(t, []byte("deleted"), entries[0].Key)
+			assert.Equal(t, DataDeleteFlag, entries[0].Meta.Flag)
+			assert.Nil(t, entries[0].Value)
+			assert.Equal(t, []byte("expired"), entries[1].Key)
+			assert.Equal(t, DataSetFlag, entries[1].Meta.Flag)
+			assert.True(t, IsExpired(entries[1].Meta.TTL, entries[1].Meta.Timestamp))
+			assert.Equal(t, []byte("value"), entries[1].Value)
+			assert.Equal(t, []byte("live"), entries[2].Key)
+			assert.Equal(t, []byte("value"), entries[2].Value)
+
+			entries = iterate(IteratorOptions{IncludeDeleted: true, Reverse: true})
+			require.Len(t, entries, 2)
+			assert.Equal(t, []byte("live"), entries[0].Key)
+			assert.Equal(t, []byte("deleted"), entries[1].Key)
+
+			// the pending writes of the tx are returned in the same way.
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				assert.NoError(t, tx.Delete(bucket, []byte("live")))
+				it := NewIterator(tx, bucket, IteratorOptions{IncludeDeleted: true})
+				var flags []uint16
+				for {
+					ok, err := it.SetNext()
+					if !assert.NoError(t, err) || !ok {
+						break
+					}
+					flags = append(flags, it.Entry().Meta.Flag)
+				}
+				assert.Equal(t, []uint16{DataDeleteFlag, DataDeleteFlag}, flags)
+				return nil
+			}))
+		})
+	}
+}
+
+func TestIterator_Range(t *testing.T) {
+	bucket := "bucket"
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		// the keys are 0, 2, 4, ..., 98 and 50 is deleted.
+		for i := 0; i < 100; i += 2 {
+			txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+		}
+		txDel(t, db, bucket, GetTestBytes(50), nil)
+
+		keys := func(start, end []byte, reverse bool) (keys [][]byte) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				it := NewRangeIterator(tx, bucket, start, end, reverse)
+				for {
+					ok, err := it.SetNext()
+					if !assert.NoError(t, err) || !ok {
+						return nil
+					}
+					keys = append(keys, it.Entry().Key)
+				}
+			}))
+			return keys
+		}
+
+		want := func(ids ...int) (keys [][]byte) {
+			for _, i := range ids {
+				keys = append(keys, GetTestBytes(i))
+			}
+			return keys
+		}
+
+		tests := []struct {
+			name       string
+			start, end []byte
+			want       [][]byte
+		}{
+			{"start equals end present", GetTestBytes(10), GetTestBytes(10), want(10)},
+			{"start equals end absent", GetTestBytes(11), GetTestBytes(11), nil},
+			{"start equals end deleted", GetTestBytes(50), GetTestBytes(50), nil},
+			{"before all keys", []byte("a"), []byte("b"), nil},
+			{"after all keys", []byte("z"), []byte("zz"), nil},
+			{"bounds between keys", GetTestBytes(45), GetTestBytes(55), want(46, 48, 52, 54)},
+			{"bounds on keys", GetTestBytes(44), GetTestBytes(56), want(44, 46, 48, 52, 54, 56)},
+			{"covering all keys", []byte("a"), []byte("z"), nil},
+		}
+		for i := 0; i < 100; i += 2 {
+			if i != 50 {
+				tests[len(tests)-1].want = append(tests[len(tests)-1].want, GetTestBytes(i))
+			}
+		}
+
+		for _, tt := range tests {
+			assert.Equal(t, tt.want, keys(tt.start, tt.end, false), tt.name)
+
+			var reversed [][]byte
+			for i := len(tt.want) - 1; i >= 0; i-- {
+				reversed = append(reversed, tt.want[i])
+			}
+			assert.Equal(t, reversed, keys(tt.start, tt.end, true), tt.name+" reverse")
+		}
+	})
+}
@@ -0,0 +1,484 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// the names of the data structures in the json records.
+const (
+	jsonBPTree    = "bptree"
+	jsonSet       = "set"
+	jsonList      = "list"
+	jsonSortedSet = "zset"
+)
+
+// jsonImportBatch is how many records ImportJSON writes by transaction.
+const jsonImportBatch = 1000
+
+// ExportOptions represents the options of DB.ExportJSON.
+type ExportOptions struct {
+	// Buckets represents the buckets exported, all of them if it's empty.
+	Buckets []string
+}
+
+// JSONRecord is a line written by ExportJSON: a BPTree key, a set member, a list item or a sorted set member.
+// The keys and values are base64 in the json, so any bytes round-trip. The ttl in seconds counts from the
+// timestamp, the ttl of a set, list or sorted set is the one of the whole collection.
+type JSONRecord struct {
+	Ds        string `json:"ds"`
+	Bucket    string `json:"bucket"`
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	TTL       uint32 `json:"ttl"`
+	Timestamp uint64 `json:"timestamp"`
+
+	// ExpireAt is the expiration in milliseconds of a BPTree key put with one, see Tx.PutWithExpireAt.
+	ExpireAt uint64 `json:"expire_at,omitempty"`
+
+	// Seq is the index of a list item in its list.
+	Seq *int `json:"seq,omitempty"`
+
+	// Score and IntScore are the score of a sorted set member, IntScore for the sorted sets of int64 scores.
+	Score    *float64 `json:"score,omitempty"`
+	IntScore *int64   `json:"int_score,omitempty"`
+}
+
+// ExportJSON writes every live record of the db to w, one JSONRecord by line, to be loaded by ImportJSON.
+// The records are in a fixed order: the BPTree keys, the set members, the list items and the sorted set
+// members, the buckets and keys sorted, the list items in the list order and the sorted set members by rank.
+func (db *DB) ExportJSON(w io.Writer, opts ExportOptions) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	e := &recordExporter{
+		emit:    func(r *JSONRecord) error { return enc.Encode(r) },
+		buckets: opts.Buckets,
+	}
+	if err := db.View(e.export); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// recordExporter walks the live records of the db in the order of ExportJSON.
+type recordExporter struct {
+	emit    func(r *JSONRecord) error
+	buckets []string
+}
+
+func (e *recordExporter) export(tx *Tx) error {
+	if err := e.exportBPTree(tx); err != nil {
+		return err
+	}
+	if err := e.exportSet(tx); err != nil {
+		return err
+	}
+	if err := e.exportList(tx); err != nil {
+		return err
+	}
+
+	return e.exportSortedSet(tx)
+}
+
+// sortedBuckets returns the buckets of the index exported, sorted.
+func (e *recordExporter) sortedBuckets(has func(bucket string) bool, all []string) []string {
+	var buckets []string
+	if len(e.buckets) == 0 {
+		buckets = all
+	} else {
+		for _, bucket := range e.buckets {
+			if has(bucket) {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	sort.Strings(buckets)
+
+	return buckets
+}
+
+func (e *recordExporter) exportBPTree(tx *Tx) error {
+	all := make([]string, 0, len(tx.db.BPTreeIdx))
+	for bucket := range tx.db.BPTreeIdx {
+		all = append(all, bucket)
+	}
+	has := func(bucket string) bool { return tx.db.hasBucketIndex(DataStructureBPTree, bucket) }
+
+	for _, bucket := range e.sortedBuckets(has, all) {
+		if err := e.exportBPTreeBucket(tx, bucket); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *recordExporter) exportBPTreeBucket(tx *Tx, bucket string) error {
+	it := NewIterator(tx, bucket, IteratorOptions{})
+	defer it.Close()
+
+	for {
+		ok, err := it.SetNext()
+		if err != nil || !ok {
+			return err
+		}
+
+		entry := it.Entry()
+		err = e.emit(&JSONRecord{
+			Ds:        jsonBPTree,
+			Bucket:    bucket,
+			Key:       entry.Key,
+			Value:     entry.Value,
+			TTL:       entry.Meta.TTL,
+			Timestamp: entry.Meta.Timestamp,
+			ExpireAt:  entry.Meta.ExpireAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *recordExporter) exportSet(tx *Tx) error {
+	all := make([]string, 0, len(tx.db.SetIdx))
+	for bucket := range tx.db.SetIdx {
+		all = append(all, bucket)
+	}
+	has := func(bucket string) bool { return tx.db.hasBucketIndex(DataStructureSet, bucket) }
+
+	for _, bucket := range e.sortedBuckets(has, all) {
+		set := tx.db.SetIdx[bucket]
+		keys := make([]string, 0, len(set.M))
+		for key := range set.M {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			records, err := set.SMembers(key)
+			if err != nil {
+				continue
+			}
+			values, err := tx.getSetValues(records)
+			if err != nil {
+				return err
+			}
+			sort.Slice(values, func(i, j int) bool {
+				return bytes.Compare(values[i], values[j]) < 0
+			})
+
+			for _, value := range values {
+				err := e.emit(&JSONRecord{
+					Ds:        jsonSet,
+					Bucket:    bucket,
+					Key:       []byte(key),
+					Value:     value,
+					TTL:       set.TTL[key],
+					Timestamp: set.TimeStamp[key],
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *recordExporter) exportList(tx *Tx) error {
+	all := make([]string, 0, len(tx.db.Index.list))
+	for bucket := range tx.db.Index.list {
+		all = append(all, bucket)
+	}
+	has := func(bucket string) bool { return tx.db.hasBucketIndex(DataStructureList, bucket) }
+
+	for _, bucket := range e.sortedBuckets(has, all) {
+		l := tx.db.Index.list[bucket]
+		keys := make([]string, 0, len(l.Items))
+		for key := range l.Items {
+			if !l.IsExpire(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			for i, item := range l.Items[key].Values() {
+				value, err := tx.db.getValueByRecord(item.(*Record))
+				if err != nil {
+					return err
+				}
+
+				seq := i
+				err = e.emit(&JSONRecord{
+					Ds:        jsonList,
+					Bucket:    bucket,
+					Key:       []byte(key),
+					Value:     value,
+					TTL:       l.TTL[key],
+					Timestamp: l.TimeStamp[key],
+					Seq:       &seq,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *recordExporter) exportSortedSet(tx *Tx) error {
+	all := make([]string, 0, len(tx.db.SortedSetIdx))
+	for bucket := range tx.db.SortedSetIdx {
+		all = append(all, bucket)
+	}
+	has := func(bucket string) bool { return tx.db.hasBucketIndex(DataStructureSortedSet, bucket) }
+
+	for _, bucket := range e.sortedBuckets(has, all) {
+		sortedSet, ok := tx.sortedSet(bucket)
+		if !ok {
+			continue
+		}
+		ttl, timestamp := sortedSet.TTL()
+
+		var (
+			nodes  []*zset.SortedSetNode
+			cursor []byte
+		)
+		for {
+			nodes, cursor, _ = sortedSet.Scan(cursor, 1024)
+			for _, node := range nodes {
+				r := &JSONRecord{
+					Ds:        jsonSortedSet,
+					Bucket:    bucket,
+					Key:       []byte(node.Key()),
+					Value:     node.Value,
+					TTL:       ttl,
+					Timestamp: timestamp,
+				}
+				if sortedSet.ScoreType() == zset.ScoreInt64 {
+					score := node.IntScore()
+					r.IntScore = &score
+				} else {
+					score := float64(node.Score())
+					r.Score = &score
+				}
+				if err := e.emit(r); err != nil {
+					return err
+				}
+			}
+			if cursor == nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImportJSON loads the records written by ExportJSON, by transactions of jsonImportBatch records. The records
+// keep their ttl and timestamp, so they expire when they would have in the exported db.
+//
+// Importing a file again leaves the db in the same state, so an import which failed can be run again from the
+// start: the BPTree keys and the sorted set members are overwritten, the set members are added once, and the
+// list item at Seq replaces the one at the index if the list is that long already. A line which is not a
+// record is returned as ErrImportJSON with its line number, the records before it are imported.
+func (db *DB) ImportJSON(r io.Reader) error {
+	br := bufio.NewReader(r)
+	batch := make([]*JSONRecord, 0, jsonImportBatch)
+	lines := make([]int, 0, jsonImportBatch)
+
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			record, err := parseJSONRecord(data)
+			if err != nil {
+				if flushErr := db.importJSONBatch(batch, lines); flushErr != nil {
+					return flushErr
+				}
+				return fmt.Errorf("%w at line %d: %v", ErrImportJSON, line, err)
+			}
+			batch = append(batch, record)
+			lines = append(lines, line)
+		}
+
+		if len(batch) == jsonImportBatch || eof {
+			if err := db.importJSONBatch(batch, lines); err != nil {
+				return err
+			}
+			batch, lines = batch[:0], lines[:0]
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// parseJSONRecord parses and checks a line of ImportJSON.
+func parseJSONRecord(data []byte) (*JSONRecord, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	r := &JSONRecord{}
+	if err := dec.Decode(r); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("more than one record")
+	}
+
+	switch r.Ds {
+	case jsonBPTree, jsonSet:
+	case jsonList:
+		if r.Seq == nil || *r.Seq < 0 {
+			return nil, errors.New("list item without seq")
+		}
+	case jsonSortedSet:
+		if (r.Score == nil) == (r.IntScore == nil) {
+			return nil, errors.New("sorted set member without either score or int_score")
+		}
+	default:
+		return nil, fmt.Errorf("unknown ds %q", r.Ds)
+	}
+	if len(r.Key) == 0 {
+		return nil, errors.New("empty key")
+	}
+
+	return r, nil
+}
+
+// importJSONBatch writes the records in a transaction, an error names the line of the record.
+func (db *DB) importJSONBatch(records []*JSONRecord, lines []int) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	b := &jsonImportBatchState{listLens: make(map[string]int), ttls: make(map[string]struct{})}
+	for i, r := range records {
+		if err := b.importRecord(tx, r); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("line %d: %w", lines[i], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// jsonImportBatchState is what a batch of ImportJSON writes which the index doesn't hold until the commit.
+type jsonImportBatchState struct {
+	listLens map[string]int      // the length of the lists with the items pushed
+	ttls     map[string]struct{} // the collections whose ttl is set
+}
+
+func (b *jsonImportBatchState) importRecord(tx *Tx, r *JSONRecord) error {
+	switch r.Ds {
+	case jsonBPTree:
+		if r.ExpireAt != 0 {
+			return tx.putExpireAt(r.Bucket, r.Key, r.Value, r.TTL, r.ExpireAt, DataSetFlag, r.Timestamp, DataStructureBPTree)
+		}
+		return tx.put(r.Bucket, r.Key, r.Value, r.TTL, DataSetFlag, r.Timestamp, DataStructureBPTree)
+
+	case jsonSet:
+		if err := tx.SAdd(r.Bucket, r.Key, r.Value); err != nil {
+			return err
+		}
+		return b.setTTL(tx, r, r.Key, DataExpireSetFlag, DataStructureSet)
+
+	case jsonList:
+		id := r.Bucket + "\x00" + string(r.Key)
+		size, ok := b.listLens[id]
+		if !ok {
+			size, _ = tx.LSize(r.Bucket, r.Key)
+		}
+
+		switch seq := *r.Seq; {
+		case seq < size:
+			if err := tx.LSet(r.Bucket, r.Key, seq, r.Value); err != nil {
+				return err
+			}
+		case seq == size:
+			if err := tx.RPush(r.Bucket, r.Key, r.Value); err != nil {
+				return err
+			}
+			size++
+		default:
+			return fmt.Errorf("list item at seq %d of a list of %d items", seq, size)
+		}
+		b.listLens[id] = size
+
+		return b.setTTL(tx, r, r.Key, DataExpireListFlag, DataStructureList)
+
+	case jsonSortedSet:
+		var err error
+		if r.IntScore != nil {
+			if err = tx.setZSetScoreType(r.Bucket, zset.ScoreInt64); err == nil {
+				err = tx.ZAddInt(r.Bucket, r.Key, *r.IntScore, r.Value)
+			}
+		} else {
+			err = tx.ZAdd(r.Bucket, r.Key, *r.Score, r.Value)
+		}
+		if err != nil {
+			return err
+		}
+		return b.setTTL(tx, r, []byte(" "), DataExpireZSetFlag, DataStructureSortedSet)
+	}
+
+	return nil
+}
+
+// setTTL sets the ttl of the collection of the record from its timestamp once by batch, like ExpireSet,
+// ExpireList and ExpireZSet do from the time of the call.
+func (b *jsonImportBatchState) setTTL(tx *Tx, r *JSONRecord, key []byte, flag uint16, ds uint16) error {
+	if r.TTL == Persistent {
+		return nil
+	}
+
+	id := r.Ds + "\x00" + r.Bucket + "\x00" + string(key)
+	if _, ok := b.ttls[id]; ok {
+		return nil
+	}
+	b.ttls[id] = struct{}{}
+
+	value := []byte(strconv2.Int64ToStr(int64(r.TTL)))
+	return tx.put(r.Bucket, key, value, Persistent, flag, r.Timestamp, ds)
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ExportJSON(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	newOpt := func() Options {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opt.SegmentSize = 64 * KB
+		opt.Clock = clock
+		return opt
+	}
+	exportJSON := func(db *DB) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, db.ExportJSON(&buf, ExportOptions{}))
+		return buf.Bytes()
+	}
+
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+
+	withDBOption(t, newOpt(), func(t *testing.T, src *DB) {
+		require.NoError(t, src.SetZSetScoreType("zint", zset.ScoreInt64))
+		require.NoError(t, src.Update(func(tx *Tx) error {
+			// more keys than a batch of the import.
+			for i := 0; i < 2*jsonImportBatch+10; i++ {
+				if err := tx.Put("kv", GetTestBytes(i), GetTestBytes(i), uint32(i%3)*3600); err != nil {
+					return err
+				}
+			}
+			if err := tx.Put("kv", binary, binary, Persistent); err != nil {
+				return err
+			}
+			if err := tx.PutWithTTL("kv", []byte("millis"), []byte("value"), 1500*time.Millisecond); err != nil {
+				return err
+			}
+			if err := tx.SAdd("set", []byte("key"), []byte("a"), []byte("b"), binary); err != nil {
+				return err
+			}
+			if err := tx.SAdd("set", []byte("expiring"), []byte("a")); err != nil {
+				return err
+			}
+			if err := tx.ExpireSet("set", []byte("expiring"), 100); err != nil {
+				return err
+			}
+			if err := tx.RPush("list", []byte("key"), []byte("a"), []byte("b"), []byte("a"), binary); err != nil {
+				return err
+			}
+			if err := tx.RPush("list", []byte("expiring"), []byte("a")); err != nil {
+				return err
+			}
+			if err := tx.ExpireList("list", []byte("expiring"), 100); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("zset", []byte("a"), 1.5, binary); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("zset", []byte("b"), -2, []byte("b")); err != nil {
+				return err
+			}
+			if err := tx.ExpireZSet("zset", 100); err != nil {
+				return err
+			}
+			return tx.ZAddInt("zint", []byte("a"), 1<<60+1, []byte("a"))
+		}))
+		txDel(t, src, "kv", GetTestBytes(0), nil)
+		txPut(t, src, "kv", []byte("expired"), []byte("value"), 1, nil)
+		clock.Add(time.Second)
+
+		export := exportJSON(src)
+		lines := strings.Split(strings.TrimSpace(string(export)), "\n")
+		require.Len(t, lines, 2*jsonImportBatch+10-1+2+4+5+3)
+		require.NotContains(t, string(export), `"expired"`)
+
+		withDBOption(t, newOpt(), func(t *testing.T, dst *DB) {
+			require.NoError(t, dst.ImportJSON(bytes.NewReader(export)))
+			require.Equal(t, contentHash(t, src, HashOptions{}), contentHash(t, dst, HashOptions{}))
+			require.Equal(t, string(export), string(exportJSON(dst)))
+
+			// the import resumed from the start leaves the same state.
+			require.NoError(t, dst.ImportJSON(strings.NewReader(strings.Join(lines[:jsonImportBatch+5], "\n"))))
+			require.NoError(t, dst.ImportJSON(bytes.NewReader(export)))
+			require.Equal(t, string(export), string(exportJSON(dst)))
+
+			// the records expire when they would have in the exported db.
+			clock.Add(time.Second)
+			txGet(t, dst, "kv", []byte("millis"), nil, ErrNotFoundKey)
+			clock.Add(100 * time.Second)
+			require.Equal(t, string(exportJSON(src)), string(exportJSON(dst)))
+			require.NotContains(t, string(exportJSON(dst)), `"bucket":"zset"`)
+		})
+
+		withDBOption(t, newOpt(), func(t *testing.T, dst *DB) {
+			// lines[1] is expired by now.
+			malformed := strings.Join([]string{lines[0], lines[2], "", lines[3], `{"ds":"bptree","bucket":`, lines[4]}, "\n")
+			err := dst.ImportJSON(strings.NewReader(malformed))
+			require.ErrorIs(t, err, ErrImportJSON)
+			require.Contains(t, err.Error(), "line 5")
+
+			// the records before the malformed line are imported.
+			require.Equal(t, lines[0]+"\n"+lines[2]+"\n"+lines[3]+"\n", string(exportJSON(dst)))
+
+			for _, line := range []string{
+				`{"ds":"hash","bucket":"b","key":"YQ==","value":"YQ==","ttl":0,"timestamp":0}`,
+				`{"ds":"list","bucket":"b","key":"YQ==","value":"YQ==","ttl":0,"timestamp":0}`,
+				`{"ds":"zset","bucket":"b","key":"YQ==","value":"YQ==","ttl":0,"timestamp":0}`,
+				`{"ds":"bptree","bucket":"b","key":"YQ==","value":"not base64","ttl":0,"timestamp":0}`,
+				`{"ds":"bptree","bucket":"b","key":"YQ==","value":"YQ==","ttl":0,"timestamp":0,"unknown":1}`,
+			} {
+				err := dst.ImportJSON(strings.NewReader(lines[0] + "\n" + line))
+				require.ErrorIs(t, err, ErrImportJSON, line)
+				require.Contains(t, err.Error(), "line 2")
+			}
+
+			// a list item out of its list is refused.
+			err = dst.ImportJSON(strings.NewReader(`{"ds":"list","bucket":"b","key":"YQ==","value":"YQ==","ttl":0,"timestamp":0,"seq":3}`))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "line 1")
+		})
+	})
+}
@@ -17,7 +17,6 @@ package nutsdb
 import (
 	"errors"
 	dll "github.com/emirpasic/gods/lists/doublylinkedlist"
-	"time"
 )
 
 var (
@@ -26,6 +25,15 @@ var (
 
 	// ErrIndexOutOfRange is returned when use LSet function set index out of range.
 	ErrIndexOutOfRange = errors.New("index out of range")
+
+	// ErrListEmpty is returned when popping or peeking an empty list.
+	ErrListEmpty = errors.New("the list is empty")
+
+	// ErrPivotNotFound is returned when the pivot of LInsertBefore or LInsertAfter is not in the list.
+	ErrPivotNotFound = errors.New("the pivot not found")
+
+	// ErrListCap is returned when LCap is called with a negative maximum length.
+	ErrListCap = errors.New("the cap of list can not be negative")
 )
 
 // List represents the list.
@@ -33,6 +41,10 @@ type List struct {
 	Items     map[string]*dll.List
 	TTL       map[string]uint32
 	TimeStamp map[string]uint64
+	Cap       map[string]int
+
+	seqs  map[string]*listSeq // the sequence numbers given to the elements of the lists, see ListFormatSeq
+	clock Clock               // the clock the ttls are checked by, the wall clock if it's nil
 }
 
 func NewList() *List {
@@ -40,6 +52,34 @@ func NewList() *List {
 		Items:     make(map[string]*dll.List),
 		TTL:       make(map[string]uint32),
 		TimeStamp: make(map[string]uint64),
+		Cap:       make(map[string]int),
+		seqs:      make(map[string]*listSeq),
+	}
+}
+
+// listSeq is the range of the sequence numbers given to the elements pushed to a list since it was cleared,
+// the range only widens so that the sequence numbers of the elements are unique and in the order of the list.
+type listSeq struct {
+	head, tail int64
+}
+
+// next widens the range by one at the isLeft end and returns the sequence number it gives out, 0 is never given.
+func (s *listSeq) next(isLeft bool) int64 {
+	if isLeft {
+		s.head--
+		return s.head
+	}
+	s.tail++
+	return s.tail
+}
+
+// add widens the range to the sequence number seq.
+func (s *listSeq) add(seq int64) {
+	if seq < s.head {
+		s.head = seq
+	}
+	if seq > s.tail {
+		s.tail = seq
 	}
 }
 
@@ -52,8 +92,10 @@ func (l *List) RPush(key string, r *Record) error {
 }
 
 func (l *List) push(key string, r *Record, isLeft bool) error {
-	if l.IsExpire(key) {
-		return ErrListNotFound
+	// a push after the list expired starts a fresh list, the expiration is judged at the time
+	// of the push, so that replaying the data files on open gives the same list.
+	if l.expiredAt(key, r.meta().Timestamp) {
+		l.clear(key)
 	}
 
 	list, ok := l.Items[key]
@@ -68,6 +110,24 @@ func (l *List) push(key string, r *Record, isLeft bool) error {
 		list.Append(r)
 	}
 
+	if r.seq != 0 {
+		seq, ok := l.seqs[key]
+		if !ok {
+			seq = &listSeq{}
+			l.seqs[key] = seq
+		}
+		seq.add(r.seq)
+	}
+
+	// the push to a capped list evicts the element at the other end.
+	if c, ok := l.Cap[key]; ok && list.Size() > c {
+		if isLeft {
+			list.Remove(list.Size() - 1)
+		} else {
+			list.Remove(0)
+		}
+	}
+
 	return nil
 }
 
@@ -92,6 +152,44 @@ func (l *List) RPop(key string) (*Record, error) {
 	return r, nil
 }
 
+// popSeq removes the element with the sequence number seq from the list stored at key. The element is expected
+// at the isLeft end where the pop found it, and looked for through the list otherwise. Nothing is removed if
+// the element is not in the list, so that replaying the pop again is harmless. A seq of 0 pops the element at
+// the isLeft end whatever it is.
+func (l *List) popSeq(key string, seq int64, isLeft bool) (*Record, error) {
+	if seq == 0 {
+		if isLeft {
+			return l.LPop(key)
+		}
+		return l.RPop(key)
+	}
+
+	r, err := l.peek(key, isLeft)
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	list := l.Items[key]
+	if r.seq == seq {
+		if isLeft {
+			list.Remove(0)
+		} else {
+			list.Remove(list.Size() - 1)
+		}
+		return r, nil
+	}
+
+	iterator := list.Iterator()
+	for iterator.Next() {
+		if r := iterator.Value().(*Record); r.seq == seq {
+			list.Remove(iterator.Index())
+			return r, nil
+		}
+	}
+
+	return nil, nil
+}
+
 func (l *List) LPeek(key string) (*Record, error) {
 	return l.peek(key, true)
 }
@@ -129,9 +227,9 @@ func (l *List) LRange(key string, start, end int) ([]*Record, error) {
 		return nil, err
 	}
 
-	start, end, err = checkBounds(start, end, size)
-	if err != nil {
-		return nil, err
+	start, end, ok := normalizeRange(start, end, size)
+	if !ok {
+		return []*Record{}, nil
 	}
 
 	iterator := l.Items[key].Iterator()
@@ -155,16 +253,33 @@ func (l *List) LRange(key string, start, end int) ([]*Record, error) {
 // count < 0: Remove elements equal to value moving from tail to head.
 // count = 0: Remove all elements equal to value.
 func (l *List) LRem(key string, count int, cmp func(r *Record) (bool, error)) error {
+	indexes, err := l.lRemIndexes(key, count, cmp)
+	if err != nil {
+		return err
+	}
+
+	// the indexes are in the descending order, so removing one doesn't move the others.
+	list := l.Items[key]
+	for _, index := range indexes {
+		list.Remove(index)
+	}
+
+	return nil
+}
+
+// lRemIndexes returns the indexes of the elements LRem removes, in the descending order.
+func (l *List) lRemIndexes(key string, count int, cmp func(r *Record) (bool, error)) ([]int, error) {
 	if l.IsExpire(key) {
-		return ErrListNotFound
+		return nil, ErrListNotFound
 	}
 
 	list, ok := l.Items[key]
 
 	if !ok {
-		return ErrListNotFound
+		return nil, ErrListNotFound
 	}
 
+	var indexes []int
 	iterator := list.Iterator()
 
 	if count >= 0 {
@@ -173,38 +288,53 @@ func (l *List) LRem(key string, count int, cmp func(r *Record) (bool, error)) er
 		}
 		iterator.Begin()
 
-		for iterator.Next() && count > 0 {
-			r := iterator.Value().(*Record)
-
-			ok, err := cmp(r)
+		for iterator.Next() && len(indexes) < count {
+			ok, err := cmp(iterator.Value().(*Record))
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if ok {
-				list.Remove(iterator.Index())
-				count--
+				indexes = append(indexes, iterator.Index())
 			}
 		}
-	}
 
-	if count < 0 {
-		iterator.End()
+		for i, j := 0, len(indexes)-1; i < j; i, j = i+1, j-1 {
+			indexes[i], indexes[j] = indexes[j], indexes[i]
+		}
+		return indexes, nil
+	}
 
-		for iterator.Prev() && count < 0 {
-			r := iterator.Value().(*Record)
+	iterator.End()
 
-			ok, err := cmp(r)
-			if err != nil {
-				return err
-			}
-			if ok {
-				list.Remove(iterator.Index())
-				count++
-			}
+	for iterator.Prev() && len(indexes) < -count {
+		ok, err := cmp(iterator.Value().(*Record))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			indexes = append(indexes, iterator.Index())
 		}
 	}
 
-	return nil
+	return indexes, nil
+}
+
+// LGet returns the element at index, a negative index counts from the tail.
+func (l *List) LGet(key string, index int) (*Record, error) {
+	size, err := l.Size(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < 0 {
+		index += size
+	}
+	if index < 0 || index >= size {
+		return nil, ErrIndexOutOfRange
+	}
+
+	r, _ := l.Items[key].Get(index)
+	return r.(*Record), nil
 }
 
 // LSet sets the list element at index to value.
@@ -221,11 +351,58 @@ func (l *List) LSet(key string, index int, r *Record) error {
 		return ErrIndexOutOfRange
 	}
 
+	// the element set keeps the sequence number of the one it replaces.
+	if old, ok := l.Items[key].Get(index); ok {
+		r.seq = old.(*Record).seq
+	}
 	l.Items[key].Set(index, r)
 
 	return nil
 }
 
+// LInsert inserts the element at index, an index equal to the size of the list appends the element.
+func (l *List) LInsert(key string, index int, r *Record) error {
+	if l.IsExpire(key) {
+		return ErrListNotFound
+	}
+	if _, ok := l.Items[key]; !ok {
+		return ErrListNotFound
+	}
+
+	size, _ := l.Size(key)
+	if index > size || index < 0 {
+		return ErrIndexOutOfRange
+	}
+
+	l.Items[key].Insert(index, r)
+
+	return nil
+}
+
+// indexOf returns the index of the first element matching cmp, -1 if there is none.
+func (l *List) indexOf(key string, cmp func(r *Record) (bool, error)) (int, error) {
+	if l.IsExpire(key) {
+		return -1, ErrListNotFound
+	}
+	list, ok := l.Items[key]
+	if !ok {
+		return -1, ErrListNotFound
+	}
+
+	iterator := list.Iterator()
+	for iterator.Next() {
+		ok, err := cmp(iterator.Value().(*Record))
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return iterator.Index(), nil
+		}
+	}
+
+	return -1, nil
+}
+
 // LTrim trim an existing list so that it will contain only the specified range of elements specified.
 func (l *List) LTrim(key string, start, end int) error {
 	if l.IsExpire(key) {
@@ -235,13 +412,19 @@ func (l *List) LTrim(key string, start, end int) error {
 		return ErrListNotFound
 	}
 
+	list := l.Items[key]
+
+	// a start beyond the tail empties the list.
+	if start >= list.Size() {
+		list.Clear()
+		return nil
+	}
+
 	items, err := l.LRange(key, start, end)
 	if err != nil {
 		return err
 	}
 
-	list := l.Items[key]
-
 	list.Clear()
 	for _, item := range items {
 		list.Append(item)
@@ -250,20 +433,18 @@ func (l *List) LTrim(key string, start, end int) error {
 	return nil
 }
 
-// LRemByIndex remove the list element at specified index
+// LRemByIndex removes the list elements at the indexes, the indexes out of the list are ignored.
 func (l *List) LRemByIndex(key string, indexes []int) error {
 	if l.IsExpire(key) {
 		return ErrListNotFound
 	}
 
-	list := l.Items[key]
-
-	if list.Size() == 0 {
-		return nil
+	list, ok := l.Items[key]
+	if !ok {
+		return ErrListNotFound
 	}
 
-	if len(indexes) == 1 {
-		list.Remove(indexes[0])
+	if list.Size() == 0 {
 		return nil
 	}
 
@@ -300,22 +481,62 @@ func (l *List) IsExpire(key string) bool {
 		return false
 	}
 
-	_, ok := l.TTL[key]
-	if !ok {
+	if !l.expiredAt(key, unixSeconds(l.clock)) {
 		return false
 	}
 
-	now := time.Now().Unix()
-	timestamp := l.TimeStamp[key]
-	if l.TTL[key] > 0 && uint64(l.TTL[key])+timestamp > uint64(now) || l.TTL[key] == uint32(0) {
+	l.clear(key)
+
+	return true
+}
+
+// Expire sets the ttl of the list stored at key from the timestamp, the list expired before the timestamp is cleared first.
+func (l *List) Expire(key string, ttl uint32, timestamp uint64) {
+	if l.expiredAt(key, timestamp) {
+		l.clear(key)
+	}
+
+	l.TTL[key] = ttl
+	l.TimeStamp[key] = timestamp
+}
+
+// SetCap sets the maximum length of the list stored at key from the timestamp, 0 removes it.
+// The list expired before the timestamp is cleared first, the list longer than the cap is trimmed from the head.
+func (l *List) SetCap(key string, maxLen int, timestamp uint64) {
+	if l.expiredAt(key, timestamp) {
+		l.clear(key)
+	}
+
+	if maxLen == 0 {
+		delete(l.Cap, key)
+		return
+	}
+	l.Cap[key] = maxLen
+
+	if list, ok := l.Items[key]; ok {
+		for list.Size() > maxLen {
+			list.Remove(0)
+		}
+	}
+}
+
+// expiredAt returns whether the ttl of the list stored at key has elapsed at the unix time now.
+func (l *List) expiredAt(key string, now uint64) bool {
+	ttl, ok := l.TTL[key]
+	if !ok || ttl == Persistent {
 		return false
 	}
 
+	return uint64(ttl)+l.TimeStamp[key] <= now
+}
+
+// clear removes the elements, the ttl and the cap of the list stored at key.
+func (l *List) clear(key string) {
 	delete(l.Items, key)
 	delete(l.TTL, key)
 	delete(l.TimeStamp, key)
-
-	return true
+	delete(l.Cap, key)
+	delete(l.seqs, key)
 }
 
 func (l *List) Size(key string) (int, error) {
@@ -348,13 +569,35 @@ func (l *List) GetListTTL(key string) (uint32, error) {
 		return 0, nil
 	}
 
-	now := time.Now().Unix()
-	remain := timestamp + uint64(ttl) - uint64(now)
+	remain := timestamp + uint64(ttl) - unixSeconds(l.clock)
 
 	return uint32(remain), nil
 
 }
 
+// normalizeRange turns start and end into the indexes of a list of size like Redis does, the negative
+// offsets count from the tail and the offsets out of the list are clamped. It returns false if the range is empty.
+func normalizeRange(start, end, size int) (int, int, bool) {
+	if start < 0 {
+		start += size
+		if start < 0 {
+			start = 0
+		}
+	}
+	if end < 0 {
+		end += size
+	}
+	if end >= size {
+		end = size - 1
+	}
+
+	if start > end {
+		return 0, 0, false
+	}
+
+	return start, end, true
+}
+
 func checkBounds(start, end int, size int) (int, int, error) {
 	if start >= 0 && end < 0 {
 		end = size + end
@@ -109,6 +109,47 @@ func TestList_Pop(t *testing.T) {
 	ListCmp(t, list, key, expectRecords, false)
 }
 
+func TestList_PopSeq(t *testing.T) {
+	list := NewList()
+	records := generateRecords(5)
+	key := string(GetTestBytes(0))
+
+	// r2 r1 r0 r3 r4 with the sequence numbers -2 -1 1 2 3.
+	seq := &listSeq{}
+	for i, r := range records {
+		isLeft := i == 1 || i == 2
+		r.seq = seq.next(isLeft)
+		ListPush(t, list, key, r, isLeft, nil)
+	}
+	require.Equal(t, listSeq{head: -2, tail: 3}, *list.seqs[key])
+	ListCmp(t, list, key, []*Record{records[2], records[1], records[0], records[3], records[4]}, false)
+
+	// the element is removed by its sequence number, even if it's not at the end.
+	r, err := list.popSeq(key, records[0].seq, true)
+	require.NoError(t, err)
+	require.Equal(t, records[0], r)
+	ListCmp(t, list, key, []*Record{records[2], records[1], records[3], records[4]}, false)
+
+	// replaying the pop again removes nothing.
+	r, err = list.popSeq(key, records[0].seq, true)
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	// a seq of 0 pops the end.
+	r, err = list.popSeq(key, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, records[4], r)
+
+	// the element set keeps the sequence number, and the range only widens.
+	newRecord := generateRecords(1)[0]
+	require.NoError(t, list.LSet(key, 0, newRecord))
+	require.Equal(t, int64(-2), newRecord.seq)
+	require.Equal(t, listSeq{head: -2, tail: 3}, *list.seqs[key])
+
+	list.clear(key)
+	require.Nil(t, list.seqs[key])
+}
+
 func TestList_LRem(t *testing.T) {
 	list := NewList()
 	records := generateRecords(2)
@@ -0,0 +1,138 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// listNotifier wakes up the BLPop waiters of a list when a commit pushes to it.
+type listNotifier struct {
+	mu    sync.Mutex
+	lists map[string]*listWaiters
+	// closed is closed with the db, so that the waiters give up.
+	closed chan struct{}
+}
+
+// listWaiters is the channel closed on the next push to a list, and how many waiters are waiting on it.
+type listWaiters struct {
+	ch      chan struct{}
+	waiters int
+}
+
+func newListNotifier() *listNotifier {
+	return &listNotifier{
+		lists:  make(map[string]*listWaiters),
+		closed: make(chan struct{}),
+	}
+}
+
+// wait registers a waiter of the list, it returns the channel closed on the next push
+// and the func to deregister the waiter.
+func (n *listNotifier) wait(bucket string, key []byte) (<-chan struct{}, func()) {
+	k := string(getNewKey(bucket, key))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lw, ok := n.lists[k]
+	if !ok {
+		lw = &listWaiters{ch: make(chan struct{})}
+		n.lists[k] = lw
+	}
+	lw.waiters++
+
+	return lw.ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		lw.waiters--
+		if lw.waiters == 0 && n.lists[k] == lw {
+			delete(n.lists, k)
+		}
+	}
+}
+
+// notify wakes up the waiters of the list.
+func (n *listNotifier) notify(bucket string, key []byte) {
+	k := string(getNewKey(bucket, key))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if lw, ok := n.lists[k]; ok {
+		close(lw.ch)
+		lw.ch = make(chan struct{})
+	}
+}
+
+// close wakes up all the waiters for good.
+func (n *listNotifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	close(n.closed)
+}
+
+// BLPop removes and returns the first element of the list stored in the bucket at given bucket and key.
+// If the list is empty or missing, it waits until an element is pushed, the timeout elapses or ctx is done,
+// the timeout 0 means waiting until ctx is done. Each element is popped in its own Update, so the concurrent
+// waiters get distinct elements. It returns ctx.Err() or context.DeadlineExceeded if nothing is popped in time.
+func (db *DB) BLPop(ctx context.Context, bucket string, key []byte, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for {
+		// the waiter is registered before popping, so a push committed in between isn't missed.
+		pushed, done := db.listNotifier.wait(bucket, key)
+
+		item, err := db.tryLPop(bucket, key)
+		if err != nil || item != nil {
+			done()
+			return item, err
+		}
+
+		select {
+		case <-pushed:
+			done()
+		case <-db.listNotifier.closed:
+			done()
+			return nil, ErrDBClosed
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryLPop pops the first element of the list, it returns nil without an error if the list is empty or missing.
+func (db *DB) tryLPop(bucket string, key []byte) (item []byte, err error) {
+	err = db.Update(func(tx *Tx) error {
+		item, err = tx.LPop(bucket, key)
+		if errors.Is(err, ErrListEmpty) || errors.Is(err, ErrListNotFound) {
+			item = nil
+			return nil
+		}
+		return err
+	})
+
+	return item, err
+}
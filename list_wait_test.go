@@ -0,0 +1,162 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BLPop(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	t.Run("pop concurrently pushed values exactly once", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			const producers, consumers, perProducer = 4, 4, 50
+
+			var (
+				mu       sync.Mutex
+				consumed = make(map[string]int)
+				wg       sync.WaitGroup
+			)
+
+			for c := 0; c < consumers; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						item, err := db.BLPop(context.Background(), bucket, key, 500*time.Millisecond)
+						if err == context.DeadlineExceeded {
+							return
+						}
+						if !assert.NoError(t, err) {
+							return
+						}
+						mu.Lock()
+						consumed[string(item)]++
+						mu.Unlock()
+					}
+				}()
+			}
+
+			var pwg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				pwg.Add(1)
+				go func(p int) {
+					defer pwg.Done()
+					for i := 0; i < perProducer; i++ {
+						value := []byte(fmt.Sprintf("%d-%d", p, i))
+						assert.NoError(t, db.Update(func(tx *Tx) error {
+							return tx.RPush(bucket, key, value)
+						}))
+					}
+				}(p)
+			}
+
+			pwg.Wait()
+			wg.Wait()
+
+			require.Len(t, consumed, producers*perProducer)
+			for value, n := range consumed {
+				require.Equal(t, 1, n, value)
+			}
+			require.Empty(t, db.listNotifier.lists)
+		})
+	})
+
+	t.Run("pop the existing value without waiting", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.RPush(bucket, key, []byte("a"), []byte("b"))
+			}))
+
+			item, err := db.BLPop(context.Background(), bucket, key, 0)
+			require.NoError(t, err)
+			require.Equal(t, []byte("a"), item)
+		})
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			_, err := db.BLPop(context.Background(), bucket, key, 20*time.Millisecond)
+			require.Equal(t, context.DeadlineExceeded, err)
+			require.Empty(t, db.listNotifier.lists)
+		})
+	})
+
+	t.Run("cancel deregisters the waiter", func(t *testing.T) {
+		withDefaultDB(t, func(t *testing.T, db *DB) {
+			ctx, cancel := context.WithCancel(context.Background())
+			errCh := make(chan error)
+			go func() {
+				_, err := db.BLPop(ctx, bucket, key, 0)
+				errCh <- err
+			}()
+
+			require.Eventually(t, func() bool {
+				db.listNotifier.mu.Lock()
+				defer db.listNotifier.mu.Unlock()
+				return len(db.listNotifier.lists) == 1
+			}, time.Second, time.Millisecond)
+
+			cancel()
+			require.Equal(t, context.Canceled, <-errCh)
+			require.Empty(t, db.listNotifier.lists)
+
+			// the value pushed after the cancellation is left in the list.
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.RPush(bucket, key, []byte("a"))
+			}))
+			require.NoError(t, db.View(func(tx *Tx) error {
+				size, err := tx.LSize(bucket, key)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, size)
+				return nil
+			}))
+		})
+	})
+
+	t.Run("close wakes up the waiters", func(t *testing.T) {
+		opts := DefaultOptions
+		opts.Dir = "/tmp/test-nutsdb-blpop-close"
+		require.NoError(t, os.RemoveAll(opts.Dir))
+		db, err := Open(opts)
+		require.NoError(t, err)
+		defer os.RemoveAll(opts.Dir)
+
+		errCh := make(chan error)
+		go func() {
+			_, err := db.BLPop(context.Background(), bucket, key, 0)
+			errCh <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			db.listNotifier.mu.Lock()
+			defer db.listNotifier.mu.Unlock()
+			return len(db.listNotifier.lists) == 1
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, db.Close())
+		require.Equal(t, ErrDBClosed, <-errCh)
+	})
+}
@@ -15,22 +15,76 @@
 package nutsdb
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"strings"
+	"strconv"
+	"sync/atomic"
 	"time"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/xujiajun/utils/strconv2"
 )
 
 var (
 	ErrDontNeedMerge = errors.New("the number of files waiting to be merged is at least 2")
+
+	// ErrMergeTransformKey is returned when MergeOptions.Transform replaces an entry with another key.
+	ErrMergeTransformKey = errors.New("merge transform can not change the bucket or key of the entry")
+)
+
+// MergeAction represents what merge does with an entry, see MergeOptions.Transform.
+type MergeAction int
+
+const (
+	// MergeKeep keeps the entry as it is.
+	MergeKeep MergeAction = iota
+
+	// MergeDrop removes the entry like a delete.
+	MergeDrop
+
+	// MergeReplace writes the value of the returned entry in place of the entry.
+	MergeReplace
 )
 
+// MergeOptions represents the options of DB.MergeWithOptions.
+type MergeOptions struct {
+	// Transform is called for each live entry of the BPTree buckets rewritten by merge.
+	// The replaced entry must keep the bucket and key, only its value is written, and it
+	// goes through the same checks as a Put. If the replaced entry is refused, the merge
+	// stops before the file is removed, the entries rewritten so far are kept.
+	Transform func(e *Entry) (*Entry, MergeAction)
+}
+
+// MergeResult represents how many entries are rewritten by merge, by the action.
+type MergeResult struct {
+	Kept     int
+	Dropped  int
+	Replaced int
+}
+
+type mergeDone struct {
+	result MergeResult
+	err    error
+}
+
 func (db *DB) Merge() error {
-	db.mergeStartCh <- struct{}{}
-	return <-db.mergeEndCh
+	_, err := db.MergeWithOptions(MergeOptions{})
+	return err
+}
+
+// MergeWithOptions merges the data files like Merge, calling MergeOptions.Transform on the rewritten entries.
+func (db *DB) MergeWithOptions(opts MergeOptions) (MergeResult, error) {
+	if db.isReadOnly() {
+		return MergeResult{}, ErrDBReadOnly
+	}
+
+	db.mergeStartCh <- opts
+	done := <-db.mergeEndCh
+	return done.result, done.err
 }
 
 // merge removes dirty data and reduce data redundancy,following these steps:
@@ -45,14 +99,18 @@ func (db *DB) Merge() error {
 //
 // Caveat: merge is Called means starting multiple write transactions, and it
 // will affect the other write request. so execute it at the appropriate time.
-func (db *DB) merge() error {
+func (db *DB) merge(opts MergeOptions) (result MergeResult, err error) {
 	var (
 		off              int64
 		pendingMergeFIds []int
 	)
 
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-		return ErrNotSupportHintBPTSparseIdxMode
+		return result, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if db.degraded {
+		return result, ErrDBReadOnly
 	}
 
 	// to prevent the initiation of multiple merges simultaneously.
@@ -60,7 +118,12 @@ func (db *DB) merge() error {
 
 	if db.isMerging {
 		db.mu.Unlock()
-		return ErrIsMerging
+		return result, ErrIsMerging
+	}
+
+	if atomic.LoadInt64(&db.runningBackups) > 0 {
+		db.mu.Unlock()
+		return result, ErrIsBackingUp
 	}
 
 	db.isMerging = true
@@ -71,25 +134,29 @@ func (db *DB) merge() error {
 	_, pendingMergeFIds = db.getMaxFileIDAndFileIDs()
 	if len(pendingMergeFIds) < 2 {
 		db.mu.Unlock()
-		return ErrDontNeedMerge
+		return result, ErrDontNeedMerge
 	}
 
 	dataFile, err := db.fm.getDataFile(getDataPath(db.MaxFileID+1, db.opt.Dir), db.opt.SegmentSize)
 	if err != nil {
 		db.mu.Unlock()
-		return err
+		return result, err
 	}
 	db.ActiveFile = dataFile
 	db.MaxFileID++
+	db.ActiveFile.fileID = db.MaxFileID
 
-	db.mu.Unlock()
+	// mergeCollections takes over db.mu and releases it.
+	if err := db.mergeCollections(&result); err != nil {
+		return result, err
+	}
 
 	for _, pendingMergeFId := range pendingMergeFIds {
 		off = 0
 		path := getDataPath(int64(pendingMergeFId), db.opt.Dir)
 		fr, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
 		if err != nil {
-			return err
+			return result, err
 		}
 
 		for {
@@ -98,7 +165,9 @@ func (db *DB) merge() error {
 					break
 				}
 
-				if entry.isFilter() {
+				// the lists, sets and sorted sets are rewritten as a whole by mergeCollections.
+				if entry.isFilter(db.nowMillis()) || entry.Meta.Ds == DataStructureList || entry.Meta.Ds == DataStructureSet ||
+					entry.Meta.Ds == DataStructureSortedSet {
 					off += entry.Size()
 					if off >= db.opt.SegmentSize {
 						break
@@ -111,19 +180,17 @@ func (db *DB) merge() error {
 				// while a transaction is being committed, causing modifications to the index.
 				// To address this issue, we need to use a transaction to perform this operation.
 				err := db.Update(func(tx *Tx) error {
-					// check if we have a new entry with same key and bucket
+					tx.merging = true
+
+					// the entry is merged into the bucket it's renamed to since.
+					entry.Bucket = []byte(db.currentBucket(entry.Meta.Ds, string(entry.Bucket), int64(pendingMergeFId), off))
+
+					// check that the index still points at the entry, it's not overwritten since. The tx ids
+					// can't tell, the txs committed within the same millisecond get the same id.
 					if r, _ := db.getRecordFromKey(entry.Bucket, entry.Key); r != nil {
-						if r.E.Meta.TxID <= entry.Meta.TxID {
+						if r.H.FileID == int64(pendingMergeFId) && int64(r.H.DataPos) == off {
 							if ok := db.isPendingMergeEntry(entry); ok {
-								return tx.put(
-									string(entry.Bucket),
-									entry.Key,
-									entry.Value,
-									entry.Meta.TTL,
-									entry.Meta.Flag,
-									entry.Meta.Timestamp,
-									entry.Meta.Ds,
-								)
+								return db.mergeEntry(tx, entry, opts, &result)
 							}
 						}
 					}
@@ -132,7 +199,7 @@ func (db *DB) merge() error {
 
 				if err != nil {
 					_ = fr.release()
-					return err
+					return result, err
 				}
 
 				off += entry.Size()
@@ -150,22 +217,281 @@ func (db *DB) merge() error {
 				if err == io.ErrUnexpectedEOF {
 					break
 				}
-				return fmt.Errorf("when merge operation build hintIndex readAt err: %s", err)
+				return result, fmt.Errorf("when merge operation build hintIndex readAt err: %s", err)
 			}
 		}
 
 		err = fr.release()
 		if err != nil {
-			return err
+			return result, err
 		}
 		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("when merge err: %s", err)
+			return result, fmt.Errorf("when merge err: %s", err)
+		}
+
+		db.mu.Lock()
+		db.bucketRecords.removeFile(int64(pendingMergeFId))
+		db.removeBucketRenames(int64(pendingMergeFId))
+		db.mu.Unlock()
+	}
+
+	return result, nil
+}
+
+// mergeEntry rewrites the live entry into the active file, applying MergeOptions.Transform to the BPTree entries.
+// The entry keeps its timestamp, ttl and expiration, so that the merges don't extend the life of the expiring keys.
+func (db *DB) mergeEntry(tx *Tx, entry *Entry, opts MergeOptions, result *MergeResult) error {
+	value := entry.Value
+
+	if opts.Transform != nil && entry.Meta.Ds == DataStructureBPTree {
+		replaced, action := opts.Transform(entry)
+		switch action {
+		case MergeDrop:
+			result.Dropped++
+			return tx.Delete(string(entry.Bucket), entry.Key)
+		case MergeReplace:
+			if replaced == nil || !bytes.Equal(replaced.Bucket, entry.Bucket) || !bytes.Equal(replaced.Key, entry.Key) {
+				return ErrMergeTransformKey
+			}
+			value = replaced.Value
+			result.Replaced++
+		default:
+			result.Kept++
+		}
+	} else {
+		result.Kept++
+	}
+
+	return tx.putExpireAt(
+		string(entry.Bucket),
+		entry.Key,
+		value,
+		entry.Meta.TTL,
+		entry.Meta.ExpireAt,
+		entry.Meta.Flag,
+		entry.Meta.Timestamp,
+		entry.Meta.Ds,
+	)
+}
+
+// mergeCollectionsBatch is the number of records a tx of mergeCollections queues before it's committed and the
+// rewrite goes on in a new tx. A list, set or sorted set isn't split between txs, so a larger one makes a larger tx.
+var mergeCollectionsBatch = 4096
+
+// mergeCollection is a list or set key, or a sorted set bucket, rewritten by mergeCollections.
+type mergeCollection struct {
+	ds          uint16
+	bucket, key string
+}
+
+// mergeCollections rewrites the live lists, sets and sorted sets into the new active file, so that the records
+// superseded by later pops, removals, trims and expiries are dropped with the merged files. The default ttls,
+// the read-only flags and the quotas of the buckets are rewritten along.
+// It is called with db.mu held by merge and its first tx releases the lock. The collections are rewritten in txs
+// of about mergeCollectionsBatch records, each collection with the record clearing it and its members in the
+// same tx, from the index as it is at the commit of the tx. The merged files are removed after the last tx, so
+// a crash between two txs replays the old records of a collection before its clearing record, if it has one.
+func (db *DB) mergeCollections(result *MergeResult) error {
+	tx, err := newTx(db, true)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	tx.setStatusRunning()
+	db.trackTx(tx)
+
+	tx.merging = true
+	fileID := db.ActiveFile.fileID
+
+	err = db.mergeBucketDefaultTTLs(tx)
+	if err == nil {
+		err = db.mergeBucketReadOnly(tx)
+	}
+	if err == nil {
+		err = db.mergeBucketQuotas(tx)
+	}
+	if err == nil {
+		err = db.mergeBucketScoreTypes(tx)
+	}
+
+	var collections []mergeCollection
+	_ = db.Index.handleListBucket(func(bucket string) error {
+		for key := range db.Index.getList(bucket).Items {
+			collections = append(collections, mergeCollection{ds: DataStructureList, bucket: bucket, key: key})
+		}
+		return nil
+	})
+	for bucket, set := range db.SetIdx {
+		for key := range set.M {
+			collections = append(collections, mergeCollection{ds: DataStructureSet, bucket: bucket, key: key})
+		}
+	}
+	for bucket := range db.SortedSetIdx {
+		collections = append(collections, mergeCollection{ds: DataStructureSortedSet, bucket: bucket})
+	}
+
+	for _, c := range collections {
+		if err != nil {
+			break
+		}
+
+		if len(tx.pendingWrites) >= mergeCollectionsBatch {
+			if err = tx.Commit(); err != nil {
+				return err
+			}
+			if tx, err = db.Begin(true); err != nil {
+				return err
+			}
+			tx.merging = true
+		}
+
+		// the bucket may be renamed by a tx committed since the merge started.
+		bucket := db.currentBucket(c.ds, c.bucket, fileID, -1)
+		switch c.ds {
+		case DataStructureList:
+			err = db.mergeList(tx, bucket, c.key, result)
+		case DataStructureSet:
+			err = db.mergeSet(tx, bucket, c.key, result)
+		case DataStructureSortedSet:
+			err = db.mergeSortedSet(tx, bucket, result)
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mergeList writes the live elements and the cap of the list after a record clearing the list, the elements keep
+// their sequence numbers in the ListFormatSeq.
+func (db *DB) mergeList(tx *Tx, bucket, key string, result *MergeResult) error {
+	if !db.Index.existList(bucket) {
+		return nil
+	}
+	l := db.Index.getList(bucket)
+
+	if _, ok := l.Items[key]; !ok || l.IsExpire(key) {
+		return nil
+	}
+	records, err := l.LRange(key, 0, -1)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	timestamp := db.nowSeconds()
+	if err := tx.put(bucket, []byte(key), nil, Persistent, DataListClearFlag, timestamp, DataStructureList); err != nil {
+		return err
+	}
+	if maxLen, ok := l.Cap[key]; ok {
+		if err := tx.put(bucket, []byte(key), []byte(strconv2.IntToStr(maxLen)), Persistent, DataListCapFlag, timestamp, DataStructureList); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		value, err := db.getValueByRecord(r)
+		if err != nil {
+			return err
+		}
+		if err := tx.put(bucket, tx.listRecordKey([]byte(key), r), value, Persistent, DataRPushFlag, r.meta().Timestamp, DataStructureList); err != nil {
+			return err
+		}
+		result.Kept++
+	}
+	if ttl := l.TTL[key]; ttl != Persistent {
+		ttls := []byte(strconv2.Int64ToStr(int64(ttl)))
+		if err := tx.put(bucket, []byte(key), ttls, Persistent, DataExpireListFlag, l.TimeStamp[key], DataStructureList); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// mergeSet writes the live members and the ttl of the set after a record clearing the set, one record per member.
+// A set whose members are all removed is kept as an empty set.
+func (db *DB) mergeSet(tx *Tx, bucket, key string, result *MergeResult) error {
+	set, ok := db.SetIdx[bucket]
+	if !ok {
+		return nil
+	}
+	members, ok := set.M[key]
+	if !ok {
+		return nil
+	}
+
+	now := db.nowSeconds()
+	if set.expiredAt(key, now) {
+		return nil
+	}
+
+	if err := tx.put(bucket, []byte(key), nil, Persistent, DataSetClearFlag, now, DataStructureSet); err != nil {
+		return err
+	}
+
+	var err error
+	members.each(func(hash uint64, r *Record) bool {
+		var value []byte
+		if value, err = db.getValueByRecord(r); err != nil {
+			return false
+		}
+		if err = tx.put(bucket, []byte(key), value, Persistent, DataSetFlag, r.meta().Timestamp, DataStructureSet); err != nil {
+			return false
+		}
+		result.Kept++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if ttl, ok := set.TTL[key]; ok {
+		ttls := []byte(strconv2.Int64ToStr(int64(ttl)))
+		if err := tx.put(bucket, []byte(key), ttls, Persistent, DataExpireSetFlag, set.TimeStamp[key], DataStructureSet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mergeSortedSet writes the members and the ttl of the sorted set of the bucket after a record deleting the bucket.
+// An empty or expired sorted set is left out, so its bucket is gone once the merged files are.
+func (db *DB) mergeSortedSet(tx *Tx, bucket string, result *MergeResult) error {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok || sortedSet.Size() == 0 {
+		return nil
+	}
+
+	if err := tx.put(bucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, db.nowSeconds(), DataStructureNone); err != nil {
+		return err
+	}
+
+	for _, node := range sortedSet.GetByRankRange(1, -1, false) {
+		var err error
+		if sortedSet.ScoreType() == zset.ScoreInt64 {
+			err = tx.zAdd(bucket, []byte(node.Key()), []byte(strconv.FormatInt(node.IntScore(), 10)), node.Value, DataZAddIntFlag)
+		} else {
+			err = tx.zAdd(bucket, []byte(node.Key()), []byte(strconv.FormatFloat(float64(node.Score()), 'f', -1, 64)), node.Value, DataZAddFlag)
+		}
+		if err != nil {
+			return err
+		}
+		result.Kept++
+	}
+
+	if ttl, timestamp := sortedSet.TTL(); ttl != Persistent {
+		ttls := []byte(strconv2.Int64ToStr(int64(ttl)))
+		return tx.put(bucket, []byte(" "), ttls, Persistent, DataExpireZSetFlag, timestamp, DataStructureSortedSet)
+	}
+
+	return nil
+}
+
 func (db *DB) mergeWorker() {
 	var ticker *time.Ticker
 
@@ -178,15 +504,16 @@ func (db *DB) mergeWorker() {
 
 	for {
 		select {
-		case <-db.mergeStartCh:
-			db.mergeEndCh <- db.merge()
+		case opts := <-db.mergeStartCh:
+			result, err := db.merge(opts)
+			db.mergeEndCh <- mergeDone{result: result, err: err}
 			// if automatic merging is enabled, then after a manual merge
 			// the timer needs to be reset.
 			if db.opt.MergeInterval != 0 {
 				ticker.Reset(db.opt.MergeInterval)
 			}
 		case <-ticker.C:
-			_ = db.merge()
+			_, _ = db.merge(MergeOptions{})
 		case <-db.mergeWorkCloseCh:
 			return
 		}
@@ -218,51 +545,5 @@ func (db *DB) isPendingMergeEntry(entry *Entry) bool {
 		}
 	}
 
-	if entry.Meta.Ds == DataStructureSet {
-		setIdx, exist := db.SetIdx[string(entry.Bucket)]
-		if exist {
-			isMember, err := setIdx.SIsMember(string(entry.Key), entry.Value)
-			if err != nil {
-				return false
-			}
-			if isMember {
-				return true
-			}
-		}
-	}
-
-	if entry.Meta.Ds == DataStructureSortedSet {
-		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
-		if len(keyAndScore) == 2 {
-			key := keyAndScore[0]
-			sortedSetIdx, exist := db.SortedSetIdx[string(entry.Bucket)]
-			if exist {
-				n := sortedSetIdx.GetByKey(key)
-				if n != nil {
-					return true
-				}
-			}
-		}
-	}
-
-	if entry.Meta.Ds == DataStructureList {
-		//check the key of list is expired or not
-		//if expired, it will clear the items of index
-		//so that nutsdb can clear entry of expiring list in the function isPendingMergeEntry
-		db.checkListExpired()
-
-		if listIdx := db.Index.getList(string(entry.Bucket)); listIdx != nil {
-			items, _ := listIdx.LRange(string(entry.Key), 0, -1)
-			if entry.Meta.Flag == DataRPushFlag || entry.Meta.Flag == DataLPushFlag {
-				for _, item := range items {
-					v, _ := db.getValueByRecord(item)
-					if string(entry.Value) == string(v) {
-						return true
-					}
-				}
-			}
-		}
-	}
-
 	return false
 }
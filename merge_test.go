@@ -15,8 +15,17 @@
 package nutsdb
 
 import (
+	"errors"
+	"fmt"
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/xujiajun/utils/strconv2"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -37,6 +46,23 @@ func TestDB_MergeForString(t *testing.T) {
 	})
 }
 
+func TestDB_MergeKeepsOverwrites(t *testing.T) {
+	opts := DefaultOptions
+	opts.SegmentSize = 1 * KB
+	runNutsDBTest(t, &opts, func(t *testing.T, db *DB) {
+		bucket := "bucket"
+		// the txs are committed within the same millisecond mostly, so they get the same tx id.
+		for i := 0; i < 20; i++ {
+			txPut(t, db, bucket, GetTestBytes(i), []byte("old"), Persistent, nil)
+			txPut(t, db, bucket, GetTestBytes(i), []byte("new"), Persistent, nil)
+		}
+		require.NoError(t, db.Merge())
+		for i := 0; i < 20; i++ {
+			txGet(t, db, bucket, GetTestBytes(i), []byte("new"), nil)
+		}
+	})
+}
+
 func TestDB_MergeRepeated(t *testing.T) {
 	opts := DefaultOptions
 	opts.SegmentSize = 120
@@ -136,6 +162,203 @@ func TestDB_MergeForList(t *testing.T) {
 	})
 }
 
+func TestDB_MergeListReclaimsPopped(t *testing.T) {
+	bucket := "bucket"
+	key, other := GetTestBytes(0), GetTestBytes(1)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = MB
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	dataSize := func(t *testing.T) (size int64) {
+		paths, err := filepath.Glob(filepath.Join(opts.Dir, "*"+DataSuffix))
+		require.NoError(t, err)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		const pushed, popped, batch = 100000, 90000, 10000
+		for n := 0; n < pushed; n += batch {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+batch; i++ {
+					if err := tx.RPush(bucket, key, GetTestBytes(i)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		for n := 0; n < popped; n += batch {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+batch; i++ {
+					if _, err := tx.LPop(bucket, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, other, []byte("a"), []byte("b"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.LSet(bucket, other, 0, []byte("c")); err != nil {
+				return err
+			}
+			return tx.ExpireList(bucket, other, 3600)
+		}))
+
+		before := dataSize(t)
+		require.NoError(t, db.Merge())
+		after := dataSize(t)
+		// a tenth of the pushed elements is left, the pop records are all dropped.
+		require.Less(t, after*8, before)
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			if assert.Len(t, values, pushed-popped) {
+				for i, value := range values {
+					assert.Equal(t, GetTestBytes(popped+i), value)
+				}
+			}
+
+			values, err = tx.LRange(bucket, other, 0, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("c"), []byte("b")}, values)
+			ttl, err := tx.GetListTTL(bucket, other)
+			assert.NoError(t, err)
+			assert.InDelta(t, 3600, ttl, 5)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestDB_MergeSetReclaimsChurn(t *testing.T) {
+	bucket := "bucket"
+	key, emptied, expiring, expired := GetTestBytes(0), GetTestBytes(1), GetTestBytes(2), GetTestBytes(3)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 64 * KB
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	dataSize := func(t *testing.T) (size int64) {
+		paths, err := filepath.Glob(filepath.Join(opts.Dir, "*"+DataSuffix))
+		require.NoError(t, err)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+
+	sets := func(t *testing.T, db *DB) map[string][]string {
+		members := make(map[string][]string)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.SKeys(bucket, "*", func(key string) bool {
+				values, err := tx.SMembers(bucket, []byte(key))
+				assert.NoError(t, err)
+				members[key] = []string{}
+				for _, value := range values {
+					members[key] = append(members[key], string(value))
+				}
+				sort.Strings(members[key])
+				return true
+			})
+		}))
+		return members
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, expired, []byte("a")); err != nil {
+				return err
+			}
+			if err := tx.ExpireSet(bucket, expired, 1); err != nil {
+				return err
+			}
+			if err := tx.SAdd(bucket, emptied, []byte("a")); err != nil {
+				return err
+			}
+			if err := tx.SAdd(bucket, expiring, []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+			return tx.ExpireSet(bucket, expiring, 3600)
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SRem(bucket, emptied, []byte("a"))
+		}))
+
+		const members, cycles, batch = 1000, 100000, 10000
+		for n := 0; n < cycles; n += batch {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+batch; i++ {
+					if err := tx.SAdd(bucket, key, GetTestBytes(i%members)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+batch; i++ {
+					if i%members < members/2 {
+						if err := tx.SRem(bucket, key, GetTestBytes(i%members)); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}))
+		}
+		// the last batch re-adds half of the members and leaves them.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < members/4; i++ {
+				if err := tx.SAdd(bucket, key, GetTestBytes(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		// the set with a ttl of a second has expired from the next second on.
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+		live := sets(t, db)
+		require.Len(t, live[string(key)], members/2+members/4)
+		require.Empty(t, live[string(emptied)])
+		require.Equal(t, []string{"a", "b"}, live[string(expiring)])
+		require.NotContains(t, live, string(expired))
+
+		before := dataSize(t)
+		require.NoError(t, db.Merge())
+		after := dataSize(t)
+		// the live members are about a hundredth of the records written.
+		require.Less(t, after*50, before)
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, live, sets(t, db))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			// the ttl is carried over by merge.
+			assert.Equal(t, uint32(3600), db.SetIdx[bucket].TTL[string(expiring)])
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
 func TestDB_MergeAutomatic(t *testing.T) {
 	opts := DefaultOptions
 	opts.SegmentSize = 1024
@@ -243,3 +466,390 @@ func TestDB_MergeInHintBPTSparseIdxMode(t *testing.T) {
 		require.Equal(t, ErrNotSupportHintBPTSparseIdxMode, err)
 	})
 }
+
+func TestDB_MergeWithOptions(t *testing.T) {
+	bucket := "bucket"
+	n := 100000
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 1 * MB
+	opts.SyncEnable = false
+	defer removeDir(opts.Dir)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key_%06d", i)) }
+	value := func(version, i int) []byte { return []byte(fmt.Sprintf("v%d:%06d", version, i)) }
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	for i := 0; i < n; i += 1000 {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for j := i; j < i+1000; j++ {
+				if err := tx.Put(bucket, key(j), value(1, j), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	// migrate the values to v2 and drop the deprecated keys.
+	result, err := db.MergeWithOptions(MergeOptions{
+		Transform: func(e *Entry) (*Entry, MergeAction) {
+			var i int
+			_, err := fmt.Sscanf(string(e.Value), "v1:%d", &i)
+			require.NoError(t, err)
+			if i%10 == 0 {
+				return nil, MergeDrop
+			}
+			return NewEntry().WithBucket(e.Bucket).WithKey(e.Key).WithValue(value(2, i)), MergeReplace
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, MergeResult{Dropped: n / 10, Replaced: n - n/10}, result)
+
+	assertMigrated := func(t *testing.T, db *DB) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < n; i++ {
+				e, err := tx.Get(bucket, key(i))
+				if i%10 == 0 {
+					assert.Error(t, err)
+					continue
+				}
+				if !assert.NoError(t, err) || !assert.Equal(t, value(2, i), e.Value) {
+					return nil
+				}
+			}
+			return nil
+		}))
+	}
+	assertMigrated(t, db)
+
+	// restart and merge again without transform.
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	assertMigrated(t, db)
+
+	txPut(t, db, "filler", []byte("key"), make([]byte, 512*KB), Persistent, nil)
+	txPut(t, db, "filler", []byte("key"), make([]byte, 512*KB), Persistent, nil)
+	result, err = db.MergeWithOptions(MergeOptions{})
+	require.NoError(t, err)
+	require.Equal(t, n-n/10+1, result.Kept)
+	assertMigrated(t, db)
+}
+
+func TestDB_MergeTransformKey(t *testing.T) {
+	opts := DefaultOptions
+	opts.SegmentSize = 120
+	runNutsDBTest(t, &opts, func(t *testing.T, db *DB) {
+		bucket := "bucket"
+		for i := 0; i < 4; i++ {
+			txPut(t, db, bucket, GetTestBytes(i), []byte("v1"), Persistent, nil)
+		}
+
+		_, err := db.MergeWithOptions(MergeOptions{
+			Transform: func(e *Entry) (*Entry, MergeAction) {
+				return NewEntry().WithBucket(e.Bucket).WithKey([]byte("other")).WithValue([]byte("v2")), MergeReplace
+			},
+		})
+		require.True(t, errors.Is(err, ErrMergeTransformKey))
+
+		// the merge is aborted, the entries are kept.
+		for i := 0; i < 4; i++ {
+			txGet(t, db, bucket, GetTestBytes(i), []byte("v1"), nil)
+		}
+		txGet(t, db, bucket, []byte("other"), nil, ErrKeyNotFound)
+	})
+}
+
+func TestDB_MergeDropsExpiredZSet(t *testing.T) {
+	expiring, kept := "expiring", "kept"
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 64 * KB
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		// the ttl counts from the second of the timestamp, start right after it so the expiry time is exact.
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 2000; i++ {
+				if err := tx.ZAdd(expiring, GetTestBytes(i), float64(i), GetRandomBytes(100)); err != nil {
+					return err
+				}
+			}
+			if err := tx.ZAdd(kept, []byte("member"), 1, nil); err != nil {
+				return err
+			}
+			if err := tx.ExpireZSet(kept, 100); err != nil {
+				return err
+			}
+			return tx.ExpireZSet(expiring, 1)
+		}))
+
+		time.Sleep(1100 * time.Millisecond)
+		require.NoError(t, db.Merge())
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, db.Close())
+		}()
+
+		// nothing of the expired sorted set is left to replay, the kept one keeps its ttl.
+		_, ok := db.SortedSetIdx[expiring]
+		require.False(t, ok)
+		ttl, _ := db.SortedSetIdx[kept].TTL()
+		require.Equal(t, uint32(100), ttl)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			score, err := tx.ZScore(kept, []byte("member"))
+			assert.Equal(t, float64(1), score)
+			return err
+		}))
+	})
+}
+
+func TestDB_MergeZSetReclaimsRemovedRange(t *testing.T) {
+	bucket, intBucket := "leaderboard", "timeline"
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 64 * KB
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	dataSize := func(t *testing.T) (size int64) {
+		paths, err := filepath.Glob(filepath.Join(opts.Dir, "*"+DataSuffix))
+		require.NoError(t, err)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+
+	members := func(t *testing.T, db *DB, bucket string) (members []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			for _, node := range nodes {
+				members = append(members, fmt.Sprintf("%s:%v:%d:%s", node.Key(), node.Score(), node.IntScore(), node.Value))
+			}
+			return err
+		}))
+		return members
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		const count, batch = 20000, 5000
+		for n := 0; n < count; n += batch {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+batch; i++ {
+					if err := tx.ZAdd(bucket, GetTestBytes(i), float64(i), GetRandomBytes(100)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		require.NoError(t, db.SetZSetScoreType(intBucket, zset.ScoreInt64))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := int64(0); i < 10; i++ {
+				if err := tx.ZAddInt(intBucket, GetTestBytes(int(i)), 1<<60+i, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			removed, err := tx.ZRemRangeByScore(bucket, math.Inf(-1), count-200, &zset.GetByScoreRangeOptions{ExcludeEnd: true})
+			assert.Equal(t, count-200, removed)
+			return err
+		}))
+
+		live, liveInt := members(t, db, bucket), members(t, db, intBucket)
+		require.Len(t, live, 200)
+		require.Len(t, liveInt, 10)
+
+		before := dataSize(t)
+		require.NoError(t, db.Merge())
+		after := dataSize(t)
+		// the live members are a hundredth of the records written.
+		require.Less(t, after*20, before)
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, live, members(t, db, bucket))
+		require.Equal(t, liveInt, members(t, db, intBucket))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			// the int64 scores keep their type.
+			_, err := tx.ZScoreInt(intBucket, GetTestBytes(0))
+			return err
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestDB_MergeKeepsRemainingTTL(t *testing.T) {
+	bucket := "bucket"
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+
+	timestamp := uint64(clock.Now().Unix())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("ttl"), []byte("value"), 10); err != nil {
+			return err
+		}
+		if err := tx.PutWithTTL(bucket, []byte("millis"), []byte("value"), 10*time.Second); err != nil {
+			return err
+		}
+		if err := tx.Put(bucket, []byte("persistent"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("expired"), []byte("value"), 1)
+	}))
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "filler", GetTestBytes(i), GetRandomBytes(64), Persistent, nil)
+	}
+
+	// the entries rewritten by merge keep the time they're written at, the expired one is dropped.
+	clock.Add(5 * time.Second)
+	require.NoError(t, db.Merge())
+	reopen()
+	txGet(t, db, bucket, []byte("expired"), nil, ErrKeyNotFound)
+
+	var metas []*MetaData
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for _, key := range []string{"ttl", "millis", "persistent"} {
+			r, err := db.BPTreeIdx[bucket].Find([]byte(key))
+			if err != nil {
+				return err
+			}
+			metas = append(metas, r.H.Meta)
+		}
+		return nil
+	}))
+	for _, meta := range metas {
+		require.Equal(t, timestamp, meta.Timestamp)
+	}
+	require.Equal(t, uint32(Persistent), metas[2].TTL)
+
+	clock.Add(4 * time.Second)
+	txGet(t, db, bucket, []byte("ttl"), []byte("value"), nil)
+	txGet(t, db, bucket, []byte("millis"), []byte("value"), nil)
+
+	clock.Add(2 * time.Second)
+	txGet(t, db, bucket, []byte("ttl"), nil, ErrNotFoundKey)
+	txGet(t, db, bucket, []byte("millis"), nil, ErrNotFoundKey)
+	txGet(t, db, bucket, []byte("persistent"), []byte("value"), nil)
+}
+
+func TestDB_MergeCollectionsInBatches(t *testing.T) {
+	defer func(batch int) { mergeCollectionsBatch = batch }(mergeCollectionsBatch)
+	mergeCollectionsBatch = 16
+
+	const keys, members = 20, 5
+
+	contents := func(t *testing.T, db *DB) map[string][]string {
+		contents := make(map[string][]string)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < keys; i++ {
+				key := GetTestBytes(i)
+				items, err := tx.LRange("list", key, 0, -1)
+				if err != nil {
+					return err
+				}
+				for _, item := range items {
+					contents["list/"+string(key)] = append(contents["list/"+string(key)], string(item))
+				}
+
+				values, err := tx.SMembers("set", key)
+				if err != nil {
+					return err
+				}
+				for _, value := range values {
+					contents["set/"+string(key)] = append(contents["set/"+string(key)], string(value))
+				}
+				sort.Strings(contents["set/"+string(key)])
+			}
+
+			nodes, err := tx.ZRangeByRank("zset", 1, -1)
+			if err != nil {
+				return err
+			}
+			for _, node := range nodes {
+				contents["zset"] = append(contents["zset"], node.Key())
+			}
+			return nil
+		}))
+		return contents
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		opts := DefaultOptions
+		opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opts.SegmentSize = 64 * KB
+		opts.EntryIdxMode = mode
+
+		withDBOption(t, opts, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := 0; i < keys; i++ {
+					for j := 0; j < members; j++ {
+						value := GetTestBytes(i*members + j)
+						if err := tx.RPush("list", GetTestBytes(i), value); err != nil {
+							return err
+						}
+						if err := tx.SAdd("set", GetTestBytes(i), value); err != nil {
+							return err
+						}
+						if err := tx.ZAdd("zset", value, float64(j), nil); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}))
+			// a second data file for the merge.
+			for i := 0; i < 2000; i++ {
+				txPut(t, db, "kv", GetTestBytes(i), GetRandomBytes(64), Persistent, nil)
+			}
+
+			expected := contents(t, db)
+			result, err := db.MergeWithOptions(MergeOptions{})
+			require.NoError(t, err)
+			require.Equal(t, 2*keys*members+keys*members+2000, result.Kept)
+			require.Equal(t, expected, contents(t, db))
+
+			// the collections are only rebuilt from the data files with their values.
+			if mode != HintKeyValAndRAMIdxMode {
+				return
+			}
+			require.NoError(t, db.Close())
+			db, err = Open(opts)
+			require.NoError(t, err)
+			defer db.Close()
+			require.Equal(t, expected, contents(t, db))
+		})
+	}
+}
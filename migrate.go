@@ -0,0 +1,169 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// Migrate rewrites the db in srcDir, written with srcOpts, into the dir of dstOpts under the options of dstOpts,
+// e.g. another EntryIdxMode or SegmentSize. The db in srcDir is locked and read-only meanwhile, it must not be
+// open. The dir of dstOpts must be missing or empty, otherwise ErrMigrateDirNotEmpty is returned. The lists, sets
+// and sorted sets are only supported in HintKeyValAndRAMIdxMode, a db holding some is migrated to it only.
+//
+// The live records are copied like CopyTo does. The data files are read whatever mode the db is written in, so
+// a db of HintBPTSparseIdxMode is migrated too. Once written, the destination is read again and the records
+// are counted by data structure, a count not matching the source is returned as ErrMigrateCountMismatch. The
+// destination is removed when the migration fails.
+func Migrate(srcDir string, srcOpts, dstOpts Options) (err error) {
+	srcOpts.Dir = srcDir
+	if filepath.Clean(srcDir) == filepath.Clean(dstOpts.Dir) {
+		return ErrCopyToSelf
+	}
+	if err := checkDirEntryIdxMode(srcDir, srcOpts.EntryIdxMode); err != nil {
+		return err
+	}
+	empty, err := isEmptyDir(dstOpts.Dir)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrMigrateDirNotEmpty
+	}
+
+	lock := flock.New(filepath.Join(srcDir, FLockName))
+	if ok, err := lock.TryLock(); err != nil {
+		return err
+	} else if !ok {
+		return ErrDirLocked
+	}
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	src, err := openDataDir(srcOpts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if dstOpts.EntryIdxMode != HintKeyValAndRAMIdxMode && src.hasCollections() {
+		return fmt.Errorf("%w: the lists, sets and sorted sets are only supported in HintKeyValAndRAMIdxMode",
+			ErrEntryIdxModeOpt)
+	}
+
+	dst, err := Open(dstOpts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.RemoveAll(dstOpts.Dir)
+		}
+	}()
+
+	c, err := src.copyTo(dst)
+	if err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return checkMigratedCounts(dstOpts, c)
+}
+
+// checkMigratedCounts reads the records written into the dir of opts as they are at the time of the copy, so that
+// the records expiring since are counted too, and compares their counts by data structure to the ones copied.
+func checkMigratedCounts(opts Options, c *dbCopier) error {
+	opts.Clock = fixedClock(time.Unix(0, int64(c.now)*int64(time.Millisecond)))
+	db, err := openDataDir(opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	counts := make(map[string]int)
+	e := &recordExporter{emit: func(r *JSONRecord) error {
+		if expireAt := recordExpireAt(r); expireAt == 0 || expireAt > c.now {
+			counts[r.Ds]++
+		}
+		return nil
+	}}
+	if err := db.View(e.export); err != nil {
+		return err
+	}
+
+	for _, ds := range []string{jsonBPTree, jsonSet, jsonList, jsonSortedSet} {
+		if counts[ds] != c.counts[ds] {
+			return fmt.Errorf("%w: %d %s records copied, %d read back", ErrMigrateCountMismatch, c.counts[ds], ds, counts[ds])
+		}
+	}
+
+	return nil
+}
+
+// hasCollections returns if the db holds a list, a set or a sorted set.
+func (db *DB) hasCollections() bool {
+	for _, set := range db.SetIdx {
+		if len(set.M) > 0 {
+			return true
+		}
+	}
+	for _, l := range db.Index.list {
+		if len(l.Items) > 0 {
+			return true
+		}
+	}
+
+	return len(db.SortedSetIdx) > 0
+}
+
+// openDataDir opens the db in the dir of opts read-only, its indexes built in memory from the data files alone
+// in HintKeyValAndRAMIdxMode, whatever mode it's written in. The dir is neither locked nor written to.
+func openDataDir(opts Options) (*DB, error) {
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.SortedSetSnapshot = false
+	opts.PurgeExpiredOnOpen = false
+
+	db := newDB(opts)
+	db.dataFS = &dirDataFileSystem{dir: opts.Dir}
+	if err := db.buildIndexes(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// dirDataFileSystem reads the data files from a dir.
+type dirDataFileSystem struct {
+	dir string
+}
+
+func (d *dirDataFileSystem) dataFileIDs() ([]int, error) {
+	fileIDs, _, err := dataFileIDsIn(d.dir)
+	return fileIDs, err
+}
+
+func (d *dirDataFileSystem) openDataFile(fID int64) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.dir, dataFileName(int(fID))))
+}
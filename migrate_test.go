@@ -0,0 +1,162 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// migratedContents reads the items written by fillContentHashDB or putMigratedKeys key by key, the way every
+// EntryIdxMode reads, the collections only if the db holds them.
+func migratedContents(t *testing.T, db *DB) map[string]string {
+	contents := make(map[string]string)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for i := 0; i < 150; i++ {
+			e, err := tx.Get("kv", GetTestBytes(i))
+			if err == nil {
+				contents["kv/"+string(e.Key)] = fmt.Sprintf("%s ttl=%d", e.Value, e.Meta.TTL)
+			}
+		}
+		if !db.hasCollections() {
+			return nil
+		}
+
+		members, err := tx.SMembers("set", []byte("key"))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			contents["set/"+string(member)] = ""
+		}
+
+		items, err := tx.LRange("list", []byte("key"), 0, -1)
+		if err != nil {
+			return err
+		}
+		for i, item := range items {
+			contents[fmt.Sprintf("list/%d", i)] = string(item)
+		}
+
+		nodes, err := tx.ZRangeByRank("zset", 1, -1)
+		if err != nil {
+			return err
+		}
+		for i, node := range nodes {
+			contents[fmt.Sprintf("zset/%d", i)] = fmt.Sprintf("%s %s %v", node.Key(), node.Value, node.Score())
+		}
+		return nil
+	}))
+
+	return contents
+}
+
+// putMigratedKeys writes the keys of fillContentHashDB without the collections, with some garbage.
+func putMigratedKeys(t *testing.T, db *DB) {
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "kv", GetTestBytes(i), []byte("stale"), Persistent, nil)
+	}
+	for i := 0; i < 100; i++ {
+		ttl := Persistent
+		if i%5 == 0 {
+			ttl = 3600
+		}
+		txPut(t, db, "kv", GetTestBytes(i), GetTestBytes(i), ttl, nil)
+	}
+	for i := 50; i < 100; i++ {
+		txDel(t, db, "kv", GetTestBytes(i), nil)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	root, _ := ioutil.TempDir("", "nutsdb-migrate")
+	defer os.RemoveAll(root)
+	// the ttls left are the same through the migrations by the clock.
+	clock := NewFakeClock(time.Now())
+	newOpt := func(name string, mode EntryIdxMode) Options {
+		opt := DefaultOptions
+		opt.Clock = clock
+		opt.Dir = filepath.Join(root, name)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 64 * KB
+		return opt
+	}
+	// fill writes the db and returns what it holds.
+	fill := func(t *testing.T, opt Options, put func(t *testing.T, db *DB)) map[string]string {
+		db, err := Open(opt)
+		require.NoError(t, err)
+		put(t, db)
+		contents := migratedContents(t, db)
+
+		// the db must be closed.
+		require.Equal(t, ErrDirLocked, Migrate(opt.Dir, opt, newOpt("locked", HintKeyValAndRAMIdxMode)))
+		require.NoError(t, db.Close())
+		return contents
+	}
+	// requireMigrated migrates the db and reads it back.
+	requireMigrated := func(t *testing.T, srcOpt, dstOpt Options, expected map[string]string) {
+		// the db is opened in the mode it's written in only.
+		mismatched := dstOpt
+		mismatched.Dir = srcOpt.Dir
+		_, err := Open(mismatched)
+		require.True(t, errors.Is(err, ErrEntryIdxModeMismatch))
+		require.Contains(t, err.Error(), "Migrate")
+
+		require.NoError(t, Migrate(srcOpt.Dir, srcOpt, dstOpt))
+		require.Equal(t, ErrMigrateDirNotEmpty, Migrate(srcOpt.Dir, srcOpt, dstOpt))
+
+		dst, err := Open(dstOpt)
+		require.NoError(t, err)
+		defer dst.Close()
+		require.Equal(t, expected, migratedContents(t, dst))
+	}
+
+	t.Run("from sparse", func(t *testing.T) {
+		srcOpt := newOpt("sparse", HintBPTSparseIdxMode)
+		expected := fill(t, srcOpt, func(t *testing.T, db *DB) { fillContentHashDB(t, db, true) })
+		require.Len(t, expected, 50+10+3+10)
+
+		requireMigrated(t, srcOpt, newOpt("sparse-to-ram", HintKeyValAndRAMIdxMode), expected)
+	})
+
+	t.Run("to sparse", func(t *testing.T) {
+		srcOpt := newOpt("ram", HintKeyValAndRAMIdxMode)
+		expected := fill(t, srcOpt, putMigratedKeys)
+		require.Len(t, expected, 50)
+
+		sparseOpt := newOpt("ram-to-sparse", HintBPTSparseIdxMode)
+		requireMigrated(t, srcOpt, sparseOpt, expected)
+		// and back again.
+		requireMigrated(t, sparseOpt, newOpt("sparse-to-keys", HintKeyAndRAMIdxMode), expected)
+	})
+
+	t.Run("collections", func(t *testing.T) {
+		srcOpt := newOpt("collections", HintKeyValAndRAMIdxMode)
+		fill(t, srcOpt, func(t *testing.T, db *DB) { fillContentHashDB(t, db, false) })
+
+		dstOpt := newOpt("collections-to-sparse", HintBPTSparseIdxMode)
+		err := Migrate(srcOpt.Dir, srcOpt, dstOpt)
+		require.True(t, errors.Is(err, ErrEntryIdxModeOpt))
+		_, err = os.Stat(dstOpt.Dir)
+		require.True(t, os.IsNotExist(err))
+	})
+}
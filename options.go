@@ -30,6 +30,18 @@ const (
 	HintBPTSparseIdxMode
 )
 
+// ListFormat represents the format the list pushes and pops are written in.
+type ListFormat int
+
+const (
+	// ListFormatIndex represents the records replayed by their position, a push goes to the end its flag
+	// names and a pop removes the element at that end.
+	ListFormatIndex ListFormat = iota
+
+	// ListFormatSeq represents the records keyed by the sequence number of the element, see Options.ListFormat.
+	ListFormatSeq
+)
+
 // An ErrorHandler handles an error occurred during transaction.
 type ErrorHandler interface {
 	HandleError(err error)
@@ -97,6 +109,69 @@ type Options struct {
 
 	// MergeInterval represent the interval for automatic merges, with 0 meaning automatic merging is disabled.
 	MergeInterval time.Duration
+
+	// CloseTimeout represents how long Close waits for the live transactions, with 0 meaning waiting forever.
+	CloseTimeout time.Duration
+
+	// ReconcileDir represents if Open resolves the data files that do not map to the file IDs one by one,
+	// instead of failing with ErrDirectoryInconsistent. For each file ID the newest file wins,
+	// the other files are renamed with ConflictSuffix and kept for the user.
+	ReconcileDir bool
+
+	// HotKeySampleRate represents that one of every HotKeySampleRate reads and writes, picked at random, is
+	// sampled by the hot key profiler, with 0 meaning the profiler is disabled. It's rounded up to a power of two.
+	// See DB.HotKeys.
+	HotKeySampleRate uint32
+
+	// HotKeyPlaintextSize represents how many bytes of the sampled keys are retained in plaintext
+	// for debugging, with 0 meaning the keys are only reported hashed.
+	HotKeyPlaintextSize int
+
+	// CrashLoopThreshold represents how many consecutive unclean starts make Open fall back to
+	// the degraded read-only mode, with 0 meaning the crash loop detection is disabled.
+	// In the degraded mode the unreadable parts of the data files are skipped. See DB.ExitDegradedMode.
+	CrashLoopThreshold int
+
+	// PurgeExpiredOnOpen represents if Open skips the keys and lists that are expired already,
+	// instead of loading them into the indexes. See Stats.PurgedOnOpen.
+	PurgeExpiredOnOpen bool
+
+	// SortedSetSnapshot represents if Close writes the sorted sets into the SortedSetSnapshotName file, so that
+	// the next Open loads them from it and replays only the sorted set records written after it, instead of
+	// all of them. A snapshot that is corrupted or no longer matches the data files, e.g. after a merge, is
+	// ignored and all the records are replayed. See Stats.SortedSetSnapshot.
+	SortedSetSnapshot bool
+
+	// MaxScanResultBytes represents the max size of the result of GetAll, RangeScan, PrefixScan,
+	// PrefixSearchScan and LRange, counting the key, value and entry header of each item before
+	// its value is loaded, with 0 meaning no limit. A scan over the limit returns the partial
+	// result with a ResultTooLargeError to resume from.
+	MaxScanResultBytes int64
+
+	// ActiveExpireInterval represents the interval for deleting the expired keys of the BPTree buckets in
+	// the background, with 0 meaning the expired keys are only skipped by the reads until a merge. The keys
+	// deleted are dropped from the index, and a tombstone is written for each so that merge reclaims them.
+	// It's not supported in the HintBPTSparseIdxMode. See Stats.ActiveExpired.
+	ActiveExpireInterval time.Duration
+
+	// OnExpired is called with the keys of the BPTree buckets noticed expired, by Get, Delete, the scans and
+	// the iterators, or deleted by the expirer, see ActiveExpireInterval. A key is notified once for each
+	// expiration within the lifetime of the db, the deleted keys are not. The calls are made one at a time from
+	// a goroutine of the db, outside of the transactions, and a panic is recovered and passed to ErrorHandler.
+	// Close waits for the keys queued to be notified.
+	OnExpired func(bucket string, key []byte)
+
+	// Clock tells the time to the timestamps of the entries written and to the expiration of the keys, of the
+	// BPTree buckets, the lists, the sets and the sorted sets alike, including the expirer. It's meant for the
+	// tests which would otherwise sleep for the keys to expire. The wall clock is used if it's nil.
+	Clock Clock
+
+	// ListFormat represents the format the list pushes and pops are written in. In the ListFormatSeq each
+	// element pushed gets a sequence number in its record key, decreasing for LPush and increasing for RPush,
+	// and a pop names the sequence number of the element it removes. The records of both formats are read
+	// whatever the option is, so it can be changed on an existing db, but the older versions can't read
+	// the records of the ListFormatSeq.
+	ListFormat ListFormat
 }
 
 const (
@@ -204,3 +279,75 @@ func WithLessFunc(lessFunc LessFunc) Option {
 		opt.LessFunc = lessFunc
 	}
 }
+
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(opt *Options) {
+		opt.CloseTimeout = timeout
+	}
+}
+
+func WithReconcileDir(enable bool) Option {
+	return func(opt *Options) {
+		opt.ReconcileDir = enable
+	}
+}
+
+func WithHotKeySampleRate(rate uint32) Option {
+	return func(opt *Options) {
+		opt.HotKeySampleRate = rate
+	}
+}
+
+func WithHotKeyPlaintextSize(size int) Option {
+	return func(opt *Options) {
+		opt.HotKeyPlaintextSize = size
+	}
+}
+
+func WithCrashLoopThreshold(threshold int) Option {
+	return func(opt *Options) {
+		opt.CrashLoopThreshold = threshold
+	}
+}
+
+func WithPurgeExpiredOnOpen(enable bool) Option {
+	return func(opt *Options) {
+		opt.PurgeExpiredOnOpen = enable
+	}
+}
+
+func WithSortedSetSnapshot(enable bool) Option {
+	return func(opt *Options) {
+		opt.SortedSetSnapshot = enable
+	}
+}
+
+func WithMaxScanResultBytes(size int64) Option {
+	return func(opt *Options) {
+		opt.MaxScanResultBytes = size
+	}
+}
+
+func WithActiveExpireInterval(interval time.Duration) Option {
+	return func(opt *Options) {
+		opt.ActiveExpireInterval = interval
+	}
+}
+
+func WithOnExpired(onExpired func(bucket string, key []byte)) Option {
+	return func(opt *Options) {
+		opt.OnExpired = onExpired
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(opt *Options) {
+		opt.Clock = clock
+	}
+}
+
+func WithListFormat(format ListFormat) Option {
+	return func(opt *Options) {
+		opt.ListFormat = format
+	}
+}
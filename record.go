@@ -21,11 +21,47 @@ type Record struct {
 	H      *Hint
 	E      *Entry
 	Bucket string
+
+	seq int64 // the sequence number of the list element, 0 if it was written in the ListFormatIndex
 }
 
-// IsExpired returns the record if expired or not.
+// IsExpired returns the record if expired or not, by the wall clock.
 func (r *Record) IsExpired() bool {
-	return IsExpired(r.H.Meta.TTL, r.H.Meta.Timestamp)
+	return r.H.Meta.IsExpired()
+}
+
+// IsExpiredAt returns if the record has expired at the time now, see MetaData.IsExpiredAt.
+func (r *Record) IsExpiredAt(now time.Time) bool {
+	return r.H.Meta.IsExpiredAt(now)
+}
+
+// IsExpired returns if the entry has expired, at its ExpireAt if it's set or else by its TTL, by the wall clock.
+// The db tells the expiration by Options.Clock, IsExpiredAt takes the time of another clock.
+func (meta *MetaData) IsExpired() bool {
+	return meta.isExpiredAt(unixMillis(nil))
+}
+
+// IsExpiredAt returns if the entry has expired at the time now, like IsExpired.
+func (meta *MetaData) IsExpiredAt(now time.Time) bool {
+	return meta.isExpiredAt(timeMillis(now))
+}
+
+// isExpiredAt returns if the entry has expired at the unix time now in milliseconds.
+func (meta *MetaData) isExpiredAt(now uint64) bool {
+	expireAt := meta.ExpireAtMillis()
+	return expireAt != 0 && expireAt <= now
+}
+
+// ExpireAtMillis returns the unix time in milliseconds the entry expires at, 0 if it's persistent.
+func (meta *MetaData) ExpireAtMillis() uint64 {
+	if meta.ExpireAt != 0 {
+		return meta.ExpireAt
+	}
+	if meta.TTL == Persistent {
+		return 0
+	}
+
+	return (meta.Timestamp + uint64(meta.TTL)) * 1000
 }
 
 // IsExpired checks the ttl if expired or not.
@@ -38,6 +74,14 @@ func IsExpired(ttl uint32, timestamp uint64) bool {
 	return true
 }
 
+// meta returns the meta of the record, from the hint or else from the entry.
+func (r *Record) meta() *MetaData {
+	if r.H != nil {
+		return r.H.Meta
+	}
+	return r.E.Meta
+}
+
 // UpdateRecord updates the record.
 func (r *Record) UpdateRecord(h *Hint, e *Entry) error {
 	r.E = e
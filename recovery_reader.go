@@ -8,7 +8,7 @@ import (
 
 // fileRecovery use bufio.Reader to read entry
 type fileRecovery struct {
-	fd     *os.File
+	fd     io.ReadCloser
 	reader *bufio.Reader
 }
 
@@ -17,11 +17,15 @@ func newFileRecovery(path string, bufSize int) (fr *fileRecovery, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return newFileRecoveryFromReader(fd, bufSize), nil
+}
+
+func newFileRecoveryFromReader(fd io.ReadCloser, bufSize int) *fileRecovery {
 	bufSize = calBufferSize(bufSize)
 	return &fileRecovery{
 		fd:     fd,
 		reader: bufio.NewReaderSize(fd, bufSize),
-	}, nil
+	}
 }
 
 // readEntry will read an Entry from disk.
@@ -42,6 +46,14 @@ func (fr *fileRecovery) readEntry() (e *Entry, err error) {
 		return nil, nil
 	}
 
+	if e.Meta.HeaderSize() > DataEntryHeaderSize {
+		buf = append(buf, make([]byte, DataEntryExpireAtSize)...)
+		if _, err = io.ReadFull(fr.reader, buf[DataEntryHeaderSize:]); err != nil {
+			return nil, err
+		}
+		e.ParseExpireAt(buf)
+	}
+
 	meta := e.Meta
 	dataSize := meta.PayloadSize()
 	dataBuf := make([]byte, dataSize)
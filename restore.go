@@ -0,0 +1,146 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// RestoreTarGZ extracts the backup written by BackupTarGZ or BackupArchive into dir, to be opened by Open
+// afterwards. The codec of the archive is told from its first bytes.
+// The dir must be missing or empty, otherwise ErrRestoreDirNotEmpty is returned. The archive is extracted
+// aside first, so dir is left untouched when the archive is broken, holds an entry out of the backup, which is
+// refused with ErrRestoreArchive, or doesn't match its manifest, which is refused with ErrBackupCorrupted.
+func RestoreTarGZ(r io.Reader, dir string) error {
+	empty, err := isEmptyDir(dir)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrRestoreDirNotEmpty
+	}
+
+	tmp, err := extractArchive(r, dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+
+	return renameDir(tmp, dir)
+}
+
+// RestoreFrom replaces the files of the db with the backup written by BackupTarGZ or BackupArchive. The db must be closed by
+// Close first, otherwise ErrDBNotClosed is returned, and opened again by Open afterwards to read the restored
+// data. The files of the db are only replaced once the whole archive is extracted, see RestoreTarGZ.
+func (db *DB) RestoreFrom(r io.Reader) error {
+	if db.dataFS != nil {
+		return ErrDBReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.closed {
+		return ErrDBNotClosed
+	}
+
+	dir := filepath.Clean(db.opt.Dir)
+	tmp, err := extractArchive(r, dir)
+	if err != nil {
+		return err
+	}
+
+	old := dir + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := renameDir(tmp, dir); err != nil {
+		_ = os.Rename(old, dir)
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+// extractArchive extracts the archive into a new dir next to dir and returns it, it's removed on error. The
+// archive is compressed with any codec of BackupArchive.
+func extractArchive(r io.Reader, dir string) (string, error) {
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempDir(parent, filepath.Base(dir)+".restore")
+	if err != nil {
+		return "", err
+	}
+
+	ar, err := newArchiveReader(r)
+	if err == nil {
+		err = tarDecompress(tmp, ar)
+		_ = ar.Close()
+	}
+	if err == nil {
+		err = verifyRestoredBackup(tmp)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", err
+	}
+
+	return tmp, nil
+}
+
+// renameDir moves the dir src to dst and syncs their parent, src is removed if it fails.
+func renameDir(src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		_ = os.RemoveAll(src)
+		return err
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// isEmptyDir returns if dir is missing or holds no file.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(filepath.Clean(dir))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := f.Readdirnames(1); err != nil {
+		if err == io.EOF {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return false, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dbContents reads the items written by fillContentHashDB.
+func dbContents(t *testing.T, db *DB) map[string]string {
+	contents := make(map[string]string)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("kv")
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			contents["kv/"+string(e.Key)] = fmt.Sprintf("%s ttl=%d", e.Value, e.Meta.TTL)
+		}
+
+		members, err := tx.SMembers("set", []byte("key"))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			contents["set/"+string(member)] = ""
+		}
+
+		items, err := tx.LRange("list", []byte("key"), 0, -1)
+		if err != nil {
+			return err
+		}
+		for i, item := range items {
+			contents[fmt.Sprintf("list/%d", i)] = string(item)
+		}
+
+		nodes, err := tx.ZRangeByRank("zset", 1, -1)
+		if err != nil {
+			return err
+		}
+		for i, node := range nodes {
+			contents[fmt.Sprintf("zset/%d", i)] = fmt.Sprintf("%s %s %v", node.Key(), node.Value, node.Score())
+		}
+		return nil
+	}))
+
+	return contents
+}
+
+func tarGZArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestRestoreTarGZ(t *testing.T) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.SegmentSize = 8 * KB
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	fillContentHashDB(t, db, true)
+	want := dbContents(t, db)
+	require.Len(t, want, 50+10+3+10)
+	wantHash := contentHash(t, db, HashOptions{})
+
+	var backup bytes.Buffer
+	require.NoError(t, db.BackupTarGZ(&backup))
+	activeFile, committed := dataFileName(int(db.ActiveFile.fileID)), db.ActiveFile.writeOff
+	require.NoError(t, db.Close())
+
+	restoreDir, _ := ioutil.TempDir("", "nutsdb_restore")
+	defer os.RemoveAll(restoreDir)
+
+	t.Run("restore into an empty dir", func(t *testing.T) {
+		require.NoError(t, RestoreTarGZ(bytes.NewReader(backup.Bytes()), restoreDir))
+
+		// the files are restored with their names and sizes, the active file as far as it's committed.
+		files, err := ioutil.ReadDir(opt.Dir)
+		require.NoError(t, err)
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			restored, err := os.Stat(filepath.Join(restoreDir, file.Name()))
+			require.NoError(t, err)
+			if file.Name() == activeFile {
+				require.Equal(t, committed, restored.Size())
+				continue
+			}
+			require.Equal(t, file.Size(), restored.Size(), file.Name())
+		}
+
+		restoreOpt := opt
+		restoreOpt.Dir = restoreDir
+		restored, err := Open(restoreOpt)
+		require.NoError(t, err)
+		require.Equal(t, want, dbContents(t, restored))
+		require.Equal(t, wantHash, contentHash(t, restored, HashOptions{}))
+		require.NoError(t, restored.Close())
+	})
+
+	t.Run("restore into a dir not empty", func(t *testing.T) {
+		require.ErrorIs(t, RestoreTarGZ(bytes.NewReader(backup.Bytes()), restoreDir), ErrRestoreDirNotEmpty)
+	})
+
+	t.Run("archive escaping the dir", func(t *testing.T) {
+		dir := filepath.Join(restoreDir, "malicious")
+		for _, name := range []string{"backup/../../evil", "../evil", "/tmp/evil", "evil"} {
+			archive := tarGZArchive(t, map[string]string{name: "evil"})
+			require.ErrorIs(t, RestoreTarGZ(bytes.NewReader(archive), dir), ErrRestoreArchive, name)
+
+			_, err := os.Stat(dir)
+			require.True(t, os.IsNotExist(err))
+			_, err = os.Stat(filepath.Join(restoreDir, "evil"))
+			require.True(t, os.IsNotExist(err))
+		}
+
+		// nothing is left behind next to the dir.
+		files, err := ioutil.ReadDir(restoreDir)
+		require.NoError(t, err)
+		for _, file := range files {
+			require.NotContains(t, file.Name(), "malicious")
+		}
+	})
+
+	t.Run("restore over a db", func(t *testing.T) {
+		dbOpt := opt
+		dbOpt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		defer os.RemoveAll(dbOpt.Dir)
+
+		other, err := Open(dbOpt)
+		require.NoError(t, err)
+		txPut(t, other, "kv", []byte("other"), []byte("value"), Persistent, nil)
+
+		// the db must be closed first.
+		require.ErrorIs(t, other.RestoreFrom(bytes.NewReader(backup.Bytes())), ErrDBNotClosed)
+		require.NoError(t, other.Close())
+
+		// the db is left as is when the archive is refused.
+		archive := tarGZArchive(t, map[string]string{"backup/../evil": "evil"})
+		require.ErrorIs(t, other.RestoreFrom(bytes.NewReader(archive)), ErrRestoreArchive)
+		other, err = Open(dbOpt)
+		require.NoError(t, err)
+		txGet(t, other, "kv", []byte("other"), []byte("value"), nil)
+		require.NoError(t, other.Close())
+
+		require.NoError(t, other.RestoreFrom(bytes.NewReader(backup.Bytes())))
+		other, err = Open(dbOpt)
+		require.NoError(t, err)
+		require.Equal(t, want, dbContents(t, other))
+		txGet(t, other, "kv", []byte("other"), nil, ErrKeyNotFound)
+		require.NoError(t, other.Close())
+	})
+}
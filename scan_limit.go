@@ -0,0 +1,71 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrResultTooLarge is returned when the result of a scan exceeds Options.MaxScanResultBytes.
+var ErrResultTooLarge = errors.New("scan result too large")
+
+// ResultTooLargeError is returned with the partial result when a scan exceeds Options.MaxScanResultBytes,
+// the scan can be resumed from the position it reached.
+type ResultTooLargeError struct {
+	// Key is the first key not returned, it is nil for a list.
+	Key []byte
+
+	// Count is how many items are returned before the limit.
+	Count int
+}
+
+func (e *ResultTooLargeError) Error() string {
+	return fmt.Sprintf("%s: %d items returned, next key %q", ErrResultTooLarge, e.Count, e.Key)
+}
+
+// Is makes errors.Is(err, ErrResultTooLarge) true.
+func (e *ResultTooLargeError) Is(target error) bool {
+	return target == ErrResultTooLarge
+}
+
+// scanLimit accounts the bytes of a scan result against Options.MaxScanResultBytes.
+type scanLimit struct {
+	max  int64
+	size int64
+}
+
+func (tx *Tx) newScanLimit() *scanLimit {
+	return &scanLimit{max: tx.db.opt.MaxScanResultBytes}
+}
+
+// admit adds the record to the result size before its value is loaded,
+// it returns false if the record would exceed the limit.
+func (l *scanLimit) admit(r *Record) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	var meta *MetaData
+	if r.H != nil {
+		meta = r.H.Meta
+	} else {
+		meta = r.E.Meta
+	}
+
+	// the key, the value and the overhead of the entry.
+	l.size += meta.HeaderSize() + meta.PayloadSize()
+	return l.size <= l.max
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_ScanResultLimit(t *testing.T) {
+	bucket := "bucket"
+	value := make([]byte, 100)
+	opt := DefaultOptions
+	opt.SegmentSize = 8 * KB
+	// each entry is accounted as the header, the key and the value, 5 entries fit in the limit.
+	entrySize := int64(DataEntryHeaderSize + len(bucket) + len(GetTestBytes(0)) + len(value))
+	opt.MaxScanResultBytes = 5*entrySize + 1
+
+	t.Run("range scan", func(t *testing.T) {
+		runNutsDBTest(t, &opt, func(t *testing.T, db *DB) {
+			for i := 0; i < 12; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), value, Persistent, nil)
+			}
+
+			var keys [][]byte
+			start := GetTestBytes(0)
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for {
+					es, err := tx.RangeScan(bucket, start, GetTestBytes(11))
+					for _, e := range es {
+						keys = append(keys, e.Key)
+					}
+					if err == nil {
+						return nil
+					}
+
+					var tooLarge *ResultTooLargeError
+					if !assert.True(t, errors.As(err, &tooLarge)) {
+						return err
+					}
+					assert.True(t, IsResultTooLarge(err))
+					assert.Equal(t, 5, tooLarge.Count)
+					assert.Len(t, es, tooLarge.Count)
+					start = tooLarge.Key
+				}
+			}))
+
+			require.Len(t, keys, 12)
+			for i, key := range keys {
+				assert.Equal(t, GetTestBytes(i), key)
+			}
+
+			require.NoError(t, db.View(func(tx *Tx) error {
+				es, err := tx.GetAll(bucket)
+				assert.True(t, IsResultTooLarge(err))
+				assert.Len(t, es, 5)
+				return nil
+			}))
+		})
+	})
+
+	t.Run("prefix scan", func(t *testing.T) {
+		runNutsDBTest(t, &opt, func(t *testing.T, db *DB) {
+			for i := 0; i < 12; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), value, Persistent, nil)
+			}
+
+			var keys [][]byte
+			offset := 0
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for {
+					es, _, err := tx.PrefixScan(bucket, []byte("nutsdb"), offset, 100)
+					for _, e := range es {
+						keys = append(keys, e.Key)
+					}
+					if err == nil {
+						return nil
+					}
+
+					var tooLarge *ResultTooLargeError
+					if !assert.True(t, errors.As(err, &tooLarge)) {
+						return err
+					}
+					offset += tooLarge.Count
+				}
+			}))
+
+			require.Len(t, keys, 12)
+			for i, key := range keys {
+				assert.Equal(t, GetTestBytes(i), key)
+			}
+		})
+	})
+
+	t.Run("list range", func(t *testing.T) {
+		runNutsDBTest(t, &opt, func(t *testing.T, db *DB) {
+			key := GetTestBytes(0)
+			for i := 0; i < 12; i++ {
+				require.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.RPush(bucket, key, append(GetTestBytes(i), value[:73]...))
+				}))
+			}
+
+			var items [][]byte
+			start := 0
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for {
+					values, err := tx.LRange(bucket, key, start, -1)
+					items = append(items, values...)
+					if err == nil {
+						return nil
+					}
+
+					var tooLarge *ResultTooLargeError
+					if !assert.True(t, errors.As(err, &tooLarge)) {
+						return err
+					}
+					assert.Len(t, values, tooLarge.Count)
+					start += tooLarge.Count
+				}
+			}))
+
+			require.Len(t, items, 12)
+			for i, item := range items {
+				assert.Equal(t, GetTestBytes(i), item[:len(GetTestBytes(i))])
+			}
+		})
+	})
+}
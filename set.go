@@ -15,8 +15,10 @@
 package nutsdb
 
 import (
+	"bytes"
 	"errors"
-	"hash/fnv"
+	"math/rand"
+	"sort"
 )
 
 var (
@@ -28,17 +30,29 @@ var (
 
 	// ErrMemberEmpty is returned when the item received is nil
 	ErrMemberEmpty = errors.New("item empty")
-)
 
-var fnvHash = fnv.New32a()
+	// ErrSetEmpty is returned when popping from a set without members.
+	ErrSetEmpty = errors.New("the set is empty")
+
+	// ErrSetScanCursor is returned when the cursor of a set scan is not one returned by SScan.
+	ErrSetScanCursor = errors.New("invalid set scan cursor")
+)
 
 type Set struct {
-	M map[string]map[uint32]*Record
+	M map[string]*setMembers
+
+	// TTL and TimeStamp hold the ttl of the expiring sets and the time it was set.
+	TTL       map[string]uint32
+	TimeStamp map[string]uint64
+
+	clock Clock // the clock the ttls are checked by, the wall clock if it's nil
 }
 
 func NewSet() *Set {
 	return &Set{
-		M: map[string]map[uint32]*Record{},
+		M:         map[string]*setMembers{},
+		TTL:       map[string]uint32{},
+		TimeStamp: map[string]uint64{},
 	}
 }
 
@@ -46,16 +60,12 @@ func NewSet() *Set {
 func (s *Set) SAdd(key string, values [][]byte, records []*Record) error {
 	set, ok := s.M[key]
 	if !ok {
-		s.M[key] = map[uint32]*Record{}
-		set = s.M[key]
+		set = newSetMembers()
+		s.M[key] = set
 	}
 
 	for i, value := range values {
-		hash, err := getFnv32(value)
-		if err != nil {
-			return err
-		}
-		set[hash] = records[i]
+		set.add(memberHash(value), value, records[i])
 	}
 
 	return nil
@@ -73,11 +83,7 @@ func (s *Set) SRem(key string, values ...[]byte) error {
 	}
 
 	for _, value := range values {
-		hash, err := getFnv32(value)
-		if err != nil {
-			return err
-		}
-		delete(set, hash)
+		set.remove(memberHash(value), value)
 	}
 
 	return nil
@@ -85,33 +91,34 @@ func (s *Set) SRem(key string, values ...[]byte) error {
 
 // SHasKey returns whether it has the set at given key.
 func (s *Set) SHasKey(key string) bool {
-	if _, ok := s.M[key]; ok {
-		return true
-	}
-	return false
+	_, ok := s.members(key)
+	return ok
 }
 
 // SPop removes and returns one or more random elements from the set value store at key.
 func (s *Set) SPop(key string) *Record {
-	if !s.SHasKey(key) {
+	members, ok := s.members(key)
+	if !ok {
 		return nil
 	}
 
-	for hash, record := range s.M[key] {
-		delete(s.M[key], hash)
-		return record
-	}
+	var popped *Record
+	members.each(func(hash uint64, record *Record) bool {
+		popped = members.remove(hash, recordValue(record))
+		return false
+	})
 
-	return nil
+	return popped
 }
 
 // SCard Returns the set cardinality (number of elements) of the set stored at key.
 func (s *Set) SCard(key string) int {
-	if !s.SHasKey(key) {
+	members, ok := s.members(key)
+	if !ok {
 		return 0
 	}
 
-	return len(s.M[key])
+	return members.len()
 }
 
 // SDiff Returns the members of the set resulting from the difference between the first set and all the successive sets.
@@ -120,14 +127,7 @@ func (s *Set) SDiff(key1, key2 string) ([]*Record, error) {
 		return nil, ErrSetNotExist
 	}
 
-	records := make([]*Record, 0)
-
-	for hash, record := range s.M[key1] {
-		if _, ok := s.M[key2][hash]; !ok {
-			records = append(records, record)
-		}
-	}
-	return records, nil
+	return s.diffKeys(key1, key2), nil
 }
 
 // SInter Returns the members of the set resulting from the intersection of all the given sets.
@@ -136,49 +136,29 @@ func (s *Set) SInter(key1, key2 string) ([]*Record, error) {
 		return nil, ErrSetNotExist
 	}
 
-	records := make([]*Record, 0)
-
-	for hash, record := range s.M[key1] {
-		if _, ok := s.M[key2][hash]; ok {
-			records = append(records, record)
-		}
-	}
-	return records, nil
+	return s.interKeys(key1, key2), nil
 }
 
 // SIsMember Returns if member is a member of the set stored at key.
 func (s *Set) SIsMember(key string, value []byte) (bool, error) {
-	if _, ok := s.M[key]; !ok {
+	members, ok := s.members(key)
+	if !ok {
 		return false, ErrSetNotExist
 	}
 
-	hash, err := getFnv32(value)
-	if err != nil {
-		return false, err
-	}
-
-	if _, ok := s.M[key][hash]; ok {
-		return true, nil
-	}
-
-	return false, nil
+	return members.get(memberHash(value), value) != nil, nil
 }
 
 // SAreMembers Returns if members are members of the set stored at key.
 // For multiple items it returns true only if all the items exist.
 func (s *Set) SAreMembers(key string, values ...[]byte) (bool, error) {
-	if _, ok := s.M[key]; !ok {
+	members, ok := s.members(key)
+	if !ok {
 		return false, ErrSetNotExist
 	}
 
 	for _, value := range values {
-
-		hash, err := getFnv32(value)
-		if err != nil {
-			return false, err
-		}
-
-		if _, ok := s.M[key][hash]; !ok {
+		if members.get(memberHash(value), value) == nil {
 			return false, nil
 		}
 	}
@@ -188,15 +168,16 @@ func (s *Set) SAreMembers(key string, values ...[]byte) (bool, error) {
 
 // SMembers returns all the members of the set value stored at key.
 func (s *Set) SMembers(key string) ([]*Record, error) {
-	if _, ok := s.M[key]; !ok {
+	members, ok := s.members(key)
+	if !ok {
 		return nil, ErrSetNotExist
 	}
 
-	records := make([]*Record, 0)
-
-	for _, record := range s.M[key] {
+	records := make([]*Record, 0, members.len())
+	members.each(func(hash uint64, record *Record) bool {
 		records = append(records, record)
-	}
+		return true
+	})
 
 	return records, nil
 }
@@ -208,31 +189,15 @@ func (s *Set) SMove(key1, key2 string, value []byte) (bool, error) {
 	}
 
 	set1, set2 := s.M[key1], s.M[key2]
+	hash := memberHash(value)
 
-	hash, err := getFnv32(value)
-	if err != nil {
-		return false, err
-	}
-
-	var (
-		member *Record
-		ok     bool
-	)
-
-	if member, ok = set1[hash]; !ok {
+	member := set1.remove(hash, value)
+	if member == nil {
 		return false, ErrSetMemberNotExist
 	}
 
-	if _, ok = set2[hash]; !ok {
-		err = s.SAdd(key2, [][]byte{value}, []*Record{member})
-		if err != nil {
-			return false, err
-		}
-	}
-
-	err = s.SRem(key1, value)
-	if err != nil {
-		return false, err
+	if set2.get(hash, value) == nil {
+		set2.add(hash, value, member)
 	}
 
 	return true, nil
@@ -244,27 +209,309 @@ func (s *Set) SUnion(key1, key2 string) ([]*Record, error) {
 		return nil, ErrSetNotExist
 	}
 
-	records, err := s.SMembers(key1)
+	return s.unionKeys(key1, key2), nil
+}
+
+// unionKeys returns the members of the set resulting from the union of the sets at the keys,
+// a member is returned once however many sets have it, and the missing keys are skipped.
+func (s *Set) unionKeys(keys ...string) []*Record {
+	seen := newSetMembers()
+
+	for _, key := range keys {
+		members, _ := s.members(key)
+		members.each(func(hash uint64, record *Record) bool {
+			if seen.get(hash, recordValue(record)) == nil {
+				seen.add(hash, recordValue(record), record)
+			}
+			return true
+		})
+	}
+
+	records := make([]*Record, 0, seen.len())
+	seen.each(func(hash uint64, record *Record) bool {
+		records = append(records, record)
+		return true
+	})
+
+	return records
+}
 
-	if err != nil {
-		return nil, err
+// interKeys returns the members of the set resulting from the intersection of the sets at the keys.
+// It walks the smallest set and probes the others, and returns no members if any key is missing.
+func (s *Set) interKeys(keys ...string) []*Record {
+	records := make([]*Record, 0)
+	if len(keys) == 0 {
+		return records
 	}
 
-	for hash, record := range s.M[key2] {
-		if _, ok := s.M[key1][hash]; !ok {
-			records = append(records, record)
+	sets := make([]*setMembers, len(keys))
+	smallest := 0
+	for i, key := range keys {
+		members, ok := s.members(key)
+		if !ok {
+			return records
+		}
+		sets[i] = members
+		if members.len() < sets[smallest].len() {
+			smallest = i
 		}
 	}
 
-	return records, nil
+	sets[smallest].each(func(hash uint64, record *Record) bool {
+		for i, members := range sets {
+			if i != smallest && members.get(hash, recordValue(record)) == nil {
+				return true
+			}
+		}
+		records = append(records, record)
+		return true
+	})
+
+	return records
+}
+
+// diffKeys returns the members of the set at the key that are in none of the sets at the other keys,
+// the missing keys are treated as empty sets.
+func (s *Set) diffKeys(key string, others ...string) []*Record {
+	records := make([]*Record, 0)
+
+	otherSets := make([]*setMembers, len(others))
+	for i, other := range others {
+		otherSets[i], _ = s.members(other)
+	}
+
+	members, _ := s.members(key)
+	members.each(func(hash uint64, record *Record) bool {
+		for _, other := range otherSets {
+			if other.get(hash, recordValue(record)) != nil {
+				return true
+			}
+		}
+		records = append(records, record)
+		return true
+	})
+
+	return records
+}
+
+// randMembers returns random members of the set at the key without removing them. A positive count returns
+// up to count distinct members, a negative count returns -count members which may repeat.
+func (s *Set) randMembers(key string, count int) []*Record {
+	members, _ := s.members(key)
+	if count == 0 || members.len() == 0 {
+		return []*Record{}
+	}
+
+	all := make([]*Record, 0, members.len())
+	members.each(func(hash uint64, record *Record) bool {
+		all = append(all, record)
+		return true
+	})
+
+	if count < 0 {
+		records := make([]*Record, -count)
+		for i := range records {
+			records[i] = all[rand.Intn(len(all))]
+		}
+		return records
+	}
+
+	if count > len(all) {
+		count = len(all)
+	}
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(all)-i)
+		all[i], all[j] = all[j], all[i]
+	}
+
+	return all[:count]
+}
+
+// scan returns members of the set stored at key in the order of their hashes from the hash from, up to count
+// unless the first hash alone has more members. It also returns the hash of the next member and whether there is one.
+func (s *Set) scan(key string, from uint64, count int) (records []*Record, next uint64, more bool) {
+	members, _ := s.members(key)
+
+	hashes := make([]uint64, 0)
+	for hash := range members.records() {
+		if hash >= from {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+
+	records = make([]*Record, 0, count)
+	for i, hash := range hashes {
+		chain := members.records()[hash]
+		if len(records) > 0 && len(records)+len(chain) > count {
+			return records, hashes[i], true
+		}
+		records = append(records, chain...)
+	}
+
+	return records, 0, false
+}
+
+// members returns the members of the set stored at key, and false if there is no set or it has expired.
+func (s *Set) members(key string) (*setMembers, bool) {
+	if s.expiredAt(key, unixSeconds(s.clock)) {
+		return nil, false
+	}
+
+	members, ok := s.M[key]
+	return members, ok
+}
+
+// Expire sets the ttl of the set stored at key from the timestamp, Persistent removes it.
+func (s *Set) Expire(key string, ttl uint32, timestamp uint64) {
+	if ttl == Persistent {
+		delete(s.TTL, key)
+		delete(s.TimeStamp, key)
+		return
+	}
+
+	s.TTL[key] = ttl
+	s.TimeStamp[key] = timestamp
+}
+
+// expiredAt returns whether the ttl of the set stored at key has elapsed at the unix time now.
+func (s *Set) expiredAt(key string, now uint64) bool {
+	ttl, ok := s.TTL[key]
+	if !ok {
+		return false
+	}
+
+	return uint64(ttl)+s.TimeStamp[key] <= now
+}
+
+// clear removes the set stored at key with its ttl.
+func (s *Set) clear(key string) {
+	delete(s.M, key)
+	delete(s.TTL, key)
+	delete(s.TimeStamp, key)
+}
+
+// empty replaces the set stored at key with an empty set without ttl.
+func (s *Set) empty(key string) {
+	s.clear(key)
+	s.M[key] = newSetMembers()
+}
+
+// setMembers holds the members of a set by the 64-bit hash of their values, so finding a member costs one
+// hash of its value whatever the size of the set. The rare members sharing a hash are told apart by their
+// values, and a record without its value in memory is taken as the member of its hash.
+type setMembers struct {
+	byHash map[uint64][]*Record
+	size   int
+}
+
+func newSetMembers() *setMembers {
+	return &setMembers{byHash: map[uint64][]*Record{}}
 }
 
-func getFnv32(value []byte) (uint32, error) {
-	_, err := fnvHash.Write(value)
-	if err != nil {
-		return 0, err
+// get returns the record of the member with the value, nil if there is none.
+// A nil value matches any member with the hash.
+func (m *setMembers) get(hash uint64, value []byte) *Record {
+	if m == nil {
+		return nil
+	}
+
+	for _, record := range m.byHash[hash] {
+		if isMember(record, value) {
+			return record
+		}
 	}
-	hash := fnvHash.Sum32()
-	fnvHash.Reset()
-	return hash, nil
+
+	return nil
+}
+
+// add adds the member with the value, or replaces the record of the member already in the set.
+func (m *setMembers) add(hash uint64, value []byte, record *Record) {
+	chain := m.byHash[hash]
+	for i, r := range chain {
+		if isMember(r, value) {
+			chain[i] = record
+			return
+		}
+	}
+
+	m.byHash[hash] = append(chain, record)
+	m.size++
+}
+
+// remove removes the member with the value and returns its record, nil if there is none.
+func (m *setMembers) remove(hash uint64, value []byte) *Record {
+	chain := m.byHash[hash]
+	for i, record := range chain {
+		if !isMember(record, value) {
+			continue
+		}
+
+		if len(chain) == 1 {
+			delete(m.byHash, hash)
+		} else {
+			m.byHash[hash] = append(chain[:i:i], chain[i+1:]...)
+		}
+		m.size--
+		return record
+	}
+
+	return nil
+}
+
+// each calls f for the members, until f returns false.
+func (m *setMembers) each(f func(hash uint64, record *Record) bool) {
+	if m == nil {
+		return
+	}
+
+	for hash, chain := range m.byHash {
+		for _, record := range chain {
+			if !f(hash, record) {
+				return
+			}
+		}
+	}
+}
+
+// records returns the records of the members by hash.
+func (m *setMembers) records() map[uint64][]*Record {
+	if m == nil {
+		return nil
+	}
+
+	return m.byHash
+}
+
+func (m *setMembers) len() int {
+	if m == nil {
+		return 0
+	}
+
+	return m.size
+}
+
+// isMember returns whether the record is the member with the value, a record without
+// its value in memory or a nil value is taken as the same member.
+func isMember(record *Record, value []byte) bool {
+	if value == nil || record.E == nil {
+		return true
+	}
+
+	return bytes.Equal(record.E.Value, value)
+}
+
+// recordValue returns the value of the record if it is in memory.
+func recordValue(record *Record) []byte {
+	if record.E == nil {
+		return nil
+	}
+
+	return record.E.Value
+}
+
+func memberHash(value []byte) uint64 {
+	return fnvHash64(value)
 }
@@ -443,3 +443,77 @@ func TestSet_SUnion(t *testing.T) {
 		})
 	}
 }
+
+func TestSet_MembersWithSameHash(t *testing.T) {
+	members := newSetMembers()
+	hash := memberHash([]byte("a"))
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	record := func(value []byte) *Record {
+		return NewRecord().WithEntry(NewEntry().WithValue(value))
+	}
+
+	// b and c are forced on the hash of a.
+	members.add(hash, a, record(a))
+	members.add(hash, b, record(b))
+	members.add(hash, c, record(c))
+	members.add(hash, b, record(b))
+	require.Equal(t, 3, members.len())
+
+	for _, value := range [][]byte{a, b, c} {
+		r := members.get(hash, value)
+		require.NotNil(t, r)
+		require.Equal(t, value, r.E.Value)
+	}
+	require.Nil(t, members.get(hash, []byte("d")))
+	require.Nil(t, members.get(hash+1, a))
+
+	require.Equal(t, b, members.remove(hash, b).E.Value)
+	require.Nil(t, members.remove(hash, b))
+	require.Nil(t, members.get(hash, b))
+	require.NotNil(t, members.get(hash, a))
+	require.NotNil(t, members.get(hash, c))
+	require.Equal(t, 2, members.len())
+
+	var values [][]byte
+	members.each(func(h uint64, r *Record) bool {
+		require.Equal(t, hash, h)
+		values = append(values, r.E.Value)
+		return true
+	})
+	require.ElementsMatch(t, [][]byte{a, c}, values)
+
+	require.NotNil(t, members.remove(hash, a))
+	require.NotNil(t, members.remove(hash, c))
+	require.Equal(t, 0, members.len())
+	require.Empty(t, members.records())
+
+	// a record without its value in memory is the member of its hash.
+	members.add(hash, a, NewRecord().WithHint(NewHint()))
+	require.NotNil(t, members.get(hash, b))
+	require.Equal(t, 1, members.len())
+}
+
+func BenchmarkSet_SIsMember(b *testing.B) {
+	key := "key"
+	num, size := 1000000, 2*KB
+
+	// the members are windows of one random buffer, so they are distinct without holding 2GB of values.
+	buf := GetRandomBytes(num + int(size))
+	values := make([][]byte, num)
+	records := make([]*Record, num)
+	for i := range values {
+		values[i] = buf[i : i+int(size)]
+		records[i] = NewRecord().WithEntry(NewEntry().WithValue(values[i]))
+	}
+
+	set := NewSet()
+	require.NoError(b, set.SAdd(key, values, records))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ok, err := set.SIsMember(key, values[i%num])
+		if err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
@@ -16,70 +16,60 @@ package nutsdb
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-func tarGZCompress(dst io.Writer, src string) error {
-	gz := gzip.NewWriter(dst)
-	defer gz.Close()
-	return tarCompress(gz, src)
-}
-
-// https://blog.ralch.com/articles/golang-working-with-tar-and-gzip
-func tarCompress(dst io.Writer, src string) error {
-	tarball := tar.NewWriter(dst)
-	defer tarball.Close()
-
-	info, err := os.Stat(src)
+// tarAddFile writes the first size bytes of the file src into the archive under name and to sum, or the
+// directory src.
+func tarAddFile(tw *tar.Writer, name, src string, info os.FileInfo, size int64, sum io.Writer) error {
+	header, err := tar.FileInfoHeader(info, info.Name())
 	if err != nil {
-		return nil
+		return err
 	}
+	header.Name = name
 
-	var baseDir string
 	if info.IsDir() {
-		baseDir = filepath.Base(src)
+		return tw.WriteHeader(header)
 	}
 
-	return filepath.Walk(src,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			header, err := tar.FileInfoHeader(info, info.Name())
-			if err != nil {
-				return err
-			}
-
-			if baseDir != "" {
-				header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, src))
-			}
+	file, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-			if err := tarball.WriteHeader(header); err != nil {
-				return err
-			}
+	header.Size = size
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
 
-			if info.IsDir() {
-				return nil
-			}
+	_, err = io.CopyN(io.MultiWriter(tw, sum), file, size)
+	return err
+}
 
-			file, err := os.Open(filepath.Clean(path))
-			if err != nil {
-				return err
-			}
+// tarAddBytes writes data into the archive as the file name.
+func tarAddBytes(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg, ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
 
-			defer file.Close()
-			_, err = io.Copy(tarball, file)
-			return err
-		})
+	_, err := tw.Write(data)
+	return err
 }
 
+// tarDecompress extracts the archive written by tarCompress into dst, without the directory at the root of the
+// archive. The entries escaping dst, the links and the other special files are refused with ErrRestoreArchive.
+// The files are synced along with the directories holding them.
 func tarDecompress(dst string, src io.Reader) error {
 	tarReader := tar.NewReader(src)
+	dirs := []string{dst}
 
 	for {
 		header, err := tarReader.Next()
@@ -90,25 +80,85 @@ func tarDecompress(dst string, src io.Reader) error {
 			return err
 		}
 
-		path := filepath.Join(dst, header.Name)
-		info := header.FileInfo()
-		if info.IsDir() {
-			if err = os.MkdirAll(path, info.Mode()); err != nil {
+		name, err := tarEntryPath(header)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		target := filepath.Join(dst, name)
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
 				return err
 			}
+			dirs = append(dirs, target)
 			continue
 		}
 
-		file, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-		if err != nil {
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
 			return err
 		}
-		defer file.Close()
-		_, err = io.Copy(file, tarReader)
-		if err != nil {
+		if err := tarExtractFile(target, header, tarReader); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syncDir(dirs[i]); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// tarEntryPath returns the path of the entry relative to the directory at the root of the archive, "" for the
+// root itself.
+func tarEntryPath(header *tar.Header) (string, error) {
+	if header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg {
+		return "", fmt.Errorf("%w: %s is not a regular file or a directory", ErrRestoreArchive, header.Name)
+	}
+
+	name := strings.TrimSuffix(filepath.ToSlash(header.Name), "/")
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(header.Name) || filepath.VolumeName(header.Name) != "" {
+		return "", fmt.Errorf("%w: %s is not a relative path", ErrRestoreArchive, header.Name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%w: %s escapes the directory", ErrRestoreArchive, header.Name)
+		}
+	}
+
+	elems := strings.SplitN(path.Clean(name), "/", 2)
+	if len(elems) == 1 {
+		if header.Typeflag != tar.TypeDir {
+			return "", fmt.Errorf("%w: %s is out of the root directory", ErrRestoreArchive, header.Name)
+		}
+		return "", nil
+	}
+
+	return filepath.FromSlash(elems[1]), nil
+}
+
+// tarExtractFile writes the file of the entry to target, which must not exist, and syncs it.
+func tarExtractFile(target string, header *tar.Header, r io.Reader) error {
+	file, err := os.OpenFile(filepath.Clean(target), os.O_CREATE|os.O_EXCL|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(file, r, header.Size); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
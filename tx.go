@@ -17,12 +17,11 @@ package nutsdb
 import (
 	"bytes"
 	"errors"
-	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/bwmarrin/snowflake"
 	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/strconv2"
 )
@@ -74,6 +73,9 @@ var (
 
 	ErrCannotRollbackAClosedTx = errors.New("can not rollback a closed tx")
 
+	// ErrTxKilled is returned when using a tx which was killed by DB.KillTransaction.
+	ErrTxKilled = errors.New("tx is killed")
+
 	// ErrNotFoundBucket is returned when key not found int the bucket on an view function.
 	ErrNotFoundBucket = errors.New("bucket not found")
 )
@@ -81,11 +83,24 @@ var (
 // Tx represents a transaction.
 type Tx struct {
 	id                     uint64
+	seq                    uint64 // identifies the live tx in DB, unique within the process unlike id
 	db                     *DB
 	writable               bool
 	status                 atomic.Value
 	pendingWrites          []*Entry
 	ReservedStoreTxIDIdxes map[int64]*BPTree
+	startTime              time.Time
+	label                  atomic.Value
+	killed                 int32
+	lockReleased           int32
+	ops                    int32      // the operations of the tx running, see beginOp
+	killMu                 sync.Mutex // serializes releasing the killed tx by DB.KillTransaction and by the owner
+	iterators              []*Iterator
+	merging                bool // the tx rewrites records for merge, which writes to the read-only buckets
+	expiring               bool // the tx writes the tombstones of the expirer, which drop the keys from the index
+	quotaUsages            map[bucketID]*bucketQuotaUsage
+	scoreTypes             map[string]zset.ScoreType // the score types of the sorted sets set by the tx
+	listSeqs               map[string]*listSeq       // the sequence numbers given to the elements pushed by the tx
 }
 
 // Begin opens a new transaction.
@@ -95,6 +110,10 @@ type Tx struct {
 // the current read/write transaction is completed.
 // All transactions must be closed by calling Commit() or Rollback() when done.
 func (db *DB) Begin(writable bool) (tx *Tx, err error) {
+	if writable && db.isReadOnly() {
+		return nil, ErrDBReadOnly
+	}
+
 	tx, err = newTx(db, writable)
 	if err != nil {
 		return nil, err
@@ -108,6 +127,8 @@ func (db *DB) Begin(writable bool) (tx *Tx, err error) {
 		return nil, ErrDBClosed
 	}
 
+	db.trackTx(tx)
+
 	return
 }
 
@@ -120,6 +141,8 @@ func newTx(db *DB, writable bool) (tx *Tx, err error) {
 		writable:               writable,
 		pendingWrites:          []*Entry{},
 		ReservedStoreTxIDIdxes: make(map[int64]*BPTree),
+		startTime:              time.Now(),
+		seq:                    atomic.AddUint64(&db.txSeq, 1),
 	}
 
 	txID, err = tx.getTxID()
@@ -134,12 +157,11 @@ func newTx(db *DB, writable bool) (tx *Tx, err error) {
 
 // getTxID returns the tx id.
 func (tx *Tx) getTxID() (id uint64, err error) {
-	node, err := snowflake.NewNode(tx.db.opt.NodeNum)
-	if err != nil {
-		return 0, err
+	if tx.db.txIDNodeErr != nil {
+		return 0, tx.db.txIDNodeErr
 	}
 
-	id = uint64(node.Generate().Int64())
+	id = uint64(tx.db.txIDNode.Generate().Int64())
 
 	return
 }
@@ -156,11 +178,25 @@ func (tx *Tx) getTxID() (id uint64, err error) {
 //
 // 5. Unlock the database and clear the db field.
 func (tx *Tx) Commit() (err error) {
+	tx.enterOp()
+	defer tx.endOp()
+
+	if tx.isKilled() {
+		tx.closeKilled()
+		return ErrTxKilled
+	}
+
+	var encodeStart time.Time
 	defer func() {
 		if err != nil {
 			tx.handleErr(err)
 		}
-		tx.unlock()
+		tx.closeIterators()
+		if !encodeStart.IsZero() {
+			// taken ahead of the unlock, which may hand the processor over to the next tx.
+			atomic.AddInt64(&tx.db.commitLockNanos, int64(time.Since(encodeStart)))
+		}
+		tx.releaseLock()
 		tx.db = nil
 
 		tx.pendingWrites = nil
@@ -196,15 +232,23 @@ func (tx *Tx) Commit() (err error) {
 		countFlag = CountFlagDisabled
 	}
 
+	// no entry is written if one of them does not fit in a data file.
+	for _, entry := range tx.pendingWrites {
+		if entry.Size() > tx.db.opt.SegmentSize {
+			return ErrDataSizeExceed
+		}
+	}
+
+	// the last entry is the commit record of the tx.
+	tx.pendingWrites[lastIndex].Meta.Status = Committed
+
+	encodeStart = time.Now()
 	buff := tx.allocCommitBuffer()
 	defer tx.db.commitBuffer.Reset()
 
 	for i := 0; i < writesLen; i++ {
 		entry := tx.pendingWrites[i]
 		entrySize := entry.Size()
-		if entrySize > tx.db.opt.SegmentSize {
-			return ErrDataSizeExceed
-		}
 
 		bucket := string(entry.Bucket)
 
@@ -225,13 +269,8 @@ func (tx *Tx) Commit() (err error) {
 			tx.db.BPTreeKeyEntryPosMap[string(getNewKey(string(entry.Bucket), entry.Key))] = offset
 		}
 
-		if i == lastIndex {
-			entry.Meta.Status = Committed
-		}
-
-		if _, err := buff.Write(entry.Encode()); err != nil {
-			return err
-		}
+		entry.encodeTo(buff)
+		tx.db.bucketRecords.add(entry.Meta, bucket, tx.db.ActiveFile.fileID)
 
 		if i == lastIndex {
 			if _, err := tx.writeData(buff.Bytes()); err != nil {
@@ -278,6 +317,31 @@ func (tx *Tx) Commit() (err error) {
 		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBPTreeBucketDeleteFlag {
 			tx.db.deleteBucket(DataStructureBPTree, bucket)
 		}
+
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketDefaultTTLFlag {
+			tx.db.setBucketDefaultTTL(bucket, entry.Key)
+		}
+
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketReadOnlyFlag {
+			tx.db.setBucketReadOnly(bucket, entry.Key)
+		}
+
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketQuotaFlag {
+			tx.db.setBucketQuota(bucket, entry.Key)
+		}
+
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketScoreTypeFlag {
+			tx.db.setBucketScoreType(bucket, entry.Key)
+		}
+
+		// the sorted sets are indexed by buildIdxes, so are their renames.
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketRenameFlag {
+			rename := newBucketRename(bucket, entry.Key, tx.db.ActiveFile.fileID, offset)
+			tx.db.bucketRenames = append(tx.db.bucketRenames, rename)
+			if rename.ds != DataStructureSortedSet {
+				tx.db.renameBucket(rename.ds, rename.from, rename.to)
+			}
+		}
 	}
 
 	tx.buildIdxes()
@@ -350,21 +414,9 @@ func (tx *Tx) buildBucketMetaIdx(bucket string, key []byte, bucketMetaTemp Bucke
 	}
 
 	if updateFlag {
-		fd, err := os.OpenFile(getBucketMetaFilePath(bucket, tx.db.opt.Dir), os.O_CREATE|os.O_RDWR, 0644)
-		if err != nil {
-			return err
-		}
-		defer fd.Close()
-
-		if _, err = fd.WriteAt(bucketMeta.Encode(), 0); err != nil {
+		if err := writeBucketMeta(getBucketMetaFilePath(bucket, tx.db.opt.Dir), bucketMeta, tx.db.opt.SyncEnable); err != nil {
 			return err
 		}
-
-		if tx.db.opt.SyncEnable {
-			if err = fd.Sync(); err != nil {
-				return err
-			}
-		}
 		tx.db.bucketMetas[bucket] = bucketMeta
 	}
 
@@ -435,6 +487,11 @@ func (tx *Tx) buildIdxes() {
 			if entry.Meta.Flag == DataListBucketDeleteFlag {
 				tx.db.deleteBucket(DataStructureList, bucket)
 			}
+			if entry.Meta.Flag == DataBucketRenameFlag {
+				if rename := newBucketRename(bucket, entry.Key, 0, 0); rename.ds == DataStructureSortedSet {
+					tx.db.renameBucket(rename.ds, rename.from, rename.to)
+				}
+			}
 		}
 
 		tx.db.KeyCount++
@@ -442,6 +499,11 @@ func (tx *Tx) buildIdxes() {
 }
 
 func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, offset int64, countFlag bool) {
+	// the key written again can expire again, merge and the expirer rewrite or drop the record notified.
+	if tx.db.expiredNotifier != nil && !tx.merging && !tx.expiring {
+		tx.db.expiredNotifier.forget(bucket, entry.Key)
+	}
+
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		newKey := getNewKey(bucket, entry.Key)
 		_ = tx.db.ActiveBPTreeIdx.Insert(newKey, e, &Hint{
@@ -458,32 +520,64 @@ func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, offset int64, count
 		if tx.db.BPTreeIdx[bucket] == nil {
 			tx.db.BPTreeIdx[bucket] = NewTree()
 		}
+
+		// the tombstones of the expirer are only kept on disk, for merge to drop the expired records.
+		if tx.expiring && entry.Meta.Flag == DataDeleteFlag {
+			tx.db.BPTreeIdx[bucket].Delete(entry.Key)
+			delete(tx.db.BPTreeKeyEntryPosMap, string(getNewKey(bucket, entry.Key)))
+			return
+		}
+
 		_ = tx.db.BPTreeIdx[bucket].Insert(entry.Key, e, &Hint{
 			FileID:  tx.db.ActiveFile.fileID,
 			Key:     entry.Key,
 			Meta:    entry.Meta,
 			DataPos: uint64(offset),
 		}, countFlag)
+
+		// merge rewrites the keys already tracked.
+		if !tx.merging {
+			tx.db.trackExpiringKey(bucket, entry.Key, entry.Meta)
+		}
 	}
 }
 
 func (tx *Tx) buildSetIdx(bucket string, entry *Entry, offset int64) {
 	if _, ok := tx.db.SetIdx[bucket]; !ok {
 		tx.db.SetIdx[bucket] = NewSet()
+		tx.db.SetIdx[bucket].clock = tx.db.opt.Clock
 	}
 
-	if entry.Meta.Flag == DataDeleteFlag {
-		_ = tx.db.SetIdx[bucket].SRem(string(entry.Key), entry.Value)
+	set, key := tx.db.SetIdx[bucket], string(entry.Key)
+	if set.expiredAt(key, entry.Meta.Timestamp) {
+		set.clear(key)
 	}
 
-	if entry.Meta.Flag == DataSetFlag {
+	switch entry.Meta.Flag {
+	case DataDeleteFlag:
+		_ = set.SRem(key, entry.Value)
+	case DataSetFlag:
 		r := tx.db.buildRecordByEntryAndOffset(entry, offset)
-		_ = tx.db.SetIdx[bucket].SAdd(string(entry.Key), [][]byte{entry.Value}, []*Record{r})
+		_ = set.SAdd(key, [][]byte{entry.Value}, []*Record{r})
+	case DataExpireSetFlag:
+		ttl, _ := strconv2.StrToInt64(string(entry.Value))
+		set.Expire(key, uint32(ttl), entry.Meta.Timestamp)
+	case DataSetClearFlag:
+		set.empty(key)
 	}
 }
 
 func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.db.SortedSetIdx[bucket]
+	if ok && sortedSet.ExpiredAt(entry.Meta.Timestamp) {
+		delete(tx.db.SortedSetIdx, bucket)
+		ok = false
+	}
+	if !ok {
+		// the ttl of a missing sorted set is dropped, it does not create the sorted set.
+		if entry.Meta.Flag == DataExpireZSetFlag {
+			return
+		}
 		tx.db.SortedSetIdx[bucket] = zset.New()
 	}
 
@@ -493,6 +587,11 @@ func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
 		key := keyAndScore[0]
 		score, _ := strconv2.StrToFloat64(keyAndScore[1])
 		_ = tx.db.SortedSetIdx[bucket].Put(key, zset.SCORE(score), entry.Value)
+	case DataZAddIntFlag:
+		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
+		key := keyAndScore[0]
+		score, _ := strconv2.StrToInt64(keyAndScore[1])
+		_ = tx.db.SortedSetIdx[bucket].PutInt(key, score, entry.Value)
 	case DataZRemFlag:
 		_ = tx.db.SortedSetIdx[bucket].Remove(string(entry.Key))
 	case DataZRemRangeByRankFlag:
@@ -503,6 +602,9 @@ func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
 		_ = tx.db.SortedSetIdx[bucket].PopMax()
 	case DataZPopMinFlag:
 		_ = tx.db.SortedSetIdx[bucket].PopMin()
+	case DataExpireZSetFlag:
+		ttl, _ := strconv2.StrToInt64(string(entry.Value))
+		tx.db.SortedSetIdx[bucket].Expire(uint32(ttl), entry.Meta.Timestamp)
 	}
 }
 
@@ -510,24 +612,27 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry, offset int64) {
 	l := tx.db.Index.getList(bucket)
 
 	key, value := entry.Key, entry.Value
-	if IsExpired(entry.Meta.TTL, entry.Meta.Timestamp) {
+	if tx.db.isExpired(entry.Meta) {
 		return
 	}
 
 	switch entry.Meta.Flag {
 	case DataExpireListFlag:
 		t, _ := strconv2.StrToInt64(string(value))
-		ttl := uint32(t)
-		l.TTL[string(key)] = ttl
-		l.TimeStamp[string(key)] = entry.Meta.Timestamp
-	case DataLPushFlag:
-		r := tx.db.buildRecordByEntryAndOffset(entry, offset)
-		_ = l.LPush(string(key), r)
-	case DataRPushFlag:
+		l.Expire(string(key), uint32(t), entry.Meta.Timestamp)
+	case DataListClearFlag:
+		l.clear(string(key))
+	case DataListCapFlag:
+		maxLen, _ := strconv2.StrToInt(string(value))
+		l.SetCap(string(key), maxLen, entry.Meta.Timestamp)
+	case DataLPushFlag, DataRPushFlag:
+		listKey, seq := splitListSeqKey(key)
 		r := tx.db.buildRecordByEntryAndOffset(entry, offset)
-		_ = l.RPush(string(key), r)
+		r.seq = seq
+		_ = l.push(string(listKey), r, entry.Meta.Flag == DataLPushFlag)
+		tx.db.listNotifier.notify(bucket, listKey)
 	case DataLRemFlag:
-		countAndValue := strings.Split(string(value), SeparatorForListKey)
+		countAndValue := strings.SplitN(string(value), SeparatorForListKey, 2)
 		count, _ := strconv2.StrToInt(countAndValue[0])
 		newValue := countAndValue[1]
 
@@ -539,10 +644,9 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry, offset int64) {
 			return bytes.Equal([]byte(newValue), v), nil
 		})
 
-	case DataLPopFlag:
-		_, _ = l.LPop(string(key))
-	case DataRPopFlag:
-		_, _ = l.RPop(string(key))
+	case DataLPopFlag, DataRPopFlag:
+		listKey, seq := splitListSeqKey(key)
+		_, _ = l.popSeq(string(listKey), seq, entry.Meta.Flag == DataLPopFlag)
 	case DataLSetFlag:
 		keyAndIndex := strings.Split(string(key), SeparatorForListKey)
 		newKey := keyAndIndex[0]
@@ -555,6 +659,13 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry, offset int64) {
 		start, _ := strconv2.StrToInt(keyAndStartIndex[1])
 		end, _ := strconv2.StrToInt(string(value))
 		_ = l.LTrim(newKey, start, end)
+	case DataLInsertFlag:
+		keyAndIndex := strings.Split(string(key), SeparatorForListKey)
+		newKey := keyAndIndex[0]
+		index, _ := strconv2.StrToInt(keyAndIndex[1])
+		r := tx.db.buildRecordByEntryAndOffset(entry, offset)
+		_ = l.LInsert(newKey, index, r)
+		tx.db.listNotifier.notify(bucket, []byte(newKey))
 	case DataLRemByIndex:
 		indexes, _ := UnmarshalInts(value)
 		_ = l.LRemByIndex(string(key), indexes)
@@ -660,6 +771,13 @@ func (tx *Tx) writeData(data []byte) (n int, err error) {
 
 // Rollback closes the transaction.
 func (tx *Tx) Rollback() error {
+	tx.enterOp()
+	defer tx.endOp()
+
+	if tx.isKilled() {
+		tx.closeKilled()
+		return ErrTxKilled
+	}
 	if tx.db == nil {
 		tx.setStatusClosed()
 		return ErrDBClosed
@@ -673,14 +791,120 @@ func (tx *Tx) Rollback() error {
 	}
 
 	tx.setStatusClosed()
-	tx.unlock()
+	tx.closeIterators()
+	tx.releaseLock()
+
+	tx.db = nil
+	tx.pendingWrites = nil
+
+	return nil
+}
+
+// closeIterators closes the iterators of the tx that are not closed by the user.
+func (tx *Tx) closeIterators() {
+	iterators := tx.iterators
+	tx.iterators = nil
+	for _, it := range iterators {
+		it.close()
+	}
+}
+
+// removeIterator forgets the closed iterator, the latest iterators are usually closed first.
+func (tx *Tx) removeIterator(it *Iterator) {
+	for i := len(tx.iterators) - 1; i >= 0; i-- {
+		if tx.iterators[i] == it {
+			copy(tx.iterators[i:], tx.iterators[i+1:])
+			tx.iterators[len(tx.iterators)-1] = nil
+			tx.iterators = tx.iterators[:len(tx.iterators)-1]
+			return
+		}
+	}
+}
+
+// SetLabel sets a label which identifies the tx in DB.LiveTransactions.
+func (tx *Tx) SetLabel(label string) {
+	tx.label.Store(label)
+}
+
+// getLabel returns the label of the tx, an empty string if it is not set.
+func (tx *Tx) getLabel() string {
+	label, _ := tx.label.Load().(string)
+	return label
+}
+
+// releaseLock unlocks the database and stops tracking the tx, it only takes effect once,
+// so a killed tx which is released by an operation can still be committed or rolled back by its owner.
+func (tx *Tx) releaseLock() {
+	if atomic.CompareAndSwapInt32(&tx.lockReleased, 0, 1) {
+		tx.db.untrackTx(tx)
+		tx.unlock()
+	}
+}
+
+// kill marks the read-only tx as killed and releases its lock and iterators, unless an operation of the tx is
+// running, then the operation releases them when it ends. The operations begun afterwards return ErrTxKilled.
+func (tx *Tx) kill() {
+	atomic.StoreInt32(&tx.killed, 1)
+	if atomic.LoadInt32(&tx.ops) == 0 {
+		tx.releaseKilled()
+	}
+}
+
+// releaseKilled releases the lock and the iterators held by the killed tx, it's called by DB.KillTransaction
+// when no operation of the tx is running, or else by the owner of the tx.
+func (tx *Tx) releaseKilled() {
+	tx.killMu.Lock()
+	defer tx.killMu.Unlock()
+
+	if tx.db == nil {
+		return
+	}
+
+	tx.closeIterators()
+	tx.releaseLock()
+	tx.setStatusClosed()
+}
+
+// closeKilled releases the killed tx and clears it for Commit and Rollback.
+func (tx *Tx) closeKilled() {
+	tx.releaseKilled()
 
+	tx.killMu.Lock()
+	defer tx.killMu.Unlock()
 	tx.db = nil
 	tx.pendingWrites = nil
+	tx.ReservedStoreTxIDIdxes = nil
+}
+
+// beginOp begins an operation of the tx, which is ended by endOp if no error is returned. The operations
+// keep DB.KillTransaction from releasing the tx under them, so that they never see the index released.
+func (tx *Tx) beginOp() error {
+	tx.enterOp()
+	if err := tx.checkTxIsClosed(); err != nil {
+		tx.endOp()
+		return err
+	}
 
 	return nil
 }
 
+// enterOp is beginOp without the check of the tx, for the operations which check it their own way.
+func (tx *Tx) enterOp() {
+	atomic.AddInt32(&tx.ops, 1)
+}
+
+// endOp ends an operation of the tx, the last one running releases the tx if it's killed meanwhile.
+func (tx *Tx) endOp() {
+	if atomic.AddInt32(&tx.ops, -1) == 0 && tx.isKilled() {
+		tx.releaseKilled()
+	}
+}
+
+// isKilled will check if the tx is killed by DB.KillTransaction.
+func (tx *Tx) isKilled() bool {
+	return atomic.LoadInt32(&tx.killed) == 1
+}
+
 // lock locks the database based on the transaction type.
 func (tx *Tx) lock() {
 	if tx.writable {
@@ -706,16 +930,53 @@ func (tx *Tx) handleErr(err error) {
 }
 
 func (tx *Tx) PutWithTimestamp(bucket string, key, value []byte, ttl uint32, timestamp uint64) error {
-	return tx.put(bucket, key, value, ttl, DataSetFlag, timestamp, DataStructureBPTree)
+	return tx.put(bucket, key, value, tx.entryTTL(bucket, ttl), DataSetFlag, timestamp, DataStructureBPTree)
 }
 
 // Put sets the value for a key in the bucket.
 // a wrapper of the function put.
+// The ttl Persistent is replaced by the default ttl of the bucket, see DB.SetBucketDefaultTTL.
 func (tx *Tx) Put(bucket string, key, value []byte, ttl uint32) error {
-	return tx.put(bucket, key, value, ttl, DataSetFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	return tx.put(bucket, key, value, tx.entryTTL(bucket, ttl), DataSetFlag, tx.nowSeconds(), DataStructureBPTree)
+}
+
+// PutWithTTL sets the value for a key in the bucket, expiring after the ttl in milliseconds precision.
+// The ttl not positive is the same as Put with Persistent.
+func (tx *Tx) PutWithTTL(bucket string, key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return tx.Put(bucket, key, value, Persistent)
+	}
+
+	return tx.PutWithExpireAt(bucket, key, value, tx.now().Add(ttl))
+}
+
+// PutWithExpireAt sets the value for a key in the bucket, expiring at expireAt in milliseconds precision.
+// The zero expireAt is the same as Put with Persistent, the key put with a past expireAt is expired already.
+func (tx *Tx) PutWithExpireAt(bucket string, key, value []byte, expireAt time.Time) error {
+	if expireAt.IsZero() {
+		return tx.Put(bucket, key, value, Persistent)
+	}
+
+	now := tx.now()
+	expireAtMillis := expireAt.UnixNano() / int64(time.Millisecond)
+	if expireAtMillis < 1 {
+		expireAtMillis = 1
+	}
+
+	// the ttl in seconds covers the expiration, for the readers of the ttl only.
+	ttl := uint32(1)
+	if d := (expireAtMillis+999)/1000 - now.Unix(); d > 1 {
+		ttl = uint32(d)
+	}
+
+	return tx.putExpireAt(bucket, key, value, ttl, uint64(expireAtMillis), DataSetFlag, uint64(now.Unix()), DataStructureBPTree)
 }
 
 func (tx *Tx) checkTxIsClosed() error {
+	if tx.isKilled() {
+		tx.releaseKilled()
+		return ErrTxKilled
+	}
 	if tx.db == nil {
 		return ErrTxClosed
 	}
@@ -725,6 +986,13 @@ func (tx *Tx) checkTxIsClosed() error {
 // put sets the value for a key in the bucket.
 // Returns an error if tx is closed, if performing a write operation on a read-only transaction, if the key is empty.
 func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, timestamp uint64, ds uint16) error {
+	return tx.putExpireAt(bucket, key, value, ttl, 0, flag, timestamp, ds)
+}
+
+// putExpireAt is put with the expiration in milliseconds, which is written in the format MetaVersionExpireAt
+// unless it's 0.
+func (tx *Tx) putExpireAt(bucket string, key, value []byte, ttl uint32, expireAt uint64, flag uint16, timestamp uint64,
+	ds uint16) error {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return err
 	}
@@ -733,8 +1001,17 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 		return ErrTxNotWritable
 	}
 
+	if tx.db.hotKeys != nil {
+		tx.db.hotKeys.sample(bucket, key, hotKeyWrite)
+	}
+
 	meta := NewMetaData().WithTimeStamp(timestamp).WithKeySize(uint32(len(key))).WithValueSize(uint32(len(value))).WithFlag(flag).
-		WithTTL(ttl).WithBucketSize(uint32(len(bucket))).WithStatus(UnCommitted).WithDs(ds).WithTxID(tx.id)
+		WithTTL(ttl).WithBucketSize(uint32(len(bucket))).WithStatus(UnCommitted).WithDs(ds).WithTxID(tx.id).
+		WithExpireAt(expireAt)
+
+	if err := tx.checkBucketWritable(bucket, key, meta); err != nil {
+		return err
+	}
 
 	e := NewEntry().WithKey(key).WithBucket([]byte(bucket)).WithMeta(meta).WithValue(value)
 
@@ -742,6 +1019,9 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 	if err != nil {
 		return err
 	}
+	if err := tx.checkBucketQuota(bucket, key, value, meta); err != nil {
+		return err
+	}
 	tx.pendingWrites = append(tx.pendingWrites, e)
 
 	return nil
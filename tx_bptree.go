@@ -16,20 +16,24 @@ package nutsdb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"regexp"
+	"sort"
 	"time"
 
 	"github.com/xujiajun/utils/strconv2"
 )
 
+// getNewKey returns the key of the entry of the bucket in the b+ trees of HintBPTSparseIdxMode: the length of the
+// bucket as an uvarint, the bucket and the key. The length prefix keeps the keys of the different buckets apart
+// whatever bytes they hold, and the keys of a bucket contiguous for the range and prefix scans.
 func getNewKey(bucket string, key []byte) []byte {
-	newKey := []byte(bucket)
-	newKey = append(newKey, key...)
-	//avoid dup
-	newKey = append(newKey, []byte(bucket)...)
+	newKey := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(bucket)+len(key))
+	n := binary.PutUvarint(newKey, uint64(len(bucket)))
+	newKey = append(newKey[:n], bucket...)
 
-	return newKey
+	return append(newKey, key...)
 }
 
 func (tx *Tx) getByHintBPTSparseIdxInMem(key []byte) (e *Entry, err error) {
@@ -83,7 +87,7 @@ func (tx *Tx) getByHintBPTSparseIdxOnDisk(bucket string, key []byte) (e *Entry,
 
 			e, err = tx.FindOnDisk(fID, rootOff, key, newKey)
 			if err == nil && e != nil {
-				if e.Meta.Flag == DataDeleteFlag || IsExpired(e.Meta.TTL, e.Meta.Timestamp) {
+				if e.Meta.Flag == DataDeleteFlag || tx.isExpired(bucket, key, e.Meta) {
 					return nil, ErrNotFoundKey
 				}
 
@@ -109,7 +113,7 @@ func (tx *Tx) getByHintBPTSparseIdx(bucket string, key []byte) (e *Entry, err er
 
 	entry, err := tx.getByHintBPTSparseIdxInMem(newKey)
 	if entry != nil && err == nil {
-		if entry.Meta.Flag == DataDeleteFlag || IsExpired(entry.Meta.TTL, entry.Meta.Timestamp) {
+		if entry.Meta.Flag == DataDeleteFlag || tx.isExpired(bucket, key, entry.Meta) {
 			return nil, ErrNotFoundKey
 		}
 		return entry, err
@@ -135,9 +139,14 @@ func (tx *Tx) getAllByHintBPTSparseIdx(bucket string) (entries Entries, err erro
 // Get retrieves the value for a key in the bucket.
 // The returned value is only valid for the life of the transaction.
 func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
+
+	if tx.db.hotKeys != nil {
+		tx.db.hotKeys.sample(bucket, key, hotKeyRead)
+	}
 
 	idxMode := tx.db.opt.EntryIdxMode
 
@@ -156,7 +165,7 @@ func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 				return nil, ErrNotFoundKey
 			}
 
-			if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
+			if r.H.Meta.Flag == DataDeleteFlag || tx.isExpired(bucket, key, r.H.Meta) {
 				return nil, ErrNotFoundKey
 			}
 
@@ -181,9 +190,10 @@ func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 
 // GetAll returns all keys and values of the bucket stored at given bucket.
 func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	entries = Entries{}
 
@@ -200,7 +210,10 @@ func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
 				return nil, ErrBucketEmpty
 			}
 
-			entries, err = tx.getHintIdxDataItemsWrapper(records, ScanNoLimit, entries, RangeScan)
+			entries, err = tx.getHintIdxDataItemsWrapper(bucket, records, ScanNoLimit, entries, RangeScan)
+			if IsResultTooLarge(err) {
+				return entries, err
+			}
 			if err != nil {
 				return nil, ErrBucketEmpty
 			}
@@ -216,9 +229,10 @@ func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
 
 // RangeScan query a range at given bucket, start and end slice.
 func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		newStart, newEnd := getNewKey(bucket, start), getNewKey(bucket, end)
@@ -259,7 +273,7 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 		if len(es) == 0 {
 			return nil, ErrRangeScan
 		}
-		return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(), nil
+		return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(tx.db.nowMillis()), nil
 	}
 
 	if index, ok := tx.db.BPTreeIdx[bucket]; ok {
@@ -268,7 +282,10 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 			return nil, ErrRangeScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, ScanNoLimit, es, RangeScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, ScanNoLimit, es, RangeScan)
+		if IsResultTooLarge(err) {
+			return es, err
+		}
 		if err != nil {
 			return nil, ErrRangeScan
 		}
@@ -293,10 +310,7 @@ func (tx *Tx) rangeScanOnDisk(bucket string, start, end []byte) ([]*Entry, error
 	newStart, newEnd := getNewKey(bucket, start), getNewKey(bucket, end)
 
 	for _, bptSparseIdx := range bptSparseIdxGroup {
-		if compare(newStart, bptSparseIdx.start) <= 0 &&
-			compare(bptSparseIdx.start, newEnd) <= 0 ||
-			compare(newStart, bptSparseIdx.end) <= 0 &&
-				compare(bptSparseIdx.end, newEnd) <= 0 {
+		if compare(newStart, bptSparseIdx.end) <= 0 && compare(bptSparseIdx.start, newEnd) <= 0 {
 
 			entries, err := tx.findRangeOnDisk(int64(bptSparseIdx.fID), int64(bptSparseIdx.rootOff), start, end, newStart, newEnd)
 
@@ -707,7 +721,7 @@ func (tx *Tx) prefixScanByHintBPTSparseIdx(bucket string, prefix []byte, offsetN
 		return nil, off, ErrPrefixScan
 	}
 
-	return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(), off, nil
+	return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(tx.db.nowMillis()), off, nil
 }
 
 func (tx *Tx) prefixSearchScanByHintBPTSparseIdx(bucket string, prefix []byte, reg string, offsetNum int, limitNum int) (es Entries, off int, err error) {
@@ -760,16 +774,17 @@ func (tx *Tx) prefixSearchScanByHintBPTSparseIdx(bucket string, prefix []byte, r
 		return nil, off, ErrPrefixSearchScan
 	}
 
-	return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(), off, nil
+	return es.ToCEntries(tx.db.opt.LessFunc).processEntriesScanOnDisk(tx.db.nowMillis()), off, nil
 }
 
 // PrefixScan iterates over a key prefix at given bucket, prefix and limitNum.
 // LimitNum will limit the number of entries return.
 func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum int) (es Entries, off int, err error) {
 
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, off, err
 	}
+	defer tx.endOp()
 
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return tx.prefixScanByHintBPTSparseIdx(bucket, prefix, offsetNum, limitNum)
@@ -782,7 +797,10 @@ func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 			return nil, off, ErrPrefixScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, limitNum, es, PrefixScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, limitNum, es, PrefixScan)
+		if IsResultTooLarge(err) {
+			return es, voff, err
+		}
 		if err != nil {
 			off = voff
 			return nil, off, ErrPrefixScan
@@ -803,9 +821,10 @@ func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 // LimitNum will limit the number of entries return.
 func (tx *Tx) PrefixSearchScan(bucket string, prefix []byte, reg string, offsetNum int, limitNum int) (es Entries, off int, err error) {
 
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, off, err
 	}
+	defer tx.endOp()
 
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return tx.prefixSearchScanByHintBPTSparseIdx(bucket, prefix, reg, offsetNum, limitNum)
@@ -818,7 +837,10 @@ func (tx *Tx) PrefixSearchScan(bucket string, prefix []byte, reg string, offsetN
 			return nil, off, ErrPrefixSearchScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, limitNum, es, PrefixSearchScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, limitNum, es, PrefixSearchScan)
+		if IsResultTooLarge(err) {
+			return es, voff, err
+		}
 		if err != nil {
 			off = voff
 			return nil, off, ErrPrefixSearchScan
@@ -837,9 +859,10 @@ func (tx *Tx) PrefixSearchScan(bucket string, prefix []byte, reg string, offsetN
 
 // Delete removes a key from the bucket at given bucket and key.
 func (tx *Tx) Delete(bucket string, key []byte) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 	idxMode := tx.db.opt.EntryIdxMode
 
 	if idxMode == HintBPTSparseIdxMode {
@@ -862,7 +885,7 @@ func (tx *Tx) Delete(bucket string, key []byte) error {
 				return ErrNotFoundKey
 			}
 
-			if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
+			if r.H.Meta.Flag == DataDeleteFlag || tx.isExpired(bucket, key, r.H.Meta) {
 				return ErrNotFoundKey
 			}
 		} else {
@@ -870,17 +893,108 @@ func (tx *Tx) Delete(bucket string, key []byte) error {
 		}
 	}
 
-	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, tx.nowSeconds(), DataStructureBPTree)
+}
+
+// Expire sets the ttl in seconds of the key in the bucket from now, Persistent removes its expiration. The key is
+// written again with its value, so the errors of Get are returned for a key not found or expired.
+func (tx *Tx) Expire(bucket string, key []byte, ttl uint32) error {
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.put(bucket, key, e.Value, ttl, DataSetFlag, tx.nowSeconds(), DataStructureBPTree)
+}
+
+// ExpireAt sets the time the key in the bucket expires at, in milliseconds precision. A time in the past is not
+// refused, the key is expired at once, and the zero time removes the expiration like Persist. The time is
+// written with the key, so it holds across the restarts however long the db is closed, see PutWithExpireAt.
+func (tx *Tx) ExpireAt(bucket string, key []byte, at time.Time) error {
+	if at.IsZero() {
+		return tx.Persist(bucket, key)
+	}
+
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.PutWithExpireAt(bucket, key, e.Value, at)
+}
+
+// Persist removes the expiration of the key in the bucket, set by its ttl or by ExpireAt.
+func (tx *Tx) Persist(bucket string, key []byte) error {
+	return tx.Expire(bucket, key, Persistent)
+}
+
+// GetKeysExpiringBetween returns the keys of the bucket expiring from the time from, included, until the time to,
+// excluded, in milliseconds precision. The bucket "" returns the keys of all the buckets, by the order of their
+// names. The persistent keys and the keys expired already are left out. Only the indexes are read, the expirer's
+// if Options.ActiveExpireInterval is set, so it's not supported in the HintBPTSparseIdxMode.
+func (tx *Tx) GetKeysExpiringBetween(bucket string, from, to time.Time) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	start, end := timeMillis(from), timeMillis(to)
+	if now := tx.db.nowMillis(); start <= now {
+		start = now + 1
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	if tx.db.expirer != nil {
+		return tx.db.expiringKeysBetween(bucket, start, end), nil
+	}
+
+	buckets := []string{bucket}
+	if bucket == "" {
+		buckets = buckets[:0]
+		for name := range tx.db.BPTreeIdx {
+			buckets = append(buckets, name)
+		}
+		sort.Strings(buckets)
+	}
+
+	var keys [][]byte
+	for _, name := range buckets {
+		index, ok := tx.db.BPTreeIdx[name]
+		if !ok {
+			continue
+		}
+
+		records, _ := index.All()
+		for _, r := range records {
+			if expireAt := r.H.Meta.ExpireAtMillis(); r.H.Meta.Flag == DataSetFlag && expireAt >= start && expireAt < end {
+				keys = append(keys, r.H.Key)
+			}
+		}
+	}
+
+	return keys, nil
 }
 
 // getHintIdxDataItemsWrapper returns wrapped entries when prefix scanning or range scanning.
-func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entries, scanMode string) (Entries, error) {
+// It returns the entries so far with a ResultTooLargeError if they exceed Options.MaxScanResultBytes.
+func (tx *Tx) getHintIdxDataItemsWrapper(bucket string, records Records, limitNum int, es Entries, scanMode string) (Entries, error) {
+	limit := tx.newScanLimit()
 	for _, r := range records {
-		if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
+		if r.H.Meta.Flag == DataDeleteFlag || tx.isExpired(bucket, r.H.Key, r.H.Meta) {
 			continue
 		}
 
 		if limitNum > 0 && len(es) < limitNum || limitNum == ScanNoLimit {
+			if !limit.admit(r) {
+				return es, &ResultTooLargeError{Key: r.H.Key, Count: len(es)}
+			}
+
 			idxMode := tx.db.opt.EntryIdxMode
 			if idxMode == HintKeyAndRAMIdxMode {
 				path := getDataPath(r.H.FileID, tx.db.opt.Dir)
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -814,3 +815,34 @@ func TestTx_Notfound_For_BPTSparseIdxMode(t *testing.T) {
 		}
 	})
 }
+
+func TestTx_Expire(t *testing.T) {
+	bucket := "bucket"
+	clock := NewFakeClock(time.Now())
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.Clock = clock
+	require.NoError(t, os.RemoveAll(opts.Dir))
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		txPut(t, db, bucket, []byte("key"), []byte("value"), 10, nil)
+		txPut(t, db, bucket, []byte("persisted"), []byte("value"), 10, nil)
+
+		var errs []error
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			errs = append(errs, tx.Expire(bucket, []byte("key"), 100))
+			errs = append(errs, tx.Expire(bucket, []byte("persisted"), Persistent))
+			errs = append(errs, tx.Expire(bucket, []byte("missing"), 100))
+			return nil
+		}))
+		require.Equal(t, []error{nil, nil, ErrKeyNotFound}, errs)
+
+		clock.Add(50 * time.Second)
+		txGet(t, db, bucket, []byte("key"), []byte("value"), nil)
+		txGet(t, db, bucket, []byte("persisted"), []byte("value"), nil)
+
+		clock.Add(50 * time.Second)
+		txGet(t, db, bucket, []byte("key"), nil, ErrNotFoundKey)
+		txGet(t, db, bucket, []byte("persisted"), []byte("value"), nil)
+	})
+}
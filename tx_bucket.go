@@ -14,57 +14,172 @@
 
 package nutsdb
 
-import "time"
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
 
 // IterateBuckets iterate over all the bucket depends on ds (represents the data structure)
+// The buckets are taken from the index, so a bucket whose entries are all deleted or expired
+// is still listed until it is removed by DeleteBucket.
+// It calls f for the buckets of ds matching the pattern in lexicographical order, until f returns false, e.g. the
+// names of the sorted sets with DataStructureSortedSet. Each data structure has its own buckets, a name used by
+// several of them is listed for each. The pattern is matched like filepath.Match, a malformed pattern is returned
+// before any bucket is matched.
 func (tx *Tx) IterateBuckets(ds uint16, pattern string, f func(key string) bool) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if _, err := filepath.Match(pattern, ""); err != nil {
 		return err
 	}
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return ErrNotSupportHintBPTSparseIdxMode
 	}
-	if ds == DataStructureSet {
-		for bucket := range tx.db.SetIdx {
-			if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
-				return err
-			}
+
+	for _, bucket := range tx.bucketNames(ds) {
+		if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
+			return err
 		}
 	}
-	if ds == DataStructureSortedSet {
-		for bucket := range tx.db.SortedSetIdx {
-			if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
-				return err
-			}
-		}
+	return nil
+}
+
+// IterateBucketsByPrefix calls f for the buckets of ds whose names start with prefix in lexicographical order,
+// until f returns false. With a prefix ending with BucketPathSeparator, e.g. BucketPath("app", "tenant-42") + "/",
+// it enumerates the subtree of the bucket path, see BucketPath.
+func (tx *Tx) IterateBucketsByPrefix(ds uint16, prefix string, f func(bucket string) bool) error {
+	if err := tx.beginOp(); err != nil {
+		return err
 	}
-	if ds == DataStructureList {
-		f := func(bucket string) error {
-			if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
-				return err
-			}
+	defer tx.endOp()
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	for _, bucket := range tx.bucketNames(ds) {
+		if strings.HasPrefix(bucket, prefix) && !f(bucket) {
 			return nil
 		}
-		err := tx.db.Index.handleListBucket(f)
-		if err != nil {
+	}
+	return nil
+}
+
+// DeleteBucketTree deletes the buckets of ds whose names start with prefix, like DeleteBucket for each of them,
+// so the subtree of a bucket path is dropped by one commit. Either all of them are deleted or none: if one
+// can't be, its error is returned and the tx is left as it was. It returns ErrBucketNotFound if no bucket
+// matches.
+func (tx *Tx) DeleteBucketTree(ds uint16, prefix string) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	if _, err := tx.ExistBucket(ds, ""); err != nil {
+		return err
+	}
+
+	pending := len(tx.pendingWrites)
+	for _, bucket := range tx.bucketNames(ds) {
+		if !strings.HasPrefix(bucket, prefix) {
+			continue
+		}
+		if err := tx.DeleteBucket(ds, bucket); err != nil {
+			tx.pendingWrites = tx.pendingWrites[:pending]
 			return err
 		}
 	}
-	if ds == DataStructureBPTree {
-		for bucket := range tx.db.BPTreeIdx {
-			if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
-				return err
+
+	if len(tx.pendingWrites) == pending {
+		return ErrBucketNotFound
+	}
+
+	return nil
+}
+
+// bucketNames returns the sorted names of the buckets of ds.
+func (tx *Tx) bucketNames(ds uint16) []string {
+	var buckets []string
+	switch ds {
+	case DataStructureSet:
+		for bucket := range tx.db.SetIdx {
+			buckets = append(buckets, bucket)
+		}
+	case DataStructureSortedSet:
+		for bucket := range tx.db.SortedSetIdx {
+			if _, ok := tx.sortedSet(bucket); ok {
+				buckets = append(buckets, bucket)
 			}
 		}
+	case DataStructureList:
+		_ = tx.db.Index.handleListBucket(func(bucket string) error {
+			buckets = append(buckets, bucket)
+			return nil
+		})
+	case DataStructureBPTree:
+		for bucket := range tx.db.BPTreeIdx {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+
+	return buckets
+}
+
+// IterateBucketEntries iterates over the entries of all the BPTree buckets, buckets are visited in
+// lexicographical order and the entries of a bucket in key order. Deleted and expired entries are skipped.
+// The iteration stops when f returns false.
+func (tx *Tx) IterateBucketEntries(f func(bucket string, entry *Entry) bool) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	buckets := make([]string, 0, len(tx.db.BPTreeIdx))
+	for bucket := range tx.db.BPTreeIdx {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		more, err := tx.iterateBucketEntries(bucket, f)
+		if err != nil || !more {
+			return err
+		}
 	}
+
 	return nil
 }
 
+// iterateBucketEntries calls f for the entries of the bucket, it returns false when f stopped the iteration.
+func (tx *Tx) iterateBucketEntries(bucket string, f func(bucket string, entry *Entry) bool) (bool, error) {
+	it := NewIterator(tx, bucket, IteratorOptions{Reverse: false})
+	defer it.Close()
+
+	for {
+		ok, err := it.SetNext()
+		if err != nil || !ok {
+			return true, err
+		}
+		if !f(bucket, it.Entry()) {
+			return false, nil
+		}
+	}
+}
+
 // DeleteBucket delete bucket depends on ds (represents the data structure)
 func (tx *Tx) DeleteBucket(ds uint16, bucket string) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return ErrNotSupportHintBPTSparseIdxMode
 	}
@@ -78,28 +193,108 @@ func (tx *Tx) DeleteBucket(ds uint16, bucket string) error {
 	}
 
 	if ds == DataStructureSet {
-		return tx.put(bucket, []byte("0"), nil, Persistent, DataSetBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("0"), nil, Persistent, DataSetBucketDeleteFlag, tx.nowSeconds(), DataStructureNone)
 	}
 	if ds == DataStructureSortedSet {
-		return tx.put(bucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, tx.nowSeconds(), DataStructureNone)
 	}
 	if ds == DataStructureBPTree {
-		return tx.put(bucket, []byte("2"), nil, Persistent, DataBPTreeBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("2"), nil, Persistent, DataBPTreeBucketDeleteFlag, tx.nowSeconds(), DataStructureNone)
 	}
 	if ds == DataStructureList {
-		return tx.put(bucket, []byte("3"), nil, Persistent, DataListBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("3"), nil, Persistent, DataListBucketDeleteFlag, tx.nowSeconds(), DataStructureNone)
 	}
 	return nil
 }
 
+// DeleteBucketAll deletes the bucket from every data structure holding it, like DeleteBucket for each of them,
+// so all of them are deleted when the tx is committed. It returns ErrBucketNotFound if no data structure holds
+// the bucket.
+func (tx *Tx) DeleteBucketAll(bucket string) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	found := false
+	for _, ds := range []uint16{DataStructureBPTree, DataStructureSet, DataStructureSortedSet, DataStructureList} {
+		if ok, _ := tx.ExistBucket(ds, bucket); !ok {
+			continue
+		}
+		if err := tx.DeleteBucket(ds, bucket); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return ErrBucketNotFound
+	}
+
+	return nil
+}
+
+// RenameBucket renames the bucket of the data structure ds, its entries are read under newName when the tx is
+// committed. The entries are not rewritten: a rename record maps them to the new name when the db is opened,
+// until merge rewrites them under the new name.
+// It returns ErrBucketNotFound if there is no bucket oldName, and ErrBucketExists if there is a bucket newName,
+// including one written by the tx.
+func (tx *Tx) RenameBucket(ds uint16, oldName, newName string) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	ok, err := tx.ExistBucket(ds, oldName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	if ok, _ = tx.ExistBucket(ds, newName); ok || tx.writesBucket(ds, newName) {
+		return ErrBucketExists
+	}
+
+	return tx.put(oldName, bucketRenameKey(ds, newName), nil, Persistent, DataBucketRenameFlag, tx.nowSeconds(), DataStructureNone)
+}
+
+// writesBucket returns if a pending write of the tx creates the bucket of the data structure ds.
+func (tx *Tx) writesBucket(ds uint16, bucket string) bool {
+	for _, entry := range tx.pendingWrites {
+		if entry.Meta.Ds == ds && string(entry.Bucket) == bucket {
+			return true
+		}
+		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBucketRenameFlag {
+			if rename := newBucketRename(string(entry.Bucket), entry.Key, 0, 0); rename.ds == ds && rename.to == bucket {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (tx *Tx) ExistBucket(ds uint16, bucket string) (bool, error) {
+	if err := tx.beginOp(); err != nil {
+		return false, err
+	}
+	defer tx.endOp()
+
 	var ok bool
 
 	switch ds {
 	case DataStructureSet:
 		_, ok = tx.db.SetIdx[bucket]
 	case DataStructureSortedSet:
-		_, ok = tx.db.SortedSetIdx[bucket]
+		_, ok = tx.sortedSet(bucket)
 	case DataStructureBPTree:
 		_, ok = tx.db.BPTreeIdx[bucket]
 	case DataStructureList:
@@ -1,8 +1,10 @@
 package nutsdb
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -186,3 +188,482 @@ func (suite *TxBucketTestSuite) TestC_HintBPTSparseIdxMode() {
 func TestTxBucketSuit(t *testing.T) {
 	suite.Run(t, new(TxBucketTestSuite))
 }
+
+func TestTx_IterateBucketEntries(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		for _, bucket := range []string{"bucket_b", "bucket_a", "bucket_c"} {
+			for i := 0; i < 3; i++ {
+				txPut(t, db, bucket, GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+			}
+		}
+		txDel(t, db, "bucket_a", GetTestBytes(1), nil)
+		txDeleteBucket(t, db, DataStructureBPTree, "bucket_c", nil)
+
+		type pair struct {
+			bucket string
+			key    []byte
+		}
+
+		collect := func(limit int) (pairs []pair) {
+			err := db.View(func(tx *Tx) error {
+				return tx.IterateBucketEntries(func(bucket string, entry *Entry) bool {
+					pairs = append(pairs, pair{bucket: bucket, key: entry.Key})
+					return len(pairs) < limit
+				})
+			})
+			require.NoError(t, err)
+			return
+		}
+
+		expected := []pair{
+			{"bucket_a", GetTestBytes(0)},
+			{"bucket_a", GetTestBytes(2)},
+			{"bucket_b", GetTestBytes(0)},
+			{"bucket_b", GetTestBytes(1)},
+			{"bucket_b", GetTestBytes(2)},
+		}
+		require.Equal(t, expected, collect(100))
+
+		// stop early
+		require.Equal(t, expected[:3], collect(3))
+
+		// a bucket with only deleted entries is still listed
+		txPut(t, db, "bucket_d", GetTestBytes(0), GetTestBytes(0), Persistent, nil)
+		txDel(t, db, "bucket_d", GetTestBytes(0), nil)
+		var buckets []string
+		err := db.View(func(tx *Tx) error {
+			return tx.IterateBuckets(DataStructureBPTree, "*", func(bucket string) bool {
+				buckets = append(buckets, bucket)
+				return true
+			})
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"bucket_a", "bucket_b", "bucket_d"}, buckets)
+		require.Equal(t, expected, collect(100))
+	})
+}
+
+func TestTx_IterateBucketsPerDataStructure(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	structures := map[uint16]string{
+		DataStructureBPTree:    "kv",
+		DataStructureSet:       "set",
+		DataStructureSortedSet: "zset",
+		DataStructureList:      "list",
+	}
+
+	list := func(t *testing.T, db *DB, ds uint16, pattern string) (buckets []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.IterateBuckets(ds, pattern, func(bucket string) bool {
+				buckets = append(buckets, bucket)
+				return true
+			})
+		}))
+		return buckets
+	}
+
+	check := func(t *testing.T, db *DB) {
+		seen := make(map[string]uint16)
+		for ds, name := range structures {
+			buckets := list(t, db, ds, "*")
+			want := []string{name + "_a", name + "_b", "shared"}
+			sort.Strings(want)
+			require.Equal(t, want, buckets)
+			for _, bucket := range buckets {
+				if bucket != "shared" {
+					_, ok := seen[bucket]
+					require.False(t, ok, bucket)
+					seen[bucket] = ds
+				}
+			}
+			require.Equal(t, []string{name + "_b"}, list(t, db, ds, "*_[b-z]"))
+		}
+
+		var first []string
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.IterateBuckets(DataStructureList, "*", func(bucket string) bool {
+				first = append(first, bucket)
+				return false
+			})
+		}))
+		require.Equal(t, []string{"list_a"}, first)
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, suffix := range []string{"_a", "_b", "_deleted"} {
+				if err := tx.Put(structures[DataStructureBPTree]+suffix, []byte("key"), []byte("value"), Persistent); err != nil {
+					return err
+				}
+				if err := tx.SAdd(structures[DataStructureSet]+suffix, []byte("key"), []byte("member")); err != nil {
+					return err
+				}
+				if err := tx.ZAdd(structures[DataStructureSortedSet]+suffix, []byte("member"), 1, nil); err != nil {
+					return err
+				}
+				if err := tx.RPush(structures[DataStructureList]+suffix, []byte("key"), []byte("item")); err != nil {
+					return err
+				}
+			}
+			if err := tx.Put("shared", []byte("key"), []byte("value"), Persistent); err != nil {
+				return err
+			}
+			if err := tx.SAdd("shared", []byte("key"), []byte("member")); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("shared", []byte("member"), 1, nil); err != nil {
+				return err
+			}
+			return tx.RPush("shared", []byte("key"), []byte("item"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for ds, name := range structures {
+				if err := tx.DeleteBucket(ds, name+"_deleted"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		check(t, db)
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, reopened.Close())
+		}()
+		check(t, reopened)
+	})
+}
+
+func TestTx_RenameBucket(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+
+	structures := []uint16{DataStructureBPTree, DataStructureSet, DataStructureSortedSet, DataStructureList}
+
+	// the entries span several data files.
+	for i := 0; i < 100; i++ {
+		txPut(t, db, "ordres", GetTestBytes(i), GetTestBytes(i), Persistent, nil)
+	}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.SAdd("ordres", []byte("set"), []byte("member")); err != nil {
+			return err
+		}
+		if err := tx.RPush("ordres", []byte("list"), []byte("item")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd("ordres", []byte("member"), 1, nil); err != nil {
+			return err
+		}
+		return tx.Put("taken", []byte("key"), []byte("value"), Persistent)
+	}))
+
+	var renameErrs []error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		renameErrs = append(renameErrs,
+			tx.RenameBucket(DataStructureBPTree, "missing", "orders"),
+			tx.RenameBucket(DataStructureSet, "taken", "orders"),
+			tx.RenameBucket(DataStructureBPTree, "ordres", "taken"),
+			tx.RenameBucket(DataStructureBPTree, "ordres", "ordres"))
+		if err := tx.Put("pending", []byte("key"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		renameErrs = append(renameErrs, tx.RenameBucket(DataStructureBPTree, "ordres", "pending"))
+		return nil
+	}))
+	require.Equal(t, []error{ErrBucketNotFound, ErrBucketNotFound, ErrBucketExists, ErrBucketExists, ErrBucketExists}, renameErrs)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, ds := range structures {
+			if err := tx.RenameBucket(ds, "ordres", "orders"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	check := func() {
+		var (
+			values   [][]byte
+			member   bool
+			items    [][]byte
+			score    float64
+			oldKey   error
+			fresh    *Entry
+			oldExist []bool
+		)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				e, err := tx.Get("orders", GetTestBytes(i))
+				if err != nil {
+					return err
+				}
+				values = append(values, e.Value)
+			}
+
+			var err error
+			if member, err = tx.SIsMember("orders", []byte("set"), []byte("member")); err != nil {
+				return err
+			}
+			if items, err = tx.LRange("orders", []byte("list"), 0, -1); err != nil {
+				return err
+			}
+			if score, err = tx.ZScore("orders", []byte("member")); err != nil {
+				return err
+			}
+
+			// the old name is reused by a new bucket.
+			_, oldKey = tx.Get("ordres", GetTestBytes(1))
+			if fresh, err = tx.Get("ordres", []byte("fresh")); err != nil {
+				return err
+			}
+			for _, ds := range structures[1:] {
+				ok, err := tx.ExistBucket(ds, "ordres")
+				if err != nil {
+					return err
+				}
+				oldExist = append(oldExist, ok)
+			}
+			return nil
+		}))
+
+		for i, value := range values {
+			if i == 0 {
+				require.Equal(t, []byte("overwritten"), value)
+			} else {
+				require.Equal(t, GetTestBytes(i), value)
+			}
+		}
+		require.Len(t, values, 100)
+		require.True(t, member)
+		require.Equal(t, [][]byte{[]byte("item")}, items)
+		require.Equal(t, float64(1), score)
+		require.Equal(t, ErrKeyNotFound, oldKey)
+		require.Equal(t, []byte("value"), fresh.Value)
+		require.Equal(t, []bool{false, false, false}, oldExist)
+	}
+
+	var renamed []error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, ds := range structures {
+			renamed = append(renamed, tx.RenameBucket(ds, "ordres", "elsewhere"))
+		}
+		return tx.Put("orders", GetTestBytes(0), []byte("overwritten"), Persistent)
+	}))
+	require.Equal(t, []error{ErrBucketNotFound, ErrBucketNotFound, ErrBucketNotFound, ErrBucketNotFound}, renamed)
+	txPut(t, db, "ordres", []byte("fresh"), []byte("value"), Persistent, nil)
+	check()
+
+	reopen()
+	check()
+
+	// the merged entries are written under the new name, the rename records are dropped with the merged files.
+	merged := make(map[string]int)
+	_, err = db.MergeWithOptions(MergeOptions{Transform: func(e *Entry) (*Entry, MergeAction) {
+		merged[string(e.Bucket)]++
+		return nil, MergeKeep
+	}})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"orders": 100, "ordres": 1, "taken": 1, "pending": 1}, merged)
+	require.Empty(t, db.bucketRenames)
+	check()
+
+	reopen()
+	check()
+}
+
+func TestTx_DeleteBucketAll(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.SegmentSize = 4 * KB
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// entriesOnDisk counts the entries of the bucket in the data files.
+	entriesOnDisk := func(bucket string) (n int) {
+		_, fileIDs := db.getMaxFileIDAndFileIDs()
+		for _, fileID := range fileIDs {
+			fr, err := newFileRecovery(getDataPath(int64(fileID), opts.Dir), opts.BufferSizeOfRecovery)
+			require.NoError(t, err)
+			for {
+				entry, err := fr.readEntry()
+				if err != nil || entry == nil {
+					break
+				}
+				if string(entry.Bucket) == bucket {
+					n++
+				}
+			}
+			require.NoError(t, fr.release())
+		}
+		return n
+	}
+
+	exists := func(bucket string) (found []uint16) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for _, ds := range []uint16{DataStructureBPTree, DataStructureSet, DataStructureSortedSet, DataStructureList} {
+				if ok, _ := tx.ExistBucket(ds, bucket); ok {
+					found = append(found, ds)
+				}
+			}
+			return nil
+		}))
+		return found
+	}
+
+	// the namespace has a BPTree bucket, a set and a list but no sorted set.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Put("namespace", GetTestBytes(i), GetRandomBytes(16), Persistent); err != nil {
+				return err
+			}
+			if err := tx.SAdd("namespace", []byte("set"), GetTestBytes(i)); err != nil {
+				return err
+			}
+			if err := tx.RPush("namespace", []byte("list"), GetTestBytes(i)); err != nil {
+				return err
+			}
+			return tx.Put("other", GetTestBytes(i), GetTestBytes(i), Persistent)
+		}))
+	}
+	require.Equal(t, []uint16{DataStructureBPTree, DataStructureSet, DataStructureList}, exists("namespace"))
+
+	var missing error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		missing = tx.DeleteBucketAll("missing")
+		return tx.DeleteBucketAll("namespace")
+	}))
+	require.Equal(t, ErrBucketNotFound, missing)
+	require.Empty(t, exists("namespace"))
+	require.Equal(t, []uint16{DataStructureBPTree}, exists("other"))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Empty(t, exists("namespace"))
+
+	// merge drops the entries of the deleted buckets with their tombstones.
+	require.Equal(t, 300+3, entriesOnDisk("namespace"))
+	require.NoError(t, db.Merge())
+	require.Equal(t, 0, entriesOnDisk("namespace"))
+	require.Equal(t, 100, entriesOnDisk("other"))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Empty(t, exists("namespace"))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("other")
+		if err == nil && len(entries) != 100 {
+			err = fmt.Errorf("%d entries", len(entries))
+		}
+		return err
+	}))
+}
+
+func TestTx_DeleteBucketTree(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	buckets := []string{
+		BucketPath("app", "tenant-1", "cache"),
+		BucketPath("app", "tenant-42"),
+		BucketPath("app", "tenant-42", "cache"),
+		BucketPath("app", "tenant-42", "sessions"),
+		BucketPath("app", "tenant-42", "logs", "2023"),
+		BucketPath("app", "tenant-42/x"),
+		BucketPath("app", "tenant-420", "cache"),
+	}
+	for _, bucket := range buckets {
+		txPut(t, db, bucket, []byte("key"), []byte("value"), Persistent, nil)
+	}
+
+	listBuckets := func(prefix string) []string {
+		var got []string
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.IterateBucketsByPrefix(DataStructureBPTree, prefix, func(bucket string) bool {
+				got = append(got, bucket)
+				return true
+			})
+		}))
+		return got
+	}
+
+	tenant42 := BucketPath("app", "tenant-42") + BucketPathSeparator
+	require.Equal(t, []string{"app/tenant-42/cache", "app/tenant-42/logs/2023", "app/tenant-42/sessions"}, listBuckets(tenant42))
+	require.Equal(t, []string{"app/tenant-42/logs/2023"}, listBuckets(BucketPath("app", "tenant-42", "logs")+BucketPathSeparator))
+	require.Len(t, listBuckets(BucketPath("app")+BucketPathSeparator), len(buckets))
+	require.Empty(t, listBuckets("other/"))
+
+	// a read-only bucket keeps the whole subtree.
+	var queued int
+	require.NoError(t, db.SetBucketReadOnly(DataStructureBPTree, "app/tenant-42/sessions", true))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		err = tx.DeleteBucketTree(DataStructureBPTree, tenant42)
+		queued = len(tx.pendingWrites)
+		return nil
+	}))
+	require.Equal(t, ErrBucketReadOnly, err)
+	require.Zero(t, queued)
+	require.NoError(t, db.SetBucketReadOnly(DataStructureBPTree, "app/tenant-42/sessions", false))
+
+	// the subtree is dropped by one commit, with a delete record for each bucket.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		err := tx.DeleteBucketTree(DataStructureBPTree, tenant42)
+		queued = len(tx.pendingWrites)
+		return err
+	}))
+	require.Equal(t, 3, queued)
+	require.Empty(t, listBuckets(tenant42))
+
+	var errs []error
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		errs = append(errs, tx.DeleteBucketTree(DataStructureBPTree, tenant42), tx.DeleteBucketTree(DataStructureNone, tenant42))
+		return nil
+	}))
+	require.Equal(t, []error{ErrBucketNotFound, ErrDataStructureNotSupported}, errs)
+
+	// the siblings survive, so does the bucket named like the parent and the one with an escaped separator.
+	siblings := []string{"app/tenant-1/cache", "app/tenant-42", "app/tenant-420/cache", `app/tenant-42\/x`}
+	require.Equal(t, siblings, listBuckets(BucketPath("app")+BucketPathSeparator))
+	for _, bucket := range siblings {
+		txGet(t, db, bucket, []byte("key"), []byte("value"), nil)
+	}
+
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, siblings, listBuckets(BucketPath("app")+BucketPathSeparator))
+}
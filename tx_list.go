@@ -16,9 +16,10 @@ package nutsdb
 
 import (
 	"bytes"
+	"encoding/binary"
+	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/nutsdb/nutsdb/ds/list"
 	"github.com/pkg/errors"
@@ -35,19 +36,31 @@ const SeparatorForListKey = "|"
 
 // RPop removes and returns the last element of the list stored in the bucket at given bucket and key.
 func (tx *Tx) RPop(bucket string, key []byte) (item []byte, err error) {
-	item, err = tx.RPeek(bucket, key)
+	return tx.pop(bucket, key, false)
+}
+
+// RPeek returns the last element of the list stored in the bucket at given bucket and key without removing it.
+// It returns ErrListNotFound if there is no list at the key and ErrListEmpty if the list is empty.
+func (tx *Tx) RPeek(bucket string, key []byte) ([]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	r, err := tx.peekRecord(bucket, key, false)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	return item, tx.push(bucket, key, DataRPopFlag, item)
+	return tx.db.getValueByRecord(r)
 }
 
-// RPeek returns the last element of the list stored in the bucket at given bucket and key.
-func (tx *Tx) RPeek(bucket string, key []byte) ([]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+// peekRecord returns the record of the element at the isLeft end of the list stored in the bucket at given bucket and key.
+func (tx *Tx) peekRecord(bucket string, key []byte, isLeft bool) (*Record, error) {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	l := tx.db.Index.getList(bucket)
 	if l == nil {
@@ -55,26 +68,43 @@ func (tx *Tx) RPeek(bucket string, key []byte) ([]byte, error) {
 	}
 
 	if tx.CheckExpire(bucket, key) {
-		return nil, ErrKeyNotFound
+		return nil, ErrListNotFound
 	}
 
-	r, err := l.RPeek(string(key))
+	r, err := l.peek(string(key), isLeft)
 	if err != nil {
 		return nil, err
 	}
-
-	v, err := tx.db.getValueByRecord(r)
-	if err != nil {
-		return nil, err
+	if r == nil {
+		return nil, ErrListEmpty
 	}
 
-	return v, nil
+	return r, nil
 }
 
 // push sets values for list stored in the bucket at given bucket, key, flag and values.
+// The sizes of all the values are checked before any of them is queued, so a value too big fails the whole call.
 func (tx *Tx) push(bucket string, key []byte, flag uint16, values ...[]byte) error {
-	for _, value := range values {
-		err := tx.put(bucket, key, value, Persistent, flag, uint64(time.Now().Unix()), DataStructureList)
+	keys := make([][]byte, len(values))
+	for i := range keys {
+		keys[i] = key
+	}
+
+	return tx.pushKeys(bucket, keys, flag, values)
+}
+
+// pushKeys is like push, but each value is written with the record key at the same index of keys.
+func (tx *Tx) pushKeys(bucket string, keys [][]byte, flag uint16, values [][]byte) error {
+	for i, value := range values {
+		size := int64(DataEntryHeaderSize + len(bucket) + len(keys[i]) + len(value))
+		if len(value) > MAX_SIZE || size > tx.db.opt.SegmentSize {
+			return ErrDataSizeExceed
+		}
+	}
+
+	timestamp := tx.nowSeconds()
+	for i, value := range values {
+		err := tx.put(bucket, keys[i], value, Persistent, flag, timestamp, DataStructureList)
 		if err != nil {
 			return err
 		}
@@ -83,102 +113,305 @@ func (tx *Tx) push(bucket string, key []byte, flag uint16, values ...[]byte) err
 	return nil
 }
 
+// pushElements inserts the values at the isLeft end of the list stored in the bucket at given bucket and key.
+// In the ListFormatSeq each value is written with the next sequence number of that end in its record key.
+func (tx *Tx) pushElements(bucket string, key []byte, isLeft bool, values [][]byte) error {
+	flag := DataRPushFlag
+	if isLeft {
+		flag = DataLPushFlag
+	}
+
+	if tx.db.opt.ListFormat != ListFormatSeq {
+		return tx.push(bucket, key, flag, values...)
+	}
+
+	seq := tx.listSeq(bucket, key)
+	keys := make([][]byte, len(values))
+	for i := range keys {
+		keys[i] = listSeqKey(key, seq.next(isLeft))
+	}
+
+	return tx.pushKeys(bucket, keys, flag, values)
+}
+
+// listSeq returns the sequence numbers given to the elements of the list stored in the bucket at given bucket
+// and key, counting the ones pushed by the tx which are not in the list index yet.
+func (tx *Tx) listSeq(bucket string, key []byte) *listSeq {
+	id := bucket + SeparatorForListKey + string(key)
+	if seq, ok := tx.listSeqs[id]; ok {
+		return seq
+	}
+
+	seq := &listSeq{}
+	if l, ok := tx.db.Index.list[bucket]; ok {
+		if s, ok := l.seqs[string(key)]; ok {
+			*seq = *s
+		}
+	}
+	if tx.listSeqs == nil {
+		tx.listSeqs = make(map[string]*listSeq)
+	}
+	tx.listSeqs[id] = seq
+
+	return seq
+}
+
+// listRecordKey returns the record key naming the element r of the list at key, for popping it or for merge
+// rewriting it. In the ListFormatSeq it carries the sequence number of the element.
+func (tx *Tx) listRecordKey(key []byte, r *Record) []byte {
+	if tx.db.opt.ListFormat != ListFormatSeq || r.seq == 0 {
+		return key
+	}
+
+	return listSeqKey(key, r.seq)
+}
+
+// listSeqKey returns the record key of the list element with the sequence number seq: key | seq in 8 bytes.
+func listSeqKey(key []byte, seq int64) []byte {
+	newKey := make([]byte, len(key)+len(SeparatorForListKey)+8)
+	n := copy(newKey, key)
+	n += copy(newKey[n:], SeparatorForListKey)
+	binary.BigEndian.PutUint64(newKey[n:], uint64(seq))
+
+	return newKey
+}
+
+// splitListSeqKey returns the list key and the sequence number in the record key of a push or a pop. The list keys
+// can't contain the SeparatorForListKey, so the records written in the ListFormatIndex are told by having none,
+// and their sequence number is 0.
+func splitListSeqKey(key []byte) ([]byte, int64) {
+	i := bytes.Index(key, []byte(SeparatorForListKey))
+	if i < 0 || len(key)-i-len(SeparatorForListKey) != 8 {
+		return key, 0
+	}
+
+	return key[:i], int64(binary.BigEndian.Uint64(key[i+len(SeparatorForListKey):]))
+}
+
 // RPush inserts the values at the tail of the list stored in the bucket at given bucket,key and values.
+// Pushing to an expired list starts a fresh list.
+// The values are appended in the order given, RPush of a, b, c leaves a, b, c at the tail.
 func (tx *Tx) RPush(bucket string, key []byte, values ...[]byte) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
-	if tx.CheckExpire(bucket, key) {
-		return ErrKeyNotFound
-	}
+	defer tx.endOp()
 	if strings.Contains(string(key), SeparatorForListKey) {
 		return ErrSeparatorForListKey
 	}
 
-	return tx.push(bucket, key, DataRPushFlag, values...)
+	return tx.pushElements(bucket, key, false, values)
 }
 
 // LPush inserts the values at the head of the list stored in the bucket at given bucket,key and values.
+// Pushing to an expired list starts a fresh list.
+// The values are inserted one after another like Redis does, LPush of a, b, c leaves c, b, a at the head.
 func (tx *Tx) LPush(bucket string, key []byte, values ...[]byte) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
-	if tx.CheckExpire(bucket, key) {
-		return ErrKeyNotFound
-	}
-
+	defer tx.endOp()
 	if strings.Contains(string(key), SeparatorForListKey) {
 		return ErrSeparatorForListKey
 	}
 
-	return tx.push(bucket, key, DataLPushFlag, values...)
+	return tx.pushElements(bucket, key, true, values)
 }
 
 // LPop removes and returns the first element of the list stored in the bucket at given bucket and key.
 func (tx *Tx) LPop(bucket string, key []byte) (item []byte, err error) {
-	item, err = tx.LPeek(bucket, key)
+	return tx.pop(bucket, key, true)
+}
+
+// pop removes and returns the element at the isLeft end of the list stored in the bucket at given bucket and key.
+func (tx *Tx) pop(bucket string, key []byte, isLeft bool) ([]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	r, err := tx.peekRecord(bucket, key, isLeft)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := tx.db.getValueByRecord(r)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	flag := DataRPopFlag
+	if isLeft {
+		flag = DataLPopFlag
 	}
 
-	return item, tx.push(bucket, key, DataLPopFlag, item)
+	return item, tx.pushKeys(bucket, [][]byte{tx.listRecordKey(key, r)}, flag, [][]byte{item})
 }
 
-// LPeek returns the first element of the list stored in the bucket at given bucket and key.
-func (tx *Tx) LPeek(bucket string, key []byte) (item []byte, err error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+// LPopN removes and returns up to n elements from the head of the list stored in the bucket at given bucket and key,
+// in the order they are popped. It returns fewer elements if the list is shorter, and ErrListNotFound if there is
+// no list at the key. The pops are committed together with the tx.
+func (tx *Tx) LPopN(bucket string, key []byte, n int) ([][]byte, error) {
+	return tx.popN(bucket, key, n, true)
+}
+
+// RPopN removes and returns up to n elements from the tail of the list stored in the bucket at given bucket and key,
+// in the order they are popped. It returns fewer elements if the list is shorter, and ErrListNotFound if there is
+// no list at the key. The pops are committed together with the tx.
+func (tx *Tx) RPopN(bucket string, key []byte, n int) ([][]byte, error) {
+	return tx.popN(bucket, key, n, false)
+}
+
+func (tx *Tx) popN(bucket string, key []byte, n int, isLeft bool) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
-	l := tx.db.Index.getList(bucket)
-	if l == nil {
-		return nil, ErrBucket
+	defer tx.endOp()
+	if n <= 0 {
+		return [][]byte{}, nil
 	}
 	if tx.CheckExpire(bucket, key) {
-		return nil, ErrKeyNotFound
+		return nil, ErrListNotFound
+	}
+
+	l := tx.db.Index.getList(bucket)
+	size, err := l.Size(string(key))
+	if err != nil {
+		return nil, err
+	}
+	if n > size {
+		n = size
+	}
+
+	start, end, flag := 0, n-1, DataLPopFlag
+	if !isLeft {
+		start, end, flag = size-n, size-1, DataRPopFlag
+	}
+	records, err := l.LRange(string(key), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(records))
+	keys := make([][]byte, len(records))
+	for i, r := range records {
+		v, err := tx.db.getValueByRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		if !isLeft {
+			i = len(records) - 1 - i
+		}
+		values[i], keys[i] = v, tx.listRecordKey(key, r)
+	}
+
+	if err := tx.pushKeys(bucket, keys, flag, values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// ListSide represents the side of a list LMove pops from or pushes to.
+type ListSide int
+
+const (
+	// ListLeft represents the head of a list.
+	ListLeft ListSide = iota
+
+	// ListRight represents the tail of a list.
+	ListRight
+)
+
+// LMove pops an element from the srcSide of the list at src and pushes it to the dstSide of the list at dst,
+// the pop and the push are committed together with the tx. src and dst may be the same key, which rotates the list.
+func (tx *Tx) LMove(bucket string, src, dst []byte, srcSide, dstSide ListSide) (item []byte, err error) {
+	if strings.Contains(string(dst), SeparatorForListKey) {
+		return nil, ErrSeparatorForListKey
+	}
+
+	if srcSide == ListLeft {
+		item, err = tx.LPop(bucket, src)
+	} else {
+		item, err = tx.RPop(bucket, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dstSide == ListLeft {
+		err = tx.LPush(bucket, dst, item)
+	} else {
+		err = tx.RPush(bucket, dst, item)
 	}
-	r, err := l.LPeek(string(key))
 	if err != nil {
 		return nil, err
 	}
 
-	v, err := tx.db.getValueByRecord(r)
+	return item, nil
+}
+
+// RPopLPush pops the last element of the list at src and pushes it to the head of the list at dst, see LMove.
+func (tx *Tx) RPopLPush(bucket string, src, dst []byte) ([]byte, error) {
+	return tx.LMove(bucket, src, dst, ListRight, ListLeft)
+}
+
+// LPeek returns the first element of the list stored in the bucket at given bucket and key without removing it.
+// It returns ErrListNotFound if there is no list at the key and ErrListEmpty if the list is empty.
+func (tx *Tx) LPeek(bucket string, key []byte) (item []byte, err error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	r, err := tx.peekRecord(bucket, key, true)
 	if err != nil {
 		return nil, err
 	}
 
-	return v, nil
+	return tx.db.getValueByRecord(r)
 }
 
 // LSize returns the size of key in the bucket in the bucket at given bucket and key.
+// It only consults the list index and never reads the values from the data files,
+// it returns 0 and ErrListNotFound if there is no list at the key.
 func (tx *Tx) LSize(bucket string, key []byte) (int, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 	l := tx.db.Index.getList(bucket)
 	if l == nil {
 		return 0, ErrBucket
 	}
 	if tx.CheckExpire(bucket, key) {
-		return 0, ErrKeyNotFound
+		return 0, ErrListNotFound
 	}
 	return l.Size(string(key))
 }
 
+// LLen is an alias of LSize.
+func (tx *Tx) LLen(bucket string, key []byte) (int, error) {
+	return tx.LSize(bucket, key)
+}
+
 // LRange returns the specified elements of the list stored in the bucket at given bucket,key, start and end.
 // The offsets start and stop are zero-based indexes 0 being the first element of the list (the head of the list),
 // 1 being the next element and so on.
 // Start and end can also be negative numbers indicating offsets from the end of the list,
 // where -1 is the last element of the list, -2 the penultimate element and so on.
+// The offsets out of the list are clamped like Redis does, and an empty range returns no elements without an error.
 func (tx *Tx) LRange(bucket string, key []byte, start, end int) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 	l := tx.db.Index.getList(bucket)
 	if l == nil {
 		return nil, ErrBucket
 	}
 	if tx.CheckExpire(bucket, key) {
-		return nil, ErrKeyNotFound
+		return nil, ErrListNotFound
 	}
 
 	records, err := l.LRange(string(key), start, end)
@@ -188,7 +421,12 @@ func (tx *Tx) LRange(bucket string, key []byte, start, end int) ([][]byte, error
 
 	values := make([][]byte, len(records))
 
+	limit := tx.newScanLimit()
 	for i, r := range records {
+		if !limit.admit(r) {
+			return values[:i], &ResultTooLargeError{Count: i}
+		}
+
 		value, err := tx.db.getValueByRecord(r)
 		if err != nil {
 			return nil, err
@@ -204,18 +442,39 @@ func (tx *Tx) LRange(bucket string, key []byte, start, end int) ([][]byte, error
 // count > 0: Remove elements equal to value moving from head to tail.
 // count < 0: Remove elements equal to value moving from tail to head.
 // count = 0: Remove all elements equal to value.
-func (tx *Tx) LRem(bucket string, key []byte, count int, value []byte) error {
+// It returns the number of the removed elements, counted on the list as committed before the tx.
+func (tx *Tx) LRem(bucket string, key []byte, count int, value []byte) (int, error) {
 	var (
 		buffer bytes.Buffer
 		size   int
 	)
+
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+
 	size, err := tx.LSize(bucket, key)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if count > size || count < -size {
-		return list.ErrCount
+		return 0, list.ErrCount
+	}
+
+	indexes, err := tx.db.Index.getList(bucket).lRemIndexes(string(key), count, func(r *Record) (bool, error) {
+		v, err := tx.db.getValueByRecord(r)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(value, v), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(indexes) == 0 {
+		return 0, nil
 	}
 
 	buffer.Write([]byte(strconv2.IntToStr(count)))
@@ -225,33 +484,40 @@ func (tx *Tx) LRem(bucket string, key []byte, count int, value []byte) error {
 
 	err = tx.push(bucket, key, DataLRemFlag, newValue)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return len(indexes), nil
 }
 
-// LSet sets the list element at index to value.
+// LSet sets the list element at index to value, a negative index counts from the tail,
+// -1 being the last element. It returns ErrListNotFound if there is no list at the key
+// and ErrIndexOutOfRange if the index is out of the list.
 func (tx *Tx) LSet(bucket string, key []byte, index int, value []byte) error {
 	var (
 		err    error
 		buffer bytes.Buffer
 	)
 
-	if err = tx.checkTxIsClosed(); err != nil {
+	if err = tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 	l := tx.db.Index.getList(bucket)
 	if tx.CheckExpire(bucket, key) {
-		return ErrKeyNotFound
+		return ErrListNotFound
 	}
-	if _, ok := l.Items[string(key)]; !ok {
-		return ErrKeyNotFound
+	items, ok := l.Items[string(key)]
+	if !ok {
+		return ErrListNotFound
 	}
 
-	size, _ := tx.LSize(bucket, key)
+	size := items.Size()
+	if index < 0 {
+		index += size
+	}
 	if index < 0 || index >= size {
-		return list.ErrIndexOutOfRange
+		return ErrIndexOutOfRange
 	}
 
 	buffer.Write(key)
@@ -263,31 +529,107 @@ func (tx *Tx) LSet(bucket string, key []byte, index int, value []byte) error {
 	return tx.push(bucket, newKey, DataLSetFlag, value)
 }
 
+// LGet returns the element at index of the list stored in the bucket at given bucket and key, a negative index
+// counts from the tail, -1 being the last element. Only the value of that element is read. It returns
+// ErrListNotFound if there is no list at the key and ErrIndexOutOfRange if the index is out of the list.
+func (tx *Tx) LGet(bucket string, key []byte, index int) ([]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+	l := tx.db.Index.getList(bucket)
+	if tx.CheckExpire(bucket, key) {
+		return nil, ErrListNotFound
+	}
+
+	r, err := l.LGet(string(key), index)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.db.getValueByRecord(r)
+}
+
+// LInsertBefore inserts the value before the first element equal to pivot in the list stored in the bucket at given bucket and key.
+// It returns ErrListNotFound if there is no list at the key and ErrPivotNotFound if there is no element equal to pivot.
+func (tx *Tx) LInsertBefore(bucket string, key, pivot, value []byte) error {
+	return tx.lInsert(bucket, key, pivot, value, false)
+}
+
+// LInsertAfter inserts the value after the first element equal to pivot in the list stored in the bucket at given bucket and key.
+// It returns ErrListNotFound if there is no list at the key and ErrPivotNotFound if there is no element equal to pivot.
+func (tx *Tx) LInsertAfter(bucket string, key, pivot, value []byte) error {
+	return tx.lInsert(bucket, key, pivot, value, true)
+}
+
+// lInsert finds the pivot in the committed list and writes the insert at the index it resolves to.
+func (tx *Tx) lInsert(bucket string, key, pivot, value []byte, after bool) error {
+	var buffer bytes.Buffer
+
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	l := tx.db.Index.getList(bucket)
+	if tx.CheckExpire(bucket, key) {
+		return ErrListNotFound
+	}
+
+	index, err := l.indexOf(string(key), func(r *Record) (bool, error) {
+		v, err := tx.db.getValueByRecord(r)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(pivot, v), nil
+	})
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		return ErrPivotNotFound
+	}
+	if after {
+		index++
+	}
+
+	buffer.Write(key)
+	buffer.Write([]byte(SeparatorForListKey))
+	buffer.Write([]byte(strconv2.IntToStr(index)))
+	newKey := buffer.Bytes()
+
+	return tx.push(bucket, newKey, DataLInsertFlag, value)
+}
+
 // LTrim trims an existing list so that it will contain only the specified range of elements specified.
 // the offsets start and stop are zero-based indexes 0 being the first element of the list (the head of the list),
 // 1 being the next element and so on.
 // start and end can also be negative numbers indicating offsets from the end of the list,
 // where -1 is the last element of the list, -2 the penultimate element and so on.
+// A start beyond the tail empties the list.
 func (tx *Tx) LTrim(bucket string, key []byte, start, end int) error {
 	var (
 		err    error
 		buffer bytes.Buffer
 	)
 
-	if err = tx.checkTxIsClosed(); err != nil {
+	if err = tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 
 	l := tx.db.Index.getList(bucket)
 	if tx.CheckExpire(bucket, key) {
 		return ErrKeyNotFound
 	}
-	if _, ok := l.Items[string(key)]; !ok {
+	items, ok := l.Items[string(key)]
+	if !ok {
 		return ErrKeyNotFound
 	}
 
-	if _, err := tx.LRange(bucket, key, start, end); err != nil {
-		return err
+	if size := items.Size(); start < size {
+		if _, _, err := checkBounds(start, end, size); err != nil {
+			return err
+		}
 	}
 
 	buffer.Write(key)
@@ -298,44 +640,79 @@ func (tx *Tx) LTrim(bucket string, key []byte, start, end int) error {
 	return tx.push(bucket, newKey, DataLTrimFlag, []byte(strconv2.IntToStr(end)))
 }
 
-// LRemByIndex remove the list element at specified index
-func (tx *Tx) LRemByIndex(bucket string, key []byte, indexes ...int) error {
-	if err := tx.checkTxIsClosed(); err != nil {
-		return err
+// LRemByIndex removes the elements at the indexes of the list stored in the bucket at given bucket and key,
+// and returns how many elements are removed. A negative index counts from the tail, -1 being the last element.
+// The indexes all refer to the list before the removal, the duplicate indexes are removed once and the indexes
+// out of the list are ignored. It returns ErrListNotFound if there is no list at the key.
+func (tx *Tx) LRemByIndex(bucket string, key []byte, indexes ...int) (int, error) {
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+	if len(indexes) == 0 {
+		return 0, nil
 	}
 	if tx.CheckExpire(bucket, key) {
-		return ErrKeyNotFound
+		return 0, ErrListNotFound
 	}
 
-	if len(indexes) == 0 {
-		return nil
+	size, err := tx.db.Index.getList(bucket).Size(string(key))
+	if err != nil {
+		return 0, err
+	}
+
+	// the record keeps the resolved indexes, so that replaying it doesn't depend on the size of the list.
+	seen := make(map[int]struct{}, len(indexes))
+	resolved := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		if index < 0 {
+			index += size
+		}
+		if index < 0 || index >= size {
+			continue
+		}
+		if _, ok := seen[index]; ok {
+			continue
+		}
+		seen[index] = struct{}{}
+		resolved = append(resolved, index)
+	}
+	if len(resolved) == 0 {
+		return 0, nil
 	}
 
-	sort.Ints(indexes)
-	data, err := MarshalInts(indexes)
+	sort.Ints(resolved)
+	data, err := MarshalInts(resolved)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	err = tx.push(bucket, key, DataLRemByIndex, data)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return len(resolved), nil
 }
 
-// LKeys find all keys matching a given pattern
+// LKeys calls f for the keys of the lists in the bucket matching the pattern, until f returns false.
+// The pattern is matched like filepath.Match, a malformed pattern is returned before any key is matched.
+// It only reads the list index in memory, and skips the expired lists.
 func (tx *Tx) LKeys(bucket, pattern string, f func(key string) bool) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if _, err := filepath.Match(pattern, ""); err != nil {
 		return err
 	}
 	l := tx.db.Index.getList(bucket)
 	if l == nil {
 		return ErrBucket
 	}
+	now := tx.nowSeconds()
 	for key := range l.Items {
-		if tx.CheckExpire(bucket, []byte(key)) {
+		if l.expiredAt(key, now) {
 			continue
 		}
 		if end, err := MatchForRange(pattern, key, f); end || err != nil {
@@ -345,13 +722,14 @@ func (tx *Tx) LKeys(bucket, pattern string, f func(key string) bool) error {
 	return nil
 }
 
+// ExpireList sets the ttl in seconds of the list stored in the bucket at given bucket and key, Persistent removes it.
+// Once the ttl elapses the list is gone for the reads and pops, its records are dropped by merge, and a later push
+// starts a fresh list. Calling ExpireList again refreshes the ttl from the time of the call.
 func (tx *Tx) ExpireList(bucket string, key []byte, ttl uint32) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
-	l := tx.db.Index.getList(bucket)
-	l.TTL[string(key)] = ttl
-	l.TimeStamp[string(key)] = uint64(time.Now().Unix())
+	defer tx.endOp()
 	ttls := strconv2.Int64ToStr(int64(ttl))
 	err := tx.push(bucket, key, DataExpireListFlag, []byte(ttls))
 	if err != nil {
@@ -360,6 +738,25 @@ func (tx *Tx) ExpireList(bucket string, key []byte, ttl uint32) error {
 	return nil
 }
 
+// LCap sets the maximum length of the list stored in the bucket at given bucket and key, 0 removes it.
+// Once capped, a push evicts the element at the other end of the list when the list is full, LPush evicts
+// the tail and RPush evicts the head. A list longer than maxLen is trimmed from the head at commit.
+// The cap is kept until it is removed or the list expires.
+func (tx *Tx) LCap(bucket string, key []byte, maxLen int) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+	if maxLen < 0 {
+		return ErrListCap
+	}
+	if strings.Contains(string(key), SeparatorForListKey) {
+		return ErrSeparatorForListKey
+	}
+
+	return tx.push(bucket, key, DataListCapFlag, []byte(strconv2.IntToStr(maxLen)))
+}
+
 func (tx *Tx) CheckExpire(bucket string, key []byte) bool {
 	l := tx.db.Index.getList(bucket)
 	if l.IsExpire(string(key)) {
@@ -370,9 +767,10 @@ func (tx *Tx) CheckExpire(bucket string, key []byte) bool {
 }
 
 func (tx *Tx) GetListTTL(bucket string, key []byte) (uint32, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 	l := tx.db.Index.getList(bucket)
 	if l == nil {
 		return 0, ErrBucket
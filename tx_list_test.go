@@ -15,10 +15,14 @@
 package nutsdb
 
 import (
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 )
@@ -262,7 +266,7 @@ func TestTx_LRem(t *testing.T) {
 
 	bucket := "myBucket"
 	key := []byte("myList")
-	err := tx.LRem(bucket, key, 1, []byte("val"))
+	_, err := tx.LRem(bucket, key, 1, []byte("val"))
 	if err == nil {
 		t.Fatal(err)
 	}
@@ -305,7 +309,7 @@ func TestTx_LRem2(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err = tx.LRem(bucket, []byte("fake_key"), 1, []byte("fake_val")); err == nil {
+		if _, err = tx.LRem(bucket, []byte("fake_key"), 1, []byte("fake_val")); err == nil {
 			t.Fatal("TestTx_LRem err")
 		} else {
 			tx.Rollback()
@@ -317,7 +321,7 @@ func TestTx_LRem2(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err = tx.LRem(bucket, key, 1, []byte("a")); err != nil {
+		if _, err = tx.LRem(bucket, key, 1, []byte("a")); err != nil {
 			tx.Rollback()
 			t.Fatal(err)
 		} else {
@@ -360,7 +364,7 @@ func TestTx_LRem3(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		err := tx.LRem(bucket, key, 4, []byte("b"))
+		_, err := tx.LRem(bucket, key, 4, []byte("b"))
 		if err == nil {
 			t.Error("TestTx_LRem err")
 		}
@@ -373,7 +377,7 @@ func TestTx_LRem3(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		err := tx.LRem(bucket, []byte("myList2"), 4, []byte("b"))
+		_, err := tx.LRem(bucket, []byte("myList2"), 4, []byte("b"))
 		if err == nil {
 			t.Error("TestTx_LRem err")
 		}
@@ -386,7 +390,7 @@ func TestTx_LRem3(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		err := tx.LRem(bucket, []byte("myList2"), 1, []byte("b"))
+		_, err := tx.LRem(bucket, []byte("myList2"), 1, []byte("b"))
 		if err != nil {
 			t.Error("TestTx_LRem err")
 		}
@@ -412,7 +416,7 @@ func TestTx_LRem3(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err := tx.LRem(bucket, []byte("myList3"), 0, []byte("b"))
+	_, err := tx.LRem(bucket, []byte("myList3"), 0, []byte("b"))
 	if err != nil {
 		t.Error("TestTx_LRem err")
 	}
@@ -458,7 +462,7 @@ func TestTx_LSet(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = tx.LSet(bucket, key, -1, []byte("a1"))
+	err = tx.LSet(bucket, key, -4, []byte("a1"))
 	if err == nil {
 		t.Error("TestTx_LSet err")
 	}
@@ -680,7 +684,7 @@ func TestTx_LRemByIndex(t *testing.T) {
 	bucket := "myBucket"
 	key := []byte("myList")
 
-	err := tx.LRemByIndex(bucket, []byte("fake_key"))
+	_, err := tx.LRemByIndex(bucket, []byte("fake_key"))
 	assertions.NoError(err)
 	tx.Rollback()
 
@@ -688,20 +692,22 @@ func TestTx_LRemByIndex(t *testing.T) {
 
 	tx, _ = db.Begin(true)
 
-	err = tx.LRemByIndex(bucket, key, 1, 0, 8, -8)
+	_, err = tx.LRemByIndex(bucket, key, 1, 0, 8, -8)
 	assertions.NoError(err, "TestTx_LRemByIndex")
 
-	err = tx.LRemByIndex(bucket, key, 88, -88)
+	_, err = tx.LRemByIndex(bucket, key, 88, -88)
 	assertions.NoError(err, "TestTx_LRemByIndex")
 
 	tx.Commit()
 
-	err = tx.LRemByIndex(bucket, key, 1, 0, 8, -8)
+	_, err = tx.LRemByIndex(bucket, key, 1, 0, 8, -8)
 	assertions.Error(err, "TestTx_LRemByIndex")
 }
 
 func TestTx_ExpireList(t *testing.T) {
 	InitForList()
+	clock := NewFakeClock(time.Now())
+	opt.Clock = clock
 	assertions := assert.New(t)
 	db, err = Open(opt)
 	assertions.NoError(err, "TestTx_ExpireList")
@@ -725,7 +731,7 @@ func TestTx_ExpireList(t *testing.T) {
 	}
 	tx.Commit()
 
-	time.Sleep(time.Second)
+	clock.Add(time.Second)
 
 	tx, _ = db.Begin(false)
 	_, err = tx.LRange(bucket, key, 0, -1)
@@ -747,7 +753,7 @@ func TestTx_ExpireList(t *testing.T) {
 	}
 	tx.Commit()
 
-	time.Sleep(time.Second)
+	clock.Add(time.Second)
 
 	tx, _ = db.Begin(true)
 	_, err = tx.LRange(bucket, key, 0, -1)
@@ -896,3 +902,1204 @@ func TestTx_ListEntryIdxMode_HintKeyAndRAMIdxMode(t *testing.T) {
 		require.Equal(t, []byte("a"), val)
 	})
 }
+
+func TestTx_LRemCount(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		}))
+		return items
+	}
+
+	lRem := func(t *testing.T, db *DB, count int, value string) (removed int) {
+		require.NoError(t, db.Update(func(tx *Tx) (err error) {
+			removed, err = tx.LRem(bucket, key, count, []byte(value))
+			return err
+		}))
+		return removed
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			values := []string{"a", "a", "b", "a", "c", "a", "a", "b|c"}
+			for _, value := range values {
+				if err := tx.RPush(bucket, key, []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		require.Equal(t, 2, lRem(t, db, 2, "a"))
+		require.Equal(t, []string{"b", "a", "c", "a", "a", "b|c"}, lRange(t, db))
+
+		require.Equal(t, 2, lRem(t, db, -2, "a"))
+		require.Equal(t, []string{"b", "a", "c", "b|c"}, lRange(t, db))
+
+		require.Equal(t, 0, lRem(t, db, 1, "d"))
+		require.Equal(t, 1, lRem(t, db, 0, "b|c"))
+		require.Equal(t, 1, lRem(t, db, 0, "a"))
+		require.Equal(t, []string{"b", "c"}, lRange(t, db))
+
+		// reopen to rebuild the list from the data files.
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "c"}, lRange(t, db))
+
+		require.Equal(t, 1, lRem(t, db, 1, "b"))
+		require.Equal(t, 1, lRem(t, db, -1, "c"))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			size, err := tx.LSize(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, size)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Empty(t, lRange(t, db))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LTrimRange(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		}))
+		return items
+	}
+
+	lTrim := func(db *DB, key []byte, start, end int) error {
+		return db.Update(func(tx *Tx) error {
+			return tx.LTrim(bucket, key, start, end)
+		})
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"))
+		}))
+
+		require.NoError(t, lTrim(db, key, 1, -1))
+		require.Equal(t, []string{"b", "c", "d", "e"}, lRange(t, db))
+		require.NoError(t, lTrim(db, key, -3, 1))
+		require.Equal(t, []string{"c"}, lRange(t, db))
+
+		// reopen to replay the trims.
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, lRange(t, db))
+
+		require.NoError(t, lTrim(db, key, 5, 10))
+		require.Empty(t, lRange(t, db))
+
+		require.True(t, errors.Is(lTrim(db, GetTestBytes(1), 0, -1), ErrKeyNotFound))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.RPush(bucket, GetTestBytes(2), []byte("a")); err != nil {
+				return err
+			}
+			return tx.ExpireList(bucket, GetTestBytes(2), 1)
+		}))
+		time.Sleep(1100 * time.Millisecond)
+		require.True(t, errors.Is(lTrim(db, GetTestBytes(2), 0, -1), ErrKeyNotFound))
+
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Empty(t, lRange(t, db))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LSetIndex(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		}))
+		return items
+	}
+
+	lSet := func(db *DB, key []byte, index int, value string) error {
+		return db.Update(func(tx *Tx) error {
+			return tx.LSet(bucket, key, index, []byte(value))
+		})
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+		}))
+
+		require.NoError(t, lSet(db, key, 0, "head"))
+		require.NoError(t, lSet(db, key, -1, "tail"))
+		require.NoError(t, lSet(db, key, 1, "middle"))
+		require.NoError(t, lSet(db, key, -2, "c|d"))
+		require.Equal(t, []string{"head", "middle", "c|d", "tail"}, lRange(t, db))
+
+		require.True(t, errors.Is(lSet(db, key, 4, "x"), ErrIndexOutOfRange))
+		require.True(t, errors.Is(lSet(db, key, -5, "x"), ErrIndexOutOfRange))
+		require.True(t, errors.Is(lSet(db, GetTestBytes(1), 0, "x"), ErrListNotFound))
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"head", "middle", "c|d", "tail"}, lRange(t, db))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LInsert(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		}))
+		return items
+	}
+
+	lInsert := func(db *DB, key []byte, after bool, pivot, value string) error {
+		return db.Update(func(tx *Tx) error {
+			if after {
+				return tx.LInsertAfter(bucket, key, []byte(pivot), []byte(value))
+			}
+			return tx.LInsertBefore(bucket, key, []byte(pivot), []byte(value))
+		})
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.SegmentSize = 8 * KB
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("a"), []byte("c"))
+		}))
+
+		// only the first pivot is used.
+		require.NoError(t, lInsert(db, key, false, "a", "x"))
+		require.NoError(t, lInsert(db, key, true, "a", "y"))
+		require.Equal(t, []string{"x", "a", "y", "b", "a", "c"}, lRange(t, db))
+
+		require.NoError(t, lInsert(db, key, true, "c", "tail"))
+		require.NoError(t, lInsert(db, key, false, "x", "head"))
+		require.Equal(t, []string{"head", "x", "a", "y", "b", "a", "c", "tail"}, lRange(t, db))
+
+		require.True(t, errors.Is(lInsert(db, key, true, "d", "z"), ErrPivotNotFound))
+		require.True(t, errors.Is(lInsert(db, GetTestBytes(1), true, "a", "z"), ErrListNotFound))
+
+		// the list spans several data files before the inserts.
+		longKey := GetTestBytes(2)
+		var want []string
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 40; i++ {
+				value := append(GetTestBytes(i), make([]byte, 512)...)
+				want = append(want, string(value))
+				if err := tx.RPush(bucket, longKey, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, lInsert(db, longKey, true, want[39], "tail"))
+		require.NoError(t, lInsert(db, longKey, false, want[20], "middle"))
+		want = append(want[:20], append([]string{"middle"}, append(want[20:], "tail")...)...)
+		require.Greater(t, db.MaxFileID, int64(1))
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"head", "x", "a", "y", "b", "a", "c", "tail"}, lRange(t, db))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, longKey, 0, -1)
+			if assert.NoError(t, err) && assert.Len(t, values, len(want)) {
+				for i := range want {
+					assert.Equal(t, want[i], string(values[i]))
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LLen(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"))
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			size, err := tx.LLen(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, size)
+
+			size, err = tx.LLen(bucket, GetTestBytes(1))
+			assert.Equal(t, ErrListNotFound, err)
+			assert.Equal(t, 0, size)
+			return nil
+		}))
+	})
+}
+
+func BenchmarkTx_LSize(b *testing.B) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyAndRAMIdxMode
+	db, err := Open(opt)
+	require.NoError(b, err)
+	defer func() {
+		require.NoError(b, db.Close())
+		require.NoError(b, os.RemoveAll(opt.Dir))
+	}()
+
+	require.NoError(b, db.Update(func(tx *Tx) error {
+		for i := 0; i < 100000; i++ {
+			if err := tx.RPush(bucket, key, GetTestBytes(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	b.Run("LSize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = db.View(func(tx *Tx) error {
+				_, err := tx.LSize(bucket, key)
+				return err
+			})
+		}
+	})
+
+	b.Run("LRange", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = db.View(func(tx *Tx) error {
+				values, err := tx.LRange(bucket, key, 0, -1)
+				_ = len(values)
+				return err
+			})
+		}
+	})
+}
+
+func TestTx_LMove(t *testing.T) {
+	bucket := "bucket"
+	src, dst := []byte("pending"), []byte("processing")
+
+	lRange := func(t *testing.T, db *DB, key []byte) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			if err == ErrListNotFound {
+				return nil
+			}
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		}))
+		return items
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, src, []byte("a"), []byte("b"), []byte("c"))
+		}))
+
+		var item []byte
+		require.NoError(t, db.Update(func(tx *Tx) (err error) {
+			item, err = tx.RPopLPush(bucket, src, dst)
+			return err
+		}))
+		require.Equal(t, []byte("c"), item)
+		require.Equal(t, []string{"a", "b"}, lRange(t, db, src))
+		require.Equal(t, []string{"c"}, lRange(t, db, dst))
+
+		require.NoError(t, db.Update(func(tx *Tx) (err error) {
+			item, err = tx.LMove(bucket, src, dst, ListLeft, ListRight)
+			return err
+		}))
+		require.Equal(t, []byte("a"), item)
+
+		// the same list is rotated.
+		require.NoError(t, db.Update(func(tx *Tx) (err error) {
+			_, err = tx.RPopLPush(bucket, dst, dst)
+			return err
+		}))
+		require.Equal(t, []string{"a", "c"}, lRange(t, db, dst))
+
+		// reopen to check that each element is in exactly one list.
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, lRange(t, db, src))
+		require.Equal(t, []string{"a", "c"}, lRange(t, db, dst))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			_, err := tx.RPopLPush(bucket, src, dst)
+			return err
+		}))
+		err = db.Update(func(tx *Tx) error {
+			_, err := tx.RPopLPush(bucket, src, dst)
+			return err
+		})
+		require.True(t, errors.Is(err, ErrListEmpty))
+		err = db.Update(func(tx *Tx) error {
+			_, err := tx.RPopLPush(bucket, []byte("none"), dst)
+			return err
+		})
+		require.True(t, errors.Is(err, ErrListNotFound))
+		require.Equal(t, []string{"b", "a", "c"}, lRange(t, db, dst))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LPeekAndRPeek(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	peek := func(t *testing.T, db *DB, key []byte) (head, tail []byte, size int, headErr, tailErr error) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			head, headErr = tx.LPeek(bucket, key)
+			tail, tailErr = tx.RPeek(bucket, key)
+			size, _ = tx.LSize(bucket, key)
+			return nil
+		}))
+		return
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		opts := DefaultOptions
+		opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opts.EntryIdxMode = mode
+		withDBOption(t, opts, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				if err := tx.RPush(bucket, key, []byte("b"), []byte("c"), []byte("d")); err != nil {
+					return err
+				}
+				return tx.LPush(bucket, key, []byte("a"))
+			}))
+
+			for i := 0; i < 2; i++ {
+				head, tail, size, headErr, tailErr := peek(t, db, key)
+				require.NoError(t, headErr)
+				require.NoError(t, tailErr)
+				require.Equal(t, []byte("a"), head)
+				require.Equal(t, []byte("d"), tail)
+				require.Equal(t, 4, size)
+			}
+
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				if _, err := tx.LPop(bucket, key); err != nil {
+					return err
+				}
+				_, err := tx.RPop(bucket, key)
+				return err
+			}))
+			head, tail, size, _, _ := peek(t, db, key)
+			require.Equal(t, []byte("b"), head)
+			require.Equal(t, []byte("c"), tail)
+			require.Equal(t, 2, size)
+
+			for i := 0; i < 2; i++ {
+				require.NoError(t, db.Update(func(tx *Tx) error {
+					_, err := tx.LPop(bucket, key)
+					return err
+				}))
+			}
+			_, _, _, headErr, tailErr := peek(t, db, key)
+			require.Equal(t, ErrListEmpty, headErr)
+			require.Equal(t, ErrListEmpty, tailErr)
+
+			_, _, _, headErr, tailErr = peek(t, db, GetTestBytes(2))
+			require.Equal(t, ErrListNotFound, headErr)
+			require.Equal(t, ErrListNotFound, tailErr)
+		})
+	}
+}
+
+func TestTx_PushMultipleValues(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		tx, err := db.Begin(true)
+		require.NoError(t, err)
+		// the value too big for a segment fails the whole call.
+		require.Equal(t, ErrDataSizeExceed, tx.RPush(bucket, key, []byte("x"), make([]byte, 8*KB), []byte("y")))
+		require.NoError(t, tx.RPush(bucket, key, []byte("d"), []byte("e")))
+		require.NoError(t, tx.LPush(bucket, key, []byte("a"), []byte("b"), []byte("c")))
+		require.NoError(t, tx.Commit())
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("c"), []byte("b"), []byte("a"), []byte("d"), []byte("e")}, values)
+			return nil
+		}))
+	})
+}
+
+func BenchmarkTx_Push(b *testing.B) {
+	values := make([][]byte, 10000)
+	for i := range values {
+		values[i] = GetTestBytes(i)
+	}
+
+	bench := func(b *testing.B, push func(tx *Tx, key []byte) error) {
+		opt := DefaultOptions
+		opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+		db, err := Open(opt)
+		require.NoError(b, err)
+		defer func() {
+			require.NoError(b, db.Close())
+			require.NoError(b, os.RemoveAll(opt.Dir))
+		}()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			key := GetTestBytes(i)
+			require.NoError(b, db.Update(func(tx *Tx) error {
+				return push(tx, key)
+			}))
+		}
+	}
+
+	b.Run("variadic", func(b *testing.B) {
+		bench(b, func(tx *Tx, key []byte) error {
+			return tx.RPush("bucket", key, values...)
+		})
+	})
+
+	b.Run("loop", func(b *testing.B) {
+		bench(b, func(tx *Tx, key []byte) error {
+			for _, value := range values {
+				if err := tx.RPush("bucket", key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func TestTx_ExpireListLifetime(t *testing.T) {
+	bucket := "bucket"
+	expiring, refreshed, later, pushedAgain := GetTestBytes(0), GetTestBytes(1), GetTestBytes(2), GetTestBytes(3)
+
+	opts := DefaultOptions
+	opts.Dir = "/tmp/test-nutsdb-expire-list"
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// the ttl counts from the second of the timestamp, start right after it so the expiry times are exact.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	lRange := func(key []byte) (items []string, err error) {
+		err = db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return err
+		})
+		return items, err
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range [][]byte{expiring, refreshed, later, pushedAgain} {
+			if err := tx.RPush(bucket, key, []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+		}
+		if err := tx.ExpireList(bucket, expiring, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireList(bucket, pushedAgain, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireList(bucket, later, 2); err != nil {
+			return err
+		}
+		return tx.ExpireList(bucket, refreshed, 1)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.ExpireList(bucket, refreshed, 100)
+	}))
+
+	// right before the expiry the lists are kept across the restart.
+	reopen()
+	for _, key := range [][]byte{expiring, refreshed, later, pushedAgain} {
+		items, err := lRange(key)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, items)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.LPop(bucket, expiring)
+		assert.Equal(t, ErrListNotFound, err)
+		_, err = tx.LSize(bucket, expiring)
+		assert.Equal(t, ErrListNotFound, err)
+		// the push starts a fresh list without the expired elements.
+		return tx.RPush(bucket, pushedAgain, []byte("c"))
+	}))
+	_, err = lRange(expiring)
+	require.True(t, errors.Is(err, ErrListNotFound))
+
+	check := func() {
+		items, err := lRange(refreshed)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, items)
+
+		items, err = lRange(pushedAgain)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c"}, items)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			ttl, err := tx.GetListTTL(bucket, pushedAgain)
+			assert.NoError(t, err)
+			assert.Equal(t, Persistent, ttl)
+			return nil
+		}))
+	}
+	check()
+
+	// right after the expiry the expired lists stay gone across the restart.
+	reopen()
+	check()
+	_, err = lRange(expiring)
+	require.True(t, errors.Is(err, ErrListNotFound))
+
+	items, err := lRange(later)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, items)
+
+	time.Sleep(time.Second)
+	reopen()
+	_, err = lRange(later)
+	require.True(t, errors.Is(err, ErrListNotFound))
+}
+
+func TestTx_LGet(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 8 * KB
+	opts.EntryIdxMode = HintKeyAndRAMIdxMode
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		const n = 500
+		for i := 0; i < n; i++ {
+			txPush(t, db, bucket, key, GetTestBytes(i), nil, false)
+		}
+		// the list spans many data files, so the elements are read back from the older ones.
+		_, fileIDs := db.getMaxFileIDAndFileIDs()
+		require.Greater(t, len(fileIDs), 2)
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for _, index := range []int{0, 1, 100, 250, n - 1} {
+				value, err := tx.LGet(bucket, key, index)
+				assert.NoError(t, err)
+				assert.Equal(t, GetTestBytes(index), value)
+
+				value, err = tx.LGet(bucket, key, index-n)
+				assert.NoError(t, err)
+				assert.Equal(t, GetTestBytes(index), value)
+			}
+
+			_, err := tx.LGet(bucket, key, n)
+			assert.Equal(t, ErrIndexOutOfRange, err)
+			_, err = tx.LGet(bucket, key, -n-1)
+			assert.Equal(t, ErrIndexOutOfRange, err)
+			_, err = tx.LGet(bucket, GetTestBytes(1), 0)
+			assert.Equal(t, ErrListNotFound, err)
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ExpireList(bucket, key, 1)
+		}))
+		time.Sleep(1100 * time.Millisecond)
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.LGet(bucket, key, 0)
+			assert.Equal(t, ErrListNotFound, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_LRemByIndexPositions(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return nil
+		}))
+		return items
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f"))
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			// the head, the tail twice and the middle, the out of range indexes are ignored.
+			removed, err := tx.LRemByIndex(bucket, key, 0, -1, 5, 3, 3, 6, -7)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, removed)
+
+			removed, err = tx.LRemByIndex(bucket, key, 100)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, removed)
+
+			_, err = tx.LRemByIndex(bucket, GetTestBytes(1), 0)
+			assert.Equal(t, ErrListNotFound, err)
+			return nil
+		}))
+		require.Equal(t, []string{"b", "c", "e"}, lRange(t, db))
+
+		require.NoError(t, db.Close())
+		var err error
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "c", "e"}, lRange(t, db))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LKeysPattern(t *testing.T) {
+	bucket := "bucket"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		lKeys := func(pattern string, limit int) (keys []string, err error) {
+			err = db.View(func(tx *Tx) error {
+				return tx.LKeys(bucket, pattern, func(key string) bool {
+					keys = append(keys, key)
+					return len(keys) != limit
+				})
+			})
+			sort.Strings(keys)
+			return keys, err
+		}
+
+		// the malformed pattern is returned even if there is no list to match.
+		_, err := lKeys("jobs:[", 0)
+		require.True(t, errors.Is(err, filepath.ErrBadPattern))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, key := range []string{"jobs:a", "jobs:b", "jobs:cc", "other", "jobs:x"} {
+				if err := tx.RPush(bucket, []byte(key), []byte("job")); err != nil {
+					return err
+				}
+			}
+			return tx.ExpireList(bucket, []byte("jobs:x"), 1)
+		}))
+		time.Sleep(1100 * time.Millisecond)
+
+		keys, err := lKeys("none*", 0)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+
+		keys, err = lKeys("*", 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"jobs:a", "jobs:b", "jobs:cc", "other"}, keys)
+
+		keys, err = lKeys("jobs:?", 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"jobs:a", "jobs:b"}, keys)
+
+		keys, err = lKeys("jobs:*", 2)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		_, err = lKeys("jobs:[", 0)
+		require.True(t, errors.Is(err, filepath.ErrBadPattern))
+	})
+}
+
+func TestTx_LRangeBounds(t *testing.T) {
+	bucket := "bucket"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.RPush(bucket, GetTestBytes(0), []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")); err != nil {
+				return err
+			}
+			return tx.RPush(bucket, GetTestBytes(1), []byte("a"))
+		}))
+
+		tests := []struct {
+			key        []byte
+			start, end int
+			want       []string
+		}{
+			{GetTestBytes(0), 0, -1, []string{"a", "b", "c", "d", "e"}},
+			{GetTestBytes(0), -2, -1, []string{"d", "e"}},
+			{GetTestBytes(0), -3, 3, []string{"c", "d"}},
+			{GetTestBytes(0), 1, -2, []string{"b", "c", "d"}},
+			{GetTestBytes(0), -100, 1, []string{"a", "b"}},
+			{GetTestBytes(0), -100, 100, []string{"a", "b", "c", "d", "e"}},
+			{GetTestBytes(0), 3, 100, []string{"d", "e"}},
+			{GetTestBytes(0), -1, 0, []string{}},
+			{GetTestBytes(0), 3, 1, []string{}},
+			{GetTestBytes(0), 5, 10, []string{}},
+			{GetTestBytes(0), 0, -6, []string{}},
+			{GetTestBytes(1), 0, 0, []string{"a"}},
+			{GetTestBytes(1), -1, -1, []string{"a"}},
+			{GetTestBytes(1), -5, 5, []string{"a"}},
+			{GetTestBytes(1), 1, -1, []string{}},
+			{GetTestBytes(1), 0, -2, []string{}},
+		}
+
+		for _, tt := range tests {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				values, err := tx.LRange(bucket, tt.key, tt.start, tt.end)
+				assert.NoError(t, err, "[%d, %d]", tt.start, tt.end)
+				items := []string{}
+				for _, value := range values {
+					items = append(items, string(value))
+				}
+				assert.Equal(t, tt.want, items, "[%d, %d]", tt.start, tt.end)
+				return nil
+			}))
+		}
+	})
+}
+
+func TestTx_ListOrderAcrossRestart(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return nil
+		}))
+		return items
+	}
+
+	// run applies the operations from, ..., to-1 to the list and to want, which follows them on a slice.
+	run := func(t *testing.T, db *DB, from, to int, want []string) []string {
+		for i := from; i < to; i++ {
+			a, b := fmt.Sprintf("%d", i), fmt.Sprintf("%d+", i)
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				switch i % 7 {
+				case 0, 3:
+					want = append(want, a)
+					return tx.RPush(bucket, key, []byte(a))
+				case 1:
+					want = append([]string{a}, want...)
+					return tx.LPush(bucket, key, []byte(a))
+				case 2:
+					item, err := tx.LPop(bucket, key)
+					assert.Equal(t, want[0], string(item))
+					want = want[1:]
+					return err
+				case 4:
+					item, err := tx.RPop(bucket, key)
+					assert.Equal(t, want[len(want)-1], string(item))
+					want = want[:len(want)-1]
+					return err
+				case 5:
+					want = append(append([]string{b, a}, want...), a, b)
+					if err := tx.LPush(bucket, key, []byte(a), []byte(b)); err != nil {
+						return err
+					}
+					return tx.RPush(bucket, key, []byte(a), []byte(b))
+				default:
+					items, err := tx.RPopN(bucket, key, 2)
+					assert.Equal(t, [][]byte{[]byte(want[len(want)-1]), []byte(want[len(want)-2])}, items)
+					want = want[:len(want)-2]
+					return err
+				}
+			}))
+		}
+		return want
+	}
+
+	// seqs returns how many elements have a sequence number, and checks they are in the order of the list.
+	seqs := func(t *testing.T, db *DB) int {
+		n, last := 0, int64(0)
+		it := db.Index.getList(bucket).Items[string(key)].Iterator()
+		for it.Next() {
+			seq := it.Value().(*Record).seq
+			if seq == 0 {
+				continue
+			}
+			if n > 0 {
+				assert.True(t, seq > last, "%d after %d", seq, last)
+			}
+			n, last = n+1, seq
+		}
+		return n
+	}
+
+	reopen := func(t *testing.T, db *DB, opts Options) *DB {
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		return db
+	}
+
+	newOptions := func(format ListFormat) Options {
+		opts := DefaultOptions
+		opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+		opts.SegmentSize = 8 * KB
+		opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+		opts.ListFormat = format
+		return opts
+	}
+
+	t.Run("index", func(t *testing.T) {
+		withDBOption(t, newOptions(ListFormatIndex), func(t *testing.T, db *DB) {
+			want := run(t, db, 0, 300, nil)
+			require.Equal(t, want, lRange(t, db))
+
+			db = reopen(t, db, db.opt)
+			require.Equal(t, want, lRange(t, db))
+			require.Equal(t, 0, seqs(t, db))
+			require.NoError(t, db.Close())
+		})
+	})
+
+	t.Run("seq", func(t *testing.T) {
+		withDBOption(t, newOptions(ListFormatSeq), func(t *testing.T, db *DB) {
+			want := run(t, db, 0, 300, nil)
+			require.Equal(t, want, lRange(t, db))
+
+			db = reopen(t, db, db.opt)
+			require.Equal(t, want, lRange(t, db))
+			require.Equal(t, len(want), seqs(t, db))
+
+			// the pushes after the restart go on from the sequence numbers replayed.
+			want = run(t, db, 300, 400, want)
+			require.Equal(t, want, lRange(t, db))
+			require.Equal(t, len(want), seqs(t, db))
+
+			// merge rewrites the elements with their sequence numbers.
+			require.NoError(t, db.Merge())
+			db = reopen(t, db, db.opt)
+			require.Equal(t, want, lRange(t, db))
+			require.Equal(t, len(want), seqs(t, db))
+
+			want = run(t, db, 400, 500, want)
+			db = reopen(t, db, db.opt)
+			require.Equal(t, want, lRange(t, db))
+			require.Equal(t, len(want), seqs(t, db))
+			require.NoError(t, db.Close())
+		})
+	})
+
+	t.Run("index to seq", func(t *testing.T) {
+		withDBOption(t, newOptions(ListFormatIndex), func(t *testing.T, db *DB) {
+			opts := db.opt
+			want := run(t, db, 0, 150, nil)
+
+			opts.ListFormat = ListFormatSeq
+			db = reopen(t, db, opts)
+			require.Equal(t, want, lRange(t, db))
+			want = run(t, db, 150, 300, want)
+			require.Equal(t, want, lRange(t, db))
+
+			db = reopen(t, db, opts)
+			require.Equal(t, want, lRange(t, db))
+			require.True(t, seqs(t, db) > 0)
+
+			// the records of both formats are read whatever the option is.
+			opts.ListFormat = ListFormatIndex
+			db = reopen(t, db, opts)
+			require.Equal(t, want, lRange(t, db))
+			want = run(t, db, 300, 400, want)
+			db = reopen(t, db, opts)
+			require.Equal(t, want, lRange(t, db))
+			require.NoError(t, db.Close())
+		})
+	})
+}
+
+func BenchmarkDB_OpenLargeList(b *testing.B) {
+	for _, format := range []struct {
+		name   string
+		format ListFormat
+	}{{"index", ListFormatIndex}, {"seq", ListFormatSeq}} {
+		b.Run(format.name, func(b *testing.B) {
+			opt := DefaultOptions
+			opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+			opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+			opt.ListFormat = format.format
+			defer os.RemoveAll(opt.Dir)
+
+			db, err := Open(opt)
+			require.NoError(b, err)
+			const size, batch = 1000000, 10000
+			for n := 0; n < size; n += batch {
+				require.NoError(b, db.Update(func(tx *Tx) error {
+					for i := n; i < n+batch; i++ {
+						push := tx.RPush
+						if i%2 == 1 {
+							push = tx.LPush
+						}
+						if err := push("bucket", []byte("list"), GetTestBytes(i)); err != nil {
+							return err
+						}
+					}
+					return nil
+				}))
+			}
+			require.NoError(b, db.Close())
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db, err := Open(opt)
+				require.NoError(b, err)
+				require.NoError(b, db.Close())
+			}
+		})
+	}
+}
+
+func TestTx_PopN(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	popN := func(t *testing.T, db *DB, pop func(tx *Tx) ([][]byte, error)) (items []string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			values, err := pop(tx)
+			if err != nil {
+				return err
+			}
+			assert.NotNil(t, values)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return nil
+		}))
+		return items
+	}
+
+	lRange := func(t *testing.T, db *DB) (items []string) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			for _, value := range values {
+				items = append(items, string(value))
+			}
+			return nil
+		}))
+		return items
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"), []byte("f"))
+		}))
+
+		require.Empty(t, popN(t, db, func(tx *Tx) ([][]byte, error) {
+			return tx.LPopN(bucket, key, 0)
+		}))
+		require.Equal(t, []string{"a", "b"}, popN(t, db, func(tx *Tx) ([][]byte, error) {
+			return tx.LPopN(bucket, key, 2)
+		}))
+		require.Equal(t, []string{"f", "e"}, popN(t, db, func(tx *Tx) ([][]byte, error) {
+			return tx.RPopN(bucket, key, 2)
+		}))
+		require.Equal(t, []string{"c", "d"}, lRange(t, db))
+
+		// the pops of a failed tx are all rolled back.
+		require.Error(t, db.Update(func(tx *Tx) error {
+			if _, err := tx.LPopN(bucket, key, 1); err != nil {
+				return err
+			}
+			return errors.New("abort")
+		}))
+		require.Equal(t, []string{"c", "d"}, lRange(t, db))
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c", "d"}, lRange(t, db))
+
+		require.Equal(t, []string{"d", "c"}, popN(t, db, func(tx *Tx) ([][]byte, error) {
+			return tx.RPopN(bucket, key, 10)
+		}))
+		require.Empty(t, popN(t, db, func(tx *Tx) ([][]byte, error) {
+			return tx.LPopN(bucket, key, 10)
+		}))
+
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Empty(t, lRange(t, db))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.LPopN(bucket, GetTestBytes(1), 1)
+			assert.Equal(t, ErrListNotFound, err)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_LCap(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 64 * KB
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	// want returns the values from start to end.
+	want := func(start, end int) (values [][]byte) {
+		for i := start; i < end; i++ {
+			values = append(values, GetTestBytes(i))
+		}
+		return values
+	}
+
+	lRange := func(t *testing.T, db *DB) (values [][]byte) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			values, err = tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			return nil
+		}))
+		return values
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			assert.Equal(t, ErrListCap, tx.LCap(bucket, key, -1))
+			return tx.LCap(bucket, key, 1000)
+		}))
+		for n := 0; n < 5000; n += 500 {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+500; i++ {
+					if err := tx.RPush(bucket, key, GetTestBytes(i)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		require.Equal(t, want(4000, 5000), lRange(t, db))
+
+		// the cap is applied again while replaying the pushes.
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, want(4000, 5000), lRange(t, db))
+
+		// the capped list evicts the tail on LPush.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.LPush(bucket, key, GetTestBytes(3999))
+		}))
+		require.Equal(t, want(3999, 4999), lRange(t, db))
+
+		// a smaller cap trims the head, the cap is rewritten by merge.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.LCap(bucket, key, 10)
+		}))
+		require.Equal(t, want(4989, 4999), lRange(t, db))
+		require.NoError(t, db.Merge())
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, want(4989, 4999), lRange(t, db))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, GetTestBytes(4999))
+		}))
+		require.Equal(t, want(4990, 5000), lRange(t, db))
+
+		// 0 removes the cap.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.LCap(bucket, key, 0)
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, GetTestBytes(5000))
+		}))
+		require.Equal(t, want(4990, 5001), lRange(t, db))
+		require.NoError(t, db.Close())
+	})
+}
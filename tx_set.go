@@ -15,49 +15,43 @@
 package nutsdb
 
 import (
-	"time"
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
 
 	"github.com/pkg/errors"
+	"github.com/xujiajun/utils/strconv2"
 )
 
+// sPut queues a record for each of the values, the set members already in the set or earlier in the values
+// are skipped when adding. The values are queued all or none, a value failing the validation queues nothing.
 func (tx *Tx) sPut(bucket string, key []byte, dataFlag uint16, values ...[]byte) error {
-
 	if dataFlag == DataSetFlag {
-
-		filter := make(map[uint32]struct{})
-
+		var members *setMembers
 		if set, ok := tx.db.SetIdx[bucket]; ok {
-
-			if _, ok := set.M[string(key)]; ok {
-				for hash := range set.M[string(key)] {
-					filter[hash] = struct{}{}
-				}
-			}
-
+			members, _ = set.members(string(key))
 		}
 
+		// the values of the call are told apart like the members of a set.
+		seen := newSetMembers()
+		added := make([][]byte, 0, len(values))
 		for _, value := range values {
-			hash, err := getFnv32(value)
-			if err != nil {
-				return err
-			}
-			if _, ok := filter[hash]; !ok {
-				filter[hash] = struct{}{}
-				err := tx.put(bucket, key, value, Persistent, dataFlag, uint64(time.Now().Unix()), DataStructureSet)
-				if err != nil {
-					return err
-				}
+			hash := memberHash(value)
+			if members.get(hash, value) != nil || seen.get(hash, value) != nil {
+				continue
 			}
+			seen.add(hash, value, NewRecord().WithEntry(NewEntry().WithValue(value)))
+			added = append(added, value)
 		}
+		values = added
+	}
 
-	} else {
-		for _, value := range values {
-
-			err := tx.put(bucket, key, value, Persistent, dataFlag, uint64(time.Now().Unix()), DataStructureSet)
-			if err != nil {
-				return err
-			}
-
+	queued, timestamp := len(tx.pendingWrites), tx.nowSeconds()
+	for _, value := range values {
+		err := tx.put(bucket, key, value, Persistent, dataFlag, timestamp, DataStructureSet)
+		if err != nil {
+			tx.pendingWrites = tx.pendingWrites[:queued]
+			return err
 		}
 	}
 
@@ -65,6 +59,7 @@ func (tx *Tx) sPut(bucket string, key []byte, dataFlag uint16, values ...[]byte)
 }
 
 // SAdd adds the specified members to the set stored int the bucket at given bucket,key and items.
+// The members already in the set or repeated in items are written once, and if any member is invalid none is added.
 func (tx *Tx) SAdd(bucket string, key []byte, items ...[]byte) error {
 	return tx.sPut(bucket, key, DataSetFlag, items...)
 }
@@ -76,9 +71,10 @@ func (tx *Tx) SRem(bucket string, key []byte, items ...[]byte) error {
 
 // SAreMembers returns if the specified members are the member of the set int the bucket at given bucket,key and items.
 func (tx *Tx) SAreMembers(bucket string, key []byte, items ...[]byte) (bool, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return false, err
 	}
+	defer tx.endOp()
 
 	if sets, ok := tx.db.SetIdx[bucket]; ok {
 		return sets.SAreMembers(string(key), items...)
@@ -89,9 +85,10 @@ func (tx *Tx) SAreMembers(bucket string, key []byte, items ...[]byte) (bool, err
 
 // SIsMember returns if member is a member of the set stored int the bucket at given bucket,key and item.
 func (tx *Tx) SIsMember(bucket string, key, item []byte) (bool, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return false, err
 	}
+	defer tx.endOp()
 
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		isMember, err := set.SIsMember(string(key), item)
@@ -104,36 +101,46 @@ func (tx *Tx) SIsMember(bucket string, key, item []byte) (bool, error) {
 	return false, ErrBucketNotFound
 }
 
-// SMembers returns all the members of the set value stored int the bucket at given bucket and key.
+// SMembers returns all the members of the set value stored in the bucket at given bucket and key, in no particular order.
+// It returns ErrBucketNotFound if there is no set in the bucket and ErrKeyNotFound if there is no set at the key,
+// the set whose members are all removed has no members.
 func (tx *Tx) SMembers(bucket string, key []byte) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
-		items, err := set.SMembers(string(key))
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketAndKey(bucket, key)
+	}
+	if !set.SHasKey(string(key)) {
+		return nil, ErrNotFoundKeyInBucket(bucket, key)
+	}
+
+	items, err := set.SMembers(string(key))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		value, err := tx.db.getValueByRecord(item)
 		if err != nil {
 			return nil, err
 		}
-		values := make([][]byte, len(items))
-		for i, item := range items {
-			value, err := tx.db.getValueByRecord(item)
-			if err != nil {
-				return nil, err
-			}
-			values[i] = value
-		}
+		values[i] = value
 	}
 
-	return nil, ErrBucketNotFound
-
+	return values, nil
 }
 
 // SHasKey returns if the set in the bucket at given bucket and key.
 func (tx *Tx) SHasKey(bucket string, key []byte) (bool, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return false, err
 	}
+	defer tx.endOp()
 
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SHasKey(string(key)), nil
@@ -142,48 +149,155 @@ func (tx *Tx) SHasKey(bucket string, key []byte) (bool, error) {
 	return false, ErrBucketNotFound
 }
 
-// SPop removes and returns one or more random elements from the set value store in the bucket at given bucket and key.
+// SPop removes and returns a random member of the set value stored in the bucket at given bucket and key.
+// It returns ErrBucketNotFound if there is no set in the bucket, ErrKeyNotFound if there is no set at the key
+// and ErrSetEmpty if the set has no members.
 func (tx *Tx) SPop(bucket string, key []byte) ([]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	values, err := tx.SPopN(bucket, key, 1)
+	if err != nil {
 		return nil, err
 	}
+	if len(values) == 0 {
+		return nil, ErrSetEmpty
+	}
 
-	if _, ok := tx.db.SetIdx[bucket]; ok {
-		for _, items := range tx.db.SetIdx[bucket].M[string(key)] {
-			value, err := tx.db.getValueByRecord(items)
-			if err != nil {
-				return nil, err
-			}
-			err = tx.sPut(bucket, key, DataDeleteFlag, value)
-			if err != nil {
-				return nil, err
-			}
-			return value, err
+	return values[0], nil
+}
+
+// SPopN removes and returns up to n distinct random members of the set value stored in the bucket at given
+// bucket and key, fewer if the set is smaller. The members are drawn in the random iteration order of the set
+// index, and their removals are committed with the tx. The pops read the committed set, so popping the same
+// set again in the tx may return the same members.
+func (tx *Tx) SPopN(bucket string, key []byte, n int) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketAndKey(bucket, key)
+	}
+	if !set.SHasKey(string(key)) {
+		return nil, ErrNotFoundKeyInBucket(bucket, key)
+	}
+	if n <= 0 {
+		return [][]byte{}, nil
+	}
+
+	records := make([]*Record, 0, n)
+	set.M[string(key)].each(func(hash uint64, record *Record) bool {
+		records = append(records, record)
+		return len(records) < n
+	})
+
+	values, err := tx.getSetValues(records)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.sPut(bucket, key, DataDeleteFlag, values...); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// SRandMember returns random members of the set value stored in the bucket at given bucket and key without
+// removing them. A positive count returns up to count distinct members, a negative count returns -count members
+// which may repeat, and a zero count returns none. The members are sampled in the set index, so only the values
+// of the sample are read. It returns ErrBucketNotFound if there is no set in the bucket and ErrKeyNotFound if
+// there is no set at the key.
+func (tx *Tx) SRandMember(bucket string, key []byte, count int) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketAndKey(bucket, key)
+	}
+	if !set.SHasKey(string(key)) {
+		return nil, ErrNotFoundKeyInBucket(bucket, key)
+	}
+
+	return tx.getSetValues(set.randMembers(string(key), count))
+}
+
+// SScan returns up to count members of the set value stored in the bucket at given bucket and key from the
+// cursor, with the cursor to resume from. The scan starts from an empty cursor and is done when the returned
+// cursor is empty, a count not above 0 returns no members and the same cursor. The members are walked in a
+// fixed order, so the members kept for the whole scan are returned once even across transactions, while the
+// members added or removed during the scan may or may not be returned. It returns ErrBucketNotFound if there is
+// no set in the bucket, ErrKeyNotFound if there is no set at the key and ErrSetScanCursor for a bad cursor.
+func (tx *Tx) SScan(bucket string, key []byte, cursor []byte, count int) ([][]byte, []byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, nil, err
+	}
+	defer tx.endOp()
+
+	var from uint64
+	if len(cursor) > 0 {
+		if len(cursor) != 8 {
+			return nil, nil, ErrSetScanCursor
 		}
+		from = binary.BigEndian.Uint64(cursor)
 	}
 
-	return nil, ErrBucketNotFound
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, nil, ErrBucketAndKey(bucket, key)
+	}
+	if !set.SHasKey(string(key)) {
+		return nil, nil, ErrNotFoundKeyInBucket(bucket, key)
+	}
+	if count <= 0 {
+		return [][]byte{}, cursor, nil
+	}
+
+	records, next, more := set.scan(string(key), from, count)
+	values, err := tx.getSetValues(records)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nextCursor []byte
+	if more {
+		nextCursor = make([]byte, 8)
+		binary.BigEndian.PutUint64(nextCursor, next)
+	}
+
+	return values, nextCursor, nil
 }
 
-// SCard returns the set cardinality (number of elements) of the set stored in the bucket at given bucket and key.
+// SCard returns the set cardinality (number of elements) of the set stored in the bucket at given bucket and key,
+// it only reads the set index in memory. Like SMembers, it returns ErrBucketNotFound if there is no set in the
+// bucket and ErrKeyNotFound if there is no set at the key, with 0.
 func (tx *Tx) SCard(bucket string, key []byte) (int, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
-		return set.SCard(string(key)), nil
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return 0, ErrBucketAndKey(bucket, key)
+	}
+	if !set.SHasKey(string(key)) {
+		return 0, ErrNotFoundKeyInBucket(bucket, key)
 	}
 
-	return 0, ErrBucketNotFound
+	return set.SCard(string(key)), nil
 }
 
 // SDiffByOneBucket returns the members of the set resulting from the difference
 // between the first set and all the successive sets in one bucket.
 func (tx *Tx) SDiffByOneBucket(bucket string, key1, key2 []byte) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		items, err := set.SDiff(string(key1), string(key2))
@@ -207,9 +321,10 @@ func (tx *Tx) SDiffByOneBucket(bucket string, key1, key2 []byte) ([][]byte, erro
 // SDiffByTwoBuckets returns the members of the set resulting from the difference
 // between the first set and all the successive sets in two buckets.
 func (tx *Tx) SDiffByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key2 []byte) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	var (
 		set1, set2 *Set
@@ -226,14 +341,20 @@ func (tx *Tx) SDiffByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 
 	values := make([][]byte, 0)
 
-	for hash, item := range set1.M[string(key1)] {
-		if _, ok := set2.M[string(key2)][hash]; !ok {
-			value, err := tx.db.getValueByRecord(item)
-			if err != nil {
-				return nil, err
-			}
-			values = append(values, value)
+	var err error
+	set1.M[string(key1)].each(func(hash uint64, item *Record) bool {
+		if set2.M[string(key2)].get(hash, recordValue(item)) != nil {
+			return true
+		}
+		var value []byte
+		if value, err = tx.db.getValueByRecord(item); err != nil {
+			return false
 		}
+		values = append(values, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return values, nil
@@ -241,9 +362,10 @@ func (tx *Tx) SDiffByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 
 // SMoveByOneBucket moves member from the set at source to the set at destination in one bucket.
 func (tx *Tx) SMoveByOneBucket(bucket string, key1, key2, item []byte) (bool, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return false, err
 	}
+	defer tx.endOp()
 
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		return set.SMove(string(key1), string(key2), item)
@@ -254,9 +376,10 @@ func (tx *Tx) SMoveByOneBucket(bucket string, key1, key2, item []byte) (bool, er
 
 // SMoveByTwoBuckets moves member from the set at source to the set at destination in two buckets.
 func (tx *Tx) SMoveByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key2, item []byte) (bool, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return false, err
 	}
+	defer tx.endOp()
 
 	var (
 		set1, set2 *Set
@@ -279,20 +402,12 @@ func (tx *Tx) SMoveByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 		return false, ErrNotFoundKeyInBucket(bucket2, key2)
 	}
 
-	hash, err := getFnv32(item)
-	if err != nil {
-		return false, err
+	hash := memberHash(item)
+	if member := set1.M[string(key1)].get(hash, item); member != nil && set2.M[string(key2)].get(hash, item) == nil {
+		set2.M[string(key2)].add(hash, item, member)
 	}
 
-	if r, ok := set2.M[string(key2)][hash]; !ok {
-		err := set2.SAdd(string(key2), [][]byte{item}, []*Record{r})
-		if err != nil {
-			return false, err
-		}
-	}
-
-	err = set1.SRem(string(key1), item)
-	if err != nil {
+	if err := set1.SRem(string(key1), item); err != nil {
 		return false, err
 	}
 
@@ -301,9 +416,10 @@ func (tx *Tx) SMoveByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key
 
 // SUnionByOneBucket the members of the set resulting from the union of all the given sets in one bucket.
 func (tx *Tx) SUnionByOneBucket(bucket string, key1, key2 []byte) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	if set, ok := tx.db.SetIdx[bucket]; ok {
 		items, err := set.SUnion(string(key1), string(key2))
@@ -328,9 +444,10 @@ func (tx *Tx) SUnionByOneBucket(bucket string, key1, key2 []byte) ([][]byte, err
 
 // SUnionByTwoBuckets the members of the set resulting from the union of all the given sets in two buckets.
 func (tx *Tx) SUnionByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, key2 []byte) ([][]byte, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
 	var (
 		set1, set2 *Set
@@ -353,38 +470,170 @@ func (tx *Tx) SUnionByTwoBuckets(bucket1 string, key1 []byte, bucket2 string, ke
 		return nil, ErrNotFoundKeyInBucket(bucket2, key2)
 	}
 
-	values := make([][]byte, 0)
-
-	for _, r := range set1.M[string(key1)] {
-		value, err := tx.db.getValueByRecord(r)
-		if err != nil {
-			return nil, err
+	records := make([]*Record, 0)
+	set1.M[string(key1)].each(func(hash uint64, r *Record) bool {
+		records = append(records, r)
+		return true
+	})
+	set2.M[string(key2)].each(func(hash uint64, r *Record) bool {
+		if set1.M[string(key1)].get(hash, recordValue(r)) == nil {
+			records = append(records, r)
 		}
-		values = append(values, value)
+		return true
+	})
+
+	return tx.getSetValues(records)
+}
+
+// SUnion returns the members of the set resulting from the union of the sets stored in the bucket at given
+// bucket and keys, in no particular order. The members are deduplicated in the set index before their values
+// are read, and the missing keys are skipped. It returns ErrBucketNotFound if there is no set in the bucket.
+func (tx *Tx) SUnion(bucket string, keys ...[]byte) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
 	}
+	defer tx.endOp()
 
-	for hash, r := range set2.M[string(key2)] {
-		if _, ok := set1.M[string(key1)][hash]; !ok {
-			value, err := tx.db.getValueByRecord(r)
-			if err != nil {
-				return nil, err
-			}
-			values = append(values, value)
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	setKeys := make([]string, len(keys))
+	for i, key := range keys {
+		setKeys[i] = string(key)
+	}
+
+	return tx.getSetValues(set.unionKeys(setKeys...))
+}
+
+// SInter returns the members of the set resulting from the intersection of the sets stored in the bucket at
+// given bucket and keys, in no particular order. The result is empty if any of the keys is missing. Like the
+// other set reads it sees the committed sets, not the writes pending in the transaction. It returns
+// ErrBucketNotFound if there is no set in the bucket.
+func (tx *Tx) SInter(bucket string, keys ...[]byte) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	setKeys := make([]string, len(keys))
+	for i, key := range keys {
+		setKeys[i] = string(key)
+	}
+
+	return tx.getSetValues(set.interKeys(setKeys...))
+}
+
+// SDiff returns the members of the set stored in the bucket at given bucket and key that are in none of the
+// sets at the other keys, in no particular order. The missing keys are treated as empty sets. It returns
+// ErrBucketNotFound if there is no set in the bucket.
+func (tx *Tx) SDiff(bucket string, key []byte, others ...[]byte) ([][]byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	otherKeys := make([]string, len(others))
+	for i, other := range others {
+		otherKeys[i] = string(other)
+	}
+
+	return tx.getSetValues(set.diffKeys(string(key), otherKeys...))
+}
+
+// SMove moves the member from the set stored in the bucket at given bucket and src key to the set at the dst key,
+// the removal and the addition are committed together. It returns false and leaves dst untouched if the member
+// is not in the set at src, and true without any write if src and dst are the same key.
+func (tx *Tx) SMove(bucket string, src, dst, member []byte) (bool, error) {
+	if err := tx.beginOp(); err != nil {
+		return false, err
+	}
+	defer tx.endOp()
+
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return false, nil
+	}
+
+	members, _ := set.members(string(src))
+	if members.get(memberHash(member), member) == nil {
+		return false, nil
+	}
+
+	if bytes.Equal(src, dst) {
+		return true, nil
+	}
+
+	if err := tx.SRem(bucket, src, member); err != nil {
+		return false, err
+	}
+
+	if err := tx.SAdd(bucket, dst, member); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ExpireSet sets the ttl in seconds of the set stored in the bucket at given bucket and key, Persistent removes it.
+// Once the ttl elapses the set is gone for the reads, and a later SAdd starts a fresh set without the members
+// added before. Calling ExpireSet again refreshes the ttl from the time of the call.
+func (tx *Tx) ExpireSet(bucket string, key []byte, ttl uint32) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+
+	value := []byte(strconv2.Int64ToStr(int64(ttl)))
+	return tx.put(bucket, key, value, Persistent, DataExpireSetFlag, tx.nowSeconds(), DataStructureSet)
+}
+
+// getSetValues returns the values of the set members.
+func (tx *Tx) getSetValues(records []*Record) ([][]byte, error) {
+	values := make([][]byte, len(records))
+	for i, record := range records {
+		value, err := tx.db.getValueByRecord(record)
+		if err != nil {
+			return nil, err
 		}
+		values[i] = value
 	}
 
 	return values, nil
 }
 
-// SKeys find all keys matching a given pattern
+// SKeys calls f for the keys of the sets in the bucket matching the pattern, until f returns false.
+// The pattern is matched like filepath.Match, a malformed pattern is returned before any key is matched.
+// It only reads the set index in memory, and skips the expired sets. A bucket without sets has no keys, and
+// the key of a set whose members are all removed is still reported, like SMembers still finds the empty set.
 func (tx *Tx) SKeys(bucket, pattern string, f func(key string) bool) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
-	if _, ok := tx.db.SetIdx[bucket]; !ok {
-		return ErrBucket
+	defer tx.endOp()
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return err
 	}
-	for key := range tx.db.SetIdx[bucket].M {
+	set, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return nil
+	}
+	now := tx.nowSeconds()
+	for key := range set.M {
+		if set.expiredAt(key, now) {
+			continue
+		}
 		if end, err := MatchForRange(pattern, key, f); end || err != nil {
 			return err
 		}
@@ -15,12 +15,17 @@
 package nutsdb
 
 import (
+	"bytes"
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func InitForSet() {
@@ -1028,3 +1033,813 @@ func TestErrNotFoundKeyInBucket(t *testing.T) {
 	assert.True(t,
 		errors.Is(got, ErrKeyNotFound))
 }
+
+func TestTx_SMembersAll(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 8 * KB
+	opts.EntryIdxMode = HintKeyAndRAMIdxMode
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		const n = 3000
+		members := make([][]byte, n)
+		for i := range members {
+			members[i] = GetTestBytes(i)
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, members...)
+		}))
+		// the members are read back from many data files.
+		_, fileIDs := db.getMaxFileIDAndFileIDs()
+		require.Greater(t, len(fileIDs), 2)
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SMembers(bucket, key)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, members, values)
+
+			_, err = tx.SMembers(bucket, GetTestBytes(1))
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			_, err = tx.SMembers("other", key)
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SRem(bucket, key, members...)
+		}))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SMembers(bucket, key)
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+			return nil
+		}))
+	})
+}
+
+func TestTx_SCardTracksMembers(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	sCard := func(t *testing.T, db *DB, key []byte) (num int, err error) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err = tx.SCard(bucket, key)
+			return nil
+		}))
+		return num, err
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		_, err := sCard(t, db, key)
+		require.True(t, errors.Is(err, ErrBucketNotFound))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, []byte("a"), []byte("b"), []byte("c"))
+		}))
+		// adding a member twice doesn't count it twice.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, []byte("c"), []byte("d"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SRem(bucket, key, []byte("a"), []byte("x"))
+		}))
+
+		num, err := sCard(t, db, key)
+		require.NoError(t, err)
+		require.Equal(t, 3, num)
+
+		num, err = sCard(t, db, GetTestBytes(1))
+		require.True(t, errors.Is(err, ErrKeyNotFound))
+		require.Equal(t, 0, num)
+
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+
+		num, err = sCard(t, db, key)
+		require.NoError(t, err)
+		require.Equal(t, 3, num)
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_SPopAll(t *testing.T) {
+	bucket := "bucket"
+	key, other := GetTestBytes(0), GetTestBytes(1)
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	sCard := func(t *testing.T, db *DB, key []byte) (num int) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			num, err = tx.SCard(bucket, key)
+			assert.NoError(t, err)
+			return nil
+		}))
+		return num
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		const n = 100
+		members := make([][]byte, n)
+		for i := range members {
+			members[i] = GetTestBytes(i)
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, key, members...); err != nil {
+				return err
+			}
+			return tx.SAdd(bucket, other, members[:10]...)
+		}))
+
+		var popped [][]byte
+		for i := 0; i < n; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				member, err := tx.SPop(bucket, key)
+				popped = append(popped, member)
+				return err
+			}))
+		}
+		require.ElementsMatch(t, members, popped)
+		require.Equal(t, 0, sCard(t, db, key))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			_, err := tx.SPop(bucket, key)
+			assert.Equal(t, ErrSetEmpty, err)
+			_, err = tx.SPop(bucket, GetTestBytes(2))
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+			values, err := tx.SPopN(bucket, other, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+
+			values, err = tx.SPopN(bucket, other, 4)
+			assert.NoError(t, err)
+			popped = values
+			return err
+		}))
+		require.Len(t, popped, 4)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			values, err := tx.SPopN(bucket, other, 100)
+			popped = append(popped, values...)
+			return err
+		}))
+		require.ElementsMatch(t, members[:10], popped)
+
+		// the pops are replayed on open.
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, 0, sCard(t, db, key))
+		require.Equal(t, 0, sCard(t, db, other))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_SUnion(t *testing.T) {
+	bucket := "bucket"
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.SegmentSize = 64 * KB
+	opts.EntryIdxMode = HintKeyAndRAMIdxMode
+
+	members := func(start, end int) (values [][]byte) {
+		for i := start; i < end; i++ {
+			values = append(values, GetTestBytes(i))
+		}
+		return values
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, []byte("a"), members(0, 3)...); err != nil {
+				return err
+			}
+			if err := tx.SAdd(bucket, []byte("b"), members(1, 5)...); err != nil {
+				return err
+			}
+			if err := tx.SAdd(bucket, []byte("large1"), members(0, 5000)...); err != nil {
+				return err
+			}
+			return tx.SAdd(bucket, []byte("large2"), members(2500, 7500)...)
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SUnion(bucket, []byte("a"), []byte("missing"), []byte("b"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, members(0, 5), values)
+
+			values, err = tx.SUnion(bucket, []byte("a"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, members(0, 3), values)
+
+			values, err = tx.SUnion(bucket, []byte("missing"))
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+
+			values, err = tx.SUnion(bucket, []byte("large1"), []byte("large2"), []byte("a"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, members(0, 7500), values)
+
+			_, err = tx.SUnion("other", []byte("a"))
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+	})
+}
+
+func TestTx_SInter(t *testing.T) {
+	bucket := "bucket"
+
+	sAdd := func(db *DB, key string, values ...string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, value := range values {
+				if err := tx.SAdd(bucket, []byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	sInter := func(db *DB, keys ...string) (values [][]byte) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			setKeys := make([][]byte, len(keys))
+			for i, key := range keys {
+				setKeys[i] = []byte(key)
+			}
+			var err error
+			values, err = tx.SInter(bucket, setKeys...)
+			return err
+		}))
+		return values
+	}
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		sAdd(db, "a", "1", "2", "3")
+		sAdd(db, "b", "4", "5")
+		sAdd(db, "c", "0", "1", "2", "3", "4", "5", "6")
+		sAdd(db, "d", "2", "3", "9")
+
+		assert.Empty(t, sInter(db, "a", "b"))
+		assert.ElementsMatch(t, [][]byte{[]byte("2"), []byte("3")}, sInter(db, "c", "a", "d"))
+		assert.ElementsMatch(t, [][]byte{[]byte("4"), []byte("5")}, sInter(db, "b", "c"))
+		assert.Empty(t, sInter(db, "a", "missing", "c"))
+		assert.Empty(t, sInter(db))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SRem(bucket, []byte("d"), []byte("3"))
+		}))
+		assert.Equal(t, [][]byte{[]byte("2")}, sInter(db, "c", "a", "d"))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.SInter("other", []byte("a"))
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+	})
+}
+
+func TestTx_SDiff(t *testing.T) {
+	bucket := "bucket"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, []byte("a"), []byte("1"), []byte("2"), []byte("3"), []byte("4")); err != nil {
+				return err
+			}
+			if err := tx.SAdd(bucket, []byte("b"), []byte("2"), []byte("5")); err != nil {
+				return err
+			}
+			return tx.SAdd(bucket, []byte("c"), []byte("2"), []byte("3"))
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SDiff(bucket, []byte("a"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4")}, values)
+
+			values, err = tx.SDiff(bucket, []byte("a"), []byte("b"), []byte("missing"), []byte("c"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, [][]byte{[]byte("1"), []byte("4")}, values)
+
+			values, err = tx.SDiff(bucket, []byte("b"), []byte("a"))
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("5")}, values)
+
+			values, err = tx.SDiff(bucket, []byte("missing"), []byte("a"))
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+
+			_, err = tx.SDiff("other", []byte("a"))
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+	})
+}
+
+func TestTx_SMove(t *testing.T) {
+	bucket := "bucket"
+	pending, done := []byte("pending"), []byte("done")
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	sMove := func(t *testing.T, db *DB, src, dst, member []byte) (moved bool) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			var err error
+			moved, err = tx.SMove(bucket, src, dst, member)
+			return err
+		}))
+		return moved
+	}
+
+	sMembers := func(t *testing.T, db *DB, key []byte) (values [][]byte) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			values, err = tx.SMembers(bucket, key)
+			return err
+		}))
+		return values
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.False(t, sMove(t, db, pending, done, []byte("a")))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, pending, []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+			return tx.SAdd(bucket, done, []byte("c"))
+		}))
+
+		require.False(t, sMove(t, db, pending, done, []byte("x")))
+		require.ElementsMatch(t, [][]byte{[]byte("c")}, sMembers(t, db, done))
+
+		require.True(t, sMove(t, db, pending, done, []byte("a")))
+		require.ElementsMatch(t, [][]byte{[]byte("b")}, sMembers(t, db, pending))
+		require.ElementsMatch(t, [][]byte{[]byte("a"), []byte("c")}, sMembers(t, db, done))
+
+		require.True(t, sMove(t, db, pending, pending, []byte("b")))
+		require.ElementsMatch(t, [][]byte{[]byte("b")}, sMembers(t, db, pending))
+
+		require.True(t, sMove(t, db, pending, []byte("new"), []byte("b")))
+		require.Empty(t, sMembers(t, db, pending))
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+
+		require.Empty(t, sMembers(t, db, pending))
+		require.ElementsMatch(t, [][]byte{[]byte("a"), []byte("c")}, sMembers(t, db, done))
+		require.ElementsMatch(t, [][]byte{[]byte("b")}, sMembers(t, db, []byte("new")))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_SRandMember(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		var members [][]byte
+		for i := 0; i < 10; i++ {
+			members = append(members, GetTestBytes(i))
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, members...)
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SRandMember(bucket, key, 5)
+			assert.NoError(t, err)
+			assert.Len(t, values, 5)
+			distinct := make(map[string]struct{})
+			for _, value := range values {
+				assert.Contains(t, members, value)
+				distinct[string(value)] = struct{}{}
+			}
+			assert.Len(t, distinct, 5)
+
+			values, err = tx.SRandMember(bucket, key, 20)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, members, values)
+
+			values, err = tx.SRandMember(bucket, key, -50)
+			assert.NoError(t, err)
+			assert.Len(t, values, 50)
+			distinct = make(map[string]struct{})
+			for _, value := range values {
+				assert.Contains(t, members, value)
+				distinct[string(value)] = struct{}{}
+			}
+			// 50 members drawn from a set of 10 repeat some.
+			assert.Less(t, len(distinct), 50)
+
+			values, err = tx.SRandMember(bucket, key, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+
+			_, err = tx.SRandMember(bucket, GetTestBytes(1), 1)
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			_, err = tx.SRandMember("other", key, 1)
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+
+		// sampling doesn't remove the members.
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err := tx.SCard(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, 10, num)
+			return nil
+		}))
+	})
+}
+
+func TestTx_SAddMany(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	var members [][]byte
+	for i := 0; i < 50000; i++ {
+		members = append(members, GetTestBytes(i))
+	}
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SAdd(bucket, key, members[:100]...); err != nil {
+				return err
+			}
+			return tx.SAdd(bucket, key, members[:10]...)
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			// the members already in the set and the repeated ones are queued once.
+			if err := tx.SAdd(bucket, key, append(members, members[49990:]...)...); err != nil {
+				return err
+			}
+			assert.Len(t, tx.pendingWrites, len(members)-100)
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err := tx.SCard(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, len(members), num)
+
+			for _, member := range [][]byte{members[0], members[25000], members[49999]} {
+				ok, err := tx.SIsMember(bucket, key, member)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, err := tx.SIsMember(bucket, key, GetTestBytes(50000))
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			return nil
+		}))
+	})
+}
+
+func BenchmarkTx_SAdd(b *testing.B) {
+	bucket := "bucket"
+
+	var members [][]byte
+	for i := 0; i < 50000; i++ {
+		members = append(members, GetTestBytes(i))
+	}
+
+	bench := func(b *testing.B, add func(tx *Tx, key []byte) error) {
+		opts := DefaultOptions
+		opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+		db, err := Open(opts)
+		require.NoError(b, err)
+		defer func() {
+			require.NoError(b, db.Close())
+			require.NoError(b, os.RemoveAll(opts.Dir))
+		}()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, db.Update(func(tx *Tx) error {
+				return add(tx, GetTestBytes(i))
+			}))
+		}
+	}
+
+	b.Run("one call", func(b *testing.B) {
+		bench(b, func(tx *Tx, key []byte) error {
+			return tx.SAdd(bucket, key, members...)
+		})
+	})
+
+	b.Run("one call per member", func(b *testing.B) {
+		bench(b, func(tx *Tx, key []byte) error {
+			for _, member := range members {
+				if err := tx.SAdd(bucket, key, member); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func TestTx_SKeysPattern(t *testing.T) {
+	bucket := "bucket"
+
+	sKeys := func(t *testing.T, db *DB, bucket, pattern string) (keys []string, err error) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			err = tx.SKeys(bucket, pattern, func(key string) bool {
+				keys = append(keys, key)
+				return true
+			})
+			return nil
+		}))
+		return keys, err
+	}
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, key := range []string{"user:1:tags", "user:2:tags", "user:2:roles", "team:1:tags"} {
+				if err := tx.SAdd(bucket, []byte(key), []byte("a"), []byte("b")); err != nil {
+					return err
+				}
+			}
+			return tx.Put("kv", []byte("user:3:tags"), []byte("v"), Persistent)
+		}))
+
+		keys, err := sKeys(t, db, bucket, "user:*:tags")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"user:1:tags", "user:2:tags"}, keys)
+
+		keys, err = sKeys(t, db, bucket, "*:1:*")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"user:1:tags", "team:1:tags"}, keys)
+
+		// the key of an emptied set is still reported.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SRem(bucket, []byte("user:2:roles"), []byte("a"), []byte("b"))
+		}))
+		keys, err = sKeys(t, db, bucket, "user:2:*")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"user:2:tags", "user:2:roles"}, keys)
+
+		// a bucket without sets has no keys.
+		keys, err = sKeys(t, db, "kv", "*")
+		require.NoError(t, err)
+		require.Empty(t, keys)
+
+		_, err = sKeys(t, db, bucket, "user:[")
+		require.Equal(t, filepath.ErrBadPattern, err)
+	})
+}
+
+func TestTx_ExpireSetLifetime(t *testing.T) {
+	bucket := "bucket"
+	expiring, refreshed, later, addedAgain := GetTestBytes(0), GetTestBytes(1), GetTestBytes(2), GetTestBytes(3)
+
+	opts := DefaultOptions
+	opts.Dir = "/tmp/test-nutsdb-expire-set"
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// the ttl counts from the second of the timestamp, start right after it so the expiry times are exact.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	sMembers := func(key []byte) (members []string, err error) {
+		err = db.View(func(tx *Tx) error {
+			values, err := tx.SMembers(bucket, key)
+			for _, value := range values {
+				members = append(members, string(value))
+			}
+			return err
+		})
+		return members, err
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range [][]byte{expiring, refreshed, later, addedAgain} {
+			if err := tx.SAdd(bucket, key, []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+		}
+		if err := tx.ExpireSet(bucket, expiring, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireSet(bucket, addedAgain, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireSet(bucket, later, 2); err != nil {
+			return err
+		}
+		return tx.ExpireSet(bucket, refreshed, 1)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.ExpireSet(bucket, refreshed, 100)
+	}))
+
+	// right before the expiry the sets are kept across the restart.
+	reopen()
+	for _, key := range [][]byte{expiring, refreshed, later, addedAgain} {
+		members, err := sMembers(key)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b"}, members)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.SIsMember(bucket, expiring, []byte("a"))
+		assert.Equal(t, ErrSetNotExist, err)
+		_, err = tx.SCard(bucket, expiring)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+		// the add starts a fresh set without the expired members.
+		return tx.SAdd(bucket, addedAgain, []byte("a"), []byte("c"))
+	}))
+	_, err = sMembers(expiring)
+	require.True(t, errors.Is(err, ErrKeyNotFound))
+
+	check := func() {
+		members, err := sMembers(refreshed)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "b"}, members)
+
+		members, err = sMembers(addedAgain)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"a", "c"}, members)
+
+		var keys []string
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.SKeys(bucket, "*", func(key string) bool {
+				keys = append(keys, key)
+				return true
+			})
+		}))
+		require.NotContains(t, keys, string(expiring))
+	}
+	check()
+
+	// right after the expiry the expired sets stay gone across the restart.
+	reopen()
+	check()
+	_, err = sMembers(expiring)
+	require.True(t, errors.Is(err, ErrKeyNotFound))
+
+	members, err := sMembers(later)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b"}, members)
+
+	// the fresh set doesn't keep the ttl of the expired one.
+	time.Sleep(time.Second)
+	reopen()
+	_, err = sMembers(later)
+	require.True(t, errors.Is(err, ErrKeyNotFound))
+	members, err = sMembers(addedAgain)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "c"}, members)
+}
+
+func TestTx_SScan(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	var members [][]byte
+	for i := 0; i < 100000; i++ {
+		members = append(members, GetTestBytes(i))
+	}
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, members...)
+		}))
+
+		// every page is read in its own transaction.
+		var (
+			scanned [][]byte
+			cursor  []byte
+			pages   int
+		)
+		for {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				values, next, err := tx.SScan(bucket, key, cursor, 1000)
+				assert.LessOrEqual(t, len(values), 1000)
+				scanned = append(scanned, values...)
+				cursor = next
+				return err
+			}))
+			pages++
+			if len(cursor) == 0 {
+				break
+			}
+		}
+		require.Equal(t, 100, pages)
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, err := tx.SMembers(bucket, key)
+			assert.NoError(t, err)
+			sortBytes := func(values [][]byte) {
+				sort.Slice(values, func(i, j int) bool {
+					return bytes.Compare(values[i], values[j]) < 0
+				})
+			}
+			sortBytes(values)
+			sortBytes(scanned)
+			assert.Equal(t, values, scanned)
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			values, next, err := tx.SScan(bucket, key, nil, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, values)
+			assert.Empty(t, next)
+
+			_, _, err = tx.SScan(bucket, key, []byte("bad"), 10)
+			assert.Equal(t, ErrSetScanCursor, err)
+			_, _, err = tx.SScan(bucket, GetTestBytes(1), nil, 10)
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			_, _, err = tx.SScan("other", key, nil, 10)
+			assert.True(t, errors.Is(err, ErrBucketNotFound))
+			return nil
+		}))
+	})
+}
+
+func TestTx_SScanWhileChanging(t *testing.T) {
+	bucket := "bucket"
+	key := GetTestBytes(0)
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				if err := tx.SAdd(bucket, key, GetTestBytes(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		var (
+			scanned [][]byte
+			cursor  []byte
+			removed int
+		)
+		for i := 100; ; i++ {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				values, next, err := tx.SScan(bucket, key, cursor, 10)
+				scanned = append(scanned, values...)
+				cursor = next
+				return err
+			}))
+			if len(cursor) == 0 {
+				break
+			}
+			// the members kept during the scan are returned once whatever changes in between.
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				if err := tx.SRem(bucket, key, GetTestBytes(i-100)); err != nil {
+					return err
+				}
+				return tx.SAdd(bucket, key, GetTestBytes(i))
+			}))
+			removed++
+		}
+
+		seen := make(map[string]int)
+		for _, value := range scanned {
+			seen[string(value)]++
+		}
+		for i := removed; i < 100; i++ {
+			assert.Equal(t, 1, seen[string(GetTestBytes(i))])
+		}
+		for member, num := range seen {
+			assert.Equal(t, 1, num, member)
+		}
+	})
+}
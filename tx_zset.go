@@ -17,9 +17,9 @@ package nutsdb
 import (
 	"bytes"
 	"errors"
+	"math"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/strconv2"
@@ -28,8 +28,183 @@ import (
 // SeparatorForZSetKey represents separator for zSet key.
 const SeparatorForZSetKey = "|"
 
+// ErrScoreTypeMismatch is returned when the score type of the operation does not match the sorted set,
+// e.g. calling ZAdd on the sorted set holding int64 scores.
+var ErrScoreTypeMismatch = zset.ErrScoreTypeMismatch
+
+var (
+	// ErrScoreNaN is returned when the score of a member would not be a number, e.g. adding -Inf to +Inf.
+	ErrScoreNaN = errors.New("the score is not a number")
+
+	// ErrZSetPendingRemoval is returned by ZIncrBy after a pop or a rank range removal of the same sorted set
+	// in the tx, whose effect on the member is only known at commit.
+	ErrZSetPendingRemoval = errors.New("the sorted set has a pending pop or rank range removal")
+
+	// ErrZSetPendingWrite is returned by the pops after the other writes of the same sorted set in the tx,
+	// the members they add or remove are only placed at commit.
+	ErrZSetPendingWrite = errors.New("the sorted set has a pending write other than a pop")
+
+	// ErrSortedSetEmpty is returned when popping an empty sorted set.
+	ErrSortedSetEmpty = errors.New("the sorted set is empty")
+
+	// ErrInvalidLexBound is returned when a bound of ZRangeByLex is neither - or + nor starts with [ or (.
+	ErrInvalidLexBound = errors.New("the lex bound must be - or + or start with [ or (")
+
+	// ErrZSetWeights is returned when the number of weights does not match the number of sorted sets.
+	ErrZSetWeights = errors.New("the number of weights must match the number of sorted sets")
+
+	// ErrAggregate is returned for an Aggregate other than AggregateSum, AggregateMin and AggregateMax.
+	ErrAggregate = errors.New("unknown aggregate")
+
+	// ErrScoreType is returned by DB.SetZSetScoreType for a score type other than ScoreFloat64 and ScoreInt64.
+	ErrScoreType = errors.New("unknown score type")
+)
+
+// Aggregate represents how the scores of a member in several sorted sets are combined.
+type Aggregate int
+
+const (
+	// AggregateSum sums the scores.
+	AggregateSum Aggregate = iota
+
+	// AggregateMin takes the lowest score.
+	AggregateMin
+
+	// AggregateMax takes the highest score.
+	AggregateMax
+)
+
+// combine returns the score of a member with the score and the other score.
+func (a Aggregate) combine(score, other float64) float64 {
+	switch a {
+	case AggregateMin:
+		return math.Min(score, other)
+	case AggregateMax:
+		return math.Max(score, other)
+	}
+	return score + other
+}
+
 // ZAdd adds the specified member key with the specified score and specified val to the sorted set stored at bucket.
 func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
+	if err := tx.checkZSetScoreType(bucket, zset.ScoreFloat64); err != nil {
+		return err
+	}
+
+	return tx.zAdd(bucket, key, []byte(strconv.FormatFloat(score, 'f', -1, 64)), val, DataZAddFlag)
+}
+
+// ZAddInt adds the specified member key with the exact int64 score and specified val to the sorted set stored at bucket.
+// A sorted set holds either float64 or int64 scores, ZAddInt needs the sorted set to be set to zset.ScoreInt64 by
+// DB.SetZSetScoreType. Use it instead of ZAdd when the scores, e.g. nanosecond timestamps or 64-bit IDs, can not be
+// represented by float64.
+func (tx *Tx) ZAddInt(bucket string, key []byte, score int64, val []byte) error {
+	if err := tx.checkZSetScoreType(bucket, zset.ScoreInt64); err != nil {
+		return err
+	}
+
+	return tx.zAdd(bucket, key, []byte(strconv.FormatInt(score, 10)), val, DataZAddIntFlag)
+}
+
+// ScoredMember represents a member of a sorted set with its score, as added by ZAddBatch.
+type ScoredMember struct {
+	Score  float64
+	Member []byte
+}
+
+// ZAddBatch adds the members with their scores and a nil value to the sorted set stored at bucket, as ZAdd does for
+// one member. A member repeated in the batch is added once with its last score. The members are validated before
+// any is added, so on error none of them is: it returns ErrScoreNaN if a score is not a number, the error of ZAdd
+// for a member holding the separator, and ErrScoreTypeMismatch if the sorted set holds int64 scores.
+func (tx *Tx) ZAddBatch(bucket string, members []ScoredMember) error {
+	if err := tx.checkZSetScoreType(bucket, zset.ScoreFloat64); err != nil {
+		return err
+	}
+
+	last := make(map[string]int, len(members))
+	for i, member := range members {
+		if math.IsNaN(member.Score) {
+			return ErrScoreNaN
+		}
+		if strings.Contains(string(member.Member), SeparatorForZSetKey) {
+			return ErrSeparatorForZSetKey()
+		}
+		last[string(member.Member)] = i
+	}
+
+	queued := len(tx.pendingWrites)
+	for i, member := range members {
+		if last[string(member.Member)] != i {
+			continue
+		}
+		if err := tx.zAdd(bucket, member.Member, []byte(strconv.FormatFloat(member.Score, 'f', -1, 64)), nil, DataZAddFlag); err != nil {
+			tx.pendingWrites = tx.pendingWrites[:queued]
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ZIncrBy adds the increment to the score of the member key in the sorted set stored at bucket and returns
+// the new score, a missing member is added with the increment as score. Like ZAdd, val becomes the value of
+// the member. The score also takes the pending ZAdd and ZRem of the member in the tx into account, so the
+// increments in one tx add up. It returns ErrScoreNaN if the new score is not a number.
+func (tx *Tx) ZIncrBy(bucket string, key []byte, increment float64, val []byte) (float64, error) {
+	if err := tx.checkZSetScoreType(bucket, zset.ScoreFloat64); err != nil {
+		return 0, err
+	}
+
+	score, err := tx.zPendingScore(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	score += increment
+	if math.IsNaN(score) {
+		return 0, ErrScoreNaN
+	}
+
+	return score, tx.zAdd(bucket, key, []byte(strconv.FormatFloat(score, 'f', -1, 64)), val, DataZAddFlag)
+}
+
+// zPendingScore returns the float64 score of the member key in the sorted set stored at bucket with
+// the pending writes of the tx applied, 0 for a missing member.
+func (tx *Tx) zPendingScore(bucket string, key []byte) (float64, error) {
+	prefix := string(key) + SeparatorForZSetKey
+
+	for i := len(tx.pendingWrites) - 1; i >= 0; i-- {
+		entry := tx.pendingWrites[i]
+		if string(entry.Bucket) != bucket {
+			continue
+		}
+
+		switch entry.Meta.Flag {
+		case DataZAddFlag:
+			if strings.HasPrefix(string(entry.Key), prefix) {
+				return strconv.ParseFloat(strings.TrimPrefix(string(entry.Key), prefix), 64)
+			}
+		case DataZRemFlag:
+			if bytes.Equal(entry.Key, key) {
+				return 0, nil
+			}
+		case DataSortedSetBucketDeleteFlag:
+			return 0, nil
+		case DataZPopMaxFlag, DataZPopMinFlag, DataZRemRangeByRankFlag:
+			return 0, ErrZSetPendingRemoval
+		}
+	}
+
+	if sortedSet, ok := tx.sortedSet(bucket); ok {
+		if node := sortedSet.GetByKey(string(key)); node != nil {
+			return float64(node.Score()), nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (tx *Tx) zAdd(bucket string, key []byte, scoreBytes []byte, val []byte, flag uint16) error {
 	var buffer bytes.Buffer
 
 	if strings.Contains(string(key), SeparatorForZSetKey) {
@@ -38,208 +213,743 @@ func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
 
 	buffer.Write(key)
 	buffer.Write([]byte(SeparatorForZSetKey))
-	scoreBytes := []byte(strconv.FormatFloat(score, 'f', -1, 64))
 	buffer.Write(scoreBytes)
 	newKey := buffer.Bytes()
 
-	return tx.put(bucket, newKey, val, Persistent, DataZAddFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, newKey, val, Persistent, flag, tx.nowSeconds(), DataStructureSortedSet)
+}
+
+// checkZSetScoreType returns ErrScoreTypeMismatch if the sorted set stored at bucket is set to
+// the scores of another type, see DB.SetZSetScoreType.
+func (tx *Tx) checkZSetScoreType(bucket string, scoreType zset.ScoreType) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+
+	return matchScoreType(tx.zsetScoreType(bucket), scoreType)
+}
+
+// sortedSet returns the sorted set stored at bucket, a sorted set whose ttl has elapsed is taken as missing.
+func (tx *Tx) sortedSet(bucket string) (*zset.SortedSet, bool) {
+	sortedSet, ok := tx.db.SortedSetIdx[bucket]
+	if !ok || sortedSet.ExpiredAt(tx.nowSeconds()) {
+		return nil, false
+	}
+
+	return sortedSet, true
+}
+
+// ExpireZSet sets the ttl in seconds of the sorted set stored at bucket, Persistent removes it.
+// Once the ttl elapses the sorted set is gone for the reads, its records are dropped by merge, and a later
+// ZAdd starts a fresh sorted set. Calling ExpireZSet again refreshes the ttl from the time of the call.
+// The ttl of a sorted set that does not exist at commit is dropped.
+func (tx *Tx) ExpireZSet(bucket string, ttl uint32) error {
+	if err := tx.beginOp(); err != nil {
+		return err
+	}
+	defer tx.endOp()
+
+	value := []byte(strconv2.Int64ToStr(int64(ttl)))
+	return tx.put(bucket, []byte(" "), value, Persistent, DataExpireZSetFlag, tx.nowSeconds(), DataStructureSortedSet)
+}
+
+func matchScoreType(have, want zset.ScoreType) error {
+	if have != want {
+		return ErrScoreTypeMismatch
+	}
+
+	return nil
+}
+
+// ZScan returns up to count members of the sorted set stored at bucket in rank order from the cursor, with the
+// cursor to resume from, see zset.SortedSet.Scan. The scan starts from an empty cursor and is done when the
+// returned cursor is empty, a count not above 0 returns no members and the same cursor. The cursor stays valid
+// across transactions, a scan resumes from the next member in order even if the last member returned was removed.
+// It returns ErrBucket if there is no sorted set at bucket and zset.ErrScanCursor for a bad cursor.
+func (tx *Tx) ZScan(bucket string, cursor []byte, count int) ([]*zset.SortedSetNode, []byte, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, nil, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return nil, nil, ErrBucket
+	}
+	if count <= 0 {
+		return []*zset.SortedSetNode{}, cursor, nil
+	}
+
+	return sortedSet.Scan(cursor, count)
 }
 
-// ZMembers returns all the members of the set value stored at bucket.
+// ZMembers returns all the members of the sorted set stored at bucket with their scores, keyed by member. Use
+// ZRangeByRank(bucket, 1, -1) for the members in rank order. The map is a copy, so it can be kept and changed
+// after the tx, and its keys are strings, which never alias a buffer of the db. The nodes are the ones of the
+// index though, so read their values in the tx and don't modify them. The sorted set index holds the values
+// in memory, so no data file is read.
 func (tx *Tx) ZMembers(bucket string) (map[string]*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].Dict, nil
+	members := make(map[string]*zset.SortedSetNode, sortedSet.Size())
+	for key, node := range sortedSet.Dict {
+		members[key] = node
+	}
+
+	return members, nil
 }
 
-// ZCard returns the sorted set cardinality (number of elements) of the sorted set stored at bucket.
+// ZCard returns the sorted set cardinality (number of elements) of the sorted set stored at bucket,
+// it only reads the size kept by the index in memory. Like SCard, it returns 0 with an error, ErrBucket,
+// if there is no sorted set at bucket.
 func (tx *Tx) ZCard(bucket string) (int, error) {
-	members, err := tx.ZMembers(bucket)
-	if err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return 0, ErrBucket
+	}
 
-	return len(members), nil
+	return sortedSet.Size(), nil
 }
 
 // ZCount returns the number of elements in the sorted set at bucket with a score between min and max and opts.
 // opts includes the following parameters:
 // Limit        int  // limit the max nodes to return
+// Offset       int  // skip the first nodes in the range before returning any
 // ExcludeStart bool // exclude start value, so it search in interval (start, end] or (start, end)
 // ExcludeEnd   bool // exclude end value, so it search in interval [start, end) or (start, end)
+// It counts the members from the ranks of the bounds rather than collecting them, so it takes O(log(N)).
 func (tx *Tx) ZCount(bucket string, start, end float64, opts *zset.GetByScoreRangeOptions) (int, error) {
-	nodes, err := tx.ZRangeByScore(bucket, start, end, opts)
-	if err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 
-	return len(nodes), nil
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return 0, ErrBucket
+	}
+
+	if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreFloat64); err != nil {
+		return 0, err
+	}
+
+	return sortedSet.CountByScoreRange(zset.SCORE(start), zset.SCORE(end), opts), nil
 }
 
 // ZPopMax removes and returns the member with the highest score in the sorted set stored at bucket.
+// It returns ErrSortedSetEmpty if the sorted set has no members left. The pops of the same sorted set
+// in one tx return the successive members, see ZPopMaxN.
 func (tx *Tx) ZPopMax(bucket string) (*zset.SortedSetNode, error) {
-	item, err := tx.ZPeekMax(bucket)
+	nodes, err := tx.zPop(bucket, 1, DataZPopMaxFlag)
 	if err != nil {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMaxFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return nodes[0], nil
 }
 
 // ZPopMin removes and returns the member with the lowest score in the sorted set stored at bucket.
+// It returns ErrSortedSetEmpty if the sorted set has no members left. The pops of the same sorted set
+// in one tx return the successive members, see ZPopMinN.
 func (tx *Tx) ZPopMin(bucket string) (*zset.SortedSetNode, error) {
-	item, err := tx.ZPeekMin(bucket)
+	nodes, err := tx.zPop(bucket, 1, DataZPopMinFlag)
 	if err != nil {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMinFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return nodes[0], nil
+}
+
+// ZPopMaxN removes and returns up to count members with the highest scores in the sorted set stored at bucket,
+// from the highest score down. It returns ErrSortedSetEmpty if the sorted set has no members left.
+// The pops take the earlier pops of the tx into account, and return ErrZSetPendingWrite after the other
+// writes of the sorted set in the tx.
+func (tx *Tx) ZPopMaxN(bucket string, count int) ([]*zset.SortedSetNode, error) {
+	return tx.zPop(bucket, count, DataZPopMaxFlag)
+}
+
+// ZPopMinN removes and returns up to count members with the lowest scores in the sorted set stored at bucket,
+// from the lowest score up. It returns ErrSortedSetEmpty if the sorted set has no members left.
+// The pops take the earlier pops of the tx into account, and return ErrZSetPendingWrite after the other
+// writes of the sorted set in the tx.
+func (tx *Tx) ZPopMinN(bucket string, count int) ([]*zset.SortedSetNode, error) {
+	return tx.zPop(bucket, count, DataZPopMinFlag)
+}
+
+// zPop queues a pop record with the flag for each of the count members it returns, the records are
+// applied in order at commit like ZPopMin or ZPopMax.
+func (tx *Tx) zPop(bucket string, count int, flag uint16) ([]*zset.SortedSetNode, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return nil, ErrBucket
+	}
+
+	minPops, maxPops, err := tx.zPendingPops(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	size := sortedSet.Size()
+	left := size - minPops - maxPops
+	if left <= 0 {
+		return nil, ErrSortedSetEmpty
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	if count > left {
+		count = left
+	}
+
+	var nodes []*zset.SortedSetNode
+	if flag == DataZPopMinFlag {
+		nodes = sortedSet.GetByRankRange(minPops+1, minPops+count, false)
+	} else {
+		nodes = sortedSet.GetByRankRange(size-maxPops, size-maxPops-count+1, false)
+	}
+
+	timestamp := tx.nowSeconds()
+	for range nodes {
+		if err := tx.put(bucket, []byte(" "), []byte(""), Persistent, flag, timestamp, DataStructureSortedSet); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+// zPendingPops returns the number of the pending ZPopMin and ZPopMax records of the sorted set stored at bucket
+// in the tx. It returns ErrZSetPendingWrite if the sorted set has pending writes of another kind.
+func (tx *Tx) zPendingPops(bucket string) (minPops, maxPops int, err error) {
+	for _, entry := range tx.pendingWrites {
+		if string(entry.Bucket) != bucket ||
+			(entry.Meta.Ds != DataStructureSortedSet && entry.Meta.Flag != DataSortedSetBucketDeleteFlag) {
+			continue
+		}
+
+		switch entry.Meta.Flag {
+		case DataZPopMinFlag:
+			minPops++
+		case DataZPopMaxFlag:
+			maxPops++
+		default:
+			return 0, 0, ErrZSetPendingWrite
+		}
+	}
+
+	return minPops, maxPops, nil
 }
 
 // ZPeekMax returns the member with the highest score in the sorted set stored at bucket.
 func (tx *Tx) ZPeekMax(bucket string) (*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].PeekMax(), nil
+	return sortedSet.PeekMax(), nil
 }
 
 // ZPeekMin returns the member with the lowest score in the sorted set stored at bucket.
 func (tx *Tx) ZPeekMin(bucket string) (*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].PeekMin(), nil
+	return sortedSet.PeekMin(), nil
 }
 
-// ZRangeByScore returns all the elements in the sorted set at bucket with a score between min and max.
+// ZRangeByScore returns all the elements in the sorted set at bucket with a score between min and max,
+// in ascending score order, or descending if start is above end. The nodes carry their keys and scores.
+// opts pages the range with Offset and Limit and excludes the bounds with ExcludeStart and ExcludeEnd,
+// and math.Inf(-1) or math.Inf(1) leave a side of the range open.
 func (tx *Tx) ZRangeByScore(bucket string, start, end float64, opts *zset.GetByScoreRangeOptions) ([]*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return nil, ErrBucket
+	}
+
+	if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreFloat64); err != nil {
+		return nil, err
+	}
+
+	return sortedSet.GetByScoreRange(zset.SCORE(start), zset.SCORE(end), opts), nil
+}
+
+// ZRangeByLex returns the members of the sorted set at bucket whose keys are within min and max, in the
+// byte-wise order of the keys. Like Redis ZRANGEBYLEX, the bounds are - and + for an open side, or the key
+// prefixed with [ to include it or ( to exclude it, e.g. [a and (b for the keys in [a, b). opts pages
+// the range with Offset and Limit.
+//
+// It is meant for the sorted sets whose members all have the same score, where the members are ordered by
+// key. If the scores differ, the members keep their score order and it returns the first run of consecutive
+// members whose keys are within the range.
+func (tx *Tx) ZRangeByLex(bucket string, min, max []byte, opts *zset.GetByLexRangeOptions) ([]*zset.SortedSetNode, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	minBound, err := parseLexBound(min, '-')
+	if err != nil {
+		return nil, err
+	}
+
+	maxBound, err := parseLexBound(max, '+')
+	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].GetByScoreRange(zset.SCORE(start), zset.SCORE(end), opts), nil
+	// + as min or - as max leaves no key in the range.
+	if string(min) == "+" || string(max) == "-" {
+		return nil, nil
+	}
+
+	return sortedSet.GetByLexRange(minBound, maxBound, opts), nil
+}
+
+// parseLexBound parses a bound of ZRangeByLex, open is the - or + that leaves the side of the range open.
+func parseLexBound(bound []byte, open byte) (zset.LexBound, error) {
+	if len(bound) == 1 && (bound[0] == '-' || bound[0] == '+') {
+		return zset.LexBound{Unbounded: bound[0] == open}, nil
+	}
+
+	if len(bound) == 0 || (bound[0] != '[' && bound[0] != '(') {
+		return zset.LexBound{}, ErrInvalidLexBound
+	}
+
+	return zset.LexBound{Key: string(bound[1:]), Exclusive: bound[0] == '('}, nil
+}
+
+// ZRangeByScoreInt returns all the elements in the sorted set at bucket with an exact int64 score between min and max.
+// It returns ErrScoreTypeMismatch if the sorted set holds float64 scores.
+func (tx *Tx) ZRangeByScoreInt(bucket string, start, end int64, opts *zset.GetByScoreRangeOptions) ([]*zset.SortedSetNode, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return nil, ErrBucket
+	}
+
+	if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreInt64); err != nil {
+		return nil, err
+	}
+
+	return sortedSet.GetByIntScoreRange(start, end, opts), nil
 }
 
 // ZRangeByRank returns all the elements in the sorted set in one bucket and key
 // with a rank between start and end (including elements with rank equal to start or end).
 func (tx *Tx) ZRangeByRank(bucket string, start, end int) ([]*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return nil, ErrBucket
+	}
+
+	return sortedSet.GetByRankRange(start, end, false), nil
+}
+
+// ZRevRangeByRank returns the elements in the sorted set in one bucket with a reverse rank between start and end
+// (including elements with rank equal to start or end), from the highest score down. The ranks are the ones of
+// ZRevRank, so the ties come out in the reverse order of ZRangeByRank, and a negative rank counts from the lowest
+// score, -1 being the lowest. It walks only the elements returned, so the top N of a large sorted set is cheap.
+func (tx *Tx) ZRevRangeByRank(bucket string, start, end int) ([]*zset.SortedSetNode, error) {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].GetByRankRange(start, end, false), nil
+	return sortedSet.GetByRevRankRange(start, end), nil
 }
 
 // ZRem removes the specified members from the sorted set stored in one bucket at given bucket and key.
 func (tx *Tx) ZRem(bucket, key string) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	if _, ok := tx.sortedSet(bucket); !ok {
 		return ErrBucket
 	}
 
-	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, tx.nowSeconds(), DataStructureSortedSet)
 }
 
 // ZRemRangeByRank removes all elements in the sorted set stored in one bucket at given bucket with rank between start and end.
 // the rank is 1-based integer. Rank 1 means the first node; Rank -1 means the last node.
 func (tx *Tx) ZRemRangeByRank(bucket string, start, end int) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	if _, ok := tx.sortedSet(bucket); !ok {
 		return ErrBucket
 	}
 
 	newKey := strconv2.IntToStr(start)
 	newVal := strconv2.IntToStr(end)
-	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, tx.nowSeconds(), DataStructureSortedSet)
+}
+
+// ZRemRangeByScore removes the members of the sorted set at bucket with a score between start and end and
+// returns how many it removed, the range and opts work like in ZRangeByScore. It returns 0 and a nil error
+// if the sorted set does not exist. Like ZRangeByScore it sees the members committed before the tx, each removal
+// is written as a ZRem of the member.
+func (tx *Tx) ZRemRangeByScore(bucket string, start, end float64, opts *zset.GetByScoreRangeOptions) (int, error) {
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+
+	nodes, err := tx.ZRangeByScore(bucket, start, end, opts)
+	if err == ErrBucket {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, node := range nodes {
+		if err := tx.ZRem(bucket, node.Key()); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(nodes), nil
+}
+
+// ZUnionStore stores the union of the sorted sets at srcBuckets into the sorted set at destBucket, replacing
+// its members, and returns the number of members stored. The score of each member is its score in every
+// sorted set it is in, multiplied by the weight of the sorted set and combined by the aggregate. weights
+// has a weight per sorted set, or is nil to weigh them all 1. The value of a member is taken from the first
+// sorted set it is in. A missing sorted set counts as empty, and destBucket may be one of srcBuckets.
+// An empty union leaves no sorted set at destBucket.
+//
+// Like ZRangeByScore it reads the members committed before the tx. It returns ErrScoreNaN if a score would
+// not be a number, e.g. the sum of +Inf and -Inf, and ErrScoreTypeMismatch for the sorted sets of int64 scores.
+func (tx *Tx) ZUnionStore(destBucket string, srcBuckets []string, weights []float64, aggregate Aggregate) (int, error) {
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+
+	sortedSets, err := tx.zStoreSources(srcBuckets, weights, aggregate)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	members := make(map[string]*zStoreMember)
+	for i, sortedSet := range sortedSets {
+		if sortedSet == nil {
+			continue
+		}
+
+		for _, node := range sortedSet.GetByRankRange(1, -1, false) {
+			score := float64(node.Score()) * zStoreWeight(weights, i)
+			if m, ok := members[node.Key()]; ok {
+				score = aggregate.combine(m.score, score)
+				m.score = score
+			} else {
+				keys = append(keys, node.Key())
+				members[node.Key()] = &zStoreMember{score: score, value: node.Value}
+			}
+
+			if math.IsNaN(score) {
+				return 0, ErrScoreNaN
+			}
+		}
+	}
+
+	return tx.zStore(destBucket, keys, members)
+}
+
+// ZInterStore stores the intersection of the sorted sets at srcBuckets into the sorted set at destBucket,
+// replacing its members, and returns the number of members stored. Only the members in every sorted set
+// are kept, with the scores weighed and combined like in ZUnionStore and the value of the first sorted set.
+// A missing sorted set counts as empty, so the intersection is empty, and destBucket may be one of srcBuckets.
+// An empty intersection leaves no sorted set at destBucket.
+//
+// It walks the smallest sorted set and looks its members up in the others. Like ZRangeByScore it reads
+// the members committed before the tx.
+func (tx *Tx) ZInterStore(destBucket string, srcBuckets []string, weights []float64, aggregate Aggregate) (int, error) {
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+
+	sortedSets, err := tx.zStoreSources(srcBuckets, weights, aggregate)
+	if err != nil {
+		return 0, err
+	}
+
+	smallest := -1
+	for i, sortedSet := range sortedSets {
+		if sortedSet == nil {
+			return tx.zStore(destBucket, nil, nil)
+		}
+		if smallest < 0 || sortedSet.Size() < sortedSets[smallest].Size() {
+			smallest = i
+		}
+	}
+	if smallest < 0 {
+		return tx.zStore(destBucket, nil, nil)
+	}
+
+	var keys []string
+	members := make(map[string]*zStoreMember)
+	for _, node := range sortedSets[smallest].GetByRankRange(1, -1, false) {
+		var m *zStoreMember
+		for i, sortedSet := range sortedSets {
+			found := sortedSet.GetByKey(node.Key())
+			if found == nil {
+				m = nil
+				break
+			}
+
+			score := float64(found.Score()) * zStoreWeight(weights, i)
+			if m == nil {
+				m = &zStoreMember{score: score, value: found.Value}
+			} else {
+				m.score = aggregate.combine(m.score, score)
+			}
+
+			if math.IsNaN(m.score) {
+				return 0, ErrScoreNaN
+			}
+		}
+
+		if m != nil {
+			keys = append(keys, node.Key())
+			members[node.Key()] = m
+		}
+	}
+
+	return tx.zStore(destBucket, keys, members)
+}
+
+// zStoreMember represents a member computed by ZUnionStore or ZInterStore.
+type zStoreMember struct {
+	score float64
+	value []byte
+}
+
+// zStoreSources validates the arguments of ZUnionStore and ZInterStore and returns the sorted sets
+// at srcBuckets, nil for the missing ones.
+func (tx *Tx) zStoreSources(srcBuckets []string, weights []float64, aggregate Aggregate) ([]*zset.SortedSet, error) {
+	if err := tx.beginOp(); err != nil {
+		return nil, err
+	}
+	defer tx.endOp()
+
+	if weights != nil && len(weights) != len(srcBuckets) {
+		return nil, ErrZSetWeights
+	}
+
+	if aggregate < AggregateSum || aggregate > AggregateMax {
+		return nil, ErrAggregate
+	}
+
+	sortedSets := make([]*zset.SortedSet, len(srcBuckets))
+	for i, bucket := range srcBuckets {
+		sortedSet, ok := tx.sortedSet(bucket)
+		if !ok {
+			continue
+		}
+
+		if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreFloat64); err != nil {
+			return nil, err
+		}
+		sortedSets[i] = sortedSet
+	}
+
+	return sortedSets, nil
+}
+
+// zStoreWeight returns the weight of the i-th sorted set, 1 if there are no weights.
+func zStoreWeight(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[i]
+}
+
+// zStore queues a record deleting the sorted set at destBucket and a ZAdd record for each of the members
+// in the order of keys. The records are queued all or none.
+func (tx *Tx) zStore(destBucket string, keys []string, members map[string]*zStoreMember) (int, error) {
+	queued, timestamp := len(tx.pendingWrites), tx.nowSeconds()
+	err := tx.put(destBucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, timestamp, DataStructureNone)
+	for i := 0; err == nil && i < len(keys); i++ {
+		m := members[keys[i]]
+		err = tx.zAdd(destBucket, []byte(keys[i]), []byte(strconv.FormatFloat(m.score, 'f', -1, 64)), m.value, DataZAddFlag)
+	}
+	if err != nil {
+		tx.pendingWrites = tx.pendingWrites[:queued]
+		return 0, err
+	}
+
+	return len(keys), nil
 }
 
 // ZRank returns the rank of member in the sorted set stored in the bucket at given bucket and key,
-// with the scores ordered from low to high.
+// with the scores ordered from low to high. The rank is 1-based like in ZRangeByRank, the members
+// with the same score are ordered by their key bytes. It returns ErrNotFoundKey if the member does not exist.
 func (tx *Tx) ZRank(bucket string, key []byte) (int, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].FindRank(string(key)), nil
+	if rank := sortedSet.FindRank(string(key)); rank > 0 {
+		return rank, nil
+	}
+
+	return 0, ErrNotFoundKey
 }
 
 // ZRevRank returns the rank of member in the sorted set stored in the bucket at given bucket and key,
-// with the scores ordered from high to low.
+// with the scores ordered from high to low, it is the reverse of the ZRank order. It returns ErrNotFoundKey
+// if the member does not exist.
 func (tx *Tx) ZRevRank(bucket string, key []byte) (int, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].FindRevRank(string(key)), nil
+	if rank := sortedSet.FindRevRank(string(key)); rank > 0 {
+		return rank, nil
+	}
+
+	return 0, ErrNotFoundKey
 }
 
 // ZScore returns the score of member in the sorted set in the bucket at given bucket and key.
+// It returns ErrBucket if the sorted set does not exist and ErrNotFoundKey if the member does not,
+// the lookup does not copy the member node.
 func (tx *Tx) ZScore(bucket string, key []byte) (float64, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return 0, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	if node := tx.db.SortedSetIdx[bucket].GetByKey(string(key)); node != nil {
+	if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreFloat64); err != nil {
+		return 0, err
+	}
+
+	if node := sortedSet.GetByKey(string(key)); node != nil {
 		return float64(node.Score()), nil
 	}
 
 	return 0, ErrNotFoundKey
 }
 
+// ZScoreInt returns the exact int64 score of member in the sorted set at given bucket and key.
+// It returns ErrScoreTypeMismatch if the sorted set holds float64 scores.
+func (tx *Tx) ZScoreInt(bucket string, key []byte) (int64, error) {
+	if err := tx.beginOp(); err != nil {
+		return 0, err
+	}
+	defer tx.endOp()
+
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
+		return 0, ErrBucket
+	}
+
+	if err := matchScoreType(tx.zsetScoreType(bucket), zset.ScoreInt64); err != nil {
+		return 0, err
+	}
+
+	if node := sortedSet.GetByKey(string(key)); node != nil {
+		return node.IntScore(), nil
+	}
+
+	return 0, ErrNotFoundKey
+}
+
 // ZGetByKey returns node in the bucket at given bucket and key.
 func (tx *Tx) ZGetByKey(bucket string, key []byte) (*zset.SortedSetNode, error) {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return nil, err
 	}
+	defer tx.endOp()
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	if node := tx.db.SortedSetIdx[bucket].GetByKey(string(key)); node != nil {
+	if node := sortedSet.GetByKey(string(key)); node != nil {
 		return node, nil
 	}
 
@@ -247,14 +957,18 @@ func (tx *Tx) ZGetByKey(bucket string, key []byte) (*zset.SortedSetNode, error)
 }
 
 // ZKeys find all keys matching a given pattern
+// The keys are the members of the sorted set at bucket, IterateBuckets with DataStructureSortedSet lists
+// the sorted sets themselves.
 func (tx *Tx) ZKeys(bucket, pattern string, f func(key string) bool) error {
-	if err := tx.checkTxIsClosed(); err != nil {
+	if err := tx.beginOp(); err != nil {
 		return err
 	}
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	defer tx.endOp()
+	sortedSet, ok := tx.sortedSet(bucket)
+	if !ok {
 		return ErrBucket
 	}
-	for key := range tx.db.SortedSetIdx[bucket].Dict {
+	for key := range sortedSet.Dict {
 		if end, err := MatchForRange(pattern, key, f); end || err != nil {
 			return err
 		}
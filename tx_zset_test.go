@@ -15,11 +15,18 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -659,3 +666,1325 @@ func TestTx_ZKeys(t *testing.T) {
 
 	tx.Commit()
 }
+
+func TestTx_ZAddInt(t *testing.T) {
+	bucket := "myZSet"
+	base := int64(1) << 60
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	runNutsDBTest(t, &opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.ErrorIs(t, tx.ZAddInt(bucket, []byte("int"), 1, nil), ErrScoreTypeMismatch)
+			return nil
+		}))
+		require.ErrorIs(t, db.SetZSetScoreType(bucket, zset.ScoreType(2)), ErrScoreType)
+		require.NoError(t, db.SetZSetScoreType(bucket, zset.ScoreInt64))
+
+		// base+1 and base+2 are rounded to the same float64 score as base.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := int64(3); i >= 0; i-- {
+				if err := tx.ZAddInt(bucket, []byte(fmt.Sprintf("key%d", i)), base+i, GetTestBytes(int(i))); err != nil {
+					return err
+				}
+			}
+			require.ErrorIs(t, tx.ZAdd(bucket, []byte("float"), 1, nil), ErrScoreTypeMismatch)
+			return nil
+		}))
+
+		check := func(db *DB) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for i := int64(0); i < 4; i++ {
+					score, err := tx.ZScoreInt(bucket, []byte(fmt.Sprintf("key%d", i)))
+					require.NoError(t, err)
+					require.Equal(t, base+i, score)
+				}
+
+				nodes, err := tx.ZRangeByScoreInt(bucket, base+1, base+2, nil)
+				require.NoError(t, err)
+				require.Len(t, nodes, 2)
+				require.Equal(t, "key1", nodes[0].Key())
+				require.Equal(t, "key2", nodes[1].Key())
+
+				nodes, err = tx.ZRangeByScoreInt(bucket, base+3, base, &zset.GetByScoreRangeOptions{ExcludeStart: true})
+				require.NoError(t, err)
+				require.Len(t, nodes, 3)
+				require.Equal(t, "key2", nodes[0].Key())
+				require.Equal(t, "key0", nodes[2].Key())
+
+				_, err = tx.ZScore(bucket, []byte("key0"))
+				require.ErrorIs(t, err, ErrScoreTypeMismatch)
+				_, err = tx.ZRangeByScore(bucket, 0, 1, nil)
+				require.ErrorIs(t, err, ErrScoreTypeMismatch)
+				return nil
+			}))
+		}
+		check(db)
+
+		require.NoError(t, db.Close())
+		db, err := Open(opts)
+		require.NoError(t, err)
+		check(db)
+
+		// the score type of a sorted set holding members can't be changed.
+		require.ErrorIs(t, db.SetZSetScoreType(bucket, zset.ScoreFloat64), ErrScoreTypeMismatch)
+
+		// the score type outlives the deleted bucket.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.DeleteBucket(DataStructureSortedSet, bucket))
+			require.ErrorIs(t, tx.ZAdd(bucket, []byte("key0"), 1, nil), ErrScoreTypeMismatch)
+			return tx.ZAddInt(bucket, []byte("key0"), base, nil)
+		}))
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+		scoreType, err := db.ZSetScoreType(bucket)
+		require.NoError(t, err)
+		require.Equal(t, zset.ScoreInt64, scoreType)
+
+		// the emptied sorted set can be set to the other score type.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZRem(bucket, "key0")
+		}))
+		require.NoError(t, db.SetZSetScoreType(bucket, zset.ScoreFloat64))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZAdd(bucket, []byte("key0"), 1, nil)
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_ZRangeByScorePaging(t *testing.T) {
+	bucket := "leaderboard"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		scores := map[string]float64{"a": 900, "b": 1000, "c": 1000, "d": 1500, "e": 2000, "f": 2000, "g": 2500}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for key, score := range scores {
+				if err := tx.ZAdd(bucket, []byte(key), score, []byte(key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		zRange := func(start, end float64, opts *zset.GetByScoreRangeOptions) (keys []string) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByScore(bucket, start, end, opts)
+				for _, node := range nodes {
+					assert.Equal(t, scores[node.Key()], float64(node.Score()))
+					keys = append(keys, node.Key())
+				}
+				return err
+			}))
+			return keys
+		}
+
+		require.Equal(t, []string{"b", "c", "d", "e", "f"}, zRange(1000, 2000, nil))
+		// the members tied on an excluded bound are all left out.
+		require.Equal(t, []string{"d", "e", "f"}, zRange(1000, 2000, &zset.GetByScoreRangeOptions{ExcludeStart: true}))
+		require.Equal(t, []string{"b", "c", "d"}, zRange(1000, 2000, &zset.GetByScoreRangeOptions{ExcludeEnd: true}))
+		require.Equal(t, []string{"d"}, zRange(1000, 2000, &zset.GetByScoreRangeOptions{ExcludeStart: true, ExcludeEnd: true}))
+
+		var pages [][]string
+		for offset := 0; offset < 5; offset += 2 {
+			pages = append(pages, zRange(1000, 2000, &zset.GetByScoreRangeOptions{Offset: offset, Limit: 2}))
+		}
+		require.Equal(t, [][]string{{"b", "c"}, {"d", "e"}, {"f"}}, pages)
+		require.Empty(t, zRange(1000, 2000, &zset.GetByScoreRangeOptions{Offset: 5, Limit: 2}))
+		require.Empty(t, zRange(1000, 2000, &zset.GetByScoreRangeOptions{Offset: 100}))
+
+		require.Equal(t, []string{"f", "e", "d"}, zRange(2000, 1000, &zset.GetByScoreRangeOptions{Offset: 0, Limit: 3}))
+		require.Equal(t, []string{"d", "c"}, zRange(2000, 1000, &zset.GetByScoreRangeOptions{Offset: 2, Limit: 2}))
+
+		require.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, zRange(math.Inf(-1), math.Inf(1), nil))
+		require.Equal(t, []string{"e", "f", "g"}, zRange(1500, math.Inf(1), &zset.GetByScoreRangeOptions{ExcludeStart: true}))
+		require.Equal(t, []string{"a"}, zRange(math.Inf(-1), 1000, &zset.GetByScoreRangeOptions{ExcludeEnd: true}))
+		require.Equal(t, []string{"g", "f"}, zRange(math.Inf(1), math.Inf(-1), &zset.GetByScoreRangeOptions{Limit: 2}))
+	})
+}
+
+func TestTx_ZIncrBy(t *testing.T) {
+	bucket := "counters"
+	key := []byte("hits")
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		zScore := func(db *DB) (score float64) {
+			require.NoError(t, db.View(func(tx *Tx) (err error) {
+				score, err = tx.ZScore(bucket, key)
+				return err
+			}))
+			return score
+		}
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 1; i <= 1000; i++ {
+				score, err := tx.ZIncrBy(bucket, key, 1, []byte("v"))
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, float64(i), score)
+			}
+			return nil
+		}))
+		require.Equal(t, float64(1000), zScore(db))
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				_, err := tx.ZIncrBy(bucket, key, -0.5, []byte("v"))
+				return err
+			}))
+		}
+		require.Equal(t, float64(995), zScore(db))
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, reopened.Close()) }()
+		require.Equal(t, float64(995), zScore(reopened))
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			score, err := tx.ZIncrBy(bucket, key, 5, []byte("v"))
+			assert.Equal(t, float64(1000), score)
+			return err
+		}))
+		require.Equal(t, float64(1000), zScore(reopened))
+
+		// ZRem and ZIncrBy in one tx start over from 0.
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			if err := tx.ZRem(bucket, string(key)); err != nil {
+				return err
+			}
+			score, err := tx.ZIncrBy(bucket, key, 3, []byte("v"))
+			assert.Equal(t, float64(3), score)
+			return err
+		}))
+		require.Equal(t, float64(3), zScore(reopened))
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			score, err := tx.ZIncrBy(bucket, []byte("new"), 2.5, []byte("v"))
+			assert.Equal(t, 2.5, score)
+			return err
+		}))
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			score, err := tx.ZIncrBy(bucket, key, math.Inf(1), []byte("v"))
+			assert.True(t, math.IsInf(score, 1))
+			if err != nil {
+				return err
+			}
+			_, err = tx.ZIncrBy(bucket, key, math.Inf(-1), []byte("v"))
+			assert.Equal(t, ErrScoreNaN, err)
+			return nil
+		}))
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			_, err := tx.ZPopMin(bucket)
+			assert.NoError(t, err)
+			_, err = tx.ZIncrBy(bucket, key, 1, []byte("v"))
+			assert.Equal(t, ErrZSetPendingRemoval, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZScoreOfMember(t *testing.T) {
+	bucket := "leaderboard"
+	scores := map[string]float64{"neg": -12.75, "zero": 0, "frac": 0.1, "big": 1e300}
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for key, score := range scores {
+				if err := tx.ZAdd(bucket, []byte(key), score, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for key, score := range scores {
+				got, err := tx.ZScore(bucket, []byte(key))
+				assert.NoError(t, err)
+				assert.Equal(t, score, got, key)
+			}
+
+			_, err := tx.ZScore(bucket, []byte("missing"))
+			assert.Equal(t, ErrNotFoundKey, err)
+			_, err = tx.ZScore("missing", []byte("neg"))
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZRankConsistency(t *testing.T) {
+	bucket := "leaderboard"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				// ten members share each score and are ordered by key.
+				if err := tx.ZAdd(bucket, []byte(fmt.Sprintf("player%03d", i)), float64(i%10), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		checkRanks := func() {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+				require.NoError(t, err)
+				require.Len(t, nodes, 100)
+
+				for i, node := range nodes {
+					if i > 0 && nodes[i-1].Score() == node.Score() {
+						assert.Less(t, nodes[i-1].Key(), node.Key())
+					}
+
+					rank, err := tx.ZRank(bucket, []byte(node.Key()))
+					assert.NoError(t, err)
+					assert.Equal(t, i+1, rank)
+
+					revRank, err := tx.ZRevRank(bucket, []byte(node.Key()))
+					assert.NoError(t, err)
+					assert.Equal(t, len(nodes)-i, revRank)
+				}
+				return nil
+			}))
+		}
+		checkRanks()
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			rank, err := tx.ZRank(bucket, []byte("player000"))
+			assert.Equal(t, 1, rank)
+			return err
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZAdd(bucket, []byte("player000"), 100, nil)
+		}))
+		checkRanks()
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			rank, err := tx.ZRank(bucket, []byte("player000"))
+			assert.Equal(t, 100, rank)
+			assert.NoError(t, err)
+
+			rank, err = tx.ZRevRank(bucket, []byte("player000"))
+			assert.Equal(t, 1, rank)
+			assert.NoError(t, err)
+
+			_, err = tx.ZRank(bucket, []byte("missing"))
+			assert.Equal(t, ErrNotFoundKey, err)
+			_, err = tx.ZRevRank(bucket, []byte("missing"))
+			assert.Equal(t, ErrNotFoundKey, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZPopInOrder(t *testing.T) {
+	bucket := "queue"
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				if err := tx.ZAdd(bucket, []byte(fmt.Sprintf("job%02d", i)), float64(i), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		keys := func(nodes []*zset.SortedSetNode) (keys []string) {
+			for _, node := range nodes {
+				keys = append(keys, node.Key())
+			}
+			return keys
+		}
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			node, err := tx.ZPopMin(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, "job00", node.Key())
+
+			node, err = tx.ZPopMin(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, "job01", node.Key())
+
+			nodes, err := tx.ZPopMinN(bucket, 3)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"job02", "job03", "job04"}, keys(nodes))
+
+			node, err = tx.ZPopMax(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, "job19", node.Key())
+
+			nodes, err = tx.ZPopMaxN(bucket, 2)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"job18", "job17"}, keys(nodes))
+
+			nodes, err = tx.ZPopMinN(bucket, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, nodes)
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			require.NoError(t, err)
+			assert.Len(t, nodes, 12)
+			assert.Equal(t, "job05", nodes[0].Key())
+			assert.Equal(t, "job16", nodes[len(nodes)-1].Key())
+			return nil
+		}))
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, reopened.Close()) }()
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			nodes, err := tx.ZPopMinN(bucket, 100)
+			require.NoError(t, err)
+			assert.Len(t, nodes, 12)
+			for i, node := range nodes {
+				assert.Equal(t, fmt.Sprintf("job%02d", i+5), node.Key())
+			}
+
+			_, err = tx.ZPopMin(bucket)
+			assert.Equal(t, ErrSortedSetEmpty, err)
+			_, err = tx.ZPopMaxN(bucket, 1)
+			assert.Equal(t, ErrSortedSetEmpty, err)
+			return nil
+		}))
+
+		require.NoError(t, reopened.Close())
+		reopened, err = Open(opts)
+		require.NoError(t, err)
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			num, err := tx.ZCard(bucket)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, num)
+
+			_, err = tx.ZPopMax(bucket)
+			assert.Equal(t, ErrSortedSetEmpty, err)
+
+			// the pops can not see through the other pending writes of the sorted set.
+			require.NoError(t, tx.ZAdd(bucket, []byte("late"), 1, nil))
+			_, err = tx.ZPopMin(bucket)
+			assert.Equal(t, ErrZSetPendingWrite, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZRangeByLex(t *testing.T) {
+	bucket := "names"
+
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, key := range []string{"apple", "banana", "cherry", "date", "elder", "fig"} {
+				if err := tx.ZAdd(bucket, []byte(key), 0, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		zRangeByLex := func(bucket, min, max string, opts *zset.GetByLexRangeOptions) (keys []string) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByLex(bucket, []byte(min), []byte(max), opts)
+				for _, node := range nodes {
+					keys = append(keys, node.Key())
+				}
+				return err
+			}))
+			return keys
+		}
+
+		require.Equal(t, []string{"apple", "banana", "cherry", "date", "elder", "fig"}, zRangeByLex(bucket, "-", "+", nil))
+		require.Equal(t, []string{"banana", "cherry", "date"}, zRangeByLex(bucket, "[banana", "[date", nil))
+		// the exclusive bounds land exactly on the members.
+		require.Equal(t, []string{"cherry"}, zRangeByLex(bucket, "(banana", "(date", nil))
+		require.Equal(t, []string{"banana", "cherry"}, zRangeByLex(bucket, "[b", "(d", nil))
+		require.Equal(t, []string{"elder", "fig"}, zRangeByLex(bucket, "(date", "+", nil))
+		require.Equal(t, []string{"apple"}, zRangeByLex(bucket, "-", "(banana", nil))
+		require.Empty(t, zRangeByLex(bucket, "(banana", "(cherry", nil))
+		require.Empty(t, zRangeByLex(bucket, "[date", "[banana", nil))
+		require.Empty(t, zRangeByLex(bucket, "+", "+", nil))
+		require.Empty(t, zRangeByLex(bucket, "-", "-", nil))
+
+		require.Equal(t, []string{"cherry", "date"}, zRangeByLex(bucket, "-", "+", &zset.GetByLexRangeOptions{Offset: 2, Limit: 2}))
+		require.Equal(t, []string{"fig"}, zRangeByLex(bucket, "[c", "+", &zset.GetByLexRangeOptions{Offset: 3}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for _, bound := range []string{"", "apple", "-a"} {
+				_, err := tx.ZRangeByLex(bucket, []byte(bound), []byte("+"), nil)
+				assert.Equal(t, ErrInvalidLexBound, err)
+			}
+			_, err := tx.ZRangeByLex("missing", []byte("-"), []byte("+"), nil)
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+
+		// with different scores the members keep the score order and the first run in the range is returned.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for key, score := range map[string]float64{"a": 1, "d": 2, "b": 3, "c": 4, "e": 5} {
+				if err := tx.ZAdd("mixed", []byte(key), score, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.Equal(t, []string{"a", "d", "b", "c", "e"}, zRangeByLex("mixed", "-", "+", nil))
+		require.Equal(t, []string{"b", "c"}, zRangeByLex("mixed", "[b", "[c", nil))
+		require.Equal(t, []string{"d", "b", "c"}, zRangeByLex("mixed", "(a", "[d", nil))
+	})
+}
+
+func TestTx_ZRemRangeByScore(t *testing.T) {
+	bucket := "leaderboard"
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if err := tx.ZAdd(bucket, []byte(fmt.Sprintf("player%d", i)), float64(i*10), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		zRemRangeByScore := func(db *DB, start, end float64, opts *zset.GetByScoreRangeOptions) (removed int) {
+			require.NoError(t, db.Update(func(tx *Tx) (err error) {
+				removed, err = tx.ZRemRangeByScore(bucket, start, end, opts)
+				return err
+			}))
+			return removed
+		}
+		keys := func(db *DB) (keys []string) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+				for _, node := range nodes {
+					keys = append(keys, node.Key())
+				}
+				return err
+			}))
+			return keys
+		}
+
+		// the exclusive bounds land on player2 and player4.
+		require.Equal(t, 1, zRemRangeByScore(db, 20, 40, &zset.GetByScoreRangeOptions{ExcludeStart: true, ExcludeEnd: true}))
+		require.Equal(t, 2, zRemRangeByScore(db, math.Inf(-1), 15, nil))
+		require.Equal(t, 2, zRemRangeByScore(db, 80, math.Inf(1), nil))
+		require.Equal(t, 0, zRemRangeByScore(db, 31, 39, nil))
+		require.Equal(t, []string{"player2", "player4", "player5", "player6", "player7"}, keys(db))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			removed, err := tx.ZRemRangeByScore("missing", math.Inf(-1), math.Inf(1), nil)
+			assert.Equal(t, 0, removed)
+			return err
+		}))
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, reopened.Close()) }()
+		require.Equal(t, []string{"player2", "player4", "player5", "player6", "player7"}, keys(reopened))
+
+		require.Equal(t, 5, zRemRangeByScore(reopened, math.Inf(1), math.Inf(-1), nil))
+		require.Empty(t, keys(reopened))
+	})
+}
+
+func TestTx_ZCardTracksChanges(t *testing.T) {
+	bucket := "leaderboard"
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		zCard := func(db *DB) (num int) {
+			require.NoError(t, db.View(func(tx *Tx) (err error) {
+				num, err = tx.ZCard(bucket)
+				return err
+			}))
+			return num
+		}
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err := tx.ZCard(bucket)
+			assert.Equal(t, 0, num)
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				if err := tx.ZAdd(bucket, GetTestBytes(i), float64(i), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.Equal(t, 100, zCard(db))
+
+		// updating the score of a member does not change the cardinality.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZAdd(bucket, GetTestBytes(0), 1000, nil)
+		}))
+		require.Equal(t, 100, zCard(db))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if err := tx.ZRem(bucket, string(GetTestBytes(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.Equal(t, 90, zCard(db))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZRemRangeByRank(bucket, 1, 20)
+		}))
+		require.Equal(t, 70, zCard(db))
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, reopened.Close()) }()
+		require.Equal(t, 70, zCard(reopened))
+
+		require.NoError(t, reopened.View(func(tx *Tx) error {
+			assert.Zero(t, testing.AllocsPerRun(100, func() { _, _ = tx.ZCard(bucket) }))
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZUnionStore(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for key, score := range map[string]float64{"alice": 10, "bob": 20, "carol": 30} {
+				if err := tx.ZAdd("eu", []byte(key), score, []byte("eu")); err != nil {
+					return err
+				}
+			}
+			for key, score := range map[string]float64{"bob": 5, "carol": 50, "dave": 40} {
+				if err := tx.ZAdd("us", []byte(key), score, []byte("us")); err != nil {
+					return err
+				}
+			}
+			return tx.ZAdd("empty", []byte("x"), 1, nil)
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZRem("empty", "x")
+		}))
+
+		scores := func(db *DB, bucket string) map[string]float64 {
+			scores := make(map[string]float64)
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+				for _, node := range nodes {
+					scores[node.Key()] = float64(node.Score())
+				}
+				return err
+			}))
+			return scores
+		}
+		zUnionStore := func(dest string, srcs []string, weights []float64, aggregate Aggregate) (num int) {
+			require.NoError(t, db.Update(func(tx *Tx) (err error) {
+				num, err = tx.ZUnionStore(dest, srcs, weights, aggregate)
+				return err
+			}))
+			return num
+		}
+
+		require.Equal(t, 4, zUnionStore("global", []string{"eu", "us", "empty", "missing"}, nil, AggregateSum))
+		require.Equal(t, map[string]float64{"alice": 10, "bob": 25, "carol": 80, "dave": 40}, scores(db, "global"))
+
+		// the union replaces the members of the destination.
+		require.Equal(t, 4, zUnionStore("global", []string{"eu", "us"}, nil, AggregateMin))
+		require.Equal(t, map[string]float64{"alice": 10, "bob": 5, "carol": 30, "dave": 40}, scores(db, "global"))
+
+		require.Equal(t, 4, zUnionStore("global", []string{"eu", "us"}, []float64{2, 0.5}, AggregateMax))
+		require.Equal(t, map[string]float64{"alice": 20, "bob": 40, "carol": 60, "dave": 20}, scores(db, "global"))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			node, err := tx.ZGetByKey("global", []byte("dave"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("us"), node.Value)
+			node, err = tx.ZGetByKey("global", []byte("bob"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("eu"), node.Value)
+			return nil
+		}))
+
+		// the destination may be one of the sources.
+		require.Equal(t, 4, zUnionStore("eu", []string{"eu", "us"}, nil, AggregateSum))
+		require.Equal(t, map[string]float64{"alice": 10, "bob": 25, "carol": 80, "dave": 40}, scores(db, "eu"))
+
+		// an empty union leaves no sorted set at the destination.
+		require.Equal(t, 0, zUnionStore("global", []string{"empty", "missing"}, nil, AggregateSum))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.ZCard("global")
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, reopened.Close()) }()
+		require.Equal(t, map[string]float64{"alice": 10, "bob": 25, "carol": 80, "dave": 40}, scores(reopened, "eu"))
+		require.NoError(t, reopened.View(func(tx *Tx) error {
+			_, err := tx.ZCard("global")
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			_, err := tx.ZUnionStore("global", []string{"eu", "us"}, []float64{1}, AggregateSum)
+			assert.Equal(t, ErrZSetWeights, err)
+			_, err = tx.ZUnionStore("global", []string{"eu"}, nil, Aggregate(5))
+			assert.Equal(t, ErrAggregate, err)
+
+			require.NoError(t, tx.ZAdd("inf", []byte("bob"), math.Inf(1), nil))
+			return nil
+		}))
+		require.NoError(t, reopened.Update(func(tx *Tx) error {
+			_, err := tx.ZUnionStore("global", []string{"inf", "us"}, []float64{1, math.Inf(-1)}, AggregateSum)
+			assert.Equal(t, ErrScoreNaN, err)
+			assert.Empty(t, tx.pendingWrites)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZInterStore(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for key, score := range map[string]float64{"alice": 10, "bob": 20, "carol": 30, "erin": 1} {
+				if err := tx.ZAdd("lastWeek", []byte(key), score, []byte("last")); err != nil {
+					return err
+				}
+			}
+			for key, score := range map[string]float64{"bob": 5, "carol": 50, "dave": 40} {
+				if err := tx.ZAdd("thisWeek", []byte(key), score, []byte("this")); err != nil {
+					return err
+				}
+			}
+			for key, score := range map[string]float64{"frank": 1, "grace": 2} {
+				if err := tx.ZAdd("other", []byte(key), score, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		scores := func(bucket string) map[string]float64 {
+			scores := make(map[string]float64)
+			require.NoError(t, db.View(func(tx *Tx) error {
+				nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+				for _, node := range nodes {
+					scores[node.Key()] = float64(node.Score())
+				}
+				return err
+			}))
+			return scores
+		}
+		zInterStore := func(dest string, srcs []string, weights []float64, aggregate Aggregate) (num int) {
+			require.NoError(t, db.Update(func(tx *Tx) (err error) {
+				num, err = tx.ZInterStore(dest, srcs, weights, aggregate)
+				return err
+			}))
+			return num
+		}
+
+		require.Equal(t, 2, zInterStore("active", []string{"lastWeek", "thisWeek"}, nil, AggregateSum))
+		require.Equal(t, map[string]float64{"bob": 25, "carol": 80}, scores("active"))
+
+		require.Equal(t, 2, zInterStore("active", []string{"lastWeek", "thisWeek"}, []float64{0.5, 3}, AggregateSum))
+		require.Equal(t, map[string]float64{"bob": 25, "carol": 165}, scores("active"))
+
+		require.Equal(t, 2, zInterStore("active", []string{"lastWeek", "thisWeek"}, []float64{2, 1}, AggregateMin))
+		require.Equal(t, map[string]float64{"bob": 5, "carol": 50}, scores("active"))
+
+		require.Equal(t, 2, zInterStore("active", []string{"lastWeek", "thisWeek"}, []float64{2, 1}, AggregateMax))
+		require.Equal(t, map[string]float64{"bob": 40, "carol": 60}, scores("active"))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			node, err := tx.ZGetByKey("active", []byte("carol"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("last"), node.Value)
+			return nil
+		}))
+
+		// the destination is replaced, so the disjoint sources leave no sorted set at it.
+		require.Equal(t, 0, zInterStore("active", []string{"lastWeek", "other"}, nil, AggregateSum))
+		require.Equal(t, 0, zInterStore("thisWeek", []string{"thisWeek", "missing"}, nil, AggregateSum))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.ZCard("active")
+			assert.Equal(t, ErrBucket, err)
+			_, err = tx.ZCard("thisWeek")
+			assert.Equal(t, ErrBucket, err)
+			return nil
+		}))
+
+		// the destination may be one of the sources.
+		require.Equal(t, 4, zInterStore("lastWeek", []string{"lastWeek"}, []float64{10}, AggregateSum))
+		require.Equal(t, map[string]float64{"alice": 100, "bob": 200, "carol": 300, "erin": 10}, scores("lastWeek"))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			_, err := tx.ZInterStore("active", []string{"lastWeek", "other"}, []float64{1}, AggregateSum)
+			assert.Equal(t, ErrZSetWeights, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_IterateSortedSetBuckets(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, bucket := range []string{"mode:ranked", "mode:casual", "mode:arcade", "season:1"} {
+				if err := tx.ZAdd(bucket, []byte("alice"), 1, nil); err != nil {
+					return err
+				}
+			}
+			if err := tx.SAdd("mode:set", []byte("key"), []byte("a")); err != nil {
+				return err
+			}
+			return tx.Put("mode:kv", []byte("key"), []byte("a"), Persistent)
+		}))
+
+		buckets := func(pattern string) (buckets []string) {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				return tx.IterateBuckets(DataStructureSortedSet, pattern, func(bucket string) bool {
+					buckets = append(buckets, bucket)
+					return true
+				})
+			}))
+			sort.Strings(buckets)
+			return buckets
+		}
+
+		require.Equal(t, []string{"mode:arcade", "mode:casual", "mode:ranked"}, buckets("mode:*"))
+		require.Equal(t, []string{"mode:arcade", "mode:casual"}, buckets("mode:*a[rs]*"))
+		require.Equal(t, []string{"mode:arcade", "mode:casual", "mode:ranked", "season:1"}, buckets("*"))
+		require.Empty(t, buckets("mode:set"))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			calls := 0
+			err := tx.IterateBuckets(DataStructureSortedSet, "mode:*", func(bucket string) bool {
+				calls++
+				return false
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 1, calls)
+
+			err = tx.IterateBuckets(DataStructureSortedSet, "[", func(bucket string) bool {
+				return true
+			})
+			assert.Equal(t, filepath.ErrBadPattern, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ExpireZSetLifetime(t *testing.T) {
+	expiring, refreshed, later, addedAgain := "expiring", "refreshed", "later", "addedAgain"
+
+	opts := DefaultOptions
+	opts.Dir = "/tmp/test-nutsdb-expire-zset"
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	reopen := func() {
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+	}
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// the ttl counts from the second of the timestamp, start right after it so the expiry times are exact.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	members := func(bucket string) (members []string, err error) {
+		err = db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			for _, node := range nodes {
+				members = append(members, fmt.Sprintf("%s:%v", node.Key(), node.Score()))
+			}
+			return err
+		})
+		return members, err
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, bucket := range []string{expiring, refreshed, later, addedAgain} {
+			if err := tx.ZAdd(bucket, []byte("a"), 1, nil); err != nil {
+				return err
+			}
+			if err := tx.ZAdd(bucket, []byte("b"), 2, nil); err != nil {
+				return err
+			}
+		}
+		if err := tx.ExpireZSet(expiring, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireZSet(addedAgain, 1); err != nil {
+			return err
+		}
+		if err := tx.ExpireZSet(later, 2); err != nil {
+			return err
+		}
+		// the ttl of a sorted set that doesn't exist is dropped.
+		if err := tx.ExpireZSet("missing", 1); err != nil {
+			return err
+		}
+		return tx.ExpireZSet(refreshed, 1)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.ExpireZSet(refreshed, 100)
+	}))
+
+	// right before the expiry the sorted sets are kept across the restart.
+	reopen()
+	for _, bucket := range []string{expiring, refreshed, later, addedAgain} {
+		got, err := members(bucket)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a:1", "b:2"}, got)
+	}
+	_, err = members("missing")
+	require.True(t, errors.Is(err, ErrBucket))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.ZScore(expiring, []byte("a"))
+		assert.Equal(t, ErrBucket, err)
+		_, err = tx.ZCard(expiring)
+		assert.Equal(t, ErrBucket, err)
+		_, err = tx.ZRank(expiring, []byte("a"))
+		assert.Equal(t, ErrBucket, err)
+		// the add starts a fresh sorted set without the expired members.
+		return tx.ZAdd(addedAgain, []byte("c"), 3, nil)
+	}))
+
+	check := func() {
+		_, err := members(expiring)
+		require.True(t, errors.Is(err, ErrBucket))
+
+		got, err := members(refreshed)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a:1", "b:2"}, got)
+
+		got, err = members(addedAgain)
+		require.NoError(t, err)
+		require.Equal(t, []string{"c:3"}, got)
+
+		var buckets []string
+		require.NoError(t, db.View(func(tx *Tx) error {
+			return tx.IterateBuckets(DataStructureSortedSet, "*", func(bucket string) bool {
+				buckets = append(buckets, bucket)
+				return true
+			})
+		}))
+		require.NotContains(t, buckets, expiring)
+		require.NotContains(t, buckets, "missing")
+	}
+	check()
+
+	// right after the expiry the expired sorted sets stay gone across the restart.
+	reopen()
+	check()
+
+	got, err := members(later)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:1", "b:2"}, got)
+
+	// the fresh sorted set doesn't keep the ttl of the expired one.
+	time.Sleep(time.Second)
+	reopen()
+	_, err = members(later)
+	require.True(t, errors.Is(err, ErrBucket))
+	got, err = members(addedAgain)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c:3"}, got)
+}
+
+func TestTx_ZScan(t *testing.T) {
+	bucket := "bucket"
+	const count, page = 500000, 10000
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		for n := 0; n < count; n += 50000 {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+50000; i++ {
+					// the repeated scores are ordered by the member.
+					if err := tx.ZAdd(bucket, GetTestBytes(i), float64(i/7), nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+
+		scan := func(cursor []byte, count int) (keys []string, next []byte, err error) {
+			err = db.View(func(tx *Tx) error {
+				var nodes []*zset.SortedSetNode
+				nodes, next, err = tx.ZScan(bucket, cursor, count)
+				for _, node := range nodes {
+					keys = append(keys, node.Key())
+				}
+				return err
+			})
+			return keys, next, err
+		}
+
+		var want []string
+		require.NoError(t, db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			for _, node := range nodes {
+				want = append(want, node.Key())
+			}
+			return err
+		}))
+		require.Len(t, want, count)
+
+		var got []string
+		var cursor []byte
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, count/page)
+			keys, next, err := scan(cursor, page)
+			require.NoError(t, err)
+			got = append(got, keys...)
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+		require.Equal(t, want, got)
+
+		// the scan resumes from the next member when the last member of the page was removed.
+		keys, cursor, err := scan(nil, page)
+		require.NoError(t, err)
+		require.Equal(t, want[:page], keys)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZRem(bucket, want[page-1])
+		}))
+		keys, _, err = scan(cursor, 2)
+		require.NoError(t, err)
+		require.Equal(t, want[page:page+2], keys)
+
+		keys, next, err := scan(cursor, 0)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+		require.Equal(t, cursor, next)
+
+		_, _, err = scan([]byte("bad"), page)
+		require.True(t, errors.Is(err, zset.ErrScanCursor))
+		err = db.View(func(tx *Tx) error {
+			_, _, err := tx.ZScan("missing", nil, page)
+			return err
+		})
+		require.True(t, errors.Is(err, ErrBucket))
+	})
+}
+
+func TestTx_ZRevRangeByRank(t *testing.T) {
+	bucket := "bucket"
+	r := rand.New(rand.NewSource(1))
+
+	opts := DefaultOptions
+	opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+
+	keys := func(nodes []*zset.SortedSetNode) (keys []string) {
+		for _, node := range nodes {
+			keys = append(keys, node.Key())
+		}
+		return keys
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 300; i++ {
+				// few distinct scores so that the ranks often break ties.
+				if err := tx.ZAdd(bucket, GetTestBytes(r.Intn(1000)), float64(r.Intn(20)), nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			require.NoError(t, err)
+			desc := keys(nodes)
+			for i, j := 0, len(desc)-1; i < j; i, j = i+1, j-1 {
+				desc[i], desc[j] = desc[j], desc[i]
+			}
+			size := len(desc)
+
+			for i, key := range desc {
+				rank, err := tx.ZRevRank(bucket, []byte(key))
+				require.NoError(t, err)
+				require.Equal(t, i+1, rank)
+			}
+
+			index := func(rank int) int {
+				if rank < 0 {
+					return size + rank
+				}
+				return rank - 1
+			}
+			for i := 0; i < 500; i++ {
+				start, end := r.Intn(size)+1, r.Intn(size)+1
+				if r.Intn(2) == 0 {
+					start = -start
+				}
+				if r.Intn(2) == 0 {
+					end = -end
+				}
+
+				var want []string
+				if from, to := index(start), index(end); from <= to {
+					want = append(want, desc[from:to+1]...)
+				} else {
+					for j := from; j >= to; j-- {
+						want = append(want, desc[j])
+					}
+				}
+				nodes, err := tx.ZRevRangeByRank(bucket, start, end)
+				require.NoError(t, err)
+				require.Equal(t, want, keys(nodes), "%d %d", start, end)
+			}
+
+			nodes, err = tx.ZRevRangeByRank(bucket, 1, 10)
+			require.NoError(t, err)
+			require.Equal(t, desc[:10], keys(nodes))
+			nodes, err = tx.ZRevRangeByRank(bucket, -3, -1)
+			require.NoError(t, err)
+			require.Equal(t, desc[size-3:], keys(nodes))
+			nodes, err = tx.ZRevRangeByRank(bucket, size-1, size+10)
+			require.NoError(t, err)
+			require.Equal(t, desc[size-2:], keys(nodes))
+			nodes, err = tx.ZRevRangeByRank(bucket, size+1, size+10)
+			require.NoError(t, err)
+			require.Empty(t, nodes)
+
+			_, err = tx.ZRevRangeByRank("missing", 1, 10)
+			require.Equal(t, ErrBucket, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ZAddBatch(t *testing.T) {
+	bucket, intBucket := "bucket", "intBucket"
+	const count = 10000
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+
+	var members []ScoredMember
+	for i := 0; i < count; i++ {
+		members = append(members, ScoredMember{Score: float64(count - i), Member: GetTestBytes(i)})
+	}
+	// the last score of a repeated member wins.
+	members = append(members, ScoredMember{Score: 0.5, Member: GetTestBytes(0)})
+
+	check := func(t *testing.T, db *DB) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			size, err := tx.ZCard(bucket)
+			require.NoError(t, err)
+			require.Equal(t, count, size)
+
+			nodes, err := tx.ZRangeByRank(bucket, 1, 2)
+			require.NoError(t, err)
+			require.Equal(t, string(GetTestBytes(0)), nodes[0].Key())
+			require.Equal(t, 0.5, float64(nodes[0].Score()))
+			require.Equal(t, string(GetTestBytes(count-1)), nodes[1].Key())
+
+			for _, i := range []int{1, 42, count - 1} {
+				score, err := tx.ZScore(bucket, GetTestBytes(i))
+				require.NoError(t, err)
+				require.Equal(t, float64(count-i), score)
+				rank, err := tx.ZRank(bucket, GetTestBytes(i))
+				require.NoError(t, err)
+				require.Equal(t, count-i+1, rank)
+			}
+			return nil
+		}))
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		require.NoError(t, db.SetZSetScoreType(intBucket, zset.ScoreInt64))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.ZAddBatch(bucket, members); err != nil {
+				return err
+			}
+			return tx.ZAddInt(intBucket, []byte("member"), 1, nil)
+		}))
+		check(t, db)
+
+		// a bad member fails the whole batch.
+		for _, bad := range []ScoredMember{{Score: math.NaN(), Member: []byte("nan")}, {Score: 1, Member: []byte("a|b")}} {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				err := tx.ZAddBatch(bucket, []ScoredMember{{Score: 1, Member: []byte("good")}, bad})
+				require.Error(t, err)
+				require.Empty(t, tx.pendingWrites)
+				return nil
+			}))
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			err := tx.ZAddBatch(intBucket, members)
+			require.Equal(t, zset.ErrScoreTypeMismatch, err)
+			return tx.ZAddBatch(bucket, nil)
+		}))
+		check(t, db)
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, reopened.Close())
+		}()
+		check(t, reopened)
+	})
+}
+
+func BenchmarkTx_ZAddBatch(b *testing.B) {
+	var members []ScoredMember
+	for i := 0; i < 50000; i++ {
+		members = append(members, ScoredMember{Score: float64(i), Member: GetTestBytes(i)})
+	}
+
+	bench := func(b *testing.B, add func(tx *Tx, bucket string) error) {
+		opts := DefaultOptions
+		opts.Dir, _ = ioutil.TempDir("", "nutsdb")
+		db, err := Open(opts)
+		require.NoError(b, err)
+		defer func() {
+			require.NoError(b, db.Close())
+			require.NoError(b, os.RemoveAll(opts.Dir))
+		}()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, db.Update(func(tx *Tx) error {
+				return add(tx, fmt.Sprint("bucket", i))
+			}))
+		}
+	}
+
+	b.Run("one call", func(b *testing.B) {
+		bench(b, func(tx *Tx, bucket string) error {
+			return tx.ZAddBatch(bucket, members)
+		})
+	})
+
+	b.Run("one call per member", func(b *testing.B) {
+		bench(b, func(tx *Tx, bucket string) error {
+			for _, member := range members {
+				if err := tx.ZAdd(bucket, member.Member, member.Score, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func TestTx_ZMembersAcrossSegments(t *testing.T) {
+	bucket := "bucket"
+	const count = 3000
+
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.SegmentSize = 32 * KB
+
+	check := func(t *testing.T, db *DB) {
+		var members map[string]*zset.SortedSetNode
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			members, err = tx.ZMembers(bucket)
+			require.NoError(t, err)
+			require.Len(t, members, count)
+			for i := 0; i < count; i++ {
+				node := members[string(GetTestBytes(i))]
+				require.NotNil(t, node)
+				require.Equal(t, zset.SCORE(i%100), node.Score())
+				require.Equal(t, fmt.Sprint("value", i), string(node.Value))
+			}
+			return nil
+		}))
+
+		// the map is a copy, changing it doesn't change the sorted set, and the writes don't change it.
+		delete(members, string(GetTestBytes(0)))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.ZAdd(bucket, []byte("other"), 1, nil)
+		}))
+		require.Len(t, members, count-1)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			size, err := tx.ZCard(bucket)
+			require.Equal(t, count+1, size)
+			if err != nil {
+				return err
+			}
+			return tx.ZRem(bucket, "other")
+		}))
+	}
+
+	withDBOption(t, opts, func(t *testing.T, db *DB) {
+		for n := 0; n < count; n += 500 {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := n; i < n+500; i++ {
+					if err := tx.ZAdd(bucket, GetTestBytes(i), float64(i%100), []byte(fmt.Sprint("value", i))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		_, fileIDs := db.getMaxFileIDAndFileIDs()
+		require.Greater(t, len(fileIDs), 2)
+		check(t, db)
+
+		require.NoError(t, db.Close())
+		reopened, err := Open(opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, reopened.Close())
+		}()
+		check(t, reopened)
+	})
+}
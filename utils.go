@@ -17,6 +17,7 @@ package nutsdb
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"io"
 	"os"
@@ -92,10 +93,11 @@ func getBucketMetaPath(dir string) string {
 	return getMetaPath(dir) + separator + "bucket"
 }
 
-// getBucketMetaFilePath returns the path for the bucket meta file with the given name.
-func getBucketMetaFilePath(name, dir string) string {
+// getBucketMetaFilePath returns the path for the bucket meta file of the given bucket, the bucket is hex-encoded
+// so any bucket name is a valid file name.
+func getBucketMetaFilePath(bucket, dir string) string {
 	separator := string(filepath.Separator)
-	return getBucketMetaPath(dir) + separator + name + BucketMetaSuffix
+	return getBucketMetaPath(dir) + separator + hex.EncodeToString([]byte(bucket)) + BucketMetaSuffix
 }
 
 // getBPTDir returns the BPT directory path in the specified directory.
@@ -104,6 +106,12 @@ func getBPTDir(dir string) string {
 	return dir + separator + bptDir
 }
 
+// getBPTVersionPath returns the path for the format version file of the BPT indexes in the specified directory.
+func getBPTVersionPath(dir string) string {
+	separator := string(filepath.Separator)
+	return getBPTDir(dir) + separator + BPTSparseIdxVersionName
+}
+
 // getBPTPath returns the BPT index path for the given file ID.
 func getBPTPath(fID int64, dir string) string {
 	separator := string(filepath.Separator)
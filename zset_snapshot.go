@@ -0,0 +1,350 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+)
+
+// SortedSetSnapshotName is the file of the sorted sets written by Close, see Options.SortedSetSnapshot.
+const SortedSetSnapshotName = "nutsdb-zset-snapshot"
+
+// sortedSetSnapshotVersion is the version of the format of the sorted set snapshot.
+const sortedSetSnapshotVersion = 1
+
+// sortedSetSnapshotMagic starts the sorted set snapshot.
+var sortedSetSnapshotMagic = []byte("NUTSZSET")
+
+// errSortedSetSnapshot is returned when the sorted set snapshot can not be loaded, Open replays all the
+// sorted set records then.
+var errSortedSetSnapshot = errors.New("bad sorted set snapshot")
+
+// sortedSetSnapshot is the watermark of a sorted set snapshot: the sorted sets of the snapshot hold the
+// records of the data files before the offset in the data file of fileID.
+//
+// The snapshot is written as: magic | version | fileID | offset | data file IDs | sorted sets | crc32,
+// the crc32 covering everything before it. A sorted set is: bucket | score type | ttl | timestamp | nodes,
+// and a node is: key | score | int score | value, in rank order.
+type sortedSetSnapshot struct {
+	fileID int64
+	offset int64
+}
+
+// covers returns if the record of a sorted set is loaded from the snapshot, so Open must not replay it.
+func (s *sortedSetSnapshot) covers(r *Record) bool {
+	if s == nil {
+		return false
+	}
+
+	return r.H.FileID < s.fileID || r.H.FileID == s.fileID && int64(r.H.DataPos) < s.offset
+}
+
+// removeSortedSetSnapshot removes the sorted set snapshot, so that Open replays all the records.
+func removeSortedSetSnapshot(dir string) error {
+	err := os.Remove(filepath.Join(dir, SortedSetSnapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// useSortedSetSnapshot returns if the sorted sets are written to and loaded from the snapshot.
+func (db *DB) useSortedSetSnapshot() bool {
+	return db.opt.SortedSetSnapshot && !db.isReadOnly() && db.opt.EntryIdxMode != HintBPTSparseIdxMode
+}
+
+// writeSortedSetSnapshot writes the sorted sets into the snapshot when the db is closed, the previous
+// snapshot is kept if writing fails.
+func (db *DB) writeSortedSetSnapshot() (err error) {
+	if !db.useSortedSetSnapshot() || db.ActiveFile == nil {
+		return nil
+	}
+
+	path := filepath.Join(db.opt.Dir, SortedSetSnapshotName)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(path + ".tmp")
+		}
+	}()
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+	w := newSnapshotWriter(f)
+	w.write(sortedSetSnapshotMagic)
+	w.putUint32(sortedSetSnapshotVersion)
+	w.putUint64(uint64(db.ActiveFile.fileID))
+	w.putUint64(uint64(db.ActiveFile.writeOff))
+	w.putUint32(uint32(len(dataFileIds)))
+	for _, id := range dataFileIds {
+		w.putUint64(uint64(id))
+	}
+
+	w.putUint32(uint32(len(db.SortedSetIdx)))
+	for bucket, sortedSet := range db.SortedSetIdx {
+		ttl, timestamp := sortedSet.TTL()
+		w.putBytes([]byte(bucket))
+		w.write([]byte{byte(sortedSet.ScoreType())})
+		w.putUint32(ttl)
+		w.putUint64(timestamp)
+		w.putUint64(uint64(sortedSet.Size()))
+
+		var (
+			nodes  []*zset.SortedSetNode
+			cursor []byte
+		)
+		for {
+			nodes, cursor, _ = sortedSet.Scan(cursor, 1024)
+			for _, node := range nodes {
+				w.putBytes([]byte(node.Key()))
+				w.putUint64(math.Float64bits(float64(node.Score())))
+				w.putUint64(uint64(node.IntScore()))
+				w.putBytes(node.Value)
+			}
+			if cursor == nil {
+				break
+			}
+		}
+	}
+	w.putUint32Unhashed(w.crc.Sum32())
+
+	if err = w.flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// loadSortedSetSnapshot loads the sorted sets from the snapshot and returns its watermark, the records before
+// it are not replayed then. It returns nil if there is no snapshot, or if it is corrupted or does not match the
+// data files, e.g. they were merged since, so that all the records are replayed.
+func (db *DB) loadSortedSetSnapshot(dataFileIds []int) *sortedSetSnapshot {
+	if !db.useSortedSetSnapshot() {
+		return nil
+	}
+
+	sortedSets, snapshot, err := readSortedSetSnapshot(db.opt.Dir, dataFileIds)
+	if err != nil {
+		return nil
+	}
+
+	db.SortedSetIdx = sortedSets
+	db.loadedSortedSetSnapshot = true
+
+	return snapshot
+}
+
+func readSortedSetSnapshot(dir string, dataFileIds []int) (SortedSetIdx, *sortedSetSnapshot, error) {
+	f, err := os.Open(filepath.Join(dir, SortedSetSnapshotName))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	r := newSnapshotReader(f, info.Size())
+
+	if !bytes.Equal(r.read(len(sortedSetSnapshotMagic)), sortedSetSnapshotMagic) || r.uint32() != sortedSetSnapshotVersion {
+		return nil, nil, errSortedSetSnapshot
+	}
+	snapshot := &sortedSetSnapshot{fileID: int64(r.uint64()), offset: int64(r.uint64())}
+
+	// the data files up to the watermark are the ones the snapshot was taken from.
+	var ids []int
+	for _, id := range dataFileIds {
+		if int64(id) <= snapshot.fileID {
+			ids = append(ids, id)
+		}
+	}
+	if int(r.uint32()) != len(ids) {
+		return nil, nil, errSortedSetSnapshot
+	}
+	for _, id := range ids {
+		if int(r.uint64()) != id {
+			return nil, nil, errSortedSetSnapshot
+		}
+	}
+	if r.err != nil || len(ids) == 0 || int64(ids[len(ids)-1]) != snapshot.fileID {
+		return nil, nil, errSortedSetSnapshot
+	}
+
+	sortedSets := make(SortedSetIdx)
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		bucket := string(r.bytes())
+		scoreType := zset.ScoreType(r.uint8())
+		ttl, timestamp := r.uint32(), r.uint64()
+
+		b := zset.NewBuilder()
+		for size := r.uint64(); size > 0 && r.err == nil; size-- {
+			key := string(r.bytes())
+			score, intScore := math.Float64frombits(r.uint64()), int64(r.uint64())
+			value := r.bytes()
+			if r.err != nil {
+				break
+			}
+			if scoreType == zset.ScoreInt64 {
+				err = b.AppendInt(key, intScore, value)
+			} else {
+				err = b.Append(key, zset.SCORE(score), value)
+			}
+			if err != nil {
+				return nil, nil, errSortedSetSnapshot
+			}
+		}
+
+		sortedSets[bucket] = b.SortedSet()
+		sortedSets[bucket].Expire(ttl, timestamp)
+	}
+
+	sum := r.crc.Sum32()
+	if r.uint32Unhashed() != sum || r.err != nil {
+		return nil, nil, errSortedSetSnapshot
+	}
+	if _, err := r.r.ReadByte(); err != io.EOF {
+		return nil, nil, errSortedSetSnapshot
+	}
+
+	return sortedSets, snapshot, nil
+}
+
+// snapshotWriter writes the snapshot through a crc32, keeping the first error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [8]byte
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+}
+
+func (w *snapshotWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, _ = w.crc.Write(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *snapshotWriter) putUint32(v uint32) {
+	binary.BigEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *snapshotWriter) putUint32Unhashed(v uint32) {
+	binary.BigEndian.PutUint32(w.buf[:4], v)
+	if w.err == nil {
+		_, w.err = w.w.Write(w.buf[:4])
+	}
+}
+
+func (w *snapshotWriter) putUint64(v uint64) {
+	binary.BigEndian.PutUint64(w.buf[:], v)
+	w.write(w.buf[:])
+}
+
+func (w *snapshotWriter) putBytes(p []byte) {
+	w.putUint32(uint32(len(p)))
+	w.write(p)
+}
+
+func (w *snapshotWriter) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// snapshotReader reads the snapshot through a crc32, keeping the first error. A length is
+// checked against the size of the snapshot, so a corrupted one doesn't allocate too much.
+type snapshotReader struct {
+	r    *bufio.Reader
+	crc  hash.Hash32
+	size int64
+	buf  [8]byte
+	err  error
+}
+
+func newSnapshotReader(r io.Reader, size int64) *snapshotReader {
+	return &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE(), size: size}
+}
+
+func (r *snapshotReader) readInto(p []byte) []byte {
+	if r.err != nil {
+		return p
+	}
+	if _, r.err = io.ReadFull(r.r, p); r.err == nil {
+		_, _ = r.crc.Write(p)
+	}
+	return p
+}
+
+func (r *snapshotReader) read(n int) []byte {
+	if int64(n) > r.size {
+		r.err = errSortedSetSnapshot
+	}
+	if r.err != nil {
+		return nil
+	}
+	return r.readInto(make([]byte, n))
+}
+
+func (r *snapshotReader) uint8() uint8 {
+	return r.readInto(r.buf[:1])[0]
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.readInto(r.buf[:4]))
+}
+
+func (r *snapshotReader) uint32Unhashed() uint32 {
+	if r.err == nil {
+		_, r.err = io.ReadFull(r.r, r.buf[:4])
+	}
+	return binary.BigEndian.Uint32(r.buf[:4])
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.readInto(r.buf[:]))
+}
+
+func (r *snapshotReader) bytes() []byte {
+	return r.read(int(r.uint32()))
+}
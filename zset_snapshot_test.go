@@ -0,0 +1,225 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/stretchr/testify/require"
+)
+
+// dumpSortedSets returns the members with their scores and values and the ttl of every sorted set.
+func dumpSortedSets(t testing.TB, db *DB) map[string][]string {
+	dump := make(map[string][]string)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		var buckets []string
+		if err := tx.IterateBuckets(DataStructureSortedSet, "*", func(bucket string) bool {
+			buckets = append(buckets, bucket)
+			return true
+		}); err != nil {
+			return err
+		}
+		sort.Strings(buckets)
+
+		for _, bucket := range buckets {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			if err != nil {
+				return err
+			}
+			ttl, _ := db.SortedSetIdx[bucket].TTL()
+			members := []string{fmt.Sprint("ttl ", ttl)}
+			for _, node := range nodes {
+				members = append(members, fmt.Sprintf("%s:%v:%d:%s", node.Key(), node.Score(), node.IntScore(), node.Value))
+			}
+			dump[bucket] = members
+		}
+		return nil
+	}))
+	return dump
+}
+
+func TestDB_SortedSetSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	opts.Dir = NutsDBTestDirPath
+	opts.EntryIdxMode = HintKeyValAndRAMIdxMode
+	opts.SegmentSize = 64 * KB
+	opts.SortedSetSnapshot = true
+	require.NoError(t, os.RemoveAll(opts.Dir))
+	defer os.RemoveAll(opts.Dir)
+
+	snapshotPath := filepath.Join(opts.Dir, SortedSetSnapshotName)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	reopen := func(fromSnapshot bool) {
+		want := dumpSortedSets(t, db)
+		require.NoError(t, db.Close())
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.Equal(t, fromSnapshot, db.Stats().SortedSetSnapshot)
+		require.Equal(t, want, dumpSortedSets(t, db))
+	}
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// no snapshot yet.
+	reopen(false)
+
+	require.NoError(t, db.SetZSetScoreType("ids", zset.ScoreInt64))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 1000; i++ {
+			if err := tx.ZAdd("scores", GetTestBytes(i), float64(i%10), GetRandomBytes(16)); err != nil {
+				return err
+			}
+			if err := tx.ZAddInt("ids", GetTestBytes(i), 1<<60+int64(i), nil); err != nil {
+				return err
+			}
+		}
+		if err := tx.ZAdd("expiring", []byte("member"), 1, nil); err != nil {
+			return err
+		}
+		if err := tx.ZAdd("deleted", []byte("member"), 1, nil); err != nil {
+			return err
+		}
+		return tx.ExpireZSet("expiring", 1000)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.ZRem("scores", string(GetTestBytes(0))); err != nil {
+			return err
+		}
+		if _, err := tx.ZPopMax("ids"); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(DataStructureSortedSet, "deleted")
+	}))
+
+	reopen(true)
+	older, err := ioutil.ReadFile(snapshotPath)
+	require.NoError(t, err)
+
+	// the records written after the snapshot are replayed on top of it.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 1000; i += 2 {
+			if err := tx.ZRem("scores", string(GetTestBytes(i+1))); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("scores", GetTestBytes(i), -float64(i), []byte("again")); err != nil {
+				return err
+			}
+		}
+		if err := tx.ZAdd("added", []byte("member"), 1, nil); err != nil {
+			return err
+		}
+		if err := tx.ExpireZSet("expiring", Persistent); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(DataStructureSortedSet, "ids")
+	}))
+	want := dumpSortedSets(t, db)
+	require.NoError(t, db.Close())
+	require.NoError(t, ioutil.WriteFile(snapshotPath, older, 0644))
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.True(t, db.Stats().SortedSetSnapshot)
+	require.Equal(t, want, dumpSortedSets(t, db))
+
+	// a snapshot that can't be used is ignored.
+	for _, corrupt := range []func([]byte) []byte{
+		func(data []byte) []byte { data[len(data)/2]++; return data },
+		func(data []byte) []byte { return data[:len(data)-1] },
+		func(data []byte) []byte { return append(data, 0) },
+		func(data []byte) []byte { data[len(sortedSetSnapshotMagic)+3]++; return data },
+		func(data []byte) []byte { return nil },
+	} {
+		require.NoError(t, db.Close())
+		data, err := ioutil.ReadFile(snapshotPath)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(snapshotPath, corrupt(data), 0644))
+		db, err = Open(opts)
+		require.NoError(t, err)
+		require.False(t, db.Stats().SortedSetSnapshot)
+		require.Equal(t, want, dumpSortedSets(t, db))
+	}
+
+	// the merge removes the data files the snapshot was taken from.
+	reopen(true)
+	require.NoError(t, db.Close())
+	older, err = ioutil.ReadFile(snapshotPath)
+	require.NoError(t, err)
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+	require.NoError(t, ioutil.WriteFile(snapshotPath, older, 0644))
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.False(t, db.Stats().SortedSetSnapshot)
+	require.Equal(t, want, dumpSortedSets(t, db))
+	reopen(true)
+}
+
+func BenchmarkDB_OpenLargeSortedSet(b *testing.B) {
+	opt := DefaultOptions
+	opt.Dir, _ = ioutil.TempDir("", "nutsdb")
+	opt.EntryIdxMode = HintKeyValAndRAMIdxMode
+	defer os.RemoveAll(opt.Dir)
+
+	db, err := Open(opt)
+	require.NoError(b, err)
+	const size, batch = 5000000, 50000
+	for n := 0; n < size; n += batch {
+		require.NoError(b, db.Update(func(tx *Tx) error {
+			members := make([]ScoredMember, 0, batch)
+			for i := n; i < n+batch; i++ {
+				members = append(members, ScoredMember{Score: float64(i % 1000), Member: GetTestBytes(i)})
+			}
+			return tx.ZAddBatch("bucket", members)
+		}))
+	}
+	require.NoError(b, db.Close())
+
+	bench := func(b *testing.B, snapshot bool) {
+		opt.SortedSetSnapshot = snapshot
+		// the first close writes the snapshot.
+		db, err := Open(opt)
+		require.NoError(b, err)
+		require.NoError(b, db.Close())
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			db, err := Open(opt)
+			require.NoError(b, err)
+			require.Equal(b, snapshot, db.Stats().SortedSetSnapshot)
+			b.StopTimer()
+			require.NoError(b, db.Close())
+			b.StartTimer()
+		}
+	}
+
+	b.Run("replay", func(b *testing.B) {
+		bench(b, false)
+	})
+
+	b.Run("snapshot", func(b *testing.B) {
+		bench(b, true)
+	})
+}